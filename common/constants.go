@@ -17,11 +17,22 @@ var ServerAddress = "http://localhost:3000"
 var PayAddress = ""
 var EpayId = ""
 var EpayKey = ""
+var StripeEnabled = false
+var StripeApiAddress = "https://api.stripe.com"
+var StripeApiSecret = ""
+var StripeWebhookSecret = ""
+var StripeCurrency = "usd"
+
+// PaymentMockEnabled 本地模拟支付，访问支付链接即视为支付成功，仅用于测试
+var PaymentMockEnabled = os.Getenv("PAYMENT_MOCK_ENABLED") == "true"
 var Price = 7.3
 var RedempTionCount = 30
 var Footer = ""
 var Logo = ""
 var TopUpLink = ""
+
+// RefundClawbackPolicy 充值退款扣回额度时余额不足的处理：negative 允许余额为负，suspend 扣到 0 并封禁用户
+var RefundClawbackPolicy = "negative"
 var ChatLink = ""
 var QuotaPerUnit = 500 * 1000.0 // $0.002 / 1K tokens
 var DisplayInCurrencyEnabled = true
@@ -39,6 +50,13 @@ var DataExportEnabled = true
 var DataExportInterval = 5 // unit: minute
 var MiniQuota = 1.0
 var ProporTions = 10
+
+// 返佣引擎：开启后按 AffCommissionRates 对邀请用户的充值（topup）、消费（consume）或两者（both）返佣，替代 ProporTions 充值返现
+var AffCommissionEnabled = false
+var AffCommissionBase = "topup"
+var AffCommissionRates = ""      // 按邀请人分组设置各级返佣百分比，如 {"default":[10,2],"vip":[15,3]}，未设置时一级为 ProporTions
+var AffCommissionHoldDays = 0    // 返佣冻结天数，到期后才计入可提现的邀请额度
+var AffFraudCheckEnabled = false // 邀请人与被邀请人 IP 相同或邮箱域名相同（EmailDomainWhitelist 中的公共邮箱除外）时不发放邀请奖励
 var UserGroup = "default"
 var VipUserGroup = "default"
 
@@ -58,6 +76,11 @@ var EmailVerificationEnabled = false
 var GitHubOAuthEnabled = false
 var WeChatAuthEnabled = false
 var TurnstileCheckEnabled = false
+
+// 登录失败保护，各项为 0 时关闭对应功能
+var LoginMaxFailures = 10       // 同一账户连续失败多少次后临时锁定
+var LoginLockoutMinutes = 15    // 锁定时长，同时也是失败次数的统计窗口
+var LoginTurnstileThreshold = 3 // 连续失败多少次后即使未开启 Turnstile 也要求人机验证
 var RegisterEnabled = true
 var ApproximateTokenEnabled = false
 
@@ -120,13 +143,36 @@ var RequestInterval = time.Duration(requestInterval) * time.Second
 
 var SyncFrequency = GetOrDefault("SYNC_FREQUENCY", 60) // unit is second
 
+// NodeId identifies this replica, e.g. to skip cache invalidations it published itself
+var NodeId = GetOrDefaultString("NODE_ID", GetUUID())
+
+var CacheFullSyncFrequency = GetOrDefault("CACHE_FULL_SYNC_FREQUENCY", 600)             // unit is second
+var CacheInvalidationPollInterval = GetOrDefault("CACHE_INVALIDATION_POLL_INTERVAL", 3) // unit is second
+
+var LeaderLeaseTTL = GetOrDefault("LEADER_LEASE_TTL", 30) // unit is second
+
+var MetricsEnabled = os.Getenv("METRICS_ENABLED") == "true"
+var MetricsToken = os.Getenv("METRICS_TOKEN")
+var MetricsIPAllowlist = os.Getenv("METRICS_IP_ALLOWLIST") // comma separated IPs or CIDRs
+
+var TracingEnabled = os.Getenv("TRACING_ENABLED") == "true"
+var TracingEndpoint = GetOrDefaultString("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+var TracingHeaders = os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")
+var TracingServiceName = GetOrDefaultString("OTEL_SERVICE_NAME", "chat-api")
+var TracingSampleRatio = GetOrDefaultFloat("TRACING_SAMPLE_RATIO", 1)
+
+var TopUpReconcileDelay = GetOrDefault("TOPUP_RECONCILE_DELAY", 10)  // unit is minute
+var TopUpExpireMinutes = GetOrDefault("TOPUP_EXPIRE_MINUTES", 24*60) // unit is minute
+
+var SubscriptionRemindDays = GetOrDefault("SUBSCRIPTION_REMIND_DAYS", 3) // 订阅到期前多少天提醒续费
+
 var BatchUpdateEnabled = false
 var BatchUpdateInterval = GetOrDefault("BATCH_UPDATE_INTERVAL", 5)
 
 var RelayTimeout = GetOrDefault("RELAY_TIMEOUT", 0) // unit is second
 
 const (
-	RequestIdKey = "X-Oneapi-Request-Id"
+	RequestIdKey = "X-Chatapi-Request-Id"
 )
 
 const (
@@ -141,6 +187,8 @@ var (
 	FileDownloadPermission  = RoleGuestUser
 	ImageUploadPermission   = RoleGuestUser
 	ImageDownloadPermission = RoleGuestUser
+	// 角色不低于该值的用户必须启用两步验证，设为 101 及以上即不强制
+	TwoFactorRequiredPermission = RoleAdminUser
 )
 
 // All duration's unit is seconds
@@ -182,6 +230,26 @@ const (
 	RedemptionCodeStatusUsed     = 3 // also don't use 0
 )
 
+const (
+	PlanStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	PlanStatusDisabled = 2 // also don't use 0
+)
+
+const (
+	CouponStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	CouponStatusDisabled = 2 // also don't use 0
+)
+
+const (
+	OidcProviderStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	OidcProviderStatusDisabled = 2 // also don't use 0
+)
+
+const (
+	RefillScheduleStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	RefillScheduleStatusDisabled = 2 // also don't use 0
+)
+
 const (
 	ChannelStatusUnknown          = 0
 	ChannelStatusEnabled          = 1 // don't use 0, 0 is the default value!
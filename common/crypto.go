@@ -1,6 +1,10 @@
 package common
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+
+	"golang.org/x/crypto/bcrypt"
+)
 
 func Password2Hash(password string) (string, error) {
 	passwordBytes := []byte(password)
@@ -12,3 +16,25 @@ func ValidatePasswordAndHash(password string, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// GetSecureRandomString 用 crypto/rand 生成由数字和大小写字母组成的随机串，用于令牌、state 等不可预测的值
+func GetSecureRandomString(length int) (string, error) {
+	key := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(key) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			// 丢弃 248 及以上的字节，使每个字符等概率
+			if int(b) >= 248 {
+				continue
+			}
+			key = append(key, keyChars[int(b)%len(keyChars)])
+			if len(key) == length {
+				break
+			}
+		}
+	}
+	return string(key), nil
+}
@@ -0,0 +1,26 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSecureRandomString(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		value, err := GetSecureRandomString(40)
+		require.NoError(t, err)
+		require.Len(t, value, 40)
+		for _, c := range value {
+			assert.True(t, strings.ContainsRune(keyChars, c), value)
+		}
+		assert.False(t, seen[value])
+		seen[value] = true
+	}
+	value, err := GetSecureRandomString(0)
+	require.NoError(t, err)
+	assert.Empty(t, value)
+}
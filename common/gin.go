@@ -50,3 +50,24 @@ func SetEventStreamHeaders(c *gin.Context) {
 	c.Writer.Header().Set("Transfer-Encoding", "chunked")
 	c.Writer.Header().Set("X-Accel-Buffering", "no")
 }
+
+// defaultTrustedProxies 未设置 TRUSTED_PROXIES 时只信任本机与内网地址转发的 X-Forwarded-For
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+// TrustedProxies 解析逗号分隔的受信任代理 IP 或 CIDR，none 表示不信任任何代理，直接使用连接的对端地址
+func TrustedProxies(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return defaultTrustedProxies
+	}
+	if value == "none" {
+		return nil
+	}
+	var proxies []string
+	for _, proxy := range strings.Split(value, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
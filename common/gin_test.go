@@ -0,0 +1,13 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	assert.Equal(t, defaultTrustedProxies, TrustedProxies(""))
+	assert.Nil(t, TrustedProxies("none"))
+	assert.Equal(t, []string{"10.0.0.1", "203.0.113.0/24"}, TrustedProxies(" 10.0.0.1, ,203.0.113.0/24 "))
+}
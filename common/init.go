@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
@@ -23,6 +24,10 @@ func printHelp() {
 }
 
 func init() {
+	// go test 的 -test.* 参数在包初始化之后才注册，测试二进制不解析命令行也不创建日志目录
+	if strings.HasSuffix(os.Args[0], ".test") {
+		return
+	}
 	flag.Parse()
 
 	if *PrintVersion {
@@ -0,0 +1,100 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+var storageBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+var (
+	RelayRequests = NewCounterVec("chat_api_relay_requests_total",
+		"Relay requests by model, channel, group and HTTP status.", "model", "channel", "group", "status")
+	RelayFirstTokenLatency = NewHistogramVec("chat_api_relay_first_token_seconds",
+		"Time from request start to the first byte written to the client.", latencyBuckets, "model", "channel")
+	RelayLatency = NewHistogramVec("chat_api_relay_request_duration_seconds",
+		"Total relay request latency.", latencyBuckets, "model", "channel")
+	UpstreamErrors = NewCounterVec("chat_api_upstream_errors_total",
+		"Errors returned by upstream channels.", "channel", "status", "type")
+	RelayRetries = NewCounterVec("chat_api_relay_retries_total",
+		"Relay retry attempts after an upstream failure.", "model")
+	RelayFailovers = NewCounterVec("chat_api_relay_failovers_total",
+		"Relay requests that succeeded on a different channel after a failure.", "model")
+	QuotaConsumed = NewCounterVec("chat_api_quota_consumed_total",
+		"Quota consumed by model and channel.", "model", "channel")
+	PreConsumeRefunds = NewCounterVec("chat_api_preconsume_refunds_total",
+		"Pre-consumed quota returned to tokens after failed requests.")
+	PreConsumeRefundedQuota = NewCounterVec("chat_api_preconsume_refunded_quota_total",
+		"Amount of pre-consumed quota returned to tokens.")
+	DBLatency = NewHistogramVec("chat_api_db_operation_seconds",
+		"Database operation latency.", storageBuckets, "operation")
+	RedisLatency = NewHistogramVec("chat_api_redis_command_seconds",
+		"Redis command latency.", storageBuckets, "command")
+)
+
+const dbStartKey = "metrics:start_time"
+
+// InstrumentDB 通过 gorm 回调统计数据库操作耗时
+func InstrumentDB(db *gorm.DB) {
+	_ = db.Callback().Create().Before("gorm:create").Register("metrics:before_create", beforeDBOperation)
+	_ = db.Callback().Create().After("gorm:create").Register("metrics:after_create", afterDBOperation("create"))
+	_ = db.Callback().Query().Before("gorm:query").Register("metrics:before_query", beforeDBOperation)
+	_ = db.Callback().Query().After("gorm:query").Register("metrics:after_query", afterDBOperation("query"))
+	_ = db.Callback().Update().Before("gorm:update").Register("metrics:before_update", beforeDBOperation)
+	_ = db.Callback().Update().After("gorm:update").Register("metrics:after_update", afterDBOperation("update"))
+	_ = db.Callback().Delete().Before("gorm:delete").Register("metrics:before_delete", beforeDBOperation)
+	_ = db.Callback().Delete().After("gorm:delete").Register("metrics:after_delete", afterDBOperation("delete"))
+	_ = db.Callback().Row().Before("gorm:row").Register("metrics:before_row", beforeDBOperation)
+	_ = db.Callback().Row().After("gorm:row").Register("metrics:after_row", afterDBOperation("row"))
+	_ = db.Callback().Raw().Before("gorm:raw").Register("metrics:before_raw", beforeDBOperation)
+	_ = db.Callback().Raw().After("gorm:raw").Register("metrics:after_raw", afterDBOperation("raw"))
+}
+
+func beforeDBOperation(tx *gorm.DB) {
+	tx.InstanceSet(dbStartKey, time.Now())
+}
+
+func afterDBOperation(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		value, ok := tx.InstanceGet(dbStartKey)
+		if !ok {
+			return
+		}
+		DBLatency.Observe(time.Since(value.(time.Time)).Seconds(), operation)
+	}
+}
+
+type redisStartKey struct{}
+
+type redisHook struct{}
+
+func (redisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		RedisLatency.Observe(time.Since(start).Seconds(), cmd.Name())
+	}
+	return nil
+}
+
+func (redisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return context.WithValue(ctx, redisStartKey{}, time.Now()), nil
+}
+
+func (redisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	if start, ok := ctx.Value(redisStartKey{}).(time.Time); ok {
+		RedisLatency.Observe(time.Since(start).Seconds(), "pipeline")
+	}
+	return nil
+}
+
+// InstrumentRedis 通过 go-redis hook 统计 Redis 命令耗时
+func InstrumentRedis(client *redis.Client) {
+	client.AddHook(redisHook{})
+}
@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Enabled 未启用时所有埋点直接返回，不占用内存
+var Enabled = false
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var registryLock sync.Mutex
+var registry []collector
+
+func register(c collector) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	registry = append(registry, c)
+}
+
+// WriteText 以 Prometheus 文本格式输出所有指标
+func WriteText(w io.Writer) {
+	registryLock.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryLock.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	lock   sync.Mutex
+	values map[string]*series
+}
+
+func newVec(name string, help string, labels []string) vec {
+	return vec{name: name, help: help, labels: labels, values: make(map[string]*series)}
+}
+
+func (v *vec) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.values[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.values[key] = s
+	}
+	return s
+}
+
+func (v *vec) sortedSeries() []*series {
+	result := make([]*series, 0, len(v.values))
+	for _, s := range v.values {
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.Join(result[i].labelValues, ",") < strings.Join(result[j].labelValues, ",")
+	})
+	return result
+}
+
+func (v *vec) writeHeader(w io.Writer, type_ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, type_)
+}
+
+type CounterVec struct {
+	vec
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, labels)}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if !Enabled {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.get(labelValues).value += value
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.writeHeader(w, "counter")
+	for _, s := range c.sortedSeries() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labelValues, "", ""), formatValue(s.value))
+	}
+}
+
+type HistogramVec struct {
+	vec
+	buckets []float64
+	data    map[string]*histogramData
+}
+
+type histogramData struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{vec: newVec(name, help, labels), buckets: buckets, data: make(map[string]*histogramData)}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if !Enabled {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	h.get(labelValues)
+	data, ok := h.data[key]
+	if !ok {
+		data = &histogramData{counts: make([]uint64, len(h.buckets))}
+		h.data[key] = data
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			data.counts[i]++
+		}
+	}
+	data.count++
+	data.sum += value
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.writeHeader(w, "histogram")
+	for _, s := range h.sortedSeries() {
+		data := h.data[strings.Join(s.labelValues, "\xff")]
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", formatValue(upper)), data.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", "+Inf"), data.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), formatValue(data.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), data.count)
+	}
+}
+
+// Sample 由 GaugeFunc 在抓取时生成的单条数据
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// GaugeFunc 在每次抓取时调用 collect 计算当前值，适用于渠道状态、队列长度等从数据库统计的指标
+type GaugeFunc struct {
+	name    string
+	help    string
+	labels  []string
+	collect func() []Sample
+}
+
+func NewGaugeFunc(name string, help string, collect func() []Sample, labels ...string) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, labels: labels, collect: collect}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	samples := g.collect()
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, s.LabelValues, "", ""), formatValue(s.Value))
+	}
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, labelValueEscaper.Replace(value)))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
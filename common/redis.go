@@ -48,12 +48,19 @@ func ParseRedisOption() *redis.Options {
 }
 
 func RedisSet(key string, value string, expiration time.Duration) error {
-	ctx := context.Background()
+	return RedisSetContext(context.Background(), key, value, expiration)
+}
+
+// RedisSetContext 沿用调用方的 context，转发请求中的命令可挂在请求的 span 下
+func RedisSetContext(ctx context.Context, key string, value string, expiration time.Duration) error {
 	return RDB.Set(ctx, key, value, expiration).Err()
 }
 
 func RedisGet(key string) (string, error) {
-	ctx := context.Background()
+	return RedisGetContext(context.Background(), key)
+}
+
+func RedisGetContext(ctx context.Context, key string) (string, error) {
 	return RDB.Get(ctx, key).Result()
 }
 
@@ -63,6 +70,52 @@ func RedisDel(key string) error {
 }
 
 func RedisDecrease(key string, value int64) error {
-	ctx := context.Background()
+	return RedisDecreaseContext(context.Background(), key, value)
+}
+
+func RedisDecreaseContext(ctx context.Context, key string, value int64) error {
 	return RDB.DecrBy(ctx, key, value).Err()
 }
+
+func RedisPublish(channel string, message string) error {
+	ctx := context.Background()
+	return RDB.Publish(ctx, channel, message).Err()
+}
+
+func RedisSubscribe(channel string) *redis.PubSub {
+	ctx := context.Background()
+	return RDB.Subscribe(ctx, channel)
+}
+
+func RedisSetNX(key string, value string, expiration time.Duration) (bool, error) {
+	ctx := context.Background()
+	return RDB.SetNX(ctx, key, value, expiration).Result()
+}
+
+// RedisExpireIfEquals refreshes the TTL only if the key still holds the given value
+func RedisExpireIfEquals(key string, value string, expiration time.Duration) (bool, error) {
+	ctx := context.Background()
+	script := `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) else return 0 end`
+	result, err := RDB.Eval(ctx, script, []string{key}, value, expiration.Milliseconds()).Int()
+	return result == 1, err
+}
+
+// RedisDelIfEquals deletes the key only if it still holds the given value
+func RedisDelIfEquals(key string, value string) error {
+	ctx := context.Background()
+	script := `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+	return RDB.Eval(ctx, script, []string{key}, value).Err()
+}
+
+// RedisIncrBy increases the counter and sets its expiration, returning the new value
+func RedisIncrBy(key string, value int64, expiration time.Duration) (int64, error) {
+	ctx := context.Background()
+	pipe := RDB.TxPipeline()
+	incr := pipe.IncrBy(ctx, key, value)
+	pipe.Expire(ctx, key, expiration)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var draining int32
+var backgroundTasks sync.WaitGroup
+
+var ShutdownTimeout = GetOrDefault("SHUTDOWN_TIMEOUT", 30) // unit is second
+var ShutdownDelay = GetOrDefault("SHUTDOWN_DELAY", 5)      // unit is second
+
+// IsDraining 节点收到停机信号后进入排空状态，就绪探针返回失败，新请求不再被路由到本节点
+func IsDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+func StartDraining() {
+	atomic.StoreInt32(&draining, 1)
+}
+
+// GoBackground 启动一个停机前必须执行完的异步任务，例如请求结束后的扣费与日志记录
+func GoBackground(task func()) {
+	backgroundTasks.Add(1)
+	go func() {
+		defer backgroundTasks.Done()
+		task()
+	}()
+}
+
+// WaitBackground 等待所有 GoBackground 任务结束，超时返回 false
+func WaitBackground(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		backgroundTasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,56 @@
+package common
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTotpCodeRfc6238(t *testing.T) {
+	// RFC 6238 附录 B 的 SHA1 测试向量，取后 6 位
+	key := []byte("12345678901234567890")
+	cases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.code, totpCode(key, tc.unix/TotpPeriod), "unix %d", tc.unix)
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	secret, err := GenerateTotpSecret()
+	assert.NoError(t, err)
+	key, err := totpEncoding.DecodeString(secret)
+	assert.NoError(t, err)
+	current := time.Now().Unix() / TotpPeriod
+	cases := []struct {
+		name string
+		code string
+		want int64
+	}{
+		{"current step", totpCode(key, current), current},
+		{"previous step", totpCode(key, current-1), current - 1},
+		{"next step", totpCode(key, current+1), current + 1},
+		{"too old", totpCode(key, current-3), 0},
+		{"surrounding spaces", " " + totpCode(key, current) + " ", current},
+		{"wrong length", "12345", 0},
+		{"empty", "", 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, ValidateTotp(secret, tc.code))
+		})
+	}
+	assert.Zero(t, ValidateTotp("not base32!", totpCode(key, current)))
+	assert.NotZero(t, ValidateTotp(strings.ToLower(secret), totpCode(key, current)))
+}
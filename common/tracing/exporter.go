@@ -0,0 +1,215 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	exportBatchSize = 512
+	exportInterval  = 5 * time.Second
+	queueSize       = 8192
+)
+
+var (
+	exportEndpoint string
+	exportHeaders  map[string]string
+	serviceName    string
+	spanQueue      = make(chan *Span, queueSize)
+	flushRequests  = make(chan chan struct{})
+	exporterOnce   sync.Once
+	httpClient     = &http.Client{Timeout: 10 * time.Second}
+)
+
+// StartExporter 以 OTLP/HTTP JSON 格式批量导出 span，endpoint 为完整的 /v1/traces 地址，
+// headers 格式与 OTEL_EXPORTER_OTLP_HEADERS 相同（k1=v1,k2=v2）
+func StartExporter(endpoint string, headers string, service string) {
+	exporterOnce.Do(func() {
+		exportEndpoint = endpoint
+		exportHeaders = parseHeaders(headers)
+		serviceName = service
+		go exportLoop()
+	})
+}
+
+func parseHeaders(headers string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(headers, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) != "" {
+			result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return result
+}
+
+func enqueue(span *Span) {
+	select {
+	case spanQueue <- span:
+	default:
+		// 队列已满时丢弃，追踪不能影响中继
+	}
+}
+
+// Flush 导出队列中剩余的 span，停机时调用
+func Flush(timeout time.Duration) {
+	if exportEndpoint == "" {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case flushRequests <- done:
+	case <-time.After(timeout):
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func exportLoop() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, exportBatchSize)
+	for {
+		select {
+		case span := <-spanQueue:
+			batch = append(batch, span)
+			if len(batch) >= exportBatchSize {
+				export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				export(batch)
+				batch = batch[:0]
+			}
+		case done := <-flushRequests:
+			for drained := false; !drained; {
+				select {
+				case span := <-spanQueue:
+					batch = append(batch, span)
+				default:
+					drained = true
+				}
+			}
+			if len(batch) > 0 {
+				export(batch)
+				batch = batch[:0]
+			}
+			close(done)
+		}
+	}
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceId           string         `json:"traceId"`
+	SpanId            string         `json:"spanId"`
+	ParentSpanId      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func toOtlpValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(value)}
+}
+
+func toOtlpSpan(s *Span) otlpSpan {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	span := otlpSpan{
+		TraceId:           hex.EncodeToString(s.traceId[:]),
+		SpanId:            hex.EncodeToString(s.spanId[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parentSpanId != [8]byte{} {
+		span.ParentSpanId = hex.EncodeToString(s.parentSpanId[:])
+	}
+	for key, value := range s.attributes {
+		span.Attributes = append(span.Attributes, otlpKeyValue{Key: key, Value: toOtlpValue(value)})
+	}
+	span.Status.Code = s.statusCode
+	span.Status.Message = s.statusMessage
+	return span
+}
+
+func export(batch []*Span) {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, toOtlpSpan(s))
+	}
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpKeyValue{{Key: "service.name", Value: toOtlpValue(serviceName)}},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "chat-api"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		common.SysError("failed to marshal spans: " + err.Error())
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, exportEndpoint, bytes.NewReader(body))
+	if err != nil {
+		common.SysError("failed to create export request: " + err.Error())
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range exportHeaders {
+		req.Header.Set(key, value)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		common.SysError("failed to export spans: " + err.Error())
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		common.SysError(fmt.Sprintf("failed to export spans: collector returned status %d", resp.StatusCode))
+	}
+}
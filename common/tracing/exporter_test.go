@@ -0,0 +1,139 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type otlpRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestExporter(t *testing.T) {
+	requests := make(chan otlpRequest, 2)
+	headers := make(chan http.Header, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var payload otlpRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		headers <- r.Header
+		requests <- payload
+	}))
+	defer receiver.Close()
+
+	Enabled = true
+	defer func() { Enabled = false }()
+	StartExporter(receiver.URL+"/v1/traces", "authorization=Bearer secret, x-tenant = one", "one-api-test")
+
+	ctx, root := Start(context.Background(), "relay", SpanKindServer)
+	_, child := Start(ctx, "db.query", SpanKindClient)
+	child.SetAttribute("db.rows_affected", int64(3))
+	child.SetAttribute("db.system", "sqlite")
+	child.End()
+	root.SetError("upstream returned status 500")
+	root.End()
+	Flush(5 * time.Second)
+
+	// 定时导出可能把两个 span 拆到两次请求中
+	spans := map[string]otlpSpan{}
+	for len(spans) < 2 {
+		var payload otlpRequest
+		select {
+		case payload = <-requests:
+		case <-time.After(5 * time.Second):
+			t.Fatal("receiver got no spans")
+		}
+		header := <-headers
+		assert.Equal(t, "Bearer secret", header.Get("Authorization"))
+		assert.Equal(t, "one", header.Get("X-Tenant"))
+		require.Len(t, payload.ResourceSpans, 1)
+		resource := payload.ResourceSpans[0]
+		require.Len(t, resource.Resource.Attributes, 1)
+		assert.Equal(t, "service.name", resource.Resource.Attributes[0].Key)
+		assert.Equal(t, "one-api-test", resource.Resource.Attributes[0].Value["stringValue"])
+		require.Len(t, resource.ScopeSpans, 1)
+		for _, span := range resource.ScopeSpans[0].Spans {
+			spans[span.Name] = span
+		}
+	}
+	require.Len(t, spans, 2)
+
+	relay, query := spans["relay"], spans["db.query"]
+	assert.Len(t, relay.TraceId, 32)
+	assert.Len(t, relay.SpanId, 16)
+	assert.Empty(t, relay.ParentSpanId)
+	assert.Equal(t, SpanKindServer, relay.Kind)
+	assert.Equal(t, statusError, relay.Status.Code)
+	assert.Equal(t, "upstream returned status 500", relay.Status.Message)
+	assert.Equal(t, relay.TraceId, query.TraceId)
+	assert.Equal(t, relay.SpanId, query.ParentSpanId)
+	assert.Equal(t, SpanKindClient, query.Kind)
+	attributes := map[string]map[string]interface{}{}
+	for _, attribute := range query.Attributes {
+		attributes[attribute.Key] = attribute.Value
+	}
+	assert.Equal(t, "3", attributes["db.rows_affected"]["intValue"])
+	assert.Equal(t, "sqlite", attributes["db.system"]["stringValue"])
+	assert.NotEqual(t, "0", relay.StartTimeUnixNano)
+	assert.GreaterOrEqual(t, relay.EndTimeUnixNano, relay.StartTimeUnixNano)
+}
+
+func TestTransportPropagatesTraceContext(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("traceparent"))
+	}))
+	defer upstream.Close()
+
+	Enabled = true
+	defer func() { Enabled = false }()
+	client := &http.Client{Transport: NewTransport(http.DefaultTransport)}
+
+	ctx, root := Start(context.Background(), "relay", SpanKindServer)
+	defer root.End()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL+"/v1/chat/completions?key=secret", nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	parts := strings.Split(string(body), "-")
+	require.Len(t, parts, 4)
+	assert.Equal(t, "00", parts[0])
+	assert.Equal(t, root.TraceId(), parts[1])
+	assert.Equal(t, "01", parts[3])
+	// 上游看到的父 span 是客户端 span 而不是 relay span
+	assert.NotEqual(t, hexSpanId(root), parts[2])
+
+	// 请求不带 span 时不注入
+	req, err = http.NewRequest(http.MethodGet, upstream.URL, nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Empty(t, string(body))
+}
+
+func hexSpanId(span *Span) string {
+	return toOtlpSpan(span).SpanId
+}
@@ -0,0 +1,159 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// StartGinSpan 创建子 span 并替换 c.Request 的上下文，使期间的上游请求挂在该 span 下，
+// 返回的 end 结束 span 并恢复原上下文
+func StartGinSpan(c *gin.Context, name string, kind int) (span *Span, end func()) {
+	parentCtx := c.Request.Context()
+	ctx, span := Start(parentCtx, name, kind)
+	if span == nil {
+		return nil, func() {}
+	}
+	c.Request = c.Request.WithContext(ctx)
+	return span, func() {
+		span.End()
+		c.Request = c.Request.WithContext(parentCtx)
+	}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+// NewTransport 为上游请求创建客户端 span 并传播 W3C trace context，请求的 context 需携带 span
+func NewTransport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !Enabled || FromContext(req.Context()) == nil {
+		return t.base.RoundTrip(req)
+	}
+	ctx, span := Start(req.Context(), "HTTP "+req.Method, SpanKindClient)
+	defer span.End()
+	// 只记录 host 与 path，部分渠道的密钥放在 query 中
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.path", req.URL.Path)
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err.Error())
+		return resp, err
+	}
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetError("upstream returned status " + strconv.Itoa(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+const dbSpanKey = "tracing:span"
+
+// InstrumentDB 为携带 span 的数据库操作（DB.WithContext(ctx)）创建子 span
+func InstrumentDB(db *gorm.DB) {
+	_ = db.Callback().Create().Before("gorm:create").Register("tracing:before_create", beforeDBOperation("create"))
+	_ = db.Callback().Create().After("gorm:create").Register("tracing:after_create", afterDBOperation)
+	_ = db.Callback().Query().Before("gorm:query").Register("tracing:before_query", beforeDBOperation("query"))
+	_ = db.Callback().Query().After("gorm:query").Register("tracing:after_query", afterDBOperation)
+	_ = db.Callback().Update().Before("gorm:update").Register("tracing:before_update", beforeDBOperation("update"))
+	_ = db.Callback().Update().After("gorm:update").Register("tracing:after_update", afterDBOperation)
+	_ = db.Callback().Delete().Before("gorm:delete").Register("tracing:before_delete", beforeDBOperation("delete"))
+	_ = db.Callback().Delete().After("gorm:delete").Register("tracing:after_delete", afterDBOperation)
+	_ = db.Callback().Row().Before("gorm:row").Register("tracing:before_row", beforeDBOperation("row"))
+	_ = db.Callback().Row().After("gorm:row").Register("tracing:after_row", afterDBOperation)
+	_ = db.Callback().Raw().Before("gorm:raw").Register("tracing:before_raw", beforeDBOperation("raw"))
+	_ = db.Callback().Raw().After("gorm:raw").Register("tracing:after_raw", afterDBOperation)
+}
+
+func beforeDBOperation(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		if !Enabled || FromContext(tx.Statement.Context) == nil {
+			return
+		}
+		_, span := Start(tx.Statement.Context, "db."+operation, SpanKindClient)
+		span.SetAttribute("db.system", tx.Dialector.Name())
+		span.SetAttribute("db.operation", operation)
+		span.SetAttribute("db.sql.table", tx.Statement.Table)
+		tx.InstanceSet(dbSpanKey, span)
+	}
+}
+
+func afterDBOperation(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(dbSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(*Span)
+	span.SetAttribute("db.statement", tx.Statement.SQL.String())
+	span.SetAttribute("db.rows_affected", tx.Statement.RowsAffected)
+	if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+		span.SetError(tx.Error.Error())
+	}
+	span.End()
+}
+
+type redisHook struct{}
+
+func (redisHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if !Enabled || FromContext(ctx) == nil {
+		return ctx, nil
+	}
+	ctx, span := Start(ctx, "redis."+cmd.Name(), SpanKindClient)
+	span.SetAttribute("db.system", "redis")
+	span.SetAttribute("db.operation", cmd.Name())
+	return ctx, nil
+}
+
+func (redisHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endRedisSpan(ctx, cmd.Err())
+	return nil
+}
+
+func (redisHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if !Enabled || FromContext(ctx) == nil {
+		return ctx, nil
+	}
+	ctx, span := Start(ctx, "redis.pipeline", SpanKindClient)
+	span.SetAttribute("db.system", "redis")
+	span.SetAttribute("db.redis.commands", len(cmds))
+	return ctx, nil
+}
+
+func (redisHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			err = cmd.Err()
+			break
+		}
+	}
+	endRedisSpan(ctx, err)
+	return nil
+}
+
+func endRedisSpan(ctx context.Context, err error) {
+	span := FromContext(ctx)
+	if span == nil {
+		return
+	}
+	if err != nil && err != redis.Nil {
+		span.SetError(err.Error())
+	}
+	span.End()
+}
+
+// InstrumentRedis 为携带 span 的 Redis 命令创建子 span
+func InstrumentRedis(client *redis.Client) {
+	client.AddHook(redisHook{})
+}
@@ -0,0 +1,200 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Enabled 未启用时 Start 返回 nil span，所有埋点均为空操作
+var Enabled = false
+
+// SampleRatio 新建 trace 的采样比例，继承自上游的 trace 沿用其采样标记
+var SampleRatio = 1.0
+
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+)
+
+const (
+	statusUnset = 0
+	statusOk    = 1
+	statusError = 2
+)
+
+type Span struct {
+	traceId      [16]byte
+	spanId       [8]byte
+	parentSpanId [8]byte
+	sampled      bool
+	remote       bool
+	name         string
+	kind         int
+	start        time.Time
+	end          time.Time
+
+	lock          sync.Mutex
+	ended         bool
+	attributes    map[string]interface{}
+	statusCode    int
+	statusMessage string
+}
+
+type spanKey struct{}
+
+// Start 以 ctx 中的 span 为父 span 创建新 span，ctx 中没有 span 时开启新的 trace
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if !Enabled {
+		return ctx, nil
+	}
+	span := &Span{name: name, kind: kind, start: time.Now()}
+	if parent := FromContext(ctx); parent != nil {
+		span.traceId = parent.traceId
+		span.parentSpanId = parent.spanId
+		span.sampled = parent.sampled
+	} else {
+		_, _ = rand.Read(span.traceId[:])
+		span.sampled = sample()
+	}
+	_, _ = rand.Read(span.spanId[:])
+	return ContextWithSpan(ctx, span), span
+}
+
+func sample() bool {
+	if SampleRatio >= 1 {
+		return true
+	}
+	if SampleRatio <= 0 {
+		return false
+	}
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return float64(binary.BigEndian.Uint64(b[:])>>11)/float64(1<<53) < SampleRatio
+}
+
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// detachedContext 保留父 context 中的值（span、请求 ID），但没有截止时间也不会被取消
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// DetachedContext 返回不随客户端断开而取消的 context，用于上游请求和响应发送后的扣费，仍携带 span 与请求 ID
+func DetachedContext(ctx context.Context) context.Context {
+	if _, ok := ctx.(detachedContext); ok {
+		return ctx
+	}
+	return detachedContext{parent: ctx}
+}
+
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.statusCode = statusError
+	s.statusMessage = message
+}
+
+// End 结束 span 并提交导出，重复调用只有第一次生效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.lock.Unlock()
+	if s.sampled && !s.remote {
+		enqueue(s)
+	}
+}
+
+func (s *Span) TraceId() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceId[:])
+}
+
+// Extract 解析 W3C traceparent 请求头，得到的远端 span 作为本服务 span 的父 span
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if !Enabled {
+		return ctx
+	}
+	parts := strings.Split(strings.TrimSpace(header.Get("traceparent")), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	span := &Span{remote: true}
+	if _, err := hex.Decode(span.traceId[:], []byte(parts[1])); err != nil || span.traceId == [16]byte{} {
+		return ctx
+	}
+	if _, err := hex.Decode(span.spanId[:], []byte(parts[2])); err != nil || span.spanId == [8]byte{} {
+		return ctx
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return ctx
+	}
+	span.sampled = flags[0]&1 == 1
+	return ContextWithSpan(ctx, span)
+}
+
+// Inject 将 ctx 中的 span 写入 W3C traceparent 请求头
+func Inject(ctx context.Context, header http.Header) {
+	span := FromContext(ctx)
+	if span == nil {
+		return
+	}
+	flags := "00"
+	if span.sampled {
+		flags = "01"
+	}
+	header.Set("traceparent", fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(span.traceId[:]), hex.EncodeToString(span.spanId[:]), flags))
+}
@@ -0,0 +1,30 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testKey struct{}
+
+func TestDetachedContext(t *testing.T) {
+	Enabled = true
+	defer func() { Enabled = false }()
+	parent, cancel := context.WithCancel(context.WithValue(context.Background(), testKey{}, "request-1"))
+	ctx, span := Start(parent, "relay", SpanKindServer)
+	defer span.End()
+	detached := DetachedContext(ctx)
+	cancel()
+
+	assert.Error(t, ctx.Err())
+	assert.NoError(t, detached.Err())
+	assert.Nil(t, detached.Done())
+	_, ok := detached.Deadline()
+	assert.False(t, ok)
+	// 请求 ID 等值和 span 都保留
+	assert.Equal(t, "request-1", detached.Value(testKey{}))
+	assert.Equal(t, span, FromContext(detached))
+	assert.Equal(t, detached, DetachedContext(detached))
+}
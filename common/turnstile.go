@@ -0,0 +1,39 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+type turnstileCheckResponse struct {
+	Success bool `json:"success"`
+}
+
+// VerifyTurnstile 向 Cloudflare 校验 Turnstile token
+func VerifyTurnstile(response string, remoteIp string) error {
+	if response == "" {
+		return errors.New("Turnstile token 为空")
+	}
+	rawRes, err := http.PostForm("https://challenges.cloudflare.com/turnstile/v0/siteverify", url.Values{
+		"secret":   {TurnstileSecretKey},
+		"response": {response},
+		"remoteip": {remoteIp},
+	})
+	if err != nil {
+		SysError(err.Error())
+		return err
+	}
+	defer rawRes.Body.Close()
+	var res turnstileCheckResponse
+	err = json.NewDecoder(rawRes.Body).Decode(&res)
+	if err != nil {
+		SysError(err.Error())
+		return err
+	}
+	if !res.Success {
+		return errors.New("Turnstile 校验失败，请刷新重试！")
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAllAdminRoles(c *gin.Context) {
+	roles, err := model.GetAllAdminRoles()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    roles,
+	})
+}
+
+// GetPermissions 列出全部可分配的权限
+func GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.AllPermissions,
+	})
+}
+
+// GetSelfPermissions 当前用户拥有的权限，值为 true 表示只读
+func GetSelfPermissions(c *gin.Context) {
+	permissions, err := model.GetUserPermissions(c.GetInt("id"), c.GetInt("role"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    permissions,
+	})
+}
+
+func AddAdminRole(c *gin.Context) {
+	role := model.AdminRole{}
+	err := c.ShouldBindJSON(&role)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if role.Name == "" || len(role.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "角色名称长度必须在 1-64 之间",
+		})
+		return
+	}
+	if err := model.ValidatePermissions(role.Permissions); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanRole := model.AdminRole{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: role.Permissions,
+	}
+	err = cleanRole.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanRole,
+	})
+}
+
+func UpdateAdminRole(c *gin.Context) {
+	role := model.AdminRole{}
+	err := c.ShouldBindJSON(&role)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := model.ValidatePermissions(role.Permissions); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanRole, err := model.GetAdminRoleById(role.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// If you add more fields, please also update role.Update()
+	cleanRole.Name = role.Name
+	cleanRole.Description = role.Description
+	cleanRole.Permissions = role.Permissions
+	err = cleanRole.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanRole,
+	})
+}
+
+func DeleteAdminRole(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteAdminRoleById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// AssignAdminRole 为用户分配命名角色，role_id 为 0 时收回
+func AssignAdminRole(c *gin.Context) {
+	var req struct {
+		UserId int `json:"user_id"`
+		RoleId int `json:"role_id"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = model.AssignAdminRole(req.UserId, req.RoleId)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
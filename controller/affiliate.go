@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSelfAffCommissions 查看自己的返佣明细及汇总
+func GetSelfAffCommissions(c *gin.Context) {
+	getAffCommissions(c, c.GetInt("id"))
+}
+
+// GetAffiliateCommissions 管理员查看指定邀请人的返佣明细及汇总
+func GetAffiliateCommissions(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	getAffCommissions(c, id)
+}
+
+func getAffCommissions(c *gin.Context, affiliateId int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	commissions, err := model.GetAffCommissions(affiliateId, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	report, err := model.GetAffEarningsReport(affiliateId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    commissions,
+		"report":  report,
+	})
+}
+
+// GetAffEarningsReports 按邀请人汇总的返佣报表
+func GetAffEarningsReports(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	reports, err := model.GetAffEarningsReports(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    reports,
+	})
+}
+
+// SetAffFlag 标记或解除用户的疑似自我邀请
+func SetAffFlag(c *gin.Context) {
+	var req struct {
+		UserId  int  `json:"user_id"`
+		Flagged bool `json:"flagged"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = model.SetUserAffFlagged(req.UserId, req.Flagged)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -337,10 +337,10 @@ func UpdateAllChannelsBalance(c *gin.Context) {
 }
 
 func AutomaticallyUpdateChannels(frequency int) {
-	for {
-		time.Sleep(time.Duration(frequency) * time.Minute)
+	model.RunLeaderJobEvery("channel_balance", time.Duration(frequency)*time.Minute, func() error {
 		common.SysLog("updating all channels")
-		_ = updateAllChannelsBalance()
+		err := updateAllChannelsBalance()
 		common.SysLog("channels update done")
-	}
+		return err
+	})
 }
@@ -359,22 +359,25 @@ func AutomaticallyTestDisabledChannels(frequency int) {
 
 	for range ticker.C {
 		//common.SysLog("Testing all auto-disabled channels")
-		channels, err := model.GetAllChannels(0, 0, true, false)
-		if err != nil {
-			common.SysError(fmt.Sprintf("Error retrieving channels: %s", err.Error()))
-			continue
-		}
+		model.RunLeaderJob("disabled_channel_test", func() error {
+			channels, err := model.GetAllChannels(0, 0, true, false)
+			if err != nil {
+				common.SysError(fmt.Sprintf("Error retrieving channels: %s", err.Error()))
+				return err
+			}
 
-		currentTime := time.Now()
-		for _, channel := range channels {
-			// 如果通道状态为自动禁用并且TestedTime不为空则执行测试
-			if channel.Status == common.ChannelStatusAutoDisabled && channel.TestedTime != nil && *channel.TestedTime > 0 {
-				testInterval := time.Duration(*channel.TestedTime) * time.Second // 解引用并转换到time.Duration
+			currentTime := time.Now()
+			for _, channel := range channels {
+				// 如果通道状态为自动禁用并且TestedTime不为空则执行测试
+				if channel.Status == common.ChannelStatusAutoDisabled && channel.TestedTime != nil && *channel.TestedTime > 0 {
+					testInterval := time.Duration(*channel.TestedTime) * time.Second // 解引用并转换到time.Duration
 
-				// 执行测试并更新下一次测试的预定时间
-				go testChannelAndHandleResult(channel, testInterval, currentTime)
+					// 执行测试并更新下一次测试的预定时间
+					go testChannelAndHandleResult(channel, testInterval, currentTime)
+				}
 			}
-		}
+			return nil
+		})
 	}
 }
 
@@ -434,10 +437,10 @@ func notifyWxPusherEnabled(channel *model.Channel) {
 }
 
 func AutomaticallyTestChannels(frequency int) {
-	for {
-		time.Sleep(time.Duration(frequency) * time.Minute)
+	model.RunLeaderJobEvery("channel_test", time.Duration(frequency)*time.Minute, func() error {
 		common.SysLog("testing all channels")
-		_ = testAllChannels(false)
+		err := testAllChannels(false)
 		common.SysLog("channel test finished")
-	}
+		return err
+	})
 }
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAllCoupons(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	coupons, err := model.GetAllCoupons(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    coupons,
+	})
+}
+
+func SearchCoupons(c *gin.Context) {
+	coupons, err := model.SearchCoupons(c.Query("keyword"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    coupons,
+	})
+}
+
+func GetCoupon(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	coupon, err := model.GetCouponById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    coupon,
+	})
+}
+
+// AddCoupon 指定 code 时创建单个优惠码，否则按 count 批量生成随机优惠码
+func AddCoupon(c *gin.Context) {
+	coupon := model.Coupon{}
+	err := c.ShouldBindJSON(&coupon)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(coupon.Campaign) == 0 || len(coupon.Campaign) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "活动名称长度必须在1-64之间",
+		})
+		return
+	}
+	if err = coupon.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := model.NormalizeCouponCode(coupon.Code)
+	if code != "" {
+		coupon.Count = 1
+	}
+	if coupon.Count <= 0 || coupon.Count > 100 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "一次批量生成的优惠码个数必须在1-100之间",
+		})
+		return
+	}
+	var codes []string
+	for i := 0; i < coupon.Count; i++ {
+		cleanCoupon := model.Coupon{
+			Code:           code,
+			Campaign:       coupon.Campaign,
+			Status:         common.CouponStatusEnabled,
+			DiscountType:   coupon.DiscountType,
+			DiscountValue:  coupon.DiscountValue,
+			BonusQuota:     coupon.BonusQuota,
+			MinMoney:       coupon.MinMoney,
+			AllowedGroups:  coupon.AllowedGroups,
+			MaxUses:        coupon.MaxUses,
+			MaxUsesPerUser: coupon.MaxUsesPerUser,
+			StartTime:      coupon.StartTime,
+			EndTime:        coupon.EndTime,
+			CreatedTime:    common.GetTimestamp(),
+		}
+		if cleanCoupon.Code == "" {
+			cleanCoupon.Code = common.GetRandomString(12)
+		}
+		err = cleanCoupon.Insert()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+				"data":    codes,
+			})
+			return
+		}
+		codes = append(codes, cleanCoupon.Code)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    codes,
+	})
+}
+
+func UpdateCoupon(c *gin.Context) {
+	statusOnly := c.Query("status_only")
+	coupon := model.Coupon{}
+	err := c.ShouldBindJSON(&coupon)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanCoupon, err := model.GetCouponById(coupon.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if statusOnly != "" {
+		cleanCoupon.Status = coupon.Status
+	} else {
+		// If you add more fields, please also update coupon.Update()
+		cleanCoupon.Campaign = coupon.Campaign
+		cleanCoupon.Status = coupon.Status
+		cleanCoupon.DiscountType = coupon.DiscountType
+		cleanCoupon.DiscountValue = coupon.DiscountValue
+		cleanCoupon.BonusQuota = coupon.BonusQuota
+		cleanCoupon.MinMoney = coupon.MinMoney
+		cleanCoupon.AllowedGroups = coupon.AllowedGroups
+		cleanCoupon.MaxUses = coupon.MaxUses
+		cleanCoupon.MaxUsesPerUser = coupon.MaxUsesPerUser
+		cleanCoupon.StartTime = coupon.StartTime
+		cleanCoupon.EndTime = coupon.EndTime
+		if err = cleanCoupon.Validate(); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	err = cleanCoupon.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanCoupon,
+	})
+}
+
+func DeleteCoupon(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteCouponById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetCouponUsages(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	usages, err := model.GetCouponUsages(id, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    usages,
+	})
+}
+
+// GetCouponReports 按活动汇总优惠码使用情况，可用 campaign 参数筛选
+func GetCouponReports(c *gin.Context) {
+	reports, err := model.GetCouponCampaignReports(c.Query("campaign"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    reports,
+	})
+}
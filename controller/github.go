@@ -87,8 +87,7 @@ func GitHubOAuth(c *gin.Context) {
 		})
 		return
 	}
-	username := session.Get("username")
-	if username != nil {
+	if hasValidSession(c) {
 		GitHubBind(c)
 		return
 	}
@@ -186,10 +185,8 @@ func GitHubBind(c *gin.Context) {
 		})
 		return
 	}
-	session := sessions.Default(c)
-	id := session.Get("id")
 	// id := c.GetInt("id")  // critical bug!
-	user.Id = id.(int)
+	user.Id = sessionUserId(c)
 	err = user.FillUserById()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Liveness 进程存活即返回成功，排空期间也不应被重启
+func Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"status":  "alive",
+	})
+}
+
+// Readiness 排空中或数据库不可用时返回 503，负载均衡不再转发新请求
+func Readiness(c *gin.Context) {
+	if common.IsDraining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"status":  "draining",
+		})
+		return
+	}
+	sqlDB, err := model.DB.DB()
+	if err == nil {
+		err = sqlDB.PingContext(c.Request.Context())
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"success": false,
+			"status":  "database unavailable",
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"status":  "ready",
+	})
+}
@@ -0,0 +1,33 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetJobStatuses(c *gin.Context) {
+	jobs, err := model.GetAllJobStatuses()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	leader, leaseExpiresAt := model.GetLeaderLease()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"node_id":          common.NodeId,
+			"is_leader":        model.IsLeader(),
+			"leader":           leader,
+			"lease_expires_at": leaseExpiresAt,
+			"jobs":             jobs,
+		},
+	})
+	return
+}
@@ -0,0 +1,48 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetQuotaLedgerEntries(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	accountId, _ := strconv.Atoi(c.Query("account_id"))
+	entries, err := model.GetQuotaLedgerEntries(c.Query("account_type"), accountId, c.Query("type"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    entries,
+	})
+}
+
+// ReconcileQuotaLedger 立即核对余额与流水，返回存在差异的账户
+func ReconcileQuotaLedger(c *gin.Context) {
+	result, err := model.ReconcileQuotaLedger()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    result,
+	})
+}
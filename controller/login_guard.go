@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notifySuspiciousLogin 异步发送可疑登录提醒邮件，未绑定邮箱时只记录系统日志
+func notifySuspiciousLogin(user *model.User, ip string, reason string) {
+	common.SysLog(fmt.Sprintf("suspicious login for user %d from %s: %s", user.Id, ip, reason))
+	if user.Email == "" {
+		return
+	}
+	email := user.Email
+	subject := fmt.Sprintf("%s账户安全提醒", common.SystemName)
+	content := fmt.Sprintf("<p>您好，%s</p>"+
+		"<p>%s</p>"+
+		"<p>登录 IP：%s，时间：%s</p>"+
+		"<p>如果不是本人操作，请尽快修改密码并启用两步验证。</p>",
+		user.Username, reason, ip, time.Now().Format("2006-01-02 15:04:05"))
+	go func() {
+		if err := common.SendEmail(subject, email, content); err != nil {
+			common.SysError("failed to send suspicious login email: " + err.Error())
+		}
+	}()
+}
+
+// UnlockUserLogin 管理员清除用户的登录失败记录并解除锁定
+func UnlockUserLogin(c *gin.Context) {
+	user, ok := getManagedUser(c)
+	if !ok {
+		return
+	}
+	model.ResetLoginFailures(model.LoginGuardKey(user.Username))
+	model.RecordLog(user.Id, model.LogTypeManage, 0, "管理员解除了登录锁定")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetUserLoginFailures 管理员查看用户的登录失败次数和锁定状态
+func GetUserLoginFailures(c *gin.Context) {
+	user, ok := getManagedUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetLoginFailureState(model.LoginGuardKey(user.Username)),
+	})
+}
@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestDB 每个测试使用独立的内存 SQLite 库并执行完整迁移
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	common.SQLitePath = fmt.Sprintf("file:controller_%s?mode=memory&cache=shared", name)
+	common.IsMasterNode = true
+	common.RedisEnabled = false
+	common.BatchUpdateEnabled = false
+	require.NoError(t, model.InitDB())
+	t.Cleanup(func() {
+		_ = model.CloseDB()
+	})
+}
@@ -0,0 +1,15 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"one-api/common/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Metrics(c *gin.Context) {
+	var buf bytes.Buffer
+	metrics.WriteText(&buf)
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
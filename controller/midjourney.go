@@ -37,18 +37,21 @@ func UpdateMidjourneyTask() {
 	for {
 		time.Sleep(time.Duration(15) * time.Second)
 
-		tasks := model.GetAllUnFinishTasks()
+		model.RunLeaderJob("midjourney_task", func() error {
+			tasks := model.GetAllUnFinishTasks()
 
-		if len(tasks) == 0 {
-			continue
-		}
-		common.LogInfo(ctx, fmt.Sprintf("检测到未完成的任务数有: %v", len(tasks)))
-		// 尝试批量更新, 如果成功则跳过单个任务更新
-		success := UpdateMidjourneyTaskAll(ctx, tasks)
-		if !success {
-			// 批量更新失败,再并发更新单个任务
-			ConcurrentUpdateMidjourneyTasks(ctx, tasks)
-		}
+			if len(tasks) == 0 {
+				return nil
+			}
+			common.LogInfo(ctx, fmt.Sprintf("检测到未完成的任务数有: %v", len(tasks)))
+			// 尝试批量更新, 如果成功则跳过单个任务更新
+			success := UpdateMidjourneyTaskAll(ctx, tasks)
+			if !success {
+				// 批量更新失败,再并发更新单个任务
+				ConcurrentUpdateMidjourneyTasks(ctx, tasks)
+			}
+			return nil
+		})
 	}
 }
 
@@ -371,7 +374,7 @@ func compensateForTaskFailure(ctx context.Context, task *model.Midjourney) {
 		ratio := modelRatio * groupRatio
 		quota := int(ratio * common.QuotaPerUnit)
 		if quota != 0 {
-			err := model.IncreaseUserQuota(task.UserId, quota)
+			err := model.IncreaseUserQuota(task.UserId, quota, model.LedgerRef{Type: model.LedgerTypeRefund, RefType: model.LedgerRefMidjourney, RefId: task.Id})
 			if err != nil {
 				log.Println("fail to increase user quota")
 			}
@@ -37,6 +37,7 @@ func GetStatus(c *gin.Context) {
 			"enable_batch_update": common.BatchUpdateEnabled,
 			"enable_drawing":      common.DrawingEnabled,
 			"enable_data_export":  common.DataExportEnabled,
+			"oidc_providers":      model.GetEnabledOidcProviders(),
 		},
 	})
 	return
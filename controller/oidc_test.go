@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"one-api/common"
+	"one-api/model"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockIdp 模拟 OIDC 提供方，groups 为 userinfo 返回的分组声明
+func newMockIdp(t *testing.T, nonce *string, groups *[]string) *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "good-code", r.FormValue("code"))
+		assert.Equal(t, "client", r.FormValue("client_id"))
+		assert.Equal(t, "secret", r.FormValue("client_secret"))
+		idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss":   server.URL,
+			"aud":   "client",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": *nonce,
+			"sub":   "subject-1",
+		}).SignedString([]byte("unused"))
+		assert.NoError(t, err)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"id_token":     idToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer access", r.Header.Get("Authorization"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub":                "subject-1",
+			"preferred_username": "alice",
+			"groups":             *groups,
+		})
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+type oidcTestResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func getOidcTestResponse(t *testing.T, client *http.Client, url string) (int, *oidcTestResponse) {
+	t.Helper()
+	res, err := client.Get(url)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	var response oidcTestResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+	return res.StatusCode, &response
+}
+
+func TestOidcAuth(t *testing.T) {
+	setupTestDB(t)
+	common.RegisterEnabled = true
+	var nonce string
+	groups := []string{"vip-users"}
+	idp := newMockIdp(t, &nonce, &groups)
+	provider := &model.OidcProvider{
+		Slug:          "mock",
+		Name:          "Mock",
+		Status:        common.OidcProviderStatusEnabled,
+		DiscoveryUrl:  idp.URL,
+		ClientId:      "client",
+		ClientSecret:  "secret",
+		UsernameClaim: "preferred_username",
+		GroupClaim:    "groups",
+		GroupMapping:  `{"vip-users":"vip","svip-users":"svip"}`,
+	}
+	require.NoError(t, provider.Insert())
+
+	engine := gin.New()
+	engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("test"))))
+	engine.GET("/api/oauth/oidc/:slug/url", GetOidcAuthorizeUrl)
+	engine.GET("/api/oauth/oidc/:slug", OidcAuth)
+	app := httptest.NewServer(engine)
+	defer app.Close()
+
+	// 每次登录使用独立的浏览器会话
+	login := func() (*http.Client, string, *oidcTestResponse) {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		client := &http.Client{Jar: jar}
+		_, response := getOidcTestResponse(t, client, app.URL+"/api/oauth/oidc/mock/url")
+		require.True(t, response.Success, response.Message)
+		var authorizeUrl string
+		require.NoError(t, json.Unmarshal(response.Data, &authorizeUrl))
+		parsed, err := url.Parse(authorizeUrl)
+		require.NoError(t, err)
+		nonce = parsed.Query().Get("nonce")
+		state := parsed.Query().Get("state")
+		_, response = getOidcTestResponse(t, client, app.URL+"/api/oauth/oidc/mock?code=good-code&state="+state)
+		return client, state, response
+	}
+
+	client, state, response := login()
+	require.True(t, response.Success, response.Message)
+	userId := model.GetUserIdByOidcSubject(provider.Id, "subject-1")
+	require.NotZero(t, userId)
+	user, err := model.GetUserById(userId, false)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, "vip", user.Group)
+
+	// state 已被消费，重放回调会被拒绝
+	status, response := getOidcTestResponse(t, client, app.URL+"/api/oauth/oidc/mock?code=good-code&state="+state)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.False(t, response.Success)
+
+	// 限时分组生效期间不按声明覆盖分组
+	require.NoError(t, model.DB.Create(&model.GroupGrant{
+		UserId:        userId,
+		Group:         "vip",
+		PreviousGroup: "default",
+		Status:        model.GroupGrantStatusActive,
+		ExpiresAt:     common.GetTimestamp() + 3600,
+	}).Error)
+	groups = []string{"svip-users"}
+	_, _, response = login()
+	require.True(t, response.Success, response.Message)
+	group, err := model.GetUserGroup(userId)
+	require.NoError(t, err)
+	assert.Equal(t, "vip", group)
+
+	// 限时分组结束后恢复同步
+	require.NoError(t, model.DB.Model(&model.GroupGrant{}).Where("user_id = ?", userId).Update("status", model.GroupGrantStatusExpired).Error)
+	_, _, response = login()
+	require.True(t, response.Success, response.Message)
+	group, err = model.GetUserGroup(userId)
+	require.NoError(t, err)
+	assert.Equal(t, "svip", group)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -34,8 +35,8 @@ func GetOptions(c *gin.Context) {
 func GetUserOptions(c *gin.Context) {
 	var options []*model.Option
 	common.OptionMapRWMutex.RLock() // 使用读锁
-	keys := []string{"TopUpLink", "YzfZfb", "YzfWx", "BillingByRequestEnabled", "ModelRatioEnabled",
-		"MiniQuota", "ProporTions", "TopupRatio",
+	keys := []string{"TopUpLink", "YzfZfb", "YzfWx", "StripeEnabled", "BillingByRequestEnabled", "ModelRatioEnabled",
+		"MiniQuota", "ProporTions", "TopupRatio", "AffCommissionEnabled", "AffCommissionBase", "AffCommissionHoldDays",
 		"LogContentEnabled", "TopupAmount", "TopupRatioEnabled", "TopupAmountEnabled"}
 
 	for _, key := range keys {
@@ -99,6 +100,38 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "LoginMaxFailures", "LoginLockoutMinutes", "LoginTurnstileThreshold":
+		if value, err := strconv.Atoi(option.Value); err != nil || value < 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "登录保护参数必须是非负整数",
+			})
+			return
+		}
+	case "TwoFactorRequiredPermission":
+		if _, err := strconv.Atoi(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "两步验证强制角色必须是整数",
+			})
+			return
+		}
+	case "AffCommissionRates":
+		if err := model.ValidateAffCommissionRates(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "AffCommissionBase":
+		if option.Value != model.AffCommissionBaseTopup && option.Value != model.AffCommissionBaseConsume && option.Value != model.AffCommissionBaseBoth {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "返佣基数只能是 topup、consume 或 both",
+			})
+			return
+		}
 	}
 	err = model.UpdateOption(option.Key, option.Value)
 	if err != nil {
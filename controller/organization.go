@@ -0,0 +1,396 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// orgUsageScope 解析 org_id 查询参数。未指定时只查看自己的数据；可查看组织用量的角色查看全部成员，普通成员只能查看自己在组织中的数据
+func orgUsageScope(c *gin.Context) (userId int, orgId int, err error) {
+	userId = c.GetInt("id")
+	orgId, _ = strconv.Atoi(c.Query("org_id"))
+	if orgId == 0 {
+		return userId, 0, nil
+	}
+	member, err := model.GetOrgMember(orgId, userId)
+	if err != nil {
+		return 0, 0, err
+	}
+	if member.CanViewOrgUsage() {
+		return 0, orgId, nil
+	}
+	return userId, orgId, nil
+}
+
+// currentOrgMember 获取当前用户在路径参数 :id 对应组织中的成员身份，失败时已写入响应
+func currentOrgMember(c *gin.Context) (*model.OrgMember, bool) {
+	orgId, _ := strconv.Atoi(c.Param("id"))
+	member, err := model.GetOrgMember(orgId, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+	return member, true
+}
+
+// checkOrgRoleChange 只有所有者可以授予或变更所有者、管理员角色
+func checkOrgRoleChange(operator *model.OrgMember, from string, to string) error {
+	if err := model.ValidateOrgRole(to); err != nil {
+		return err
+	}
+	if !operator.CanManageOrgMembers() {
+		return errors.New("无权管理组织成员")
+	}
+	if operator.Role == model.OrgRoleOwner {
+		return nil
+	}
+	for _, role := range []string{from, to} {
+		if role == model.OrgRoleOwner || role == model.OrgRoleAdmin {
+			return errors.New("只有所有者可以设置所有者或管理员")
+		}
+	}
+	return nil
+}
+
+func GetSelfOrganizations(c *gin.Context) {
+	organizations, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organizations,
+	})
+}
+
+func GetOrganization(c *gin.Context) {
+	member, ok := currentOrgMember(c)
+	if !ok {
+		return
+	}
+	organization, err := model.GetOrganizationById(member.OrgId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	organization.Role = member.Role
+	members, err := model.GetOrgMembers(member.OrgId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"organization": organization,
+			"members":      members,
+		},
+	})
+}
+
+func CreateOrganization(c *gin.Context) {
+	organization := model.Organization{}
+	err := c.ShouldBindJSON(&organization)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if organization.Name == "" || len(organization.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织名称长度必须在 1-64 之间",
+		})
+		return
+	}
+	cleanOrganization := model.Organization{
+		Name:    organization.Name,
+		OwnerId: c.GetInt("id"),
+	}
+	err = cleanOrganization.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanOrganization,
+	})
+}
+
+func UpdateOrganization(c *gin.Context) {
+	member, ok := currentOrgMember(c)
+	if !ok {
+		return
+	}
+	organization := model.Organization{}
+	err := c.ShouldBindJSON(&organization)
+	if err == nil && !member.CanManageOrgMembers() {
+		err = errors.New("无权修改组织")
+	}
+	if err == nil && (organization.Name == "" || len(organization.Name) > 64) {
+		err = errors.New("组织名称长度必须在 1-64 之间")
+	}
+	if err == nil {
+		// If you add more fields, please also update organization.Update()
+		err = (&model.Organization{Id: member.OrgId, Name: organization.Name}).Update()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func DeleteOrganization(c *gin.Context) {
+	member, ok := currentOrgMember(c)
+	if !ok {
+		return
+	}
+	var err error
+	if member.Role != model.OrgRoleOwner {
+		err = errors.New("只有所有者可以删除组织")
+	} else {
+		err = model.DeleteOrganizationById(member.OrgId)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+type orgMemberRequest struct {
+	UserId   int    `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	QuotaCap int    `json:"quota_cap"`
+}
+
+func AddOrgMember(c *gin.Context) {
+	operator, ok := currentOrgMember(c)
+	if !ok {
+		return
+	}
+	var req orgMemberRequest
+	err := c.ShouldBindJSON(&req)
+	if err == nil && req.Role == "" {
+		req.Role = model.OrgRoleMember
+	}
+	if err == nil {
+		err = checkOrgRoleChange(operator, "", req.Role)
+	}
+	if err == nil && req.QuotaCap < 0 {
+		err = errors.New("额度上限不能为负数")
+	}
+	user := model.User{Username: req.Username}
+	if err == nil {
+		err = user.FillUserByUsername()
+	}
+	if err == nil && user.Id == 0 {
+		err = errors.New("用户不存在")
+	}
+	if err == nil {
+		if _, memberErr := model.GetOrgMember(operator.OrgId, user.Id); memberErr == nil {
+			err = errors.New("该用户已是组织成员")
+		}
+	}
+	if err == nil {
+		err = (&model.OrgMember{
+			OrgId:    operator.OrgId,
+			UserId:   user.Id,
+			Role:     req.Role,
+			QuotaCap: req.QuotaCap,
+		}).Insert()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func UpdateOrgMember(c *gin.Context) {
+	operator, ok := currentOrgMember(c)
+	if !ok {
+		return
+	}
+	var req orgMemberRequest
+	err := c.ShouldBindJSON(&req)
+	var member *model.OrgMember
+	if err == nil {
+		member, err = model.GetOrgMember(operator.OrgId, req.UserId)
+	}
+	if err == nil {
+		err = checkOrgRoleChange(operator, member.Role, req.Role)
+	}
+	if err == nil && req.QuotaCap < 0 {
+		err = errors.New("额度上限不能为负数")
+	}
+	if err == nil {
+		// If you add more fields, please also update member.Update()
+		member.Role = req.Role
+		member.QuotaCap = req.QuotaCap
+		err = member.Update()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    member,
+	})
+}
+
+// RemoveOrgMember 移除成员，成员也可以退出组织。被移除成员的组织令牌随即无法使用
+func RemoveOrgMember(c *gin.Context) {
+	operator, ok := currentOrgMember(c)
+	if !ok {
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	member, err := model.GetOrgMember(operator.OrgId, userId)
+	if err == nil && userId != operator.UserId {
+		err = checkOrgRoleChange(operator, member.Role, model.OrgRoleMember)
+	}
+	if err == nil {
+		err = model.DeleteOrgMember(operator.OrgId, userId)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// FundOrganization 所有者或财务从个人余额向组织额度池划转
+func FundOrganization(c *gin.Context) {
+	member, ok := currentOrgMember(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Quota int `json:"quota"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err == nil && !member.CanFundOrg() {
+		err = errors.New("只有所有者或财务可以为组织充值")
+	}
+	if err == nil {
+		err = model.FundOrganization(member.OrgId, member.UserId, req.Quota)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetAllOrganizations 管理员查看全部组织
+func GetAllOrganizations(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	organizations, err := model.GetAllOrganizations(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organizations,
+	})
+}
+
+// AdjustOrganizationQuota 管理员增减组织额度池
+func AdjustOrganizationQuota(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req struct {
+		Delta int `json:"delta"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		_, err = model.GetOrganizationById(id)
+	}
+	if err == nil {
+		err = model.AdjustOrganizationQuota(id, req.Delta)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, 0, "调整组织 "+strconv.Itoa(id)+" 额度池 "+common.LogQuota(req.Delta))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
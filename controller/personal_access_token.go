@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetPersonalAccessTokens(c *gin.Context) {
+	pats, err := model.GetUserPersonalAccessTokens(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    pats,
+		"scopes":  model.AllScopes,
+	})
+}
+
+// AddPersonalAccessToken 创建个人访问令牌，完整令牌只在此次响应中返回
+func AddPersonalAccessToken(c *gin.Context) {
+	pat := model.PersonalAccessToken{}
+	err := c.ShouldBindJSON(&pat)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if pat.Name == "" || len(pat.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "令牌名称长度必须在 1-64 之间",
+		})
+		return
+	}
+	if err := model.ValidateScopes(pat.Scopes); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if pat.ExpiredTime == 0 {
+		pat.ExpiredTime = -1
+	}
+	cleanPat := model.PersonalAccessToken{
+		UserId:      c.GetInt("id"),
+		Name:        pat.Name,
+		Scopes:      pat.Scopes,
+		ExpiredTime: pat.ExpiredTime,
+	}
+	key, err := cleanPat.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"token": cleanPat,
+			"key":   key,
+		},
+	})
+}
+
+func RevokePersonalAccessToken(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.RevokePersonalAccessToken(id, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"one-api/common"
@@ -103,15 +106,29 @@ func AddRedemption(c *gin.Context) {
 		})
 		return
 	}
+	if err = redemption.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	batch := common.GetUUID()
 	var keys []string
 	for i := 0; i < redemption.Count; i++ {
 		key := common.GetUUID()
 		cleanRedemption := model.Redemption{
-			UserId:      c.GetInt("id"),
-			Name:        redemption.Name,
-			Key:         key,
-			CreatedTime: common.GetTimestamp(),
-			Quota:       redemption.Quota,
+			UserId:       c.GetInt("id"),
+			Name:         redemption.Name,
+			Key:          key,
+			Batch:        batch,
+			CreatedTime:  common.GetTimestamp(),
+			Type:         redemption.Type,
+			Quota:        redemption.Quota,
+			UpgradeGroup: redemption.UpgradeGroup,
+			Days:         redemption.Days,
+			OnePerUser:   redemption.OnePerUser,
+			ExpiredTime:  redemption.ExpiredTime,
 		}
 		err = cleanRedemption.Insert()
 		if err != nil {
@@ -128,6 +145,7 @@ func AddRedemption(c *gin.Context) {
 		"success": true,
 		"message": "",
 		"data":    keys,
+		"batch":   batch,
 	})
 	return
 }
@@ -173,7 +191,19 @@ func UpdateRedemption(c *gin.Context) {
 	} else {
 		// If you add more fields, please also update redemption.Update()
 		cleanRedemption.Name = redemption.Name
+		cleanRedemption.Type = redemption.Type
 		cleanRedemption.Quota = redemption.Quota
+		cleanRedemption.UpgradeGroup = redemption.UpgradeGroup
+		cleanRedemption.Days = redemption.Days
+		cleanRedemption.OnePerUser = redemption.OnePerUser
+		cleanRedemption.ExpiredTime = redemption.ExpiredTime
+		if err = cleanRedemption.Validate(); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 	}
 	err = cleanRedemption.Update()
 	if err != nil {
@@ -190,3 +220,76 @@ func UpdateRedemption(c *gin.Context) {
 	})
 	return
 }
+
+func GetRedemptionBatches(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	batches, err := model.GetRedemptionBatches(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    batches,
+	})
+}
+
+// ExportRedemptionBatch 以 CSV 导出一个批次的全部兑换码
+func ExportRedemptionBatch(c *gin.Context) {
+	batch := c.Param("batch")
+	redemptions, err := model.GetRedemptionsByBatch(batch)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write([]string{"id", "key", "name", "type", "quota", "upgrade_group", "days", "one_per_user", "status", "expired_time", "created_time", "redeemed_time", "used_user_id"})
+	for _, redemption := range redemptions {
+		_ = writer.Write([]string{
+			strconv.Itoa(redemption.Id),
+			redemption.Key,
+			redemption.Name,
+			redemption.Type,
+			strconv.Itoa(redemption.Quota),
+			redemption.UpgradeGroup,
+			strconv.Itoa(redemption.Days),
+			strconv.FormatBool(redemption.OnePerUser),
+			strconv.Itoa(redemption.Status),
+			strconv.FormatInt(redemption.ExpiredTime, 10),
+			strconv.FormatInt(redemption.CreatedTime, 10),
+			strconv.FormatInt(redemption.RedeemedTime, 10),
+			strconv.Itoa(redemption.UsedUserId),
+		})
+	}
+	writer.Flush()
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=redemption-%s.csv", batch))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// DisableRedemptionBatch 停用一个批次中所有未使用的兑换码
+func DisableRedemptionBatch(c *gin.Context) {
+	count, err := model.DisableRedemptionBatch(c.Param("batch"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    count,
+	})
+}
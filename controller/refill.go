@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAllRefillSchedules(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	targetId, _ := strconv.Atoi(c.Query("target_id"))
+	schedules, err := model.GetAllRefillSchedules(c.Query("target_type"), targetId, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    schedules,
+	})
+}
+
+func AddRefillSchedule(c *gin.Context) {
+	schedule := model.RefillSchedule{}
+	err := c.ShouldBindJSON(&schedule)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanSchedule := model.RefillSchedule{
+		TargetType: schedule.TargetType,
+		TargetId:   schedule.TargetId,
+		Amount:     schedule.Amount,
+		Period:     schedule.Period,
+		Day:        schedule.Day,
+		Hour:       schedule.Hour,
+		Mode:       schedule.Mode,
+		Cap:        schedule.Cap,
+		Status:     common.RefillScheduleStatusEnabled,
+	}
+	err = cleanSchedule.Validate()
+	if err == nil {
+		err = cleanSchedule.Insert()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanSchedule,
+	})
+}
+
+func UpdateRefillSchedule(c *gin.Context) {
+	statusOnly := c.Query("status_only")
+	schedule := model.RefillSchedule{}
+	err := c.ShouldBindJSON(&schedule)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanSchedule, err := model.GetRefillScheduleById(schedule.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if statusOnly != "" {
+		cleanSchedule.Status = schedule.Status
+	} else {
+		// 补充对象不可修改，如需更换请删除后重新创建
+		cleanSchedule.Amount = schedule.Amount
+		cleanSchedule.Period = schedule.Period
+		cleanSchedule.Day = schedule.Day
+		cleanSchedule.Hour = schedule.Hour
+		cleanSchedule.Mode = schedule.Mode
+		cleanSchedule.Cap = schedule.Cap
+	}
+	err = cleanSchedule.Validate()
+	if err == nil {
+		err = cleanSchedule.Update()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanSchedule,
+	})
+}
+
+func DeleteRefillSchedule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteRefillScheduleById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// PreviewRefills 预览未来若干天（默认 7 天，最多 90 天）内将要执行的补充
+func PreviewRefills(c *gin.Context) {
+	days, _ := strconv.Atoi(c.Query("days"))
+	if days <= 0 {
+		days = 7
+	} else if days > 90 {
+		days = 90
+	}
+	previews, err := model.PreviewRefills(time.Now().AddDate(0, 0, days))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    previews,
+	})
+}
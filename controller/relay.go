@@ -6,6 +6,8 @@ import (
 	"io"
 	"net/http"
 	"one-api/common"
+	"one-api/common/metrics"
+	"one-api/common/tracing"
 	"one-api/middleware"
 	"one-api/model"
 	"one-api/relay/channel/midjourney"
@@ -36,10 +38,25 @@ func relay(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCode {
 	return err
 }
 
+// relayAttempt 每次尝试（含重试）对应一个 span，上游请求与扣费挂在该 span 下
+func relayAttempt(c *gin.Context, relayMode int, attempt int) *dbmodel.ErrorWithStatusCode {
+	span, end := tracing.StartGinSpan(c, "relay.attempt", tracing.SpanKindInternal)
+	defer end()
+	span.SetAttribute("attempt", attempt)
+	span.SetAttribute("channel_id", c.GetInt("channel_id"))
+	span.SetAttribute("channel_name", c.GetString("channel_name"))
+	bizErr := relay(c, relayMode)
+	if bizErr != nil {
+		span.SetAttribute("http.status_code", bizErr.StatusCode)
+		span.SetError(bizErr.Message)
+	}
+	return bizErr
+}
+
 func Relay(c *gin.Context) {
 	ctx := c.Request.Context()
 	relayMode := constant.Path2RelayMode(c.Request.URL.Path)
-	bizErr := relay(c, relayMode)
+	bizErr := relayAttempt(c, relayMode, 0)
 	if bizErr == nil {
 		return
 	}
@@ -50,7 +67,7 @@ func Relay(c *gin.Context) {
 	group := c.GetString("group")
 	originalModel := c.GetString("original_model")
 	go processChannelRelayError(c, channelId, channelName, bizErr)
-	requestId := c.GetString("X-Chatapi-Request-Id")
+	requestId := c.GetString(common.RequestIdKey)
 	retryTimes := 0
 	_, ok := c.Get("channelId")
 	if ok {
@@ -64,7 +81,7 @@ func Relay(c *gin.Context) {
 		retryTimes = 0
 	}
 	for i := retryTimes; i > 0; i-- {
-		channel, err := model.CacheGetRandomSatisfiedChannel(group, originalModel)
+		channel, err := model.CacheGetRandomSatisfiedChannel(ctx, group, originalModel)
 		if err != nil {
 			common.Errorf(ctx, "CacheGetRandomSatisfiedChannel failed: %w", err)
 			break
@@ -77,8 +94,10 @@ func Relay(c *gin.Context) {
 		requestBody, err := common.GetRequestBody(c)
 
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-		bizErr = relay(c, relayMode)
+		metrics.RelayRetries.Inc(originalModel)
+		bizErr = relayAttempt(c, relayMode, retryTimes-i+1)
 		if bizErr == nil {
+			metrics.RelayFailovers.Inc(originalModel)
 			return
 		}
 		channelId := c.GetInt("channel_id")
@@ -180,6 +199,7 @@ func RelayMidjourney(c *gin.Context) {
 	}
 }
 func processChannelRelayError(ctx *gin.Context, channelId int, channelName string, err *dbmodel.ErrorWithStatusCode) {
+	metrics.UpstreamErrors.Inc(strconv.Itoa(channelId), strconv.Itoa(err.StatusCode), err.Type)
 	common.Errorf(ctx, "relay error (channel #%d): %s", channelId, err.Message)
 	// https://platform.openai.com/docs/guides/error-codes/api-errors
 	if util.ShouldDisableChannel(&err.Error, err.StatusCode) {
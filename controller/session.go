@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// sessionUserId 用于未经过 UserAuth 的接口取得当前登录的用户，如第三方登录回调中的账户绑定，未登录时返回 0
+func sessionUserId(c *gin.Context) int {
+	sid, _ := sessions.Default(c).Get("sid").(string)
+	id, _ := model.ValidateUserSession(sid, c.ClientIP())
+	return id
+}
+
+func hasValidSession(c *gin.Context) bool {
+	return sessionUserId(c) != 0
+}
+
+// GetSelfSessions 当前用户的活跃会话，current 标记本次请求所用的会话
+func GetSelfSessions(c *gin.Context) {
+	userSessions, err := model.GetUserSessions(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	for _, s := range userSessions {
+		s.Current = s.Id == c.GetString("session_id")
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    userSessions,
+	})
+}
+
+func RevokeSelfSession(c *gin.Context) {
+	err := model.RevokeUserSession(c.GetInt("id"), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// RevokeOtherSelfSessions 退出除当前会话外的全部会话
+func RevokeOtherSelfSessions(c *gin.Context) {
+	err := model.RevokeUserSessions(c.GetInt("id"), c.GetString("session_id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func getManagedUser(c *gin.Context) (*model.User, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return nil, false
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+	myRole := c.GetInt("role")
+	if myRole <= user.Role && myRole != common.RoleRootUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权更新同权限等级或更高权限等级的用户信息",
+		})
+		return nil, false
+	}
+	return user, true
+}
+
+func GetUserSessions(c *gin.Context) {
+	user, ok := getManagedUser(c)
+	if !ok {
+		return
+	}
+	userSessions, err := model.GetUserSessions(user.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    userSessions,
+	})
+}
+
+// RevokeUserSessions 管理员强制用户下线
+func RevokeUserSessions(c *gin.Context) {
+	user, ok := getManagedUser(c)
+	if !ok {
+		return
+	}
+	err := model.RevokeUserSessions(user.Id, "")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(user.Id, model.LogTypeManage, 0, "管理员强制退出了全部会话")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/payment"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SubscribeRequest struct {
+	PlanId        int    `json:"plan_id"`
+	PaymentMethod string `json:"payment_method"`
+	Provider      string `json:"provider"`
+}
+
+// GetPlans 管理员查看全部套餐，普通用户只能看到已启用的套餐
+func GetPlans(c *gin.Context) {
+	plans, err := model.GetAllPlans(c.GetInt("role") < common.RoleAdminUser)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func AddPlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	err := c.ShouldBindJSON(&plan)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = plan.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	plan.Id = 0
+	plan.Status = common.PlanStatusEnabled
+	plan.CreatedTime = common.GetTimestamp()
+	err = plan.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func UpdatePlan(c *gin.Context) {
+	plan := model.SubscriptionPlan{}
+	err := c.ShouldBindJSON(&plan)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPlan, err := model.GetPlanById(plan.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// If you add more fields, please also update plan.Update()
+	cleanPlan.Name = plan.Name
+	cleanPlan.Description = plan.Description
+	cleanPlan.Status = plan.Status
+	cleanPlan.Price = plan.Price
+	cleanPlan.PeriodDays = plan.PeriodDays
+	cleanPlan.Quota = plan.Quota
+	cleanPlan.Group = plan.Group
+	cleanPlan.ModelAllowances = plan.ModelAllowances
+	if err = cleanPlan.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = cleanPlan.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanPlan,
+	})
+}
+
+func DeletePlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeletePlanById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// RequestSubscription 通过支付渠道购买或续费套餐，支付成功后开通
+func RequestSubscription(c *gin.Context) {
+	var req SubscribeRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
+		return
+	}
+	plan, err := model.GetPlanById(req.PlanId)
+	if err != nil || plan.Status != common.PlanStatusEnabled {
+		c.JSON(200, gin.H{"message": "error", "data": "套餐不存在或已停用"})
+		return
+	}
+	provider := payment.GetProvider(req.Provider)
+	if provider == nil || !provider.Enabled() {
+		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
+		return
+	}
+	topUp := newPendingTopUp(provider, &model.TopUp{
+		UserId: c.GetInt("id"),
+		Money:  plan.Price,
+		PlanId: plan.Id,
+	})
+	err = topUp.Insert()
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to create top-up %s: %s", topUp.TradeNo, err.Error()))
+		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
+		return
+	}
+	result, err := createProviderOrder(provider, topUp, req.PaymentMethod)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to create %s order: %s", provider.Name(), err.Error()))
+		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "success", "data": result.Params, "url": result.URL, "provider": provider.Name()})
+}
+
+// GetSelfSubscription 查看自己生效中的订阅及本周期各模型额度用量
+func GetSelfSubscription(c *gin.Context) {
+	subscription, err := model.GetUserSubscription(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var usages []*model.SubscriptionUsage
+	if subscription != nil {
+		usages = model.GetSubscriptionUsages(subscription)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscription,
+		"usages":  usages,
+	})
+}
+
+// UpdateSelfSubscription 取消或恢复续费提醒，订阅仍生效到已付费的到期时间
+func UpdateSelfSubscription(c *gin.Context) {
+	var req struct {
+		Cancelled bool `json:"cancelled"`
+	}
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = model.SetSubscriptionCancelled(c.GetInt("id"), req.Cancelled)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"one-api/common"
@@ -30,6 +31,7 @@ func GetAllTokens(c *gin.Context) {
 		})
 		return
 	}
+	model.FillTokenBudgetUsages(tokens)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -50,6 +52,7 @@ func SearchTokens(c *gin.Context) {
 		})
 		return
 	}
+	model.FillTokenBudgetUsages(tokens)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -76,6 +79,7 @@ func GetToken(c *gin.Context) {
 		})
 		return
 	}
+	token.BudgetUsage = model.GetTokenBudgetUsages(token)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -125,6 +129,27 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if err := model.ValidateTokenBudgets(token.Budgets); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if token.OrgId != 0 {
+		// 组织令牌从组织额度池扣费，需是组织中可创建令牌的成员
+		member, err := model.GetOrgMember(token.OrgId, c.GetInt("id"))
+		if err == nil && !member.CanCreateOrgToken() {
+			err = errors.New("财务角色不能创建组织令牌")
+		}
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	cleanToken := model.Token{
 		UserId:         c.GetInt("id"),
 		Name:           token.Name,
@@ -138,6 +163,8 @@ func AddToken(c *gin.Context) {
 		BillingEnabled: token.BillingEnabled,
 		Models:         token.Models,
 		FixedContent:   token.FixedContent,
+		Budgets:        token.Budgets,
+		OrgId:          token.OrgId,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -147,9 +174,11 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	// 完整令牌只在此次响应中返回，之后只能看到前缀
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
+		"data":    cleanToken,
 	})
 	return
 }
@@ -192,6 +221,13 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if err := model.ValidateTokenBudgets(token.Budgets); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -229,6 +265,7 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.Group = token.Group
 		cleanToken.Models = token.Models
 		cleanToken.FixedContent = token.FixedContent
+		cleanToken.Budgets = token.Budgets
 	}
 	err = cleanToken.Update()
 	if err != nil {
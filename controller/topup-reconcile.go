@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/payment"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// settleTopUp 按支付渠道返回的状态处理订单：已支付且金额一致则完成，金额不符则标记待人工处理，
+// 渠道已关闭或 expire 为 true 时关闭未支付订单。可重复调用，额度只会发放一次
+func settleTopUp(topUp *model.TopUp, state *payment.OrderState, expire bool) error {
+	switch state.Status {
+	case payment.StatusPaid:
+		if math.Abs(state.Money-topUp.Money) >= 0.01 {
+			flagged, err := model.FlagTopUpAmountMismatch(topUp, state.Money)
+			if err != nil {
+				return err
+			}
+			if flagged {
+				log.Printf("%s 订单金额不符: 订单 %s 应付 %.2f，实付 %.2f", topUp.Provider, topUp.TradeNo, topUp.Money, state.Money)
+				notifyTopUpMismatch(topUp)
+			}
+			return nil
+		}
+		return completeTopUp(topUp, state)
+	case payment.StatusClosed:
+		expire = true
+	}
+	if expire {
+		return model.ExpireTopUp(topUp)
+	}
+	return nil
+}
+
+// verifyTopUp 主动向支付渠道查询订单状态并处理，返回渠道的订单状态
+func verifyTopUp(topUp *model.TopUp, expire bool) (*payment.OrderState, error) {
+	provider := payment.GetProvider(topUp.Provider)
+	if provider == nil || !provider.Enabled() {
+		return nil, fmt.Errorf("支付渠道 %s 未启用", topUp.Provider)
+	}
+	state, err := provider.QueryOrder(topUp.TradeNo, topUp.ProviderTradeNo)
+	if err != nil {
+		return nil, err
+	}
+	return state, settleTopUp(topUp, state, expire)
+}
+
+// ReconcilePendingTopUps 查询超过 TOPUP_RECONCILE_DELAY 分钟仍未回调的订单，补发漏掉的支付成功回调，
+// 超过 TOPUP_EXPIRE_MINUTES 分钟仍未支付的订单关闭
+func ReconcilePendingTopUps() error {
+	now := time.Now()
+	before := now.Add(-time.Duration(common.TopUpReconcileDelay) * time.Minute).Unix()
+	expireBefore := now.Add(-time.Duration(common.TopUpExpireMinutes) * time.Minute).Unix()
+	topUps, err := model.GetPendingTopUps(before, 500)
+	if err != nil {
+		return err
+	}
+	for _, topUp := range topUps {
+		_, err = verifyTopUp(topUp, topUp.CreateTime <= expireBefore)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to reconcile top-up %s: %s", topUp.TradeNo, err.Error()))
+		}
+	}
+	return nil
+}
+
+// VerifyTopUp 管理员手动向支付渠道重新核实单个订单，订单状态没有变化时返回错误
+func VerifyTopUp(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	topUp := model.GetTopUpById(id)
+	if topUp == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "订单不存在",
+		})
+		return
+	}
+	err := errors.New("订单已完成，无需核实")
+	if topUp.Status != model.TopUpStatusSuccess && topUp.Status != model.TopUpStatusRefunded {
+		status := topUp.Status
+		var state *payment.OrderState
+		state, err = verifyTopUp(topUp, false)
+		if err == nil {
+			topUp = model.GetTopUpById(id)
+			if topUp == nil {
+				err = errors.New("订单不存在")
+			} else if topUp.Status == status {
+				err = fmt.Errorf("支付渠道返回订单状态为 %s（实付 %.2f），订单状态未变化", state.Status, state.Money)
+			}
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    topUp,
+	})
+}
+
+type TopUpResolveRequest struct {
+	Action string `json:"action"` // accept 按实付金额确认并发放额度，refund 退回实付金额并关闭订单
+	Reason string `json:"reason"`
+}
+
+// ResolveTopUp 管理员处理金额不符的订单
+func ResolveTopUp(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req TopUpResolveRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	topUp := model.GetTopUpById(id)
+	if topUp == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "订单不存在",
+		})
+		return
+	}
+	switch req.Action {
+	case "accept":
+		var completed bool
+		completed, err = model.AcceptTopUpMismatch(topUp)
+		if err == nil && !completed {
+			err = errors.New("订单已被处理")
+		}
+		if err == nil {
+			log.Printf("%s 金额不符订单 %s 已按实付金额 %.2f 确认", topUp.Provider, topUp.TradeNo, topUp.Money)
+			model.RecordLog(topUp.UserId, model.LogTypeManage, 0, fmt.Sprintf("管理员按实付金额 %.2f 确认订单 %s", topUp.Money, topUp.TradeNo))
+			notifyEmail(topUp)
+			notifyWxPusher(topUp)
+		}
+	case "refund":
+		err = resolveTopUpByRefund(c, topUp, req.Reason)
+	default:
+		err = errors.New("无效的处理方式")
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    topUp,
+	})
+}
+
+// resolveTopUpByRefund 退回金额不符订单的实付金额，渠道退款失败时释放占用以便重试
+func resolveTopUpByRefund(c *gin.Context, topUp *model.TopUp, reason string) error {
+	err := model.ReserveTopUpMismatchRefund(topUp)
+	if err != nil {
+		return err
+	}
+	if topUp.PaidMoney > 0 {
+		err = refundToProvider(topUp, topUp.PaidMoney)
+		if err != nil {
+			if err := model.ReleaseTopUpRefund(topUp, topUp.PaidMoney); err != nil {
+				common.SysError(fmt.Sprintf("failed to release refund of top-up %s: %s", topUp.TradeNo, err.Error()))
+			}
+			return errors.New("支付渠道退款失败：" + err.Error())
+		}
+	}
+	refund := &model.TopUpRefund{
+		OperatorId: c.GetInt("id"),
+		Reason:     reason,
+	}
+	err = model.CloseTopUpMismatch(topUp, refund)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to close refunded top-up %s: %s", topUp.TradeNo, err.Error()))
+		return errors.New("退款已完成，但关闭订单失败：" + err.Error())
+	}
+	return nil
+}
+
+func notifyTopUpMismatch(topUp *model.TopUp) {
+	subject := fmt.Sprintf("充值金额异常通知: 订单 %s", topUp.TradeNo)
+	content := fmt.Sprintf("用户「%d」的订单 %s（%s）应付 %.2f，支付渠道返回实付 %.2f，已暂停发放额度，请人工核实。", topUp.UserId, topUp.TradeNo, topUp.Provider, topUp.Money, topUp.PaidMoney)
+	emailNotifEnabled, _ := strconv.ParseBool(common.OptionMap["EmailNotificationsEnabled"])
+	if emailNotifEnabled {
+		notificationEmail := common.OptionMap["NotificationEmail"]
+		if notificationEmail == "" {
+			if common.RootUserEmail == "" {
+				common.RootUserEmail = model.GetRootUserEmail()
+			}
+			notificationEmail = common.RootUserEmail
+		}
+		err := common.SendEmail(subject, notificationEmail, content)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to send email notification: %s", err.Error()))
+		}
+	}
+	wxNotifEnabled, _ := strconv.ParseBool(common.OptionMap["WxPusherNotificationsEnabled"])
+	if wxNotifEnabled {
+		err := SendWxPusherNotification(subject, content)
+		if err != nil {
+			common.SysError(fmt.Sprintf("无法发送WxPusher通知: %s", err))
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/payment"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TopUpRefundRequest struct {
+	Money      float64 `json:"money"` // 为 0 时退还全部剩余金额
+	Reason     string  `json:"reason"`
+	Chargeback bool    `json:"chargeback"` // 用户已通过支付渠道拒付，只扣回额度不再发起退款
+}
+
+// RefundTopUp 通过支付渠道退款，并按退款比例扣回额度、冲回邀请返佣
+func RefundTopUp(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req TopUpRefundRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	topUp := model.GetTopUpById(id)
+	if topUp == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "订单不存在",
+		})
+		return
+	}
+	money := req.Money
+	if money == 0 {
+		money = topUp.Money - topUp.RefundedMoney
+	}
+	money = math.Round(money*100) / 100
+	err = model.ReserveTopUpRefund(topUp, money)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !req.Chargeback {
+		err = refundToProvider(topUp, money)
+		if err != nil {
+			if err := model.ReleaseTopUpRefund(topUp, money); err != nil {
+				common.SysError(fmt.Sprintf("failed to release refund of top-up %s: %s", topUp.TradeNo, err.Error()))
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "支付渠道退款失败：" + err.Error(),
+			})
+			return
+		}
+	}
+	refund := &model.TopUpRefund{
+		OperatorId: c.GetInt("id"),
+		Money:      money,
+		Chargeback: req.Chargeback,
+		Reason:     req.Reason,
+	}
+	err = model.ApplyTopUpRefund(topUp, refund)
+	if err != nil {
+		// 渠道已退款成功，额度扣回失败需人工处理，不释放已占用的金额
+		common.SysError(fmt.Sprintf("failed to claw back quota of refunded top-up %s: %s", topUp.TradeNo, err.Error()))
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "退款已完成，但扣回额度失败：" + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    refund,
+	})
+}
+
+func refundToProvider(topUp *model.TopUp, money float64) error {
+	provider := payment.GetProvider(topUp.Provider)
+	if provider == nil || !provider.Enabled() {
+		return fmt.Errorf("支付渠道 %s 未启用", topUp.Provider)
+	}
+	return provider.Refund(topUp.TradeNo, topUp.ProviderTradeNo, money)
+}
+
+func GetTopUpRefunds(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	refunds, err := model.GetTopUpRefunds(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    refunds,
+	})
+}
@@ -2,23 +2,25 @@ package controller
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"one-api/common"
 	"one-api/model"
+	"one-api/payment"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/samber/lo"
-	epay "github.com/star-horizon/go-epay"
 )
 
-type EpayRequest struct {
+type PaymentRequest struct {
 	Amount        int    `json:"amount"`
 	PaymentMethod string `json:"payment_method"`
+	Provider      string `json:"provider"`
 	TopUpCode     string `json:"top_up_code"`
+	CouponCode    string `json:"coupon_code"`
 	TopupRatio    string `json:"topup_ratio"`
 	TopupAmount   string `json:"topup_amount"`
 }
@@ -26,24 +28,11 @@ type EpayRequest struct {
 type AmountRequest struct {
 	Amount      int    `json:"amount"`
 	TopUpCode   string `json:"top_up_code"`
+	CouponCode  string `json:"coupon_code"`
 	TopupRatio  string `json:"topup_ratio"`
 	TopupAmount string `json:"topup_amount"`
 }
 
-func GetEpayClient() *epay.Client {
-	if common.PayAddress == "" || common.EpayId == "" || common.EpayKey == "" {
-		return nil
-	}
-	withUrl, err := epay.NewClientWithUrl(&epay.Config{
-		PartnerID: common.EpayId,
-		Key:       common.EpayKey,
-	}, common.PayAddress)
-	if err != nil {
-		return nil
-	}
-	return withUrl
-}
-
 func GetAmount(count float64, topupratio float64, topupamount float64, user model.User) float64 {
 	topupGroupRatio := common.GetTopupGroupRatio(user.Group)
 	if topupGroupRatio == 0 {
@@ -53,8 +42,24 @@ func GetAmount(count float64, topupratio float64, topupamount float64, user mode
 	return amount
 }
 
-func RequestEpay(c *gin.Context) {
-	var req EpayRequest
+// applyCoupon 校验优惠码并返回优惠金额，未填写优惠码时返回 nil
+func applyCoupon(code string, user *model.User, money float64) (*model.Coupon, float64, error) {
+	if model.NormalizeCouponCode(code) == "" {
+		return nil, 0, nil
+	}
+	coupon, err := model.GetCouponByCode(code)
+	if err != nil {
+		return nil, 0, err
+	}
+	discount, err := coupon.Apply(user.Id, user.Group, money)
+	if err != nil {
+		return nil, 0, err
+	}
+	return coupon, discount, nil
+}
+
+func RequestPayment(c *gin.Context) {
+	var req PaymentRequest
 	TopupAmountEnabled, _ := strconv.ParseBool(common.OptionMap["TopupAmountEnabled"])
 	err := c.ShouldBindJSON(&req)
 	if err != nil {
@@ -65,6 +70,11 @@ func RequestEpay(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "充值金额不能小于1", "data": 10})
 		return
 	}
+	provider := payment.GetProvider(req.Provider)
+	if provider == nil || !provider.Enabled() {
+		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
+		return
+	}
 	topupratio := common.GetTopupRatio(req.TopupRatio)
 	topupamount := 1.0
 	if TopupAmountEnabled {
@@ -73,132 +83,129 @@ func RequestEpay(c *gin.Context) {
 	id := c.GetInt("id")
 	user, _ := model.GetUserById(id, false)
 	amount := GetAmount(float64(req.Amount), topupratio, topupamount, *user)
-
-	var payType epay.PurchaseType
-	if req.PaymentMethod == "zfb" {
-		payType = epay.Alipay
-	}
-	if req.PaymentMethod == "wx" {
-		req.PaymentMethod = "wxpay"
-		payType = epay.WechatPay
-	}
-
-	returnUrl, _ := url.Parse(common.ServerAddress + "/log")
-	notifyUrl, _ := url.Parse(common.ServerAddress + "/api/user/epay/notify")
-	tradeNo := strconv.FormatInt(time.Now().Unix(), 10)
-	payMoney := amount
-	client := GetEpayClient()
-	if client == nil {
-		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
-		return
-	}
-	uri, params, err := client.Purchase(&epay.PurchaseArgs{
-		Type:           payType,
-		ServiceTradeNo: "A" + tradeNo,
-		Name:           "B" + tradeNo,
-		Money:          strconv.FormatFloat(payMoney, 'f', 2, 64),
-		Device:         epay.PC,
-		NotifyUrl:      notifyUrl,
-		ReturnUrl:      returnUrl,
-	})
+	coupon, discount, err := applyCoupon(req.CouponCode, user, amount)
 	if err != nil {
-		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
+		c.JSON(200, gin.H{"message": "error", "data": err.Error()})
 		return
 	}
-	topUp := &model.TopUp{
+
+	topUp := newPendingTopUp(provider, &model.TopUp{
 		UserId:     id,
 		Amount:     req.Amount,
-		Money:      payMoney,
+		Money:      amount - discount,
 		TopupRatio: req.TopupRatio,
-		TradeNo:    "A" + tradeNo,
-		CreateTime: time.Now().Unix(),
-		Status:     "pending",
+	})
+	if coupon != nil {
+		topUp.CouponId = coupon.Id
+		topUp.Discount = discount
+		topUp.BonusQuota = coupon.BonusQuota
+		err = topUp.InsertWithCoupon(coupon)
+	} else {
+		err = topUp.Insert()
 	}
-	err = topUp.Insert()
 	if err != nil {
+		common.SysError(fmt.Sprintf("failed to create top-up %s: %s", topUp.TradeNo, err.Error()))
 		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "success", "data": params, "url": uri})
+	result, err := createProviderOrder(provider, topUp, req.PaymentMethod)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to create %s order: %s", provider.Name(), err.Error()))
+		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "success", "data": result.Params, "url": result.URL, "provider": provider.Name()})
+}
+
+// newPendingTopUp 生成订单号并填充 topUp 的渠道和状态，调用方须在下单前保存订单
+func newPendingTopUp(provider payment.Provider, topUp *model.TopUp) *model.TopUp {
+	// 同一秒内可能有多笔订单，追加随机后缀避免订单号重复
+	topUp.TradeNo = "A" + strconv.FormatInt(time.Now().Unix(), 10) + common.GetRandomString(4)
+	topUp.Provider = provider.Name()
+	topUp.CreateTime = time.Now().Unix()
+	topUp.Status = model.TopUpStatusPending
+	return topUp
 }
 
-func EpayNotify(c *gin.Context) {
-	params := lo.Reduce(lo.Keys(c.Request.URL.Query()), func(r map[string]string, t string, i int) map[string]string {
-		r[t] = c.Request.URL.Query().Get(t)
-		return r
-	}, map[string]string{})
-	client := GetEpayClient()
-	if client == nil {
-		log.Println("易支付回调失败 未找到配置信息")
-		_, err := c.Writer.Write([]byte("fail"))
+// createProviderOrder 在支付渠道为已保存的待支付订单下单并记录渠道订单号，下单失败时关闭订单
+func createProviderOrder(provider payment.Provider, topUp *model.TopUp, paymentMethod string) (*payment.CreateResult, error) {
+	result, err := provider.CreateOrder(&payment.Order{
+		TradeNo:       topUp.TradeNo,
+		Name:          "B" + strings.TrimPrefix(topUp.TradeNo, "A"),
+		Money:         topUp.Money,
+		PaymentMethod: paymentMethod,
+		NotifyURL:     fmt.Sprintf("%s/api/user/payment/%s/notify", common.ServerAddress, provider.Name()),
+		ReturnURL:     common.ServerAddress + "/log",
+	})
+	if err != nil {
+		if err := model.ExpireTopUp(topUp); err != nil {
+			common.SysError(fmt.Sprintf("failed to close top-up %s: %s", topUp.TradeNo, err.Error()))
+		}
+		return nil, err
+	}
+	if result.ProviderTradeNo != "" {
+		err = model.SetTopUpProviderTradeNo(topUp, result.ProviderTradeNo)
 		if err != nil {
-			log.Println("易支付回调写入失败")
+			// 渠道已下单，回调或对账时仍可按订单号找到订单
+			common.SysError(fmt.Sprintf("failed to save provider trade no of top-up %s: %s", topUp.TradeNo, err.Error()))
 		}
+	}
+	return result, nil
+}
+
+// PaymentNotify 各支付渠道的异步回调，旧版易支付回调地址 /api/user/epay/notify 不带渠道参数
+func PaymentNotify(c *gin.Context) {
+	provider := payment.GetProvider(c.Param("provider"))
+	if provider == nil || !provider.Enabled() {
+		log.Printf("支付回调失败 未找到渠道配置信息: %s", c.Param("provider"))
+		c.String(http.StatusBadRequest, "fail")
 		notifyEmailForFail()    // 发送回调失败通知
 		notifyWxPusherForFail() // 发送回调失败通知
 		return
 	}
-	verifyInfo, err := client.Verify(params)
-	if err != nil || !verifyInfo.VerifyStatus {
-		log.Printf("易支付回调验证失败: %v", err)
-		_, writeErr := c.Writer.Write([]byte("fail"))
-		if writeErr != nil {
-			log.Println("易支付回调写入失败")
-		}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.String(http.StatusBadRequest, provider.CallbackResponse(false))
+		return
+	}
+	state, err := provider.VerifyCallback(c.Request, body)
+	if err != nil {
+		log.Printf("%s 回调验证失败: %v", provider.Name(), err)
+		c.String(http.StatusBadRequest, provider.CallbackResponse(false))
 		notifyEmailForFail()    // 发送验证失败通知
 		notifyWxPusherForFail() // 发送验证失败通知
 		return
 	}
+	if state.TradeNo == "" {
+		// 与充值订单无关的通知
+		c.String(http.StatusOK, provider.CallbackResponse(true))
+		return
+	}
+	topUp := model.GetTopUpByTradeNo(state.TradeNo)
+	if topUp == nil || topUp.Provider != provider.Name() {
+		log.Printf("%s 回调订单不存在: %+v", provider.Name(), state)
+		c.String(http.StatusBadRequest, provider.CallbackResponse(false))
+		return
+	}
+	err = settleTopUp(topUp, state, false)
+	if err != nil {
+		log.Printf("%s 回调更新订单失败: %v, %v", provider.Name(), topUp, err)
+		c.String(http.StatusInternalServerError, provider.CallbackResponse(false))
+		return
+	}
+	c.String(http.StatusOK, provider.CallbackResponse(true))
+}
 
-	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
-		log.Println(verifyInfo)
-		topUp := model.GetTopUpByTradeNo(verifyInfo.ServiceTradeNo)
-		if topUp != nil && topUp.Status == "pending" {
-			topUp.Status = "success"
-			err := topUp.Update()
-			if err != nil {
-				log.Printf("易支付回调更新订单失败: %v", topUp)
-				return
-			}
-			//user, _ := model.GetUserById(topUp.UserId, false)
-			//user.Quota += topUp.Amount * 500000
-			multipliedQuota := float64(topUp.Amount) * common.QuotaPerUnit
-			err = model.IncreaseUserQuota(topUp.UserId, int(multipliedQuota))
-			if err != nil {
-				log.Printf("易支付回调更新用户失败: %v", topUp)
-				return
-			}
-			log.Printf("易支付回调更新用户成功 %v", topUp)
-
-			err = model.IncreaseRechargeQuota(topUp.UserId, topUp.TopupRatio, int(multipliedQuota))
-			if err != nil {
-				log.Printf("易支付回调更新用户失败: %v", topUp)
-				return
-			}
-			notifyEmail(topUp)
-			notifyWxPusher(topUp)
-			model.RecordLog(topUp.UserId, model.LogTypeTopup, int(multipliedQuota), fmt.Sprintf("在线充值成功，充值: %v，支付金额：%.2f", common.LogQuota(int(multipliedQuota)), topUp.Money))
-			model.VipInsert(topUp.UserId, int(multipliedQuota))
-			GroupEnable, _ := strconv.ParseBool(common.OptionMap["GroupEnable"])
-			if GroupEnable {
-				err = model.VipUserQuota(topUp.UserId)
-				if err != nil {
-					log.Printf("用户分组更新失败: %v", topUp)
-					return
-				}
-			}
-		}
-		_, writeErr := c.Writer.Write([]byte("success")) // 确保发送 success 响应
-		if writeErr != nil {
-			log.Println("易支付回调响应成功写入失败")
-		}
-	} else {
-		log.Printf("易支付异常回调: %v", verifyInfo)
-		_, writeErr := c.Writer.Write([]byte("fail"))
-		if writeErr != nil {
-			log.Println("易支付回调写入失败")
-		}
+// completeTopUp 支付成功后完成订单并发送通知，重复回调不会重复发放额度
+func completeTopUp(topUp *model.TopUp, state *payment.OrderState) error {
+	completed, err := model.CompleteTopUp(topUp, state.ProviderTradeNo, state.Money)
+	if err != nil || !completed {
+		return err
 	}
+	log.Printf("%s 订单完成，更新用户成功 %v", topUp.Provider, topUp)
+	notifyEmail(topUp)
+	notifyWxPusher(topUp)
+	return nil
 }
 
 func notifyEmail(topUp *model.TopUp) {
@@ -286,7 +293,17 @@ func RequestAmount(c *gin.Context) {
 	id := c.GetInt("id")
 	user, _ := model.GetUserById(id, false)
 	payMoney := GetAmount(float64(req.Amount), topupratio, topupamount, *user)
-	c.JSON(200, gin.H{"message": "success", "data": strconv.FormatFloat(payMoney, 'f', 2, 64)})
+	coupon, discount, err := applyCoupon(req.CouponCode, user, payMoney)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": err.Error()})
+		return
+	}
+	bonusQuota := 0
+	if coupon != nil {
+		bonusQuota = coupon.BonusQuota
+	}
+	c.JSON(200, gin.H{"message": "success", "data": strconv.FormatFloat(payMoney-discount, 'f', 2, 64),
+		"discount": strconv.FormatFloat(discount, 'f', 2, 64), "bonus_quota": bonusQuota})
 }
 
 func GetAllTopUps(c *gin.Context) {
@@ -0,0 +1,261 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TwoFactorRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+func twoFactorRequired(role int) bool {
+	return role >= common.TwoFactorRequiredPermission
+}
+
+// startTwoFactorChallenge 密码等验证通过后只记录待验证的用户，尚未启用但被要求启用时同时下发绑定密钥
+func startTwoFactorChallenge(user *model.User, enabled bool, c *gin.Context) {
+	data := gin.H{
+		"two_factor": "verify",
+	}
+	if !enabled {
+		secret, err := model.StartTwoFactorEnrollment(user.Id)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"message": err.Error(),
+				"success": false,
+			})
+			return
+		}
+		data["two_factor"] = "enroll"
+		data["secret"] = secret
+		data["uri"] = common.TotpProvisioningUri(secret, user.Username)
+	}
+	challenge, err := model.CreateTwoFactorChallenge(user.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无法保存会话信息，请重试",
+			"success": false,
+		})
+		return
+	}
+	data["challenge"] = challenge
+	c.JSON(http.StatusOK, gin.H{
+		"message": "",
+		"success": true,
+		"data":    data,
+	})
+}
+
+// LoginTwoFactor 登录第二步，提交验证码或恢复码；绑定流程中提交验证码即确认绑定
+func LoginTwoFactor(c *gin.Context) {
+	var req TwoFactorRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.Challenge == "" || req.Code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无效的参数",
+			"success": false,
+		})
+		return
+	}
+	// 挑战及尝试次数保存在服务端，每次提交先占用一次尝试机会
+	userId, attemptsLeft, err := model.UseTwoFactorChallenge(req.Challenge)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
+	// 两步验证失败与密码错误计入同一账户的失败次数，锁定期间同样拒绝
+	guardKey := model.UserLoginGuardKey(userId)
+	if err := model.CheckLoginAllowed(model.GetLoginFailureState(guardKey)); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
+	user := model.User{Id: userId}
+	err = user.FillUserById()
+	if err == nil && user.Status != common.UserStatusEnabled {
+		model.DeleteTwoFactorChallenge(req.Challenge)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "用户已被封禁",
+			"success": false,
+		})
+		return
+	}
+	var recoveryCodes []string
+	if err == nil {
+		if model.IsTwoFactorEnabled(user.Id) {
+			err = model.VerifyTwoFactor(user.Id, req.Code)
+		} else {
+			recoveryCodes, err = model.EnableTwoFactor(user.Id, req.Code)
+		}
+	}
+	if err != nil {
+		if attemptsLeft <= 0 {
+			model.DeleteTwoFactorChallenge(req.Challenge)
+		}
+		state, locked := model.RecordLoginFailure(guardKey)
+		if locked && user.Id != 0 {
+			notifySuspiciousLogin(&user, c.ClientIP(), fmt.Sprintf("您的账户连续 %d 次登录失败（含两步验证失败），已被临时锁定 %d 分钟。", state.Failures, common.LoginLockoutMinutes))
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
+	if !model.DeleteTwoFactorChallenge(req.Challenge) {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "验证已过期，请重新登录",
+			"success": false,
+		})
+		return
+	}
+	finishLogin(&user, recoveryCodes, c)
+}
+
+// GetSelfTwoFactor 当前用户的两步验证状态
+func GetSelfTwoFactor(c *gin.Context) {
+	enabled := false
+	recoveryCodesLeft := 0
+	tf, err := model.GetUserTwoFactor(c.GetInt("id"))
+	if err == nil && tf.Enabled {
+		enabled = true
+		recoveryCodesLeft = tf.RecoveryCodesLeft()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"enabled":             enabled,
+			"required":            twoFactorRequired(c.GetInt("role")),
+			"recovery_codes_left": recoveryCodesLeft,
+		},
+	})
+}
+
+// SetupSelfTwoFactor 生成绑定密钥和身份验证器扫码地址，需调用 EnableSelfTwoFactor 确认
+func SetupSelfTwoFactor(c *gin.Context) {
+	secret, err := model.StartTwoFactorEnrollment(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"secret": secret,
+			"uri":    common.TotpProvisioningUri(secret, c.GetString("username")),
+		},
+	})
+}
+
+func EnableSelfTwoFactor(c *gin.Context) {
+	var req TwoFactorRequest
+	err := c.ShouldBindJSON(&req)
+	var recoveryCodes []string
+	if err == nil {
+		recoveryCodes, err = model.EnableTwoFactor(c.GetInt("id"), req.Code)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    recoveryCodes,
+	})
+}
+
+// DisableSelfTwoFactor 关闭两步验证需要提供当前验证码，被强制启用的角色不能关闭
+func DisableSelfTwoFactor(c *gin.Context) {
+	if twoFactorRequired(c.GetInt("role")) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "当前角色必须启用两步验证",
+		})
+		return
+	}
+	var req TwoFactorRequest
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = model.VerifyTwoFactor(c.GetInt("id"), req.Code)
+	}
+	if err == nil {
+		err = model.DisableTwoFactor(c.GetInt("id"))
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// RegenerateSelfRecoveryCodes 重新生成恢复码，需要提供当前验证码
+func RegenerateSelfRecoveryCodes(c *gin.Context) {
+	var req TwoFactorRequest
+	err := c.ShouldBindJSON(&req)
+	if err == nil {
+		err = model.VerifyTwoFactor(c.GetInt("id"), req.Code)
+	}
+	var recoveryCodes []string
+	if err == nil {
+		recoveryCodes, err = model.RegenerateRecoveryCodes(c.GetInt("id"))
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    recoveryCodes,
+	})
+}
+
+// ResetUserTwoFactor 管理员重置用户的两步验证，用户下次登录时如被要求需重新绑定
+func ResetUserTwoFactor(c *gin.Context) {
+	user, ok := getManagedUser(c)
+	if !ok {
+		return
+	}
+	err := model.DisableTwoFactor(user.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(user.Id, model.LogTypeManage, 0, "管理员重置了两步验证")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
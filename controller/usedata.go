@@ -10,7 +10,8 @@ import (
 func GetAllQuotaDates(c *gin.Context) {
 	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
 	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
-	dates, err := model.GetAllQuotaDates(startTimestamp, endTimestamp)
+	orgId, _ := strconv.Atoi(c.Query("org_id"))
+	dates, err := model.GetAllQuotaDates(startTimestamp, endTimestamp, orgId)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -27,10 +28,22 @@ func GetAllQuotaDates(c *gin.Context) {
 }
 
 func GetUserQuotaDates(c *gin.Context) {
-	userId := c.GetInt("id")
+	userId, orgId, err := orgUsageScope(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
 	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
-	dates, err := model.GetQuotaDataByUserId(userId, startTimestamp, endTimestamp)
+	var dates []*model.QuotaData
+	if orgId != 0 {
+		dates, err = model.GetQuotaDataByOrgId(orgId, userId, startTimestamp, endTimestamp)
+	} else {
+		dates, err = model.GetQuotaDataByUserId(userId, startTimestamp, endTimestamp)
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -45,29 +45,78 @@ func Login(c *gin.Context) {
 		})
 		return
 	}
+	guardKey := model.LoginGuardKey(username)
+	failureState := model.GetLoginFailureState(guardKey)
+	if err := model.CheckLoginAllowed(failureState); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
+	// 连续失败后即使未开启 Turnstile 也要求人机验证，且每次都需重新验证，
+	// 开启 Turnstile 时会话中已验证的标记不能跳过，除非本次请求刚由中间件验证过
+	if model.LoginTurnstileRequired(failureState) && !c.GetBool("turnstile_verified") {
+		if err := common.VerifyTurnstile(c.Query("turnstile"), c.ClientIP()); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"message": "请完成人机验证：" + err.Error(),
+				"success": false,
+				"data":    gin.H{"turnstile_required": true},
+			})
+			return
+		}
+	}
 	user := model.User{
 		Username: username,
 		Password: password,
 	}
 	err = user.ValidateAndFill()
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{
+		state, locked := model.RecordLoginFailure(guardKey)
+		if locked && user.Id != 0 {
+			notifySuspiciousLogin(&user, c.ClientIP(), fmt.Sprintf("您的账户连续 %d 次登录失败，已被临时锁定 %d 分钟。", state.Failures, common.LoginLockoutMinutes))
+		}
+		res := gin.H{
 			"message": err.Error(),
 			"success": false,
-		})
+		}
+		if model.LoginTurnstileRequired(state) {
+			res["data"] = gin.H{"turnstile_required": true}
+		}
+		c.JSON(http.StatusOK, res)
 		return
 	}
+	if common.LoginTurnstileThreshold > 0 && failureState.Failures >= common.LoginTurnstileThreshold && user.LastLoginIp != c.ClientIP() {
+		notifySuspiciousLogin(&user, c.ClientIP(), fmt.Sprintf("您的账户在连续 %d 次登录失败后从新的 IP 登录成功。", failureState.Failures))
+	}
 	setupLogin(&user, c)
 }
 
 // setup session & cookies and then return user info
+// setupLogin 所有登录方式的最后一步，启用或被要求启用两步验证的用户需先通过验证
 func setupLogin(user *model.User, c *gin.Context) {
+	enabled := model.IsTwoFactorEnabled(user.Id)
+	if enabled || twoFactorRequired(user.Role) {
+		startTwoFactorChallenge(user, enabled, c)
+		return
+	}
+	finishLogin(user, nil, c)
+}
+
+// finishLogin 写入会话完成登录，recoveryCodes 不为空时一并返回新生成的恢复码
+func finishLogin(user *model.User, recoveryCodes []string, c *gin.Context) {
+	sid, err := model.CreateUserSession(user.Id, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无法保存会话信息，请重试",
+			"success": false,
+		})
+		return
+	}
 	session := sessions.Default(c)
-	session.Set("id", user.Id)
-	session.Set("username", user.Username)
-	session.Set("role", user.Role)
-	session.Set("status", user.Status)
-	err := session.Save()
+	// cookie 中只保存会话标识，用户信息每次请求从数据库读取
+	session.Set("sid", sid)
+	err = session.Save()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "无法保存会话信息，请重试",
@@ -75,6 +124,9 @@ func setupLogin(user *model.User, c *gin.Context) {
 		})
 		return
 	}
+	// 两步验证也通过、真正完成登录后才清除失败记录
+	model.ResetLoginFailures(model.UserLoginGuardKey(user.Id))
+	model.UpdateUserLastLoginIp(user.Id, c.ClientIP())
 	cleanUser := model.User{
 		Id:          user.Id,
 		Username:    user.Username,
@@ -83,15 +135,22 @@ func setupLogin(user *model.User, c *gin.Context) {
 		Status:      user.Status,
 		Group:       user.Group,
 	}
-	c.JSON(http.StatusOK, gin.H{
+	res := gin.H{
 		"message": "",
 		"success": true,
 		"data":    cleanUser,
-	})
+	}
+	if recoveryCodes != nil {
+		res["recovery_codes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, res)
 }
 
 func Logout(c *gin.Context) {
 	session := sessions.Default(c)
+	if sid, ok := session.Get("sid").(string); ok {
+		_ = model.RevokeUserSessionBySid(sid)
+	}
 	session.Clear()
 	err := session.Save()
 	if err != nil {
@@ -179,6 +238,7 @@ func Register(c *gin.Context) {
 		Password:    user.Password,
 		DisplayName: user.Username,
 		InviterId:   inviterId,
+		RegisterIp:  c.ClientIP(),
 		CreatedAt:   time.Now().Unix(),
 		Group:       UserGroup,
 	}
@@ -309,14 +369,21 @@ func GenerateAccessToken(c *gin.Context) {
 }
 
 func GetUserDashboard(c *gin.Context) {
-	id := c.GetInt("id")
+	id, orgId, err := orgUsageScope(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	// 获取7天前 00:00:00 和 今天23:59:59  的秒时间戳
 	now := time.Now()
 	toDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 	endOfDay := toDay.Add(time.Hour * 24).Add(-time.Second).Unix()
 	startOfDay := toDay.AddDate(0, 0, -7).Unix()
 
-	dashboards, err := model.SearchLogsByDayAndModel(id, int(startOfDay), int(endOfDay))
+	dashboards, err := model.SearchLogsByDayAndModel(id, orgId, int(startOfDay), int(endOfDay))
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -457,6 +524,10 @@ func GetSelf(c *gin.Context) {
 		})
 		return
 	}
+	user.Subscription, err = model.GetUserSubscription(id)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get subscription of user %d: %s", id, err.Error()))
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -465,6 +536,27 @@ func GetSelf(c *gin.Context) {
 	return
 }
 
+// GetSelfQuotaLots 查看自己的额度包及剩余额度、过期时间
+func GetSelfQuotaLots(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	lots, err := model.GetUserQuotaLots(c.GetInt("id"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    lots,
+	})
+}
+
 func GetUserModels(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
@@ -579,6 +671,11 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if updatePassword {
+		if err := model.RevokeUserSessions(updatedUser.Id, ""); err != nil {
+			common.SysError("failed to revoke user sessions: " + err.Error())
+		}
+	}
 	if originUser.Quota != updatedUser.Quota {
 		model.RecordLog(originUser.Id, model.LogTypeManage, 0, fmt.Sprintf("管理员将用户额度从 %s修改为 %s", common.LogQuota(originUser.Quota), common.LogQuota(updatedUser.Quota)))
 	}
@@ -628,6 +725,12 @@ func UpdateSelf(c *gin.Context) {
 		})
 		return
 	}
+	if updatePassword {
+		// 修改密码后其他设备需要重新登录
+		if err := model.RevokeUserSessions(cleanUser.Id, c.GetString("session_id")); err != nil {
+			common.SysError("failed to revoke user sessions: " + err.Error())
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -691,6 +794,7 @@ func DeleteSelf(c *gin.Context) {
 		})
 		return
 	}
+	_ = model.RevokeUserSessions(id, "")
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -811,6 +915,7 @@ func ManageUser(c *gin.Context) {
 			})
 			return
 		}
+		_ = model.RevokeUserSessions(user.Id, "")
 	case "promote":
 		if myRole != common.RoleRootUser {
 			c.JSON(http.StatusOK, gin.H{
@@ -852,6 +957,13 @@ func ManageUser(c *gin.Context) {
 		})
 		return
 	}
+	if req.Action == "disable" {
+		// 禁用后立即让该用户的状态缓存和全部会话失效
+		model.CacheDeleteUserEnabled(user.Id)
+		if err := model.RevokeUserSessions(user.Id, ""); err != nil {
+			common.SysError("failed to revoke user sessions: " + err.Error())
+		}
+	}
 	clearUser := model.User{
 		Role:   user.Role,
 		Status: user.Status,
@@ -921,7 +1033,7 @@ func TopUp(c *gin.Context) {
 		return
 	}
 	id := c.GetInt("id")
-	quota, err := model.Redeem(req.Key, id)
+	redemption, err := model.Redeem(req.Key, id)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -940,7 +1052,8 @@ func TopUp(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
-		"data":    quota,
+		"data":    redemption.Quota,
+		"type":    redemption.Type,
 	})
 	return
 }
@@ -3,9 +3,16 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"one-api/common"
 	"one-api/model" // 替换为您项目的正确导入路径
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -69,6 +76,12 @@ func GetAllWithdrawalOrdersEndpoint(c *gin.Context) {
 	if endDate != "" {
 		searchParams["endDate"] = endDate
 	}
+	if status := c.Query("status"); status != "" {
+		searchParams["status"] = status
+	}
+	if payoutBatch := c.Query("payout_batch"); payoutBatch != "" {
+		searchParams["payoutBatch"] = payoutBatch
+	}
 
 	orders, err := model.GetAllWithdrawalOrders(searchParams)
 	if err != nil {
@@ -87,10 +100,9 @@ func GetAllWithdrawalOrdersEndpoint(c *gin.Context) {
 
 func UpdateWithdrawalOrderStatusEndpoint(c *gin.Context) {
 	var request struct {
-		OrderID     uint   `json:"order_id" binding:"required"`
-		Status      int    `json:"status" binding:"required"`
-		ProcessorID uint   `json:"processor_id"` // 处理该订单的管理员ID
-		Comment     string `json:"comment"`
+		OrderID uint   `json:"order_id"`
+		Status  int    `json:"status" binding:"required"`
+		Comment string `json:"comment"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -100,23 +112,15 @@ func UpdateWithdrawalOrderStatusEndpoint(c *gin.Context) {
 		})
 		return
 	}
-
-	// 如果订单被标记为已拒绝，则执行退款逻辑
-	if request.Status == StatusRejected {
-		err := model.RevertQuotaForRejectedOrder(request.OrderID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"success": false,
-				"message": "退回用户额度失败: " + err.Error(),
-			})
-			return
-		}
+	if request.OrderID == 0 {
+		id, _ := strconv.Atoi(c.Param("id"))
+		request.OrderID = uint(id)
 	}
 
-	// 更新订单状态
-	err := model.UpdateWithdrawalOrderStatus(request.OrderID, request.Status, request.ProcessorID, request.Comment)
+	// 状态变更按状态机校验，拒绝时会自动退回用户额度
+	err := model.TransitionWithdrawalOrder(request.OrderID, request.Status, c.GetInt("id"), request.Comment)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "更新提现订单状态失败: " + err.Error(),
 		})
@@ -129,6 +133,169 @@ func UpdateWithdrawalOrderStatusEndpoint(c *gin.Context) {
 	})
 }
 
+// GetWithdrawalOrderAudits 获取提现订单的状态变更记录
+func GetWithdrawalOrderAudits(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	audits, err := model.GetWithdrawalOrderAudits(uint(id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    audits,
+	})
+}
+
+// CreateWithdrawalPayoutBatch 将已批准且未导出的订单生成打款批次，并以支付宝批量转账的 CSV 格式导出
+func CreateWithdrawalPayoutBatch(c *gin.Context) {
+	batch, orders, err := model.CreateWithdrawalPayoutBatch()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	writeWithdrawalPayoutCSV(c, batch, orders)
+}
+
+// ExportWithdrawalPayoutBatch 重新下载已生成的打款批次
+func ExportWithdrawalPayoutBatch(c *gin.Context) {
+	batch := c.Param("batch")
+	orders, err := model.GetWithdrawalPayoutBatch(batch)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	writeWithdrawalPayoutCSV(c, batch, orders)
+}
+
+// writeWithdrawalPayoutCSV 按支付宝批量转账模板输出：序号、收款方支付宝账号、收款方姓名、金额（元）、备注（订单号，导入结果时据此匹配）
+// csvSafeCell 用户填写的内容以公式字符开头时加上单引号，避免在表格软件中打开时被当作公式执行
+func csvSafeCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func writeWithdrawalPayoutCSV(c *gin.Context, batch string, orders []model.WithdrawalOrder) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write([]string{"序号", "收款方支付宝账号", "收款方姓名", "金额", "备注"})
+	for i, order := range orders {
+		_ = writer.Write([]string{
+			strconv.Itoa(i + 1),
+			csvSafeCell(order.AlipayAccount),
+			csvSafeCell(order.UserName),
+			fmt.Sprintf("%.2f", order.WithdrawalAmount/common.QuotaPerUnit),
+			csvSafeCell(order.OrderNumber),
+		})
+	}
+	writer.Flush()
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=withdrawal-%s.csv", batch))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+// ImportWithdrawalPayoutResults 导入打款结果文件，表头需包含订单号（备注或 order_number）和状态（状态或 status）列，
+// 状态为成功的订单标记为已打款，失败的订单拒绝并退回额度，可选的失败原因（失败原因或 reason）列会记入审计日志
+func ImportWithdrawalPayoutResults(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "请上传打款结果文件",
+		})
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	defer f.Close()
+	results, err := parseWithdrawalPayoutResults(f)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	paid, rejected, errs := model.ApplyWithdrawalPayoutResults(results, c.GetInt("id"))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"paid":     paid,
+			"rejected": rejected,
+			"errors":   errs,
+		},
+	})
+}
+
+func parseWithdrawalPayoutResults(r io.Reader) ([]model.WithdrawalPayoutResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("打款结果文件为空")
+	}
+	orderCol, statusCol, reasonCol := -1, -1, -1
+	for i, name := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+		case "备注", "order_number":
+			orderCol = i
+		case "状态", "status":
+			statusCol = i
+		case "失败原因", "reason":
+			reasonCol = i
+		}
+	}
+	if orderCol < 0 || statusCol < 0 {
+		return nil, errors.New("打款结果文件缺少订单号或状态列")
+	}
+	var results []model.WithdrawalPayoutResult
+	for _, record := range records[1:] {
+		if orderCol >= len(record) || statusCol >= len(record) {
+			continue
+		}
+		orderNumber := strings.TrimSpace(record[orderCol])
+		if orderNumber == "" {
+			continue
+		}
+		result := model.WithdrawalPayoutResult{OrderNumber: orderNumber}
+		switch strings.ToLower(strings.TrimSpace(record[statusCol])) {
+		case "成功", "success", "paid":
+			result.Success = true
+		case "失败", "fail", "failed":
+			result.Success = false
+		default:
+			// 处理中等其他状态暂不处理，等待下一次导入
+			continue
+		}
+		if reasonCol >= 0 && reasonCol < len(record) {
+			result.Reason = strings.TrimSpace(record[reasonCol])
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
 func GetWithdrawalOrdersCount(c *gin.Context) {
 
 	count, err := model.GetWithdrawalOrdersCount()
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCsvSafeCell(t *testing.T) {
+	cases := map[string]string{
+		"":                         "",
+		"user@example.com":         "user@example.com",
+		"=HYPERLINK(\"http://x\")": "'=HYPERLINK(\"http://x\")",
+		"+86 138":                  "'+86 138",
+		"-1+1":                     "'-1+1",
+		"@SUM(A1)":                 "'@SUM(A1)",
+		"\tcmd":                    "'\tcmd",
+	}
+	for value, want := range cases {
+		assert.Equal(t, want, csvSafeCell(value), value)
+	}
+}
+
+func TestParseWithdrawalPayoutResults(t *testing.T) {
+	results, err := parseWithdrawalPayoutResults(strings.NewReader("\ufeff序号,备注,状态,失败原因\n1,A1,成功,\n2,A2,失败,账号错误\n3,A3,处理中,\n4,,成功,\n"))
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "A1", results[0].OrderNumber)
+	assert.True(t, results[0].Success)
+	assert.Equal(t, "A2", results[1].OrderNumber)
+	assert.False(t, results[1].Success)
+	assert.Equal(t, "账号错误", results[1].Reason)
+
+	_, err = parseWithdrawalPayoutResults(strings.NewReader("序号,金额\n1,2\n"))
+	assert.Error(t, err)
+}
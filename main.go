@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"log"
 	"one-api/common"
+	"one-api/common/metrics"
+	"one-api/common/tracing"
 	"one-api/controller"
 	"one-api/middleware"
 	"one-api/model"
 	"one-api/relay/channel/openai"
 	"one-api/router"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -62,6 +70,26 @@ func main() {
 	if err := common.InitRedisClient(); err != nil { // 再次使用 := 声明和初始化 err
 		common.FatalLog("failed to initialize Redis: " + err.Error())
 	}
+	model.InitSessionStore()
+
+	if common.MetricsEnabled {
+		metrics.Enabled = true
+		metrics.InstrumentDB(model.DB)
+		if common.RedisEnabled {
+			metrics.InstrumentRedis(common.RDB)
+		}
+		model.RegisterMetricsCollectors()
+	}
+	if common.TracingEnabled {
+		tracing.Enabled = true
+		tracing.SampleRatio = common.TracingSampleRatio
+		tracing.StartExporter(strings.TrimSuffix(common.TracingEndpoint, "/")+"/v1/traces", common.TracingHeaders, common.TracingServiceName)
+		tracing.InstrumentDB(model.DB)
+		if common.RedisEnabled {
+			tracing.InstrumentRedis(common.RDB)
+		}
+		common.SysLog("tracing enabled, exporting to " + common.TracingEndpoint)
+	}
 
 	// Initialize options
 	model.InitOptionMap()
@@ -74,15 +102,29 @@ func main() {
 		common.SysError(fmt.Sprintf("sync frequency: %d seconds", common.SyncFrequency))
 		model.InitChannelCache()
 	}
+	// 渠道、令牌、配置的变更通过失效事件实时广播，定期全量同步仅作兜底
+	model.StartCacheInvalidationListener()
 	if common.MemoryCacheEnabled {
-		go model.SyncOptions(common.SyncFrequency)
-		go model.SyncChannelCache(common.SyncFrequency)
+		go model.SyncOptions(common.CacheFullSyncFrequency)
+		go model.SyncChannelCache(common.CacheFullSyncFrequency)
 	}
 
-	// 数据看板
+	// 单例后台任务只在持有租约的节点上运行
+	model.StartLeaderElection()
+
+	// 数据看板，缓存在各节点本地，每个节点都需要落库
 	go model.UpdateQuotaData()
 	// 额度有效期
 	go model.UpdateUserQuotaData()
+	// 定期额度补充
+	go model.RunLeaderJobEvery("quota_refill", time.Minute, model.ApplyDueRefills)
+	go model.RunLeaderJobEvery("quota_ledger_reconcile", time.Hour, model.ReconcileQuotaLedgerJob)
+	go model.RunLeaderJobEvery("topup_reconcile", 5*time.Minute, controller.ReconcilePendingTopUps)
+	go model.RunLeaderJobEvery("group_grant_expiry", 10*time.Minute, model.ExpireGroupGrants)
+	go model.RunLeaderJobEvery("subscription_renewal", 10*time.Minute, model.RenewSubscriptions)
+	go model.RunLeaderJobEvery("aff_commission_settle", time.Hour, model.SettleConsumeCommissions)
+	go model.RunLeaderJobEvery("aff_commission_release", 10*time.Minute, model.ReleaseAffCommissions)
+	go model.RunLeaderJobEvery("user_session_cleanup", time.Hour, model.CleanExpiredUserSessions)
 
 	if os.Getenv("CHANNEL_UPDATE_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_UPDATE_FREQUENCY"))
@@ -117,6 +159,10 @@ func main() {
 
 	// Initialize HTTP server
 	server := gin.New()
+	// gin 默认信任所有代理，任何人都能通过 X-Forwarded-For 伪造 ClientIP
+	if err := server.SetTrustedProxies(common.TrustedProxies(os.Getenv("TRUSTED_PROXIES"))); err != nil {
+		common.FatalLog("invalid TRUSTED_PROXIES: " + err.Error())
+	}
 	server.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
 		common.SysError(fmt.Sprintf("panic detected: %v", err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -129,6 +175,7 @@ func main() {
 	// This will cause SSE not to work!!!
 	//server.Use(gzip.Gzip(gzip.DefaultCompression))
 	server.Use(middleware.RequestId())
+	server.Use(middleware.Tracing())
 	middleware.SetUpLogger(server)
 	// Initialize session store
 	store := cookie.NewStore([]byte(common.SessionSecret))
@@ -140,7 +187,41 @@ func main() {
 		port = strconv.Itoa(*common.Port)
 	}
 	// 启动 HTTP 服务器。
-	if err := server.Run(":" + port); err != nil { // 再次使用 := 声明和初始化 err
-		common.FatalLog("failed to start HTTP server: " + err.Error())
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: server,
 	}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			common.FatalLog("failed to start HTTP server: " + err.Error())
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-quit
+	gracefulShutdown(srv, sig)
+}
+
+// gracefulShutdown 先标记排空让就绪探针失败，再等待进行中的请求（包括流式响应）和异步扣费完成，最后落库缓存数据
+func gracefulShutdown(srv *http.Server, sig os.Signal) {
+	common.SysLog(fmt.Sprintf("received signal %s, draining", sig))
+	common.StartDraining()
+	time.Sleep(time.Duration(common.ShutdownDelay) * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(common.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		common.SysError("in-flight requests not finished before deadline, closing: " + err.Error())
+		_ = srv.Close()
+	}
+	if !common.WaitBackground(10 * time.Second) {
+		common.SysError("background billing tasks not finished before deadline")
+	}
+
+	model.FlushBatchUpdater()
+	model.SaveQuotaDataCache()
+	model.ReleaseLeadership()
+	tracing.Flush(5 * time.Second)
+	common.SysLog("server exited")
 }
@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
 	"one-api/common"
+	"one-api/common/tracing"
 	"one-api/model"
 	"strings"
 
@@ -10,13 +12,14 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func authHelper(c *gin.Context, minRole int) {
+// authHelper 校验登录状态，permissions 非空时按命名角色的权限集合校验，否则按 minRole 校验。
+// 个人访问令牌只能访问 scopes 中任一范围已授权的接口，scopes 为空的接口只接受登录会话和旧的 access token
+func authHelper(c *gin.Context, minRole int, scopes []string, permissions ...string) {
 	session := sessions.Default(c)
-	username := session.Get("username")
-	role := session.Get("role")
-	id := session.Get("id")
-	status := session.Get("status")
-	if username == nil {
+	sid, _ := session.Get("sid").(string)
+	var username, role, id, status interface{}
+	write := c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead
+	if sid == "" {
 		// Check access token
 		accessToken := c.Request.Header.Get("Authorization")
 		if accessToken == "" {
@@ -27,8 +30,25 @@ func authHelper(c *gin.Context, minRole int) {
 			c.Abort()
 			return
 		}
-		user := model.ValidateAccessToken(accessToken)
-		if user != nil && user.Username != "" {
+		if strings.HasPrefix(strings.TrimPrefix(accessToken, "Bearer "), model.PersonalAccessTokenPrefix) {
+			user, pat, err := model.ValidatePersonalAccessToken(accessToken, c.ClientIP())
+			if err == nil && !pat.HasScope(write, scopes...) {
+				err = errors.New("access token 未授权访问此接口")
+			}
+			if err != nil {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "无权进行此操作，" + err.Error(),
+				})
+				c.Abort()
+				return
+			}
+			username = user.Username
+			role = user.Role
+			id = user.Id
+			status = user.Status
+			c.Set("pat_id", pat.Id)
+		} else if user := model.ValidateAccessToken(accessToken); user != nil && user.Username != "" {
 			// Token is valid
 			username = user.Username
 			role = user.Role
@@ -42,6 +62,23 @@ func authHelper(c *gin.Context, minRole int) {
 			c.Abort()
 			return
 		}
+	} else if user := sessionUser(sid, c.ClientIP()); user == nil {
+		// 会话已被吊销或过期
+		session.Clear()
+		_ = session.Save()
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"success": false,
+			"message": "无权进行此操作，会话已失效，请重新登录",
+		})
+		c.Abort()
+		return
+	} else {
+		// 角色和状态以数据库为准，修改后立即生效
+		username = user.Username
+		role = user.Role
+		id = user.Id
+		status = user.Status
+		c.Set("session_id", model.UserSessionId(sid))
 	}
 	if status.(int) == common.UserStatusDisabled {
 		c.JSON(http.StatusOK, gin.H{
@@ -51,7 +88,11 @@ func authHelper(c *gin.Context, minRole int) {
 		c.Abort()
 		return
 	}
-	if role.(int) < minRole {
+	allowed := role.(int) >= minRole
+	if len(permissions) > 0 {
+		allowed = model.HasPermission(id.(int), role.(int), write, permissions...)
+	}
+	if !allowed {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权进行此操作，权限不足",
@@ -65,21 +106,42 @@ func authHelper(c *gin.Context, minRole int) {
 	c.Next()
 }
 
-func UserAuth() func(c *gin.Context) {
+// sessionUser 返回会话所属的用户，会话无效或用户已被删除时返回 nil
+func sessionUser(sid string, ip string) *model.User {
+	userId, ok := model.ValidateUserSession(sid, ip)
+	if !ok {
+		return nil
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// UserAuth scopes 为接受的个人访问令牌授权范围，不传时不接受个人访问令牌
+func UserAuth(scopes ...string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		authHelper(c, common.RoleCommonUser, scopes)
+	}
+}
+
+func AdminAuth(scopes ...string) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, common.RoleCommonUser)
+		authHelper(c, common.RoleAdminUser, scopes)
 	}
 }
 
-func AdminAuth() func(c *gin.Context) {
+func RootAuth(scopes ...string) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, common.RoleAdminUser)
+		authHelper(c, common.RoleRootUser, scopes)
 	}
 }
 
-func RootAuth() func(c *gin.Context) {
+// PermissionAuth 要求用户拥有任一权限，GET 请求接受只读权限。个人访问令牌需拥有与权限同名的授权范围
+func PermissionAuth(permissions ...string) func(c *gin.Context) {
 	return func(c *gin.Context) {
-		authHelper(c, common.RoleRootUser)
+		authHelper(c, common.RoleCommonUser, permissions, permissions...)
 	}
 }
 
@@ -121,6 +183,9 @@ func getModelForPath(path string) string {
 
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		// span 在进入下游前结束，中断请求时由 defer 结束
+		ctx, span := tracing.Start(c.Request.Context(), "middleware.TokenAuth", tracing.SpanKindInternal)
+		defer span.End()
 		var err error
 		key, parts := processAuthHeader(c.Request.Header.Get("Authorization"))
 		if key == "" || key == "midjourney-proxy" {
@@ -135,12 +200,12 @@ func TokenAuth() func(c *gin.Context) {
 				return
 			}
 		}
-		token, err := model.ValidateUserToken(key, modelRequest.Model)
+		token, err := model.ValidateUserToken(ctx, key, modelRequest.Model)
 		if err != nil {
 			abortWithMessage(c, http.StatusUnauthorized, err.Error())
 			return
 		}
-		userEnabled, err := model.CacheIsUserEnabled(token.UserId)
+		userEnabled, err := model.CacheIsUserEnabled(ctx, token.UserId)
 		if err != nil {
 			abortWithMessage(c, http.StatusInternalServerError, err.Error())
 			return
@@ -154,6 +219,8 @@ func TokenAuth() func(c *gin.Context) {
 		c.Set("token_name", token.Name)
 		c.Set("group", token.Group)
 		c.Set("fixed_content", token.FixedContent)
+		c.Set("token_budgeted", token.Budgets != "")
+		c.Set("org_id", token.OrgId)
 		c.Set("model", modelRequest.Model)
 		c.Set("original_model", modelRequest.Model)
 
@@ -175,6 +242,8 @@ func TokenAuth() func(c *gin.Context) {
 				return
 			}
 		}
+		span.SetAttribute("token_id", token.Id)
+		span.End()
 		c.Next()
 	}
 }
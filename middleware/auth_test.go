@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+	"testing"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	common.SQLitePath = fmt.Sprintf("file:middleware_%s?mode=memory&cache=shared", name)
+	common.IsMasterNode = true
+	common.RedisEnabled = false
+	common.BatchUpdateEnabled = false
+	require.NoError(t, model.InitDB())
+	t.Cleanup(func() {
+		_ = model.CloseDB()
+	})
+}
+
+type authTestResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+func doAuthTestRequest(t *testing.T, client *http.Client, req *http.Request) (int, *authTestResponse) {
+	t.Helper()
+	res, err := client.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	var response authTestResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+	return res.StatusCode, &response
+}
+
+func TestSessionAuth(t *testing.T) {
+	setupTestDB(t)
+	user := &model.User{
+		Username:    "session-user",
+		Role:        common.RoleCommonUser,
+		Status:      common.UserStatusEnabled,
+		AccessToken: common.GetUUID(),
+		AffCode:     common.GetRandomString(4),
+	}
+	require.NoError(t, model.DB.Create(user).Error)
+	sid, err := model.CreateUserSession(user.Id, "127.0.0.1", "test")
+	require.NoError(t, err)
+
+	engine := gin.New()
+	engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("test"))))
+	engine.GET("/login", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Set("sid", sid)
+		_ = session.Save()
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+	engine.GET("/admin", AdminAuth(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": c.GetString("username")})
+	})
+	app := httptest.NewServer(engine)
+	defer app.Close()
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+	get := func(path string) (int, *authTestResponse) {
+		req, err := http.NewRequest(http.MethodGet, app.URL+path, nil)
+		require.NoError(t, err)
+		return doAuthTestRequest(t, client, req)
+	}
+
+	_, response := get("/login")
+	require.True(t, response.Success)
+	_, response = get("/admin")
+	assert.False(t, response.Success)
+
+	// 角色和状态从数据库读取，修改后无需重新登录即可生效
+	require.NoError(t, model.DB.Model(user).Update("role", common.RoleAdminUser).Error)
+	_, response = get("/admin")
+	assert.True(t, response.Success, response.Message)
+	assert.Equal(t, "session-user", response.Message)
+	require.NoError(t, model.DB.Model(user).Update("status", common.UserStatusDisabled).Error)
+	_, response = get("/admin")
+	assert.False(t, response.Success)
+	assert.Equal(t, "用户已被封禁", response.Message)
+
+	require.NoError(t, model.DB.Model(user).Update("status", common.UserStatusEnabled).Error)
+	require.NoError(t, model.RevokeUserSessions(user.Id, ""))
+	status, response := get("/admin")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.False(t, response.Success)
+}
+
+func TestPersonalAccessTokenScopes(t *testing.T) {
+	setupTestDB(t)
+	user := &model.User{
+		Username:    "pat-user",
+		Role:        common.RoleAdminUser,
+		Status:      common.UserStatusEnabled,
+		AccessToken: common.GetUUID(),
+		AffCode:     common.GetRandomString(4),
+	}
+	require.NoError(t, model.DB.Create(user).Error)
+	pat := &model.PersonalAccessToken{UserId: user.Id, Name: "ci", Scopes: `["tokens:read","users:write"]`, ExpiredTime: -1}
+	key, err := pat.Insert()
+	require.NoError(t, err)
+
+	engine := gin.New()
+	engine.Use(sessions.Sessions("session", cookie.NewStore([]byte("test"))))
+	ok := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	}
+	engine.Any("/tokens", UserAuth(model.ScopeTokens), ok)
+	engine.Any("/self", UserAuth(model.ScopeSelf), ok)
+	engine.Any("/users", PermissionAuth(model.PermUsers), ok)
+	engine.Any("/channels", PermissionAuth(model.PermChannels), ok)
+	engine.Any("/admin", AdminAuth(), ok)
+	app := httptest.NewServer(engine)
+	defer app.Close()
+	request := func(method string, path string, token string) bool {
+		req, err := http.NewRequest(method, app.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		_, response := doAuthTestRequest(t, http.DefaultClient, req)
+		return response.Success
+	}
+
+	cases := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{http.MethodGet, "/tokens", true},
+		{http.MethodPost, "/tokens", false}, // 只读授权不能写
+		{http.MethodGet, "/self", false},
+		{http.MethodGet, "/users", true},
+		{http.MethodDelete, "/users", true},
+		{http.MethodGet, "/channels", false}, // 用户有权限但令牌未授权
+		{http.MethodGet, "/admin", false},    // 未声明授权范围的接口不接受个人访问令牌
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.want, request(tc.method, tc.path, key), tc.method+" "+tc.path)
+	}
+	// 令牌授权不能超出用户本身的权限
+	require.NoError(t, model.DB.Model(user).Update("role", common.RoleCommonUser).Error)
+	assert.False(t, request(http.MethodGet, "/users", key))
+	assert.True(t, request(http.MethodGet, "/tokens", key))
+	// 旧的 access token 不受授权范围限制
+	assert.True(t, request(http.MethodGet, "/self", user.AccessToken))
+
+	require.NoError(t, model.RevokePersonalAccessToken(pat.Id, user.Id))
+	assert.False(t, request(http.MethodGet, "/tokens", key))
+	expired := &model.PersonalAccessToken{UserId: user.Id, Name: "old", Scopes: `["tokens:read"]`, ExpiredTime: common.GetTimestamp() - 1}
+	expiredKey, err := expired.Insert()
+	require.NoError(t, err)
+	assert.False(t, request(http.MethodGet, "/tokens", expiredKey))
+	assert.False(t, request(http.MethodGet, "/tokens", "pat-invalid"))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"one-api/common"
+	"one-api/common/tracing"
 	"one-api/model"
 	"strconv"
 	"strings"
@@ -17,6 +18,8 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		ctx, span := tracing.Start(c.Request.Context(), "middleware.Distribute", tracing.SpanKindInternal)
+		defer span.End()
 		var channel *model.Channel
 		tokenGroup, exists := c.Get("group")
 		if !exists || tokenGroup == nil || tokenGroup == "" {
@@ -88,7 +91,7 @@ func Distribute() func(c *gin.Context) {
 			// Select a channel for the user
 			var err error
 
-			channel, err = model.CacheGetRandomSatisfiedChannel(tokenGroup.(string), Model.(string))
+			channel, err = model.CacheGetRandomSatisfiedChannel(ctx, tokenGroup.(string), Model.(string))
 			if err != nil {
 				message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", tokenGroup, Model)
 				if channel != nil {
@@ -100,6 +103,8 @@ func Distribute() func(c *gin.Context) {
 			}
 		}
 		SetupContextForSelectedChannel(c, channel, Model.(string))
+		span.SetAttribute("channel_id", channel.Id)
+		span.End()
 		c.Next()
 	}
 }
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"one-api/common"
+	"one-api/common/metrics"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// firstByteWriter 记录首次向客户端写出数据的时间，用于统计首字延迟
+type firstByteWriter struct {
+	gin.ResponseWriter
+	firstByteAt time.Time
+}
+
+func (w *firstByteWriter) Write(data []byte) (int, error) {
+	if w.firstByteAt.IsZero() {
+		w.firstByteAt = time.Now()
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *firstByteWriter) WriteString(s string) (int, error) {
+	if w.firstByteAt.IsZero() {
+		w.firstByteAt = time.Now()
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func RelayMetrics() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !metrics.Enabled {
+			c.Next()
+			return
+		}
+		startTime := time.Now()
+		writer := &firstByteWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		modelName := c.GetString("original_model")
+		channel := strconv.Itoa(c.GetInt("channel_id"))
+		metrics.RelayRequests.Inc(modelName, channel, c.GetString("group"), strconv.Itoa(c.Writer.Status()))
+		metrics.RelayLatency.Observe(time.Since(startTime).Seconds(), modelName, channel)
+		if !writer.firstByteAt.IsZero() {
+			metrics.RelayFirstTokenLatency.Observe(writer.firstByteAt.Sub(startTime).Seconds(), modelName, channel)
+		}
+	}
+}
+
+// MetricsAuth 校验 METRICS_TOKEN 或 IP 白名单，均未配置时仅允许本机访问。
+// 白名单按 TCP 连接的对端地址判断，不信任 X-Forwarded-For 等可伪造的请求头
+func MetricsAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if common.MetricsToken != "" {
+			token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(common.MetricsToken)) == 1 {
+				c.Next()
+				return
+			}
+		}
+		if isMetricsClientAllowed(c.RemoteIP()) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+}
+
+func isMetricsClientAllowed(clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	if common.MetricsIPAllowlist == "" {
+		return common.MetricsToken == "" && ip.IsLoopback()
+	}
+	for _, allowed := range strings.Split(common.MetricsIPAllowlist, ",") {
+		allowed = strings.TrimSpace(allowed)
+		if strings.Contains(allowed, "/") {
+			_, network, err := net.ParseCIDR(allowed)
+			if err == nil && network.Contains(ip) {
+				return true
+			}
+		} else if allowedIP := net.ParseIP(allowed); allowedIP != nil && allowedIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
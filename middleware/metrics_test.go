@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name       string
+		token      string
+		allowlist  string
+		remoteAddr string
+		header     map[string]string
+		wantStatus int
+	}{
+		{"loopback without config", "", "", "127.0.0.1:1234", nil, http.StatusOK},
+		{"remote without config", "", "", "203.0.113.5:1234", nil, http.StatusForbidden},
+		{"spoofed forwarded header", "", "", "203.0.113.5:1234", map[string]string{"X-Forwarded-For": "127.0.0.1", "X-Real-IP": "127.0.0.1"}, http.StatusForbidden},
+		{"allowlisted cidr", "", "10.0.0.0/8", "10.1.2.3:1234", nil, http.StatusOK},
+		{"spoofed allowlisted ip", "", "10.0.0.0/8", "203.0.113.5:1234", map[string]string{"X-Forwarded-For": "10.1.2.3"}, http.StatusForbidden},
+		{"valid token", "secret", "", "203.0.113.5:1234", map[string]string{"Authorization": "Bearer secret"}, http.StatusOK},
+		{"wrong token", "secret", "", "203.0.113.5:1234", map[string]string{"Authorization": "Bearer secreT"}, http.StatusForbidden},
+		{"token set disables loopback default", "secret", "", "127.0.0.1:1234", nil, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			common.MetricsToken = tc.token
+			common.MetricsIPAllowlist = tc.allowlist
+			defer func() {
+				common.MetricsToken = ""
+				common.MetricsIPAllowlist = ""
+			}()
+			router := gin.New()
+			router.GET("/metrics", MetricsAuth(), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.wantStatus, w.Code)
+		})
+	}
+}
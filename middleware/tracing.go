@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/common/tracing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tracing 为每个请求创建服务端 span，并沿用客户端传入的 W3C trace context
+func Tracing() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !tracing.Enabled {
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+		ctx, span := tracing.Start(ctx, c.Request.Method+" "+route, tracing.SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("request_id", c.GetString(common.RequestIdKey))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttribute("http.status_code", status)
+		if id := c.GetInt("id"); id != 0 {
+			span.SetAttribute("user_id", id)
+		}
+		if channelId := c.GetInt("channel_id"); channelId != 0 {
+			span.SetAttribute("channel_id", channelId)
+		}
+		if modelName := c.GetString("original_model"); modelName != "" {
+			span.SetAttribute("model", modelName)
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetError(http.StatusText(status))
+		}
+	}
+}
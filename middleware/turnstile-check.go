@@ -1,18 +1,12 @@
 package middleware
 
 import (
-	"encoding/json"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"net/http"
-	"net/url"
 	"one-api/common"
 )
 
-type turnstileCheckResponse struct {
-	Success bool `json:"success"`
-}
-
 func TurnstileCheck() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if common.TurnstileCheckEnabled {
@@ -22,34 +16,8 @@ func TurnstileCheck() gin.HandlerFunc {
 				c.Next()
 				return
 			}
-			response := c.Query("turnstile")
-			if response == "" {
-				c.JSON(http.StatusOK, gin.H{
-					"success": false,
-					"message": "Turnstile token 为空",
-				})
-				c.Abort()
-				return
-			}
-			rawRes, err := http.PostForm("https://challenges.cloudflare.com/turnstile/v0/siteverify", url.Values{
-				"secret":   {common.TurnstileSecretKey},
-				"response": {response},
-				"remoteip": {c.ClientIP()},
-			})
-			if err != nil {
-				common.SysError(err.Error())
-				c.JSON(http.StatusOK, gin.H{
-					"success": false,
-					"message": err.Error(),
-				})
-				c.Abort()
-				return
-			}
-			defer rawRes.Body.Close()
-			var res turnstileCheckResponse
-			err = json.NewDecoder(rawRes.Body).Decode(&res)
+			err := common.VerifyTurnstile(c.Query("turnstile"), c.ClientIP())
 			if err != nil {
-				common.SysError(err.Error())
 				c.JSON(http.StatusOK, gin.H{
 					"success": false,
 					"message": err.Error(),
@@ -57,14 +25,8 @@ func TurnstileCheck() gin.HandlerFunc {
 				c.Abort()
 				return
 			}
-			if !res.Success {
-				c.JSON(http.StatusOK, gin.H{
-					"success": false,
-					"message": "Turnstile 校验失败，请刷新重试！",
-				})
-				c.Abort()
-				return
-			}
+			// 标记本次请求已消耗过 Turnstile 令牌，登录接口据此避免重复校验同一令牌
+			c.Set("turnstile_verified", true)
 			session.Set("turnstile", true)
 			err = session.Save()
 			if err != nil {
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -226,8 +227,9 @@ func updateRateLimitStatus(channelId int, model string) {
 	channelRateLimitStatus.Store(key, rl)
 }
 
-func GetRandomSatisfiedChannel(group string, model string) (*Channel, error) {
-	abilities, err := getAbilitiesByPriority(group, model)
+func GetRandomSatisfiedChannel(ctx context.Context, group string, model string) (*Channel, error) {
+	db := DB.WithContext(ctx)
+	abilities, err := getAbilitiesByPriority(db, group, model)
 	if err != nil {
 		return nil, err
 	}
@@ -293,7 +295,7 @@ func GetRandomSatisfiedChannel(group string, model string) (*Channel, error) {
 	}
 
 	// 如果所有渠道都超过了频率限制，我们尝试获取下一个优先级的渠道
-	nextPriorityAbilities, err := getNextPriorityAbilities(group, model)
+	nextPriorityAbilities, err := getNextPriorityAbilities(db, group, model)
 	if err != nil {
 		return nil, err
 	}
@@ -313,7 +315,7 @@ func GetRandomSatisfiedChannel(group string, model string) (*Channel, error) {
 	return nil, errors.New("no channels available within rate limits")
 }
 
-func getAbilitiesByPriority(group string, model string) ([]Ability, error) {
+func getAbilitiesByPriority(db *gorm.DB, group string, model string) ([]Ability, error) {
 	var abilities []Ability
 	groupCol := "`group`"
 	trueVal := "1"
@@ -323,8 +325,8 @@ func getAbilitiesByPriority(group string, model string) ([]Ability, error) {
 	}
 
 	var err error = nil
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
-	channelQuery := DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = (?)", group, model, maxPrioritySubQuery)
+	maxPrioritySubQuery := db.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
+	channelQuery := db.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = (?)", group, model, maxPrioritySubQuery)
 	if common.UsingSQLite || common.UsingPostgreSQL {
 		err = channelQuery.Order("weight DESC").Find(&abilities).Error
 	} else {
@@ -337,7 +339,7 @@ func getAbilitiesByPriority(group string, model string) ([]Ability, error) {
 	return abilities, nil
 }
 
-func getNextPriorityAbilities(group string, model string) ([]Ability, error) {
+func getNextPriorityAbilities(db *gorm.DB, group string, model string) ([]Ability, error) {
 	var abilities []Ability
 	groupCol := "`group`"
 	trueVal := "1"
@@ -348,7 +350,7 @@ func getNextPriorityAbilities(group string, model string) ([]Ability, error) {
 
 	// 首先获取当前最高优先级
 	var maxPriority int
-	err := DB.Table("abilities").
+	err := db.Table("abilities").
 		Select("COALESCE(MAX(priority), 0) as max_priority"). // 假定最低优先级为 0
 		Where(groupCol+" = ? AND model = ? AND enabled = "+trueVal, group, model).
 		Pluck("max_priority", &maxPriority).Error
@@ -359,11 +361,11 @@ func getNextPriorityAbilities(group string, model string) ([]Ability, error) {
 
 	// 使用得到的最高优先级来查询次高优先级的渠道列表
 	// 我们从那些其 priority 小于当前最高优先级的记录中选择最大值
-	nextMaxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").
+	nextMaxPrioritySubQuery := db.Model(&Ability{}).Select("MAX(priority)").
 		Where(groupCol+" = ? AND model = ? AND enabled = "+trueVal+" AND priority < ?", group, model, maxPriority)
 
 	// 根据次高优先级查询能力列表
-	err = DB.Where(groupCol+" = ? AND model = ? AND enabled = "+trueVal+" AND priority = (?)", group, model, nextMaxPrioritySubQuery).
+	err = db.Where(groupCol+" = ? AND model = ? AND enabled = "+trueVal+" AND priority = (?)", group, model, nextMaxPrioritySubQuery).
 		Order("weight DESC").
 		Find(&abilities).Error
 
@@ -0,0 +1,188 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strings"
+)
+
+// 管理权限，按路由分组划分。权限名后加 ":read" 表示只读，只能访问 GET 接口
+const (
+	PermUsers         = "users"
+	PermChannels      = "channels"
+	PermOptions       = "options"
+	PermLogs          = "logs" // 日志、数据看板和绘图记录
+	PermTopups        = "topups"
+	PermRedemptions   = "redemptions"
+	PermWithdrawals   = "withdrawals"
+	PermCoupons       = "coupons"
+	PermPlans         = "plans"
+	PermAffiliates    = "affiliates"
+	PermOrganizations = "organizations"
+	PermRefills       = "refills"
+	PermLedger        = "ledger"
+	PermJobs          = "jobs"
+)
+
+const permReadSuffix = ":read"
+
+// AllPermissions 全部可分配的权限
+var AllPermissions = []string{
+	PermUsers, PermChannels, PermOptions, PermLogs, PermTopups, PermRedemptions, PermWithdrawals,
+	PermCoupons, PermPlans, PermAffiliates, PermOrganizations, PermRefills, PermLedger, PermJobs,
+}
+
+// AdminRole 命名角色及其权限集合。分配给管理员时管理员只拥有这些权限，分配给普通用户时授予这些权限；
+// 未分配角色的管理员拥有除系统设置外的全部权限，根用户始终拥有全部权限
+type AdminRole struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255)"`
+	Permissions string `json:"permissions" gorm:"type:text"` // JSON array，如 ["users:read","logs"]
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UserCount   int64  `json:"user_count" gorm:"-:all"`
+}
+
+// ValidatePermissions 校验权限 JSON 数组中的每一项都是已知权限
+func ValidatePermissions(permissions string) error {
+	var list []string
+	if err := json.Unmarshal([]byte(permissions), &list); err != nil {
+		return errors.New("权限必须是 JSON 数组")
+	}
+	for _, permission := range list {
+		name := strings.TrimSuffix(permission, permReadSuffix)
+		known := false
+		for _, p := range AllPermissions {
+			if p == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("未知的权限：%s", permission)
+		}
+	}
+	return nil
+}
+
+func (role *AdminRole) permissionList() []string {
+	var list []string
+	_ = json.Unmarshal([]byte(role.Permissions), &list)
+	return list
+}
+
+func GetAllAdminRoles() (roles []*AdminRole, err error) {
+	err = DB.Order("id asc").Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		DB.Model(&User{}).Where("admin_role_id = ?", role.Id).Count(&role.UserCount)
+	}
+	return roles, nil
+}
+
+func GetAdminRoleById(id int) (*AdminRole, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	role := AdminRole{}
+	err := DB.First(&role, "id = ?", id).Error
+	return &role, err
+}
+
+func (role *AdminRole) Insert() error {
+	role.CreatedTime = common.GetTimestamp()
+	return DB.Create(role).Error
+}
+
+func (role *AdminRole) Update() error {
+	return DB.Model(role).Select("name", "description", "permissions").Updates(role).Error
+}
+
+// DeleteAdminRoleById 删除角色并收回已分配的用户
+func DeleteAdminRoleById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	err := DB.Model(&User{}).Where("admin_role_id = ?", id).Update("admin_role_id", 0).Error
+	if err != nil {
+		return err
+	}
+	return DB.Delete(&AdminRole{}, "id = ?", id).Error
+}
+
+// AssignAdminRole 为用户分配角色，roleId 为 0 时收回
+func AssignAdminRole(userId int, roleId int) error {
+	if roleId != 0 {
+		if _, err := GetAdminRoleById(roleId); err != nil {
+			return errors.New("角色不存在")
+		}
+	}
+	result := DB.Model(&User{}).Where("id = ?", userId).Update("admin_role_id", roleId)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("用户不存在")
+	}
+	return nil
+}
+
+// GetUserPermissions 获取用户拥有的权限，key 为权限名，value 为 true 表示只读
+func GetUserPermissions(userId int, userRole int) (map[string]bool, error) {
+	permissions := make(map[string]bool)
+	if userRole >= common.RoleRootUser {
+		for _, permission := range AllPermissions {
+			permissions[permission] = false
+		}
+		return permissions, nil
+	}
+	var roleId int
+	err := DB.Model(&User{}).Where("id = ?", userId).Select("admin_role_id").Find(&roleId).Error
+	if err != nil {
+		return nil, err
+	}
+	if roleId == 0 {
+		if userRole >= common.RoleAdminUser {
+			for _, permission := range AllPermissions {
+				if permission != PermOptions {
+					permissions[permission] = false
+				}
+			}
+		}
+		return permissions, nil
+	}
+	role, err := GetAdminRoleById(roleId)
+	if err != nil {
+		// 角色已被删除，视为没有额外权限
+		return permissions, nil
+	}
+	for _, permission := range role.permissionList() {
+		name := strings.TrimSuffix(permission, permReadSuffix)
+		readOnly := name != permission
+		if existing, ok := permissions[name]; ok && !existing {
+			continue
+		}
+		permissions[name] = readOnly
+	}
+	return permissions, nil
+}
+
+// HasPermission 检查用户是否拥有任一权限，write 为 true 时只读权限不满足
+func HasPermission(userId int, userRole int, write bool, required ...string) bool {
+	permissions, err := GetUserPermissions(userId, userRole)
+	if err != nil {
+		common.SysError("failed to get user permissions: " + err.Error())
+		return false
+	}
+	for _, permission := range required {
+		readOnly, ok := permissions[permission]
+		if ok && (!write || !readOnly) {
+			return true
+		}
+	}
+	return false
+}
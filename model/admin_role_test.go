@@ -0,0 +1,50 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePermissions(t *testing.T) {
+	assert.NoError(t, ValidatePermissions(`["users:read","logs"]`))
+	assert.NoError(t, ValidatePermissions(`[]`))
+	assert.Error(t, ValidatePermissions(`["users:write"]`))
+	assert.Error(t, ValidatePermissions(`["unknown"]`))
+	assert.Error(t, ValidatePermissions(`users`))
+}
+
+func TestUserPermissions(t *testing.T) {
+	setupTestDB(t)
+	root := createTestUser(t, "perm-root")
+	admin := createTestUser(t, "perm-admin")
+	support := createTestUser(t, "perm-support")
+	require.NoError(t, DB.Model(root).Update("role", common.RoleRootUser).Error)
+	require.NoError(t, DB.Model(admin).Update("role", common.RoleAdminUser).Error)
+
+	// 未分配角色时 root 拥有全部权限，管理员拥有除系统设置外的全部权限，普通用户没有权限
+	assert.True(t, HasPermission(root.Id, common.RoleRootUser, true, PermOptions))
+	assert.True(t, HasPermission(admin.Id, common.RoleAdminUser, true, PermUsers))
+	assert.False(t, HasPermission(admin.Id, common.RoleAdminUser, false, PermOptions))
+	assert.False(t, HasPermission(support.Id, common.RoleCommonUser, false, PermUsers))
+
+	// 重复的权限以可写为准
+	role := &AdminRole{Name: "support", Permissions: `["users:read","logs:read","logs","topups:read"]`}
+	require.NoError(t, role.Insert())
+	require.NoError(t, AssignAdminRole(support.Id, role.Id))
+	assert.Error(t, AssignAdminRole(support.Id, role.Id+100))
+	permissions, err := GetUserPermissions(support.Id, common.RoleCommonUser)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{PermUsers: true, PermLogs: false, PermTopups: true}, permissions)
+	assert.True(t, HasPermission(support.Id, common.RoleCommonUser, false, PermUsers))
+	assert.False(t, HasPermission(support.Id, common.RoleCommonUser, true, PermUsers))
+	assert.True(t, HasPermission(support.Id, common.RoleCommonUser, true, PermUsers, PermLogs))
+	assert.False(t, HasPermission(support.Id, common.RoleCommonUser, false, PermChannels))
+
+	// 分配了角色的管理员只拥有角色内的权限
+	require.NoError(t, AssignAdminRole(admin.Id, role.Id))
+	assert.False(t, HasPermission(admin.Id, common.RoleAdminUser, false, PermChannels))
+	assert.True(t, HasPermission(root.Id, common.RoleRootUser, true, PermChannels))
+}
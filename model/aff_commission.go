@@ -0,0 +1,428 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"one-api/common"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AffCommissionBaseTopup   = "topup"
+	AffCommissionBaseConsume = "consume"
+	AffCommissionBaseBoth    = "both"
+)
+
+const (
+	AffCommissionStatusPending  = "pending"  // 冻结中
+	AffCommissionStatusReleased = "released" // 已计入邀请额度
+	AffCommissionStatusReversed = "reversed" // 已冲回
+)
+
+// affCommissionMaxLevel 最多返佣到邀请人的邀请人
+const affCommissionMaxLevel = 2
+
+// AffCommission 返佣明细，充值返佣关联充值订单或兑换码，消费返佣关联结算批次
+type AffCommission struct {
+	Id          int     `json:"id"`
+	AffiliateId int     `json:"affiliate_id" gorm:"index"`
+	InviteeId   int     `json:"invitee_id" gorm:"index"`
+	Level       int     `json:"level"`
+	Base        string  `json:"base" gorm:"type:varchar(16)"`
+	RefType     string  `json:"ref_type" gorm:"type:varchar(32);index:idx_aff_commission_ref"`
+	RefId       int     `json:"ref_id" gorm:"index:idx_aff_commission_ref"`
+	BaseQuota   int     `json:"base_quota"`
+	Rate        float64 `json:"rate"`
+	Amount      int     `json:"amount"`
+	Status      string  `json:"status" gorm:"type:varchar(16);index"`
+	ReleaseAt   int64   `json:"release_at" gorm:"bigint;index"`
+	CreatedAt   int64   `json:"created_at" gorm:"bigint"`
+}
+
+// AffConsumeSettlement 消费返佣结算批次，记录已结算到的流水时间
+type AffConsumeSettlement struct {
+	Id          int   `json:"id"`
+	FromTime    int64 `json:"from_time" gorm:"bigint"`
+	ToTime      int64 `json:"to_time" gorm:"bigint"`
+	Commissions int   `json:"commissions"`
+	CreatedAt   int64 `json:"created_at" gorm:"bigint"`
+}
+
+// affConsumeSettleLag 只结算写入超过该时长的流水，流水时间取自写入时，给仍未提交的事务留出时间
+const affConsumeSettleLag = 10 * 60
+
+// AffEarningsReport 邀请人返佣汇总
+type AffEarningsReport struct {
+	AffiliateId   int `json:"affiliate_id"`
+	Invitees      int `json:"invitees"`
+	Level1        int `json:"level1"`
+	Level2        int `json:"level2"`
+	Pending       int `json:"pending"`
+	Released      int `json:"released"`
+	ReversedCount int `json:"reversed_count"`
+}
+
+// affCommissionRates 按邀请人分组取各级返佣百分比，未配置时一级返佣使用 ProporTions
+func affCommissionRates(group string) []float64 {
+	if common.AffCommissionRates != "" {
+		var rates map[string][]float64
+		err := json.Unmarshal([]byte(common.AffCommissionRates), &rates)
+		if err != nil {
+			common.SysError("failed to parse AffCommissionRates: " + err.Error())
+		} else if groupRates, ok := rates[group]; ok {
+			return groupRates
+		} else if defaultRates, ok := rates["default"]; ok {
+			return defaultRates
+		}
+	}
+	return []float64{float64(common.ProporTions)}
+}
+
+func ValidateAffCommissionRates(value string) error {
+	if value == "" {
+		return nil
+	}
+	var rates map[string][]float64
+	if json.Unmarshal([]byte(value), &rates) != nil {
+		return errors.New("返佣比例格式错误")
+	}
+	for _, groupRates := range rates {
+		if len(groupRates) > affCommissionMaxLevel {
+			return fmt.Errorf("最多支持 %d 级返佣", affCommissionMaxLevel)
+		}
+		for _, rate := range groupRates {
+			if rate < 0 || rate > 100 {
+				return errors.New("返佣比例必须在 0-100 之间")
+			}
+		}
+	}
+	return nil
+}
+
+// checkSelfReferral 检查被邀请人是否疑似邀请人本人注册，返回原因，未开启检查或正常时返回空字符串
+func checkSelfReferral(inviterId int, invitee *User) string {
+	if !common.AffFraudCheckEnabled {
+		return ""
+	}
+	var inviter User
+	err := DB.Select("id", "email", "register_ip", "last_login_ip").Where("id = ?", inviterId).First(&inviter).Error
+	if err != nil {
+		return ""
+	}
+	if invitee.RegisterIp != "" && (invitee.RegisterIp == inviter.RegisterIp || invitee.RegisterIp == inviter.LastLoginIp) {
+		return "注册 IP 与邀请人相同"
+	}
+	inviteeDomain := emailDomain(invitee.Email)
+	if inviteeDomain != "" && inviteeDomain == emailDomain(inviter.Email) && !common.StringsContains(common.EmailDomainWhitelist, inviteeDomain) {
+		return "邮箱域名与邀请人相同"
+	}
+	return ""
+}
+
+func emailDomain(email string) string {
+	index := strings.LastIndex(email, "@")
+	if index < 0 {
+		return ""
+	}
+	return strings.ToLower(email[index+1:])
+}
+
+// recordAffCommissions 在 tx 中按邀请链为一次充值或一段时间的消费生成返佣，冻结期为 0 时直接计入邀请额度，
+// 返回生成的返佣，事务提交后由调用方通过 logAffCommissions 通知邀请人
+func recordAffCommissions(tx *gorm.DB, inviteeId int, base string, baseQuota int, refType string, refId int) ([]*AffCommission, error) {
+	if baseQuota <= 0 {
+		return nil, nil
+	}
+	var invitee User
+	err := tx.Select("id", "inviter_id", "aff_flagged").Where("id = ?", inviteeId).First(&invitee).Error
+	if err != nil {
+		return nil, err
+	}
+	var commissions []*AffCommission
+	now := common.GetTimestamp()
+	releaseAt := now + int64(common.AffCommissionHoldDays)*24*60*60
+	current := &invitee
+	for level := 1; level <= affCommissionMaxLevel; level++ {
+		if current.InviterId == 0 || current.AffFlagged {
+			break
+		}
+		var affiliate User
+		err = tx.Select("id", "inviter_id", "aff_flagged", "status").Where("id = ?", current.InviterId).Find(&affiliate).Error
+		if err != nil || affiliate.Id == 0 || affiliate.Id == inviteeId {
+			return commissions, err
+		}
+		group, _ := CacheGetUserGroup(affiliate.Id)
+		rates := affCommissionRates(group)
+		if len(rates) >= level && rates[level-1] > 0 && affiliate.Status == common.UserStatusEnabled {
+			rate := rates[level-1]
+			amount := int(float64(baseQuota) * rate / 100)
+			if amount > 0 {
+				commission := &AffCommission{
+					AffiliateId: affiliate.Id,
+					InviteeId:   inviteeId,
+					Level:       level,
+					Base:        base,
+					RefType:     refType,
+					RefId:       refId,
+					BaseQuota:   baseQuota,
+					Rate:        rate,
+					Amount:      amount,
+					Status:      AffCommissionStatusPending,
+					ReleaseAt:   releaseAt,
+					CreatedAt:   now,
+				}
+				err = tx.Create(commission).Error
+				if err != nil {
+					return nil, err
+				}
+				if common.AffCommissionHoldDays <= 0 {
+					_, err = releaseAffCommissionTx(tx, commission)
+					if err != nil {
+						return nil, err
+					}
+				}
+				commissions = append(commissions, commission)
+			}
+		}
+		current = &affiliate
+	}
+	return commissions, nil
+}
+
+// logAffCommissions 通知邀请人新生成的返佣
+func logAffCommissions(commissions []*AffCommission) {
+	for _, commission := range commissions {
+		if commission.Status == AffCommissionStatusReleased {
+			logAffCommissionReleased(commission)
+			continue
+		}
+		RecordLog(commission.AffiliateId, LogTypeSystem, 0, fmt.Sprintf("邀请用户%s返佣 %s（%d 级），将于 %s 后可用",
+			affCommissionBaseNames[commission.Base], common.LogQuota(commission.Amount), commission.Level, time.Unix(commission.ReleaseAt, 0).Format("2006-01-02 15:04:05")))
+	}
+}
+
+func logAffCommissionReleased(commission *AffCommission) {
+	RecordLog(commission.AffiliateId, LogTypeSystem, 0, fmt.Sprintf("邀请用户%s返佣 %s（%d 级）", affCommissionBaseNames[commission.Base], common.LogQuota(commission.Amount), commission.Level))
+}
+
+var affCommissionBaseNames = map[string]string{
+	AffCommissionBaseTopup:   "充值",
+	AffCommissionBaseConsume: "消费",
+}
+
+// releaseAffCommission 冻结期满的返佣计入邀请额度
+func releaseAffCommission(commission *AffCommission) error {
+	released := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		released, err = releaseAffCommissionTx(tx, commission)
+		return err
+	})
+	if err != nil || !released {
+		return err
+	}
+	logAffCommissionReleased(commission)
+	return nil
+}
+
+// releaseAffCommissionTx 在 tx 中将冻结中的返佣计入邀请额度，返佣已不是冻结状态时返回 false
+func releaseAffCommissionTx(tx *gorm.DB, commission *AffCommission) (bool, error) {
+	ref := LedgerRef{Type: LedgerTypeCommission, RefType: commission.RefType, RefId: commission.RefId}
+	if commission.Base == AffCommissionBaseConsume {
+		ref = LedgerRef{Type: LedgerTypeCommission, RefType: LedgerRefUser, RefId: commission.InviteeId}
+	}
+	result := tx.Model(&AffCommission{}).Where("id = ? and status = ?", commission.Id, AffCommissionStatusPending).
+		Update("status", AffCommissionStatusReleased)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	// 冻结期间的部分退款已扣减 amount，以数据库中的金额为准
+	err := tx.Model(&AffCommission{}).Where("id = ?", commission.Id).Select("amount").Find(&commission.Amount).Error
+	if err != nil {
+		return false, err
+	}
+	err = tx.Model(&User{}).Where("id = ?", commission.AffiliateId).Updates(map[string]interface{}{
+		"aff_quota":   gorm.Expr("aff_quota + ?", commission.Amount),
+		"aff_history": gorm.Expr("aff_history + ?", commission.Amount),
+	}).Error
+	if err != nil {
+		return false, err
+	}
+	err = recordLedger(tx, ref, affPosting(commission.AffiliateId, commission.Amount))
+	if err != nil {
+		return false, err
+	}
+	commission.Status = AffCommissionStatusReleased
+	return true, nil
+}
+
+// ReleaseAffCommissions 将冻结期满的返佣计入邀请额度
+func ReleaseAffCommissions() error {
+	var commissions []*AffCommission
+	err := DB.Where("status = ? and release_at <= ?", AffCommissionStatusPending, common.GetTimestamp()).Order("id asc").Limit(1000).Find(&commissions).Error
+	if err != nil {
+		return err
+	}
+	for _, commission := range commissions {
+		err = releaseAffCommission(commission)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to release commission %d: %s", commission.Id, err.Error()))
+		}
+	}
+	return nil
+}
+
+// reverseAffCommissions 充值返佣按退款比例冲回，每次按原始的 BaseQuota*Rate 计算，以剩余未冲回的金额为上限。
+// 冻结中的直接扣减，已到账的从邀请余额扣回（可能因此为负），全部扣完的标记为已冲回，返回冲回的额度
+func reverseAffCommissions(tx *gorm.DB, refType string, refId int, ratio float64) (int, error) {
+	var commissions []*AffCommission
+	err := tx.Where("ref_type = ? and ref_id = ? and status in ?", refType, refId, []string{AffCommissionStatusPending, AffCommissionStatusReleased}).Find(&commissions).Error
+	if err != nil {
+		return 0, err
+	}
+	reversed := 0
+	for _, commission := range commissions {
+		amount := int(math.Round(float64(commission.BaseQuota) * commission.Rate / 100 * ratio))
+		if amount > commission.Amount {
+			amount = commission.Amount
+		}
+		if amount <= 0 {
+			continue
+		}
+		updates := map[string]interface{}{"amount": gorm.Expr("amount - ?", amount)}
+		if amount == commission.Amount {
+			updates["status"] = AffCommissionStatusReversed
+		}
+		result := tx.Model(&AffCommission{}).Where("id = ? and status = ? and amount = ?", commission.Id, commission.Status, commission.Amount).Updates(updates)
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		if result.RowsAffected == 0 {
+			return 0, errors.New("返佣状态已变化，请重试")
+		}
+		reversed += amount
+		if commission.Status != AffCommissionStatusReleased {
+			continue
+		}
+		err = tx.Model(&User{}).Where("id = ?", commission.AffiliateId).Updates(map[string]interface{}{
+			"aff_quota":   gorm.Expr("aff_quota - ?", amount),
+			"aff_history": gorm.Expr("aff_history - ?", amount),
+		}).Error
+		if err != nil {
+			return 0, err
+		}
+		err = recordLedger(tx, LedgerRef{Type: LedgerTypeCommissionReversal, RefType: refType, RefId: refId}, affPosting(commission.AffiliateId, -amount))
+		if err != nil {
+			return 0, err
+		}
+		RecordLog(commission.AffiliateId, LogTypeSystem, 0, fmt.Sprintf("邀请用户充值退款，扣回返现 %s", common.LogQuota(amount)))
+	}
+	return reversed, nil
+}
+
+// SettleConsumeCommissions 按上次结算后的消费流水为邀请人生成消费返佣，首次运行只记录起点不追溯历史消费。
+// 按流水写入时间划分结算区间，结算批次与全部返佣在同一事务中写入，任一用户失败则整批回滚，下次重新结算
+func SettleConsumeCommissions() error {
+	if !common.AffCommissionEnabled || common.AffCommissionBase == AffCommissionBaseTopup {
+		return nil
+	}
+	var last AffConsumeSettlement
+	err := DB.Order("id desc").Limit(1).Find(&last).Error
+	if err != nil {
+		return err
+	}
+	settlement := &AffConsumeSettlement{FromTime: last.ToTime, ToTime: common.GetTimestamp() - affConsumeSettleLag, CreatedAt: common.GetTimestamp()}
+	// 旧版本按流水 id 记录的批次没有结算时间，视同首次运行
+	if last.Id == 0 || last.ToTime == 0 {
+		settlement.FromTime = settlement.ToTime
+		return DB.Create(settlement).Error
+	}
+	if settlement.ToTime <= settlement.FromTime {
+		return nil
+	}
+	var commissions []*AffCommission
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(settlement).Error
+		if err != nil {
+			return err
+		}
+		var consumptions []struct {
+			UserId int
+			Quota  int
+		}
+		err = tx.Table("quota_ledger_entries").
+			Select("quota_ledger_entries.account_id AS user_id, -SUM(quota_ledger_entries.delta) AS quota").
+			Joins("JOIN users ON users.id = quota_ledger_entries.account_id").
+			Where("quota_ledger_entries.account_type = ? and quota_ledger_entries.type in ? and quota_ledger_entries.created_at > ? and quota_ledger_entries.created_at <= ?",
+				LedgerAccountUser, []string{LedgerTypeConsume, LedgerTypeRefund}, settlement.FromTime, settlement.ToTime).
+			Where("users.inviter_id <> 0 and users.aff_flagged = ?", false).
+			Group("quota_ledger_entries.account_id").Scan(&consumptions).Error
+		if err != nil {
+			return err
+		}
+		for _, consumption := range consumptions {
+			if consumption.Quota <= 0 {
+				continue
+			}
+			userCommissions, err := recordAffCommissions(tx, consumption.UserId, AffCommissionBaseConsume, consumption.Quota, "settlement", settlement.Id)
+			if err != nil {
+				return fmt.Errorf("failed to record consume commission for user %d: %w", consumption.UserId, err)
+			}
+			commissions = append(commissions, userCommissions...)
+		}
+		return tx.Model(&AffConsumeSettlement{}).Where("id = ?", settlement.Id).Update("commissions", len(commissions)).Error
+	})
+	if err != nil {
+		return err
+	}
+	logAffCommissions(commissions)
+	return nil
+}
+
+// SetUserAffFlagged 管理员标记或解除疑似自我邀请，标记时冲回该用户带来的冻结中返佣
+func SetUserAffFlagged(userId int, flagged bool) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", userId).Update("aff_flagged", flagged)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("用户不存在")
+		}
+		if !flagged {
+			return nil
+		}
+		return tx.Model(&AffCommission{}).Where("invitee_id = ? and status = ?", userId, AffCommissionStatusPending).
+			Update("status", AffCommissionStatusReversed).Error
+	})
+}
+
+func GetAffCommissions(affiliateId int, startIdx int, num int) (commissions []*AffCommission, err error) {
+	err = DB.Where("affiliate_id = ?", affiliateId).Order("id desc").Limit(num).Offset(startIdx).Find(&commissions).Error
+	return commissions, err
+}
+
+const affEarningsSelect = "affiliate_id, COUNT(DISTINCT invitee_id) AS invitees, " +
+	"SUM(CASE WHEN level = 1 AND status <> 'reversed' THEN amount ELSE 0 END) AS level1, " +
+	"SUM(CASE WHEN level = 2 AND status <> 'reversed' THEN amount ELSE 0 END) AS level2, " +
+	"SUM(CASE WHEN status = 'pending' THEN amount ELSE 0 END) AS pending, " +
+	"SUM(CASE WHEN status = 'released' THEN amount ELSE 0 END) AS released, " +
+	"SUM(CASE WHEN status = 'reversed' THEN 1 ELSE 0 END) AS reversed_count"
+
+// GetAffEarningsReports 按邀请人汇总返佣，按已到账金额从高到低排序
+func GetAffEarningsReports(startIdx int, num int) (reports []*AffEarningsReport, err error) {
+	err = DB.Model(&AffCommission{}).Select(affEarningsSelect).Group("affiliate_id").
+		Order("released desc").Limit(num).Offset(startIdx).Scan(&reports).Error
+	return reports, err
+}
+
+func GetAffEarningsReport(affiliateId int) (*AffEarningsReport, error) {
+	report := &AffEarningsReport{AffiliateId: affiliateId}
+	err := DB.Model(&AffCommission{}).Select(affEarningsSelect).Where("affiliate_id = ?", affiliateId).Group("affiliate_id").Scan(report).Error
+	return report, err
+}
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSettleConsumeCommissions(t *testing.T) {
+	setupTestDB(t)
+	common.AffCommissionEnabled = true
+	common.AffCommissionBase = AffCommissionBaseConsume
+	common.AffCommissionHoldDays = 0
+	common.ProporTions = 10
+	defer func() {
+		common.AffCommissionEnabled = false
+		common.AffCommissionBase = AffCommissionBaseTopup
+	}()
+	inviter := createTestUser(t, "inviter")
+	invitee := createTestUser(t, "invitee")
+	require.NoError(t, DB.Model(invitee).Update("inviter_id", inviter.Id).Error)
+	require.NoError(t, GrantUserQuota(invitee.Id, 5000, QuotaLotSourceTopup, 1, 0))
+
+	// 首次运行只记录起点
+	require.NoError(t, SettleConsumeCommissions())
+	now := common.GetTimestamp()
+	require.NoError(t, DB.Model(&AffConsumeSettlement{}).Where("1 = 1").Update("to_time", now-3000).Error)
+
+	ref := LedgerRef{Type: LedgerTypeConsume, RefType: LedgerRefToken, RefId: 1}
+	require.NoError(t, DecreaseUserQuota(invitee.Id, 1000, ref))
+	require.NoError(t, DB.Model(&QuotaLedgerEntry{}).Where("type = ?", LedgerTypeConsume).Update("created_at", now-2000).Error)
+	// 刚写入的流水可能还有未提交的同批事务，留到下次结算
+	require.NoError(t, DecreaseUserQuota(invitee.Id, 500, ref))
+
+	require.NoError(t, SettleConsumeCommissions())
+	require.NoError(t, SettleConsumeCommissions())
+	require.Equal(t, 100, getTestAffQuota(t, inviter.Id))
+	var settlements []*AffConsumeSettlement
+	require.NoError(t, DB.Order("id asc").Find(&settlements).Error)
+	require.Len(t, settlements, 2)
+	require.Equal(t, now-3000, settlements[1].FromTime)
+	require.Equal(t, 1, settlements[1].Commissions)
+	requireLedgerBalanced(t)
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -21,19 +22,17 @@ var (
 	UserId2StatusCacheSeconds = common.SyncFrequency
 )
 
-func CacheGetTokenByKey(key string) (*Token, error) {
-	keyCol := "`key`"
-	if common.UsingPostgreSQL {
-		keyCol = `"key"`
-	}
+// CacheGetTokenByKey 按令牌哈希查找，Redis 缓存同样以哈希为键
+func CacheGetTokenByKey(ctx context.Context, key string) (*Token, error) {
+	keyHash := hashAccessKey(key)
 	var token Token
 	if !common.RedisEnabled {
-		err := DB.Where(keyCol+" = ?", key).First(&token).Error
+		err := DB.WithContext(ctx).Where("key_hash = ?", keyHash).First(&token).Error
 		return &token, err
 	}
-	tokenObjectString, err := common.RedisGet(fmt.Sprintf("token:%s", key))
+	tokenObjectString, err := common.RedisGetContext(ctx, fmt.Sprintf("token:%s", keyHash))
 	if err != nil {
-		err := DB.Where(keyCol+" = ?", key).First(&token).Error
+		err := DB.WithContext(ctx).Where("key_hash = ?", keyHash).First(&token).Error
 		if err != nil {
 			return nil, err
 		}
@@ -41,13 +40,14 @@ func CacheGetTokenByKey(key string) (*Token, error) {
 		if err != nil {
 			return nil, err
 		}
-		err = common.RedisSet(fmt.Sprintf("token:%s", key), string(jsonBytes), time.Duration(TokenCacheSeconds)*time.Second)
+		err = common.RedisSetContext(ctx, fmt.Sprintf("token:%s", keyHash), string(jsonBytes), time.Duration(TokenCacheSeconds)*time.Second)
 		if err != nil {
 			common.SysError("Redis set token error: " + err.Error())
 		}
 		return &token, nil
 	}
 	err = json.Unmarshal([]byte(tokenObjectString), &token)
+	token.KeyHash = keyHash
 	return &token, err
 }
 
@@ -69,17 +69,39 @@ func CacheGetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
-func CacheGetUserQuota(id int) (quota int, err error) {
+// CacheDeleteUserGroup 分组变更后清除缓存，下次读取时从数据库加载
+func CacheDeleteUserGroup(id int) {
+	if !common.RedisEnabled {
+		return
+	}
+	err := common.RedisDel(fmt.Sprintf("user_group:%d", id))
+	if err != nil {
+		common.SysError("Redis delete user group error: " + err.Error())
+	}
+}
+
+// CacheDeleteUserEnabled 用户被禁用后清除状态缓存，令牌鉴权立即读取数据库中的状态
+func CacheDeleteUserEnabled(id int) {
+	if !common.RedisEnabled {
+		return
+	}
+	err := common.RedisDel(fmt.Sprintf("user_enabled:%d", id))
+	if err != nil {
+		common.SysError("Redis delete user enabled error: " + err.Error())
+	}
+}
+
+func CacheGetUserQuota(ctx context.Context, id int) (quota int, err error) {
 	if !common.RedisEnabled {
-		return GetUserQuota(id)
+		return getUserQuota(DB.WithContext(ctx), id)
 	}
-	quotaString, err := common.RedisGet(fmt.Sprintf("user_quota:%d", id))
+	quotaString, err := common.RedisGetContext(ctx, fmt.Sprintf("user_quota:%d", id))
 	if err != nil {
-		quota, err = GetUserQuota(id)
+		quota, err = getUserQuota(DB.WithContext(ctx), id)
 		if err != nil {
 			return 0, err
 		}
-		err = common.RedisSet(fmt.Sprintf("user_quota:%d", id), fmt.Sprintf("%d", quota), time.Duration(UserId2QuotaCacheSeconds)*time.Second)
+		err = common.RedisSetContext(ctx, fmt.Sprintf("user_quota:%d", id), fmt.Sprintf("%d", quota), time.Duration(UserId2QuotaCacheSeconds)*time.Second)
 		if err != nil {
 			common.SysError("Redis set user quota error: " + err.Error())
 		}
@@ -93,7 +115,7 @@ func CacheUpdateUserQuota(id int) error {
 	if !common.RedisEnabled {
 		return nil
 	}
-	quota, err := CacheGetUserQuota(id)
+	quota, err := CacheGetUserQuota(context.Background(), id)
 	if err != nil {
 		return err
 	}
@@ -101,24 +123,24 @@ func CacheUpdateUserQuota(id int) error {
 	return err
 }
 
-func CacheDecreaseUserQuota(id int, quota int) error {
+func CacheDecreaseUserQuota(ctx context.Context, id int, quota int) error {
 	if !common.RedisEnabled {
 		return nil
 	}
-	err := common.RedisDecrease(fmt.Sprintf("user_quota:%d", id), int64(quota))
+	err := common.RedisDecreaseContext(ctx, fmt.Sprintf("user_quota:%d", id), int64(quota))
 	return err
 }
 
-func CacheIsUserEnabled(userId int) (bool, error) {
+func CacheIsUserEnabled(ctx context.Context, userId int) (bool, error) {
 	if !common.RedisEnabled {
-		return IsUserEnabled(userId)
+		return isUserEnabled(DB.WithContext(ctx), userId)
 	}
-	enabled, err := common.RedisGet(fmt.Sprintf("user_enabled:%d", userId))
+	enabled, err := common.RedisGetContext(ctx, fmt.Sprintf("user_enabled:%d", userId))
 	if err == nil {
 		return enabled == "1", nil
 	}
 
-	userEnabled, err := IsUserEnabled(userId)
+	userEnabled, err := isUserEnabled(DB.WithContext(ctx), userId)
 	if err != nil {
 		return false, err
 	}
@@ -126,7 +148,7 @@ func CacheIsUserEnabled(userId int) (bool, error) {
 	if userEnabled {
 		enabled = "1"
 	}
-	err = common.RedisSet(fmt.Sprintf("user_enabled:%d", userId), enabled, time.Duration(UserId2StatusCacheSeconds)*time.Second)
+	err = common.RedisSetContext(ctx, fmt.Sprintf("user_enabled:%d", userId), enabled, time.Duration(UserId2StatusCacheSeconds)*time.Second)
 	if err != nil {
 		common.SysError("Redis set user enabled error: " + err.Error())
 	}
@@ -186,6 +208,71 @@ func InitChannelCache() {
 	common.SysLog("channels synced from database")
 }
 
+// CacheRefreshChannel 从数据库重新加载单个渠道并增量更新内存缓存，渠道已删除或被禁用时将其移出缓存
+func CacheRefreshChannel(id int) {
+	channel, err := GetChannelById(id, true)
+	if err != nil {
+		channel = nil
+	}
+	channelSyncLock.Lock()
+	defer channelSyncLock.Unlock()
+	if group2model2channels == nil || channelsIDM == nil {
+		return
+	}
+	removeChannelFromCache(id)
+	if channel != nil && channel.Status == common.ChannelStatusEnabled {
+		addChannelToCache(channel)
+	}
+}
+
+// removeChannelFromCache 调用方需持有 channelSyncLock 写锁
+func removeChannelFromCache(id int) {
+	old, ok := channelsIDM[id]
+	if !ok {
+		return
+	}
+	delete(channelsIDM, id)
+	for _, group := range strings.Split(old.Group, ",") {
+		model2channels, ok := group2model2channels[group]
+		if !ok {
+			continue
+		}
+		for _, model := range strings.Split(old.Models, ",") {
+			channels := model2channels[model]
+			newChannels := make([]*Channel, 0, len(channels))
+			for _, ch := range channels {
+				if ch.Id != id {
+					newChannels = append(newChannels, ch)
+				}
+			}
+			if len(newChannels) == 0 {
+				delete(model2channels, model)
+			} else {
+				model2channels[model] = newChannels
+			}
+		}
+	}
+}
+
+// addChannelToCache 调用方需持有 channelSyncLock 写锁
+func addChannelToCache(channel *Channel) {
+	channelsIDM[channel.Id] = channel
+	for _, group := range strings.Split(channel.Group, ",") {
+		if _, ok := group2model2channels[group]; !ok {
+			group2model2channels[group] = make(map[string][]*Channel)
+		}
+		for _, model := range strings.Split(channel.Models, ",") {
+			channels := append(make([]*Channel, 0, len(group2model2channels[group][model])+1), group2model2channels[group][model]...)
+			channels = append(channels, channel)
+			sort.Slice(channels, func(i, j int) bool {
+				return channels[i].GetPriority() > channels[j].GetPriority()
+			})
+			group2model2channels[group][model] = channels
+		}
+	}
+}
+
+// SyncChannelCache 作为兜底定期全量同步，日常变更通过 CacheRefreshChannel 增量生效
 func SyncChannelCache(frequency int) {
 	for {
 		time.Sleep(time.Duration(frequency) * time.Second)
@@ -194,14 +281,14 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func CacheGetRandomSatisfiedChannel(group string, model string) (*Channel, error) {
+func CacheGetRandomSatisfiedChannel(ctx context.Context, group string, model string) (*Channel, error) {
 	if strings.HasPrefix(model, "gpt-4-gizmo") {
 		model = "gpt-4-gizmo-*"
 	}
 
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model)
+		return GetRandomSatisfiedChannel(ctx, group, model)
 	}
 
 	channelSyncLock.RLock()
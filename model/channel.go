@@ -115,6 +115,7 @@ func BatchInsertChannels(channels []Channel) error {
 		if err != nil {
 			return err
 		}
+		PublishChannelInvalidation(channel_.Id)
 	}
 	return nil
 }
@@ -136,6 +137,9 @@ func BatchDeleteChannels(ids []int) error {
 	}
 	// 提交事务
 	tx.Commit()
+	for _, id := range ids {
+		PublishChannelInvalidation(id)
+	}
 	return err
 }
 
@@ -205,7 +209,11 @@ func (channel *Channel) Insert() error {
 		return err
 	}
 	err = channel.AddAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	PublishChannelInvalidation(channel.Id)
+	return nil
 }
 
 func (channel *Channel) Update() error {
@@ -216,7 +224,11 @@ func (channel *Channel) Update() error {
 	}
 	DB.Model(channel).First(channel, "id = ?", channel.Id)
 	err = channel.UpdateAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	PublishChannelInvalidation(channel.Id)
+	return nil
 }
 
 func (channel *Channel) UpdateResponseTime(responseTime int64) {
@@ -246,7 +258,11 @@ func (channel *Channel) Delete() error {
 		return err
 	}
 	err = channel.DeleteAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	PublishChannelInvalidation(channel.Id)
+	return nil
 }
 
 func UpdateChannelStatusById(id int, status int) {
@@ -258,6 +274,7 @@ func UpdateChannelStatusById(id int, status int) {
 	if err != nil {
 		common.SysError("failed to update channel status: " + err.Error())
 	}
+	PublishChannelInvalidation(id)
 }
 
 func UpdateChannelUsedQuota(id int, quota int) {
@@ -281,10 +298,12 @@ func updateChannelUsedQuota(id int, quota int) {
 
 func DeleteChannelByStatus(status int64) (int64, error) {
 	result := DB.Where("status = ?", status).Delete(&Channel{})
+	PublishCacheInvalidation(InvalidationTypeAllChannels, "")
 	return result.RowsAffected, result.Error
 }
 
 func DeleteDisabledChannel() (int64, error) {
 	result := DB.Where("status = ? or status = ?", common.ChannelStatusAutoDisabled, common.ChannelStatusManuallyDisabled).Delete(&Channel{})
+	PublishCacheInvalidation(InvalidationTypeAllChannels, "")
 	return result.RowsAffected, result.Error
 }
@@ -0,0 +1,287 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"one-api/common"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	CouponDiscountPercent = "percent" // 按百分比折扣，DiscountValue 为 0-100
+	CouponDiscountFixed   = "fixed"   // 立减金额
+)
+
+const (
+	CouponUsageStatusPending  = "pending"  // 已下单未支付，占用次数
+	CouponUsageStatusUsed     = "used"     // 订单已支付
+	CouponUsageStatusReleased = "released" // 订单关闭，次数已退回
+)
+
+// Coupon 充值优惠码，可设置折扣、赠送额度、使用门槛、次数限制、有效期和可用分组
+type Coupon struct {
+	Id             int     `json:"id"`
+	Code           string  `json:"code" gorm:"type:varchar(32);uniqueIndex"`
+	Campaign       string  `json:"campaign" gorm:"type:varchar(64);index"`
+	Status         int     `json:"status" gorm:"default:1"`
+	DiscountType   string  `json:"discount_type" gorm:"type:varchar(16);default:'percent'"`
+	DiscountValue  float64 `json:"discount_value"`
+	BonusQuota     int     `json:"bonus_quota"`       // 支付成功后额外赠送的额度
+	MinMoney       float64 `json:"min_money"`         // 折扣前的最低支付金额
+	AllowedGroups  string  `json:"allowed_groups"`    // 逗号分隔的可用分组，为空时不限制
+	MaxUses        int     `json:"max_uses"`          // 总使用次数，0 为不限
+	MaxUsesPerUser int     `json:"max_uses_per_user"` // 每个用户可用次数，0 为不限
+	UsedCount      int     `json:"used_count"`
+	StartTime      int64   `json:"start_time" gorm:"bigint"` // 0 为不限
+	EndTime        int64   `json:"end_time" gorm:"bigint"`   // 0 为不限
+	CreatedTime    int64   `json:"created_time" gorm:"bigint"`
+	Count          int     `json:"count" gorm:"-:all"` // only for api request
+}
+
+// CouponUsage 优惠码使用记录，下单时创建，订单支付或关闭时更新状态
+type CouponUsage struct {
+	Id          int     `json:"id"`
+	CouponId    int     `json:"coupon_id" gorm:"index"`
+	Campaign    string  `json:"campaign" gorm:"type:varchar(64);index"`
+	UserId      int     `json:"user_id" gorm:"index"`
+	TopUpId     int     `json:"top_up_id" gorm:"index"`
+	Status      string  `json:"status" gorm:"type:varchar(16)"`
+	Discount    float64 `json:"discount"`
+	BonusQuota  int     `json:"bonus_quota"`
+	CreatedTime int64   `json:"created_time" gorm:"bigint"`
+}
+
+// CouponCampaignReport 按活动汇总的优惠码使用情况，只统计已支付的订单
+type CouponCampaignReport struct {
+	Campaign   string  `json:"campaign"`
+	Uses       int     `json:"uses"`
+	Users      int     `json:"users"`
+	Discount   float64 `json:"discount"`
+	BonusQuota int     `json:"bonus_quota"`
+	PaidMoney  float64 `json:"paid_money"`
+	Pending    int     `json:"pending"`
+}
+
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func GetAllCoupons(startIdx int, num int) (coupons []*Coupon, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&coupons).Error
+	return coupons, err
+}
+
+func SearchCoupons(keyword string) (coupons []*Coupon, err error) {
+	keyword = strings.TrimSpace(keyword)
+	err = DB.Where("id = ? or code LIKE ? or campaign LIKE ?", keyword, NormalizeCouponCode(keyword)+"%", keyword+"%").Find(&coupons).Error
+	return coupons, err
+}
+
+func GetCouponById(id int) (*Coupon, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	coupon := Coupon{Id: id}
+	err := DB.First(&coupon, "id = ?", id).Error
+	return &coupon, err
+}
+
+func GetCouponByCode(code string) (*Coupon, error) {
+	code = NormalizeCouponCode(code)
+	if code == "" {
+		return nil, errors.New("未提供优惠码")
+	}
+	coupon := Coupon{}
+	err := DB.Where("code = ?", code).First(&coupon).Error
+	if err != nil {
+		return nil, errors.New("无效的优惠码")
+	}
+	return &coupon, nil
+}
+
+func (coupon *Coupon) Insert() error {
+	coupon.Code = NormalizeCouponCode(coupon.Code)
+	return DB.Create(coupon).Error
+}
+
+// Update 已使用次数由下单流程维护，不在此更新
+func (coupon *Coupon) Update() error {
+	return DB.Model(coupon).Select("campaign", "status", "discount_type", "discount_value", "bonus_quota", "min_money",
+		"allowed_groups", "max_uses", "max_uses_per_user", "start_time", "end_time").Updates(coupon).Error
+}
+
+func DeleteCouponById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	return DB.Delete(&Coupon{}, "id = ?", id).Error
+}
+
+// Validate 检查优惠码配置是否合法
+func (coupon *Coupon) Validate() error {
+	switch coupon.DiscountType {
+	case CouponDiscountPercent:
+		if coupon.DiscountValue < 0 || coupon.DiscountValue >= 100 {
+			return errors.New("折扣百分比必须在 0-100 之间")
+		}
+	case CouponDiscountFixed:
+		if coupon.DiscountValue < 0 {
+			return errors.New("立减金额不能为负数")
+		}
+	default:
+		return errors.New("未知的折扣类型")
+	}
+	if coupon.BonusQuota < 0 || coupon.MinMoney < 0 || coupon.MaxUses < 0 || coupon.MaxUsesPerUser < 0 {
+		return errors.New("赠送额度、最低金额和次数限制不能为负数")
+	}
+	if coupon.EndTime != 0 && coupon.EndTime < coupon.StartTime {
+		return errors.New("结束时间不能早于开始时间")
+	}
+	return nil
+}
+
+// Apply 检查用户能否对该笔订单使用优惠码，返回优惠金额。次数限制在下单时再次以条件更新校验
+func (coupon *Coupon) Apply(userId int, group string, money float64) (float64, error) {
+	if coupon.Status != common.CouponStatusEnabled {
+		return 0, errors.New("该优惠码已停用")
+	}
+	now := common.GetTimestamp()
+	if coupon.StartTime != 0 && now < coupon.StartTime {
+		return 0, errors.New("该优惠码尚未生效")
+	}
+	if coupon.EndTime != 0 && now > coupon.EndTime {
+		return 0, errors.New("该优惠码已过期")
+	}
+	if coupon.AllowedGroups != "" && !common.StringsContains(strings.Split(coupon.AllowedGroups, ","), group) {
+		return 0, errors.New("当前分组不可使用该优惠码")
+	}
+	if money < coupon.MinMoney {
+		return 0, fmt.Errorf("订单金额需满 %.2f 才可使用该优惠码", coupon.MinMoney)
+	}
+	if coupon.MaxUses > 0 && coupon.UsedCount >= coupon.MaxUses {
+		return 0, errors.New("该优惠码已被领完")
+	}
+	if coupon.MaxUsesPerUser > 0 {
+		used, err := countUserCouponUsages(DB, coupon.Id, userId)
+		if err != nil {
+			return 0, err
+		}
+		if used >= int64(coupon.MaxUsesPerUser) {
+			return 0, errors.New("已达到该优惠码的使用次数上限")
+		}
+	}
+	discount := 0.0
+	switch coupon.DiscountType {
+	case CouponDiscountPercent:
+		discount = money * coupon.DiscountValue / 100
+	case CouponDiscountFixed:
+		discount = coupon.DiscountValue
+	}
+	discount = math.Round(discount*100) / 100
+	if money-discount < 0.01 {
+		return 0, errors.New("优惠后的支付金额必须大于 0")
+	}
+	return discount, nil
+}
+
+func countUserCouponUsages(tx *gorm.DB, couponId int, userId int) (count int64, err error) {
+	err = tx.Model(&CouponUsage{}).Where("coupon_id = ? and user_id = ? and status in ?", couponId, userId,
+		[]string{CouponUsageStatusPending, CouponUsageStatusUsed}).Count(&count).Error
+	return count, err
+}
+
+// InsertWithCoupon 创建使用优惠码的订单，同时占用一次使用次数，次数不足时不创建订单
+func (topUp *TopUp) InsertWithCoupon(coupon *Coupon) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Coupon{}).Where("id = ? and status = ? and (max_uses = 0 or used_count < max_uses)", coupon.Id, common.CouponStatusEnabled).
+			Update("used_count", gorm.Expr("used_count + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("该优惠码已被领完")
+		}
+		if coupon.MaxUsesPerUser > 0 {
+			used, err := countUserCouponUsages(tx, coupon.Id, topUp.UserId)
+			if err != nil {
+				return err
+			}
+			if used >= int64(coupon.MaxUsesPerUser) {
+				return errors.New("已达到该优惠码的使用次数上限")
+			}
+		}
+		err := tx.Create(topUp).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&CouponUsage{
+			CouponId:    coupon.Id,
+			Campaign:    coupon.Campaign,
+			UserId:      topUp.UserId,
+			TopUpId:     topUp.Id,
+			Status:      CouponUsageStatusPending,
+			Discount:    topUp.Discount,
+			BonusQuota:  topUp.BonusQuota,
+			CreatedTime: common.GetTimestamp(),
+		}).Error
+	})
+}
+
+// useTopUpCoupon 订单支付成功时确认优惠码使用，订单关闭后才到账的支付会重新计入次数
+func useTopUpCoupon(tx *gorm.DB, topUp *TopUp) error {
+	if topUp.CouponId == 0 {
+		return nil
+	}
+	result := tx.Model(&CouponUsage{}).Where("top_up_id = ? and status = ?", topUp.Id, CouponUsageStatusReleased).Update("status", CouponUsageStatusUsed)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		err := tx.Model(&Coupon{}).Where("id = ?", topUp.CouponId).Update("used_count", gorm.Expr("used_count + ?", result.RowsAffected)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Model(&CouponUsage{}).Where("top_up_id = ? and status = ?", topUp.Id, CouponUsageStatusPending).Update("status", CouponUsageStatusUsed).Error
+}
+
+// releaseTopUpCoupon 订单关闭时退回占用的使用次数
+func releaseTopUpCoupon(tx *gorm.DB, topUp *TopUp) error {
+	if topUp.CouponId == 0 {
+		return nil
+	}
+	result := tx.Model(&CouponUsage{}).Where("top_up_id = ? and status = ?", topUp.Id, CouponUsageStatusPending).Update("status", CouponUsageStatusReleased)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return tx.Model(&Coupon{}).Where("id = ? and used_count >= ?", topUp.CouponId, result.RowsAffected).
+		Update("used_count", gorm.Expr("used_count - ?", result.RowsAffected)).Error
+}
+
+func GetCouponUsages(couponId int, startIdx int, num int) (usages []*CouponUsage, err error) {
+	err = DB.Where("coupon_id = ?", couponId).Order("id desc").Limit(num).Offset(startIdx).Find(&usages).Error
+	return usages, err
+}
+
+// GetCouponCampaignReports 按活动统计优惠码的使用次数、用户数、优惠金额、赠送额度和实收金额
+func GetCouponCampaignReports(campaign string) (reports []*CouponCampaignReport, err error) {
+	query := DB.Table("coupon_usages").
+		Select("coupon_usages.campaign AS campaign, " +
+			"SUM(CASE WHEN coupon_usages.status = 'used' THEN 1 ELSE 0 END) AS uses, " +
+			"COUNT(DISTINCT CASE WHEN coupon_usages.status = 'used' THEN coupon_usages.user_id END) AS users, " +
+			"SUM(CASE WHEN coupon_usages.status = 'used' THEN coupon_usages.discount ELSE 0 END) AS discount, " +
+			"SUM(CASE WHEN coupon_usages.status = 'used' THEN coupon_usages.bonus_quota ELSE 0 END) AS bonus_quota, " +
+			"SUM(CASE WHEN coupon_usages.status = 'used' THEN top_ups.money ELSE 0 END) AS paid_money, " +
+			"SUM(CASE WHEN coupon_usages.status = 'pending' THEN 1 ELSE 0 END) AS pending").
+		Joins("LEFT JOIN top_ups ON top_ups.id = coupon_usages.top_up_id").
+		Group("coupon_usages.campaign").
+		Order("coupon_usages.campaign")
+	if campaign != "" {
+		query = query.Where("coupon_usages.campaign = ?", campaign)
+	}
+	err = query.Scan(&reports).Error
+	return reports, err
+}
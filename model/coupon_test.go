@@ -0,0 +1,96 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestCouponTopUp(t *testing.T, userId int, coupon *Coupon) (*TopUp, error) {
+	t.Helper()
+	discount, err := coupon.Apply(userId, "default", 10)
+	if err != nil {
+		return nil, err
+	}
+	topUp := &TopUp{
+		UserId:     userId,
+		Amount:     10,
+		Money:      10 - discount,
+		TopupRatio: "-1",
+		CouponId:   coupon.Id,
+		Discount:   discount,
+		BonusQuota: coupon.BonusQuota,
+		TradeNo:    common.GetRandomString(16),
+		CreateTime: common.GetTimestamp(),
+		Status:     TopUpStatusPending,
+	}
+	return topUp, topUp.InsertWithCoupon(coupon)
+}
+
+func getTestCouponUsedCount(t *testing.T, id int) int {
+	t.Helper()
+	coupon, err := GetCouponById(id)
+	require.NoError(t, err)
+	return coupon.UsedCount
+}
+
+func TestCouponReserveAndRelease(t *testing.T) {
+	setupTestDB(t)
+	coupon := &Coupon{
+		Code:           "SPRING",
+		Campaign:       "spring",
+		Status:         common.CouponStatusEnabled,
+		DiscountType:   CouponDiscountPercent,
+		DiscountValue:  20,
+		BonusQuota:     100,
+		MaxUses:        1,
+		MaxUsesPerUser: 1,
+	}
+	require.NoError(t, coupon.Insert())
+	first := createTestUser(t, "coupon-1")
+	second := createTestUser(t, "coupon-2")
+
+	// 下单即占用次数，未支付的订单也会挡住其他用户
+	pending, err := createTestCouponTopUp(t, first.Id, coupon)
+	require.NoError(t, err)
+	assert.Equal(t, 8.0, pending.Money)
+	assert.Equal(t, 1, getTestCouponUsedCount(t, coupon.Id))
+	_, err = createTestCouponTopUp(t, second.Id, coupon)
+	assert.Error(t, err)
+	coupon, err = GetCouponById(coupon.Id)
+	require.NoError(t, err)
+	_, err = createTestCouponTopUp(t, second.Id, coupon)
+	assert.Error(t, err)
+
+	// 订单过期后退回次数
+	require.NoError(t, ExpireTopUp(pending))
+	assert.Zero(t, getTestCouponUsedCount(t, coupon.Id))
+	coupon, err = GetCouponById(coupon.Id)
+	require.NoError(t, err)
+	paid, err := createTestCouponTopUp(t, second.Id, coupon)
+	require.NoError(t, err)
+	completed, err := CompleteTopUp(paid, "", paid.Money)
+	require.NoError(t, err)
+	assert.True(t, completed)
+	assert.Equal(t, int(10*common.QuotaPerUnit)+100, getTestUserQuota(t, second.Id))
+
+	// 过期后才到账的支付照常发放并重新计入次数
+	completed, err = CompleteTopUp(GetTopUpById(pending.Id), "", pending.Money)
+	require.NoError(t, err)
+	assert.True(t, completed)
+	assert.Equal(t, int(10*common.QuotaPerUnit)+100, getTestUserQuota(t, first.Id))
+	assert.Equal(t, 2, getTestCouponUsedCount(t, coupon.Id))
+
+	reports, err := GetCouponCampaignReports("spring")
+	require.NoError(t, err)
+	require.Len(t, reports, 1)
+	assert.Equal(t, 2, reports[0].Uses)
+	assert.Equal(t, 2, reports[0].Users)
+	assert.Equal(t, 4.0, reports[0].Discount)
+	assert.Equal(t, 200, reports[0].BonusQuota)
+	assert.Equal(t, 16.0, reports[0].PaidMoney)
+	assert.Zero(t, reports[0].Pending)
+	requireLedgerBalanced(t)
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	GroupGrantStatusActive   = "active"
+	GroupGrantStatusExpired  = "expired"
+	GroupGrantStatusReplaced = "replaced" // 被新的其他分组授予覆盖
+)
+
+const (
+	GroupGrantSourceRedemption = "redemption"
+)
+
+// GroupGrant 限时分组，到期后恢复为授予前的分组。同一时间每个用户只有一条生效记录
+type GroupGrant struct {
+	Id            int    `json:"id"`
+	UserId        int    `json:"user_id" gorm:"index"`
+	Group         string `json:"group" gorm:"column:grant_group;type:varchar(32)"`
+	PreviousGroup string `json:"previous_group" gorm:"type:varchar(32)"`
+	Source        string `json:"source" gorm:"type:varchar(32)"`
+	SourceId      int    `json:"source_id"`
+	Status        string `json:"status" gorm:"type:varchar(16);index"`
+	ExpiresAt     int64  `json:"expires_at" gorm:"bigint;index"`
+	CreatedAt     int64  `json:"created_at" gorm:"bigint"`
+}
+
+// grantUserGroup 将用户切换到 group 并在 days 天后恢复；已有相同分组的生效记录时顺延到期时间
+func grantUserGroup(tx *gorm.DB, userId int, group string, days int, source string, sourceId int) (*GroupGrant, error) {
+	if group == "" || days <= 0 {
+		return nil, errors.New("分组和天数不能为空")
+	}
+	now := common.GetTimestamp()
+	var active GroupGrant
+	err := tx.Where("user_id = ? and status = ?", userId, GroupGrantStatusActive).Limit(1).Find(&active).Error
+	if err != nil {
+		return nil, err
+	}
+	if active.Id != 0 && active.Group == group {
+		start := active.ExpiresAt
+		if start < now {
+			start = now
+		}
+		active.ExpiresAt = start + int64(days)*24*60*60
+		err = tx.Model(&GroupGrant{}).Where("id = ?", active.Id).Update("expires_at", active.ExpiresAt).Error
+		return &active, err
+	}
+	grant := &GroupGrant{
+		UserId:    userId,
+		Group:     group,
+		Source:    source,
+		SourceId:  sourceId,
+		Status:    GroupGrantStatusActive,
+		ExpiresAt: now + int64(days)*24*60*60,
+		CreatedAt: now,
+	}
+	if active.Id != 0 {
+		// 新的分组覆盖旧的限时分组，到期后仍恢复为最初的分组
+		grant.PreviousGroup = active.PreviousGroup
+		err = tx.Model(&GroupGrant{}).Where("id = ?", active.Id).Update("status", GroupGrantStatusReplaced).Error
+	} else {
+		groupCol := "`group`"
+		if common.UsingPostgreSQL {
+			groupCol = `"group"`
+		}
+		err = tx.Model(&User{}).Where("id = ?", userId).Select(groupCol).Find(&grant.PreviousGroup).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Model(&User{}).Where("id = ?", userId).Update("group", group).Error
+	if err != nil {
+		return nil, err
+	}
+	return grant, tx.Create(grant).Error
+}
+
+// GetUserGroupGrant 返回用户当前生效的限时分组，没有时返回 nil
+func GetUserGroupGrant(userId int) (*GroupGrant, error) {
+	var grant GroupGrant
+	err := DB.Where("user_id = ? and status = ?", userId, GroupGrantStatusActive).Limit(1).Find(&grant).Error
+	if err != nil || grant.Id == 0 {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// ExpireGroupGrants 恢复到期限时分组的用户原分组，期间被管理员改过分组的用户保持现状
+func ExpireGroupGrants() error {
+	var grants []*GroupGrant
+	err := DB.Where("status = ? and expires_at <= ?", GroupGrantStatusActive, common.GetTimestamp()).Limit(1000).Find(&grants).Error
+	if err != nil {
+		return err
+	}
+	groupCol := "`group`"
+	if common.UsingPostgreSQL {
+		groupCol = `"group"`
+	}
+	for _, grant := range grants {
+		reverted := false
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&GroupGrant{}).Where("id = ? and status = ?", grant.Id, GroupGrantStatusActive).Update("status", GroupGrantStatusExpired)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			result = tx.Model(&User{}).Where("id = ? and "+groupCol+" = ?", grant.UserId, grant.Group).Update("group", grant.PreviousGroup)
+			reverted = result.RowsAffected == 1
+			return result.Error
+		})
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to expire group grant %d: %s", grant.Id, err.Error()))
+			continue
+		}
+		if reverted {
+			CacheDeleteUserGroup(grant.UserId)
+			RecordLog(grant.UserId, LogTypeSystem, 0, fmt.Sprintf("限时分组 %s 已到期，恢复为 %s", grant.Group, grant.PreviousGroup))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"time"
+)
+
+const (
+	InvalidationTypeChannel     = "channel"
+	InvalidationTypeAllChannels = "all_channels"
+	InvalidationTypeToken       = "token"
+	InvalidationTypeOption      = "option"
+)
+
+const cacheInvalidationRedisChannel = "chat_api:cache_invalidation"
+
+// CacheInvalidation 缓存失效事件，未启用 Redis 时各节点通过轮询该表获取其他节点的变更
+type CacheInvalidation struct {
+	Id        int    `json:"id"`
+	Type      string `json:"type" gorm:"type:varchar(32)"`
+	Target    string `json:"target" gorm:"type:varchar(255)"`
+	Origin    string `json:"origin" gorm:"type:varchar(64)"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// PublishCacheInvalidation 先在本节点生效，再广播给其他节点
+func PublishCacheInvalidation(type_ string, target string) {
+	applyCacheInvalidation(type_, target)
+	event := CacheInvalidation{
+		Type:      type_,
+		Target:    target,
+		Origin:    common.NodeId,
+		CreatedAt: common.GetTimestamp(),
+	}
+	if common.RedisEnabled {
+		jsonBytes, err := json.Marshal(event)
+		if err != nil {
+			common.SysError("failed to marshal cache invalidation: " + err.Error())
+			return
+		}
+		err = common.RedisPublish(cacheInvalidationRedisChannel, string(jsonBytes))
+		if err != nil {
+			common.SysError("failed to publish cache invalidation: " + err.Error())
+		}
+		return
+	}
+	if type_ == InvalidationTypeToken || (!common.MemoryCacheEnabled && type_ != InvalidationTypeOption) {
+		// 未启用 Redis 时令牌不做缓存；未启用内存缓存时渠道直接查库，均无需广播
+		return
+	}
+	err := DB.Create(&event).Error
+	if err != nil {
+		common.SysError("failed to record cache invalidation: " + err.Error())
+	}
+}
+
+func PublishChannelInvalidation(channelId int) {
+	PublishCacheInvalidation(InvalidationTypeChannel, strconv.Itoa(channelId))
+}
+
+func applyCacheInvalidation(type_ string, target string) {
+	switch type_ {
+	case InvalidationTypeChannel:
+		if !common.MemoryCacheEnabled {
+			return
+		}
+		channelId, err := strconv.Atoi(target)
+		if err != nil {
+			return
+		}
+		CacheRefreshChannel(channelId)
+	case InvalidationTypeAllChannels:
+		if !common.MemoryCacheEnabled {
+			return
+		}
+		InitChannelCache()
+	case InvalidationTypeToken:
+		if !common.RedisEnabled {
+			return
+		}
+		err := common.RedisDel(fmt.Sprintf("token:%s", target))
+		if err != nil {
+			common.SysError("Redis del token error: " + err.Error())
+		}
+	case InvalidationTypeOption:
+		option := Option{}
+		err := DB.Where(&Option{Key: target}).First(&option).Error
+		if err != nil {
+			return
+		}
+		err = updateOptionMap(option.Key, option.Value)
+		if err != nil {
+			common.SysError("failed to update option map: " + err.Error())
+		}
+	}
+}
+
+// StartCacheInvalidationListener 接收其他节点广播的失效事件，Redis 不可用时退化为轮询数据库
+func StartCacheInvalidationListener() {
+	if common.RedisEnabled {
+		go listenRedisInvalidation()
+		return
+	}
+	go pollDatabaseInvalidation()
+}
+
+func listenRedisInvalidation() {
+	for {
+		pubsub := common.RedisSubscribe(cacheInvalidationRedisChannel)
+		for message := range pubsub.Channel() {
+			handleInvalidationMessage(message.Payload)
+		}
+		_ = pubsub.Close()
+		// 订阅断开期间可能丢失事件，重新订阅前全量同步一次
+		common.SysError("cache invalidation subscription closed, resubscribing")
+		time.Sleep(time.Second)
+		resyncAllCaches()
+	}
+}
+
+func handleInvalidationMessage(payload string) {
+	var event CacheInvalidation
+	err := json.Unmarshal([]byte(payload), &event)
+	if err != nil {
+		common.SysError("failed to unmarshal cache invalidation: " + err.Error())
+		return
+	}
+	if event.Origin == common.NodeId {
+		return
+	}
+	applyCacheInvalidation(event.Type, event.Target)
+}
+
+func pollDatabaseInvalidation() {
+	var lastId int
+	DB.Model(&CacheInvalidation{}).Select("COALESCE(MAX(id), 0)").Scan(&lastId)
+	for {
+		time.Sleep(time.Duration(common.CacheInvalidationPollInterval) * time.Second)
+		var events []CacheInvalidation
+		err := DB.Where("id > ?", lastId).Order("id asc").Find(&events).Error
+		if err != nil {
+			common.SysError("failed to poll cache invalidation: " + err.Error())
+			continue
+		}
+		for _, event := range events {
+			lastId = event.Id
+			if event.Origin == common.NodeId {
+				continue
+			}
+			applyCacheInvalidation(event.Type, event.Target)
+		}
+		if common.IsMasterNode {
+			// 只保留最近一小时的事件
+			DB.Where("created_at < ?", common.GetTimestamp()-3600).Delete(&CacheInvalidation{})
+		}
+	}
+}
+
+func resyncAllCaches() {
+	loadOptionsFromDatabase()
+	if common.MemoryCacheEnabled {
+		InitChannelCache()
+	}
+}
@@ -0,0 +1,189 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const leaderLeaseName = "background_jobs"
+
+// LeaderLease 未启用 Redis 时使用数据库保存选主租约
+type LeaderLease struct {
+	Name      string `json:"name" gorm:"type:varchar(64);primaryKey"`
+	Holder    string `json:"holder" gorm:"type:varchar(64)"`
+	ExpiresAt int64  `json:"expires_at" gorm:"bigint"`
+}
+
+// JobStatus 记录单例后台任务最近一次的运行情况
+type JobStatus struct {
+	Name           string `json:"name" gorm:"type:varchar(64);primaryKey"`
+	Node           string `json:"node" gorm:"type:varchar(64)"`
+	LastStartedAt  int64  `json:"last_started_at" gorm:"bigint"`
+	LastFinishedAt int64  `json:"last_finished_at" gorm:"bigint"`
+	Duration       int64  `json:"duration" gorm:"bigint"` // in milliseconds
+	LastError      string `json:"last_error" gorm:"type:text"`
+	RunCount       int    `json:"run_count" gorm:"default:0"`
+}
+
+var isLeader int32
+
+// IsLeader 当前节点是否持有后台任务租约，单例任务只在 leader 上执行
+func IsLeader() bool {
+	return atomic.LoadInt32(&isLeader) == 1
+}
+
+// StartLeaderElection 周期性地抢占或续约租约，leader 宕机后租约过期由其他节点接管
+func StartLeaderElection() {
+	ttl := time.Duration(common.LeaderLeaseTTL) * time.Second
+	go func() {
+		for {
+			acquired := tryAcquireLeaderLease(ttl)
+			if acquired != IsLeader() {
+				if acquired {
+					common.SysLog(fmt.Sprintf("node %s became leader of background jobs", common.NodeId))
+				} else {
+					common.SysLog(fmt.Sprintf("node %s lost leadership of background jobs", common.NodeId))
+				}
+			}
+			if acquired {
+				atomic.StoreInt32(&isLeader, 1)
+			} else {
+				atomic.StoreInt32(&isLeader, 0)
+			}
+			time.Sleep(ttl / 3)
+		}
+	}()
+}
+
+func tryAcquireLeaderLease(ttl time.Duration) bool {
+	if common.RedisEnabled {
+		key := "leader:" + leaderLeaseName
+		if IsLeader() {
+			renewed, err := common.RedisExpireIfEquals(key, common.NodeId, ttl)
+			if err == nil && renewed {
+				return true
+			}
+		}
+		acquired, err := common.RedisSetNX(key, common.NodeId, ttl)
+		if err != nil {
+			common.SysError("failed to acquire leader lease: " + err.Error())
+			return false
+		}
+		return acquired
+	}
+	now := common.GetTimestamp()
+	expiresAt := now + int64(ttl.Seconds())
+	result := DB.Model(&LeaderLease{}).Where("name = ? and (holder = ? or expires_at < ?)", leaderLeaseName, common.NodeId, now).
+		Updates(map[string]interface{}{"holder": common.NodeId, "expires_at": expiresAt})
+	if result.Error != nil {
+		common.SysError("failed to acquire leader lease: " + result.Error.Error())
+		return false
+	}
+	if result.RowsAffected == 1 {
+		return true
+	}
+	// 租约记录不存在时尝试创建，主键冲突说明已被其他节点持有
+	err := DB.Create(&LeaderLease{Name: leaderLeaseName, Holder: common.NodeId, ExpiresAt: expiresAt}).Error
+	return err == nil
+}
+
+// ReleaseLeadership 停机时主动释放租约，其他节点无需等待租约过期即可接管
+func ReleaseLeadership() {
+	if !IsLeader() {
+		return
+	}
+	atomic.StoreInt32(&isLeader, 0)
+	if common.RedisEnabled {
+		err := common.RedisDelIfEquals("leader:"+leaderLeaseName, common.NodeId)
+		if err != nil {
+			common.SysError("failed to release leader lease: " + err.Error())
+		}
+		return
+	}
+	err := DB.Model(&LeaderLease{}).Where("name = ? and holder = ?", leaderLeaseName, common.NodeId).Update("expires_at", 0).Error
+	if err != nil {
+		common.SysError("failed to release leader lease: " + err.Error())
+	}
+}
+
+// GetLeaderLease 返回当前租约持有者及过期时间
+func GetLeaderLease() (holder string, expiresAt int64) {
+	if common.RedisEnabled {
+		holder, _ = common.RedisGet("leader:" + leaderLeaseName)
+		return holder, 0
+	}
+	var lease LeaderLease
+	DB.Where("name = ?", leaderLeaseName).First(&lease)
+	return lease.Holder, lease.ExpiresAt
+}
+
+// RunLeaderJob 仅在 leader 上执行任务并记录运行结果，返回任务是否被执行
+func RunLeaderJob(name string, job func() error) bool {
+	if !IsLeader() {
+		return false
+	}
+	startedAt := time.Now()
+	err := job()
+	status := JobStatus{
+		Name:           name,
+		Node:           common.NodeId,
+		LastStartedAt:  startedAt.Unix(),
+		LastFinishedAt: time.Now().Unix(),
+		Duration:       time.Since(startedAt).Milliseconds(),
+	}
+	if err != nil {
+		status.LastError = err.Error()
+		common.SysError(fmt.Sprintf("job %s failed: %s", name, err.Error()))
+	}
+	saveJobStatus(&status)
+	return true
+}
+
+// RunLeaderJobEvery 按间隔运行单例任务，是否到期依据全局运行记录判断，切换 leader 后不会提前重复执行
+func RunLeaderJobEvery(name string, interval time.Duration, job func() error) {
+	checkInterval := time.Minute
+	if interval < checkInterval {
+		checkInterval = interval
+	}
+	for {
+		if IsLeader() {
+			var status JobStatus
+			DB.Where("name = ?", name).Limit(1).Find(&status)
+			if time.Since(time.Unix(status.LastStartedAt, 0)) >= interval {
+				RunLeaderJob(name, job)
+			}
+		}
+		time.Sleep(checkInterval)
+	}
+}
+
+func saveJobStatus(status *JobStatus) {
+	result := DB.Model(&JobStatus{}).Where("name = ?", status.Name).Updates(map[string]interface{}{
+		"node":             status.Node,
+		"last_started_at":  status.LastStartedAt,
+		"last_finished_at": status.LastFinishedAt,
+		"duration":         status.Duration,
+		"last_error":       status.LastError,
+		"run_count":        gorm.Expr("run_count + 1"),
+	})
+	if result.Error != nil {
+		common.SysError("failed to save job status: " + result.Error.Error())
+		return
+	}
+	if result.RowsAffected == 0 {
+		status.RunCount = 1
+		err := DB.Create(status).Error
+		if err != nil {
+			common.SysError("failed to save job status: " + err.Error())
+		}
+	}
+}
+
+func GetAllJobStatuses() (statuses []*JobStatus, err error) {
+	err = DB.Order("name asc").Find(&statuses).Error
+	return statuses, err
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"one-api/common"
+	"one-api/common/metrics"
+	"one-api/common/tracing"
+	"strconv"
 	"strings"
 
 	"gorm.io/gorm"
@@ -28,6 +31,7 @@ type Log struct {
 	IsStream         bool   `json:"is_stream" gorm:"default:false"`
 	Multiplier       string `json:"multiplier"`
 	UserQuota        int    `json:"userQuota"`
+	OrgId            int    `json:"org_id" gorm:"default:0;index"`
 }
 
 type Logs struct {
@@ -70,7 +74,7 @@ const (
 
 func GetLogByKey(key string) (logs []*Log, err error) {
 	err = DB.Joins("left join tokens on tokens.id = logs.token_id").
-		Where("tokens.key = ?", strings.Split(key, "-")[1]).
+		Where("tokens.key_hash = ?", hashAccessKey(strings.TrimPrefix(key, "sk-"))).
 		Order("created_at DESC").
 		Find(&logs).Error
 	return logs, err
@@ -93,7 +97,7 @@ func GetLogMjByKey(DB *gorm.DB, key string) (midjourneys []*Midjourney, err erro
 			SELECT mj.* FROM midjourneys mj
 			JOIN logs l ON mj.channel_id = l.channel_id AND mj.mj_id = l.content
 			JOIN tokens t ON t.name = l.token_name
-			WHERE t.key = ? AND l.model_name = 'midjourney'
+			WHERE t.key_hash = ? AND l.model_name = 'midjourney'
 			ORDER BY mj.start_time DESC
 		`
 	case "sqlite":
@@ -102,7 +106,7 @@ func GetLogMjByKey(DB *gorm.DB, key string) (midjourneys []*Midjourney, err erro
 			SELECT mj.* FROM midjourneys mj
 			JOIN logs l ON mj.channel_id = l.channel_id AND mj.mj_id = l.content
 			JOIN tokens t ON t.name = l.token_name
-			WHERE t.key = ? AND l.model_name = 'midjourney'
+			WHERE t.key_hash = ? AND l.model_name = 'midjourney'
 			ORDER BY mj.start_time DESC
 		`
 	case "mysql":
@@ -111,14 +115,14 @@ func GetLogMjByKey(DB *gorm.DB, key string) (midjourneys []*Midjourney, err erro
 			SELECT mj.* FROM midjourneys mj
 			JOIN logs l ON mj.channel_id = l.channel_id AND  mj.mj_id = l.content COLLATE utf8mb4_general_ci
 			JOIN tokens t ON t.name = l.token_name
-			WHERE t.key = ? AND l.model_name = 'midjourney'
+			WHERE t.key_hash = ? AND l.model_name = 'midjourney'
 			ORDER BY mj.start_time DESC
 		`
 	default:
 		return nil, fmt.Errorf("不支持的数据库方言: %s", dialect)
 	}
 
-	err = DB.Raw(sql, tokenKey).Scan(&midjourneys).Error
+	err = DB.Raw(sql, hashAccessKey(tokenKey)).Scan(&midjourneys).Error
 	if err != nil {
 		// 处理查询执行时遇到的任何错误
 		return nil, fmt.Errorf("query execution failed: %v", err)
@@ -149,16 +153,19 @@ func RecordLog(userId int, logType int, quota int, multiplier string) {
 		common.SysError("failed to record log: " + err.Error())
 	}
 
-	LogQuotaData(userId, GetUsernameById(userId), LogTypeTopup, 0, "", quota, common.GetTimestamp())
-
+	if logType == LogTypeTopup {
+		LogQuotaData(userId, GetUsernameById(userId), 0, LogTypeTopup, 0, "", quota, common.GetTimestamp())
+	}
 }
 
 func RecordConsumeLog(ctx context.Context, userId int, channelId int, channelName string, promptTokens int, completionTokens int, modelName string, tokenName string, quota int, content string, tokenId int, multiplier string, userQuota int, useTimeSeconds int, isStream bool) {
 	common.LogInfo(ctx, fmt.Sprintf("record consume log: userId=%d, 用户调用前余额=%d, channelId=%d, promptTokens=%d, completionTokens=%d, modelName=%s, tokenName=%s, quota=%d,multiplier=%s", userId, userQuota, channelId, promptTokens, completionTokens, modelName, tokenName, quota, multiplier))
+	metrics.QuotaConsumed.Add(float64(quota), modelName, strconv.Itoa(channelId))
 	if !common.LogConsumeEnabled {
 		return
 	}
 	username := GetUsernameById(userId)
+	orgId := getTokenOrgId(tokenId)
 	log := &Log{
 		UserId:           userId,
 		Username:         username,
@@ -177,17 +184,19 @@ func RecordConsumeLog(ctx context.Context, userId int, channelId int, channelNam
 		UserQuota:        userQuota,
 		UseTime:          useTimeSeconds,
 		IsStream:         isStream,
+		OrgId:            orgId,
 	}
-	err := DB.Create(log).Error
+	// 扣费在请求结束后异步执行，只沿用 span 而不继承请求的取消信号
+	err := DB.WithContext(tracing.DetachedContext(ctx)).Create(log).Error
 	if err != nil {
 		common.LogError(ctx, "failed to record log: "+err.Error())
 	}
 
-	LogQuotaData(userId, username, LogTypeConsume, channelId, modelName, quota, common.GetTimestamp())
+	LogQuotaData(userId, username, orgId, LogTypeConsume, channelId, modelName, quota, common.GetTimestamp())
 
 }
 
-func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int, channel int) (logs []*Log, err error) {
+func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int, channel int, orgId int) (logs []*Log, err error) {
 	var tx *gorm.DB
 	if logType == LogTypeUnknown {
 		tx = DB
@@ -212,11 +221,15 @@ func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName
 	if channel != 0 {
 		tx = tx.Where("channel_id = ?", channel)
 	}
+	if orgId != 0 {
+		tx = tx.Where("org_id = ?", orgId)
+	}
 	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&logs).Error
 	return logs, err
 }
 
-func SearchLogsByDayAndModel(user_id, startTimestamp, endTimestamp int) (LogStatistics []*LogStatistic, err error) {
+// SearchLogsByDayAndModel 按天和模型汇总消费，orgId 非 0 时只统计组织令牌的消费，user_id 为 0 时统计组织全部成员
+func SearchLogsByDayAndModel(user_id, orgId, startTimestamp, endTimestamp int) (LogStatistics []*LogStatistic, err error) {
 	groupSelect := "DATE_FORMAT(FROM_UNIXTIME(created_at), '%Y-%m-%d') as day"
 
 	if common.UsingPostgreSQL {
@@ -226,19 +239,16 @@ func SearchLogsByDayAndModel(user_id, startTimestamp, endTimestamp int) (LogStat
 	if common.UsingSQLite {
 		groupSelect = "strftime('%Y-%m-%d', datetime(created_at, 'unixepoch')) as day"
 	}
-	err = DB.Raw(`
-		SELECT `+groupSelect+`,
-		model_name, count(1) as request_count,
-		sum(quota) as quota,
-		sum(prompt_tokens) as prompt_tokens,
-		sum(completion_tokens) as completion_tokens
-		FROM logs
-		WHERE type=2
-		AND user_id= ?
-		AND created_at BETWEEN ? AND ?
-		GROUP BY day, model_name
-		ORDER BY day, model_name
-	`, user_id, startTimestamp, endTimestamp).Scan(&LogStatistics).Error
+	tx := DB.Table("logs").
+		Select(groupSelect+", model_name, count(1) as request_count, sum(quota) as quota, sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens").
+		Where("type = ? and created_at BETWEEN ? AND ?", LogTypeConsume, startTimestamp, endTimestamp)
+	if user_id != 0 {
+		tx = tx.Where("user_id = ?", user_id)
+	}
+	if orgId != 0 {
+		tx = tx.Where("org_id = ?", orgId)
+	}
+	err = tx.Group("day, model_name").Order("day, model_name").Scan(&LogStatistics).Error
 
 	//fmt.Println(user_id, startTimestamp, endTimestamp)
 
@@ -334,12 +344,17 @@ func GetProLogs(db *gorm.DB, logType int, startTimestamp int64, endTimestamp int
 	return logs, total, nil
 }
 
-func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int) (logs []*Log, err error) {
-	var tx *gorm.DB
-	if logType == LogTypeUnknown {
-		tx = DB.Where("user_id = ?", userId)
-	} else {
-		tx = DB.Where("user_id = ? and type = ?", userId, logType)
+// GetUserLogs orgId 非 0 时只查询组织令牌的日志，userId 为 0 时查询组织全部成员
+func GetUserLogs(userId int, orgId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int) (logs []*Log, err error) {
+	tx := DB
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if orgId != 0 {
+		tx = tx.Where("org_id = ?", orgId)
+	}
+	if logType != LogTypeUnknown {
+		tx = tx.Where("type = ?", logType)
 	}
 	if modelName != "" {
 		tx = tx.Where("model_name = ?", modelName)
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LoginFailureState 账户的连续登录失败记录，启用 Redis 时多节点共享
+type LoginFailureState struct {
+	Failures     int   `json:"failures"`
+	LastFailTime int64 `json:"last_fail_time"`
+	LockedUntil  int64 `json:"locked_until"`
+}
+
+var (
+	loginFailures     = make(map[string]*LoginFailureState)
+	loginFailuresLock sync.Mutex
+)
+
+func loginFailureWindow() time.Duration {
+	minutes := common.LoginLockoutMinutes
+	if minutes <= 0 {
+		minutes = 15
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// LoginGuardKey 同一账户用用户名或邮箱登录时共用失败计数，不存在的账户按登录名计数
+func LoginGuardKey(loginName string) string {
+	var id int
+	DB.Model(&User{}).Where("username = ?", loginName).Select("id").Find(&id)
+	if id == 0 {
+		DB.Model(&User{}).Where("email = ?", loginName).Select("id").Find(&id)
+	}
+	if id == 0 {
+		return "name:" + loginName
+	}
+	return UserLoginGuardKey(id)
+}
+
+// UserLoginGuardKey 已确定用户后的失败计数键，如两步验证阶段
+func UserLoginGuardKey(userId int) string {
+	return "user:" + strconv.Itoa(userId)
+}
+
+func loginFailureRedisKey(key string) string {
+	return "login_fail:" + key
+}
+
+func GetLoginFailureState(key string) LoginFailureState {
+	state := LoginFailureState{}
+	if common.RedisEnabled {
+		values, err := common.RDB.HGetAll(context.Background(), loginFailureRedisKey(key)).Result()
+		if err != nil {
+			common.SysError("failed to get login failures: " + err.Error())
+			return state
+		}
+		state.Failures, _ = strconv.Atoi(values["failures"])
+		state.LastFailTime, _ = strconv.ParseInt(values["last_fail_time"], 10, 64)
+		state.LockedUntil, _ = strconv.ParseInt(values["locked_until"], 10, 64)
+		return state
+	}
+	loginFailuresLock.Lock()
+	defer loginFailuresLock.Unlock()
+	if s, ok := loginFailures[key]; ok && time.Since(time.Unix(s.LastFailTime, 0)) < loginFailureWindow() {
+		state = *s
+	}
+	return state
+}
+
+// RecordLoginFailure 记录一次失败，达到 LoginMaxFailures 时锁定，返回记录后的状态和本次是否触发了锁定
+func RecordLoginFailure(key string) (LoginFailureState, bool) {
+	now := common.GetTimestamp()
+	window := loginFailureWindow()
+	var state LoginFailureState
+	if common.RedisEnabled {
+		ctx := context.Background()
+		redisKey := loginFailureRedisKey(key)
+		failures, err := common.RDB.HIncrBy(ctx, redisKey, "failures", 1).Result()
+		if err != nil {
+			common.SysError("failed to record login failure: " + err.Error())
+			return state, false
+		}
+		common.RDB.HSet(ctx, redisKey, "last_fail_time", now)
+		common.RDB.Expire(ctx, redisKey, window)
+		state = GetLoginFailureState(key)
+		state.Failures = int(failures)
+	} else {
+		loginFailuresLock.Lock()
+		s, ok := loginFailures[key]
+		if !ok || time.Since(time.Unix(s.LastFailTime, 0)) >= window {
+			s = &LoginFailureState{}
+			loginFailures[key] = s
+		}
+		s.Failures++
+		s.LastFailTime = now
+		state = *s
+		// 防止不存在的登录名把内存撑满
+		if len(loginFailures) > 10000 {
+			for k, v := range loginFailures {
+				if time.Since(time.Unix(v.LastFailTime, 0)) >= window {
+					delete(loginFailures, k)
+				}
+			}
+		}
+		loginFailuresLock.Unlock()
+	}
+	if common.LoginMaxFailures <= 0 || state.Failures < common.LoginMaxFailures || state.LockedUntil > now {
+		return state, false
+	}
+	state.LockedUntil = now + int64(window.Seconds())
+	if common.RedisEnabled {
+		common.RDB.HSet(context.Background(), loginFailureRedisKey(key), "locked_until", state.LockedUntil)
+	} else {
+		loginFailuresLock.Lock()
+		if s, ok := loginFailures[key]; ok {
+			s.LockedUntil = state.LockedUntil
+		}
+		loginFailuresLock.Unlock()
+	}
+	return state, true
+}
+
+// ResetLoginFailures 登录成功或管理员解锁时清除失败记录
+func ResetLoginFailures(key string) {
+	if common.RedisEnabled {
+		if err := common.RedisDel(loginFailureRedisKey(key)); err != nil {
+			common.SysError("failed to reset login failures: " + err.Error())
+		}
+		return
+	}
+	loginFailuresLock.Lock()
+	delete(loginFailures, key)
+	loginFailuresLock.Unlock()
+}
+
+// CheckLoginAllowed 锁定期间拒绝登录；失败 3 次起每次失败后需等待的时间翻倍，最长 60 秒
+func CheckLoginAllowed(state LoginFailureState) error {
+	now := common.GetTimestamp()
+	if state.LockedUntil > now {
+		return fmt.Errorf("登录失败次数过多，账户已被临时锁定，请 %d 分钟后重试或联系管理员解锁", (state.LockedUntil-now+59)/60)
+	}
+	if state.Failures < 3 {
+		return nil
+	}
+	delay := int64(60)
+	if state.Failures-3 < 6 {
+		delay = int64(1) << uint(state.Failures-3)
+	}
+	if wait := state.LastFailTime + delay - now; wait > 0 {
+		return fmt.Errorf("登录失败次数过多，请 %d 秒后重试", wait)
+	}
+	return nil
+}
+
+// LoginTurnstileRequired 连续失败次数达到阈值且已配置 Turnstile 时要求人机验证
+func LoginTurnstileRequired(state LoginFailureState) bool {
+	return common.LoginTurnstileThreshold > 0 && state.Failures >= common.LoginTurnstileThreshold &&
+		common.TurnstileSiteKey != "" && common.TurnstileSecretKey != ""
+}
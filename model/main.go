@@ -32,7 +32,10 @@ func createRootAccountIfNeed() error {
 			AccessToken: common.GetUUID(),
 			Quota:       100000000,
 		}
-		DB.Create(&rootUser)
+		if err := DB.Create(&rootUser).Error; err != nil {
+			return err
+		}
+		return recordLedger(DB, LedgerRef{Type: LedgerTypeOpening, RefType: LedgerRefUser, RefId: rootUser.Id}, userPosting(rootUser.Id, rootUser.Quota))
 	}
 	return nil
 }
@@ -100,6 +103,10 @@ func InitDB() (err error) {
 		if err != nil {
 			return err
 		}
+		err = migrateTokenKeys(db)
+		if err != nil {
+			return err
+		}
 		err = db.AutoMigrate(&User{})
 		if err != nil {
 			return err
@@ -128,15 +135,63 @@ func InitDB() (err error) {
 		if err != nil {
 			return err
 		}
+		err = db.AutoMigrate(&TopUpRefund{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Coupon{}, &CouponUsage{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&GroupGrant{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&SubscriptionPlan{}, &Subscription{}, &SubscriptionUsage{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&AffCommission{}, &AffConsumeSettlement{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Organization{}, &OrgMember{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&AdminRole{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&PersonalAccessToken{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&OidcProvider{}, &UserOidcBinding{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UserTwoFactor{}, &TwoFactorChallenge{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UserSession{})
+		if err != nil {
+			return err
+		}
 		err = db.AutoMigrate(&QuotaData{})
 		if err != nil {
 			return err
 		}
-		err = db.AutoMigrate(&RechargeRecord{})
+		err = db.AutoMigrate(&QuotaLot{})
+		if err != nil {
+			return err
+		}
+		err = migrateRechargeRecords(db)
 		if err != nil {
 			return err
 		}
-		err = db.AutoMigrate(&WithdrawalOrder{})
+		err = db.AutoMigrate(&WithdrawalOrder{}, &WithdrawalOrderAudit{})
 		if err != nil {
 			return err
 		}
@@ -144,6 +199,34 @@ func InitDB() (err error) {
 		if err != nil {
 			return err
 		}
+		err = db.AutoMigrate(&CacheInvalidation{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&LeaderLease{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&JobStatus{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&TokenBudgetUsage{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&RefillSchedule{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&QuotaLedgerEntry{})
+		if err != nil {
+			return err
+		}
+		err = openQuotaLedger(db)
+		if err != nil {
+			return err
+		}
 		common.SysLog("database migrated")
 		err = createRootAccountIfNeed()
 		return err
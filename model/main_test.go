@@ -0,0 +1,63 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestDB 每个测试使用独立的内存 SQLite 库并执行完整迁移
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	common.SQLitePath = fmt.Sprintf("file:%s?mode=memory&cache=shared", name)
+	common.IsMasterNode = true
+	common.RedisEnabled = false
+	common.BatchUpdateEnabled = false
+	require.NoError(t, InitDB())
+	t.Cleanup(func() {
+		_ = CloseDB()
+	})
+}
+
+// createTestUser 创建一个余额为 0 的普通用户
+func createTestUser(t *testing.T, username string) *User {
+	t.Helper()
+	user := &User{
+		Username:    username,
+		DisplayName: username,
+		Role:        common.RoleCommonUser,
+		Status:      common.UserStatusEnabled,
+		AccessToken: common.GetUUID(),
+		AffCode:     common.GetRandomString(4),
+	}
+	require.NoError(t, DB.Create(user).Error)
+	return user
+}
+
+func getTestUserQuota(t *testing.T, id int) int {
+	t.Helper()
+	var quota int
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", id).Select("quota").Find(&quota).Error)
+	return quota
+}
+
+func getTestLotRemaining(t *testing.T, id int) int {
+	t.Helper()
+	var remaining int
+	require.NoError(t, DB.Model(&QuotaLot{}).Where("id = ?", id).Select("remaining").Find(&remaining).Error)
+	return remaining
+}
+
+func requireLedgerBalanced(t *testing.T) {
+	t.Helper()
+	result, err := ReconcileQuotaLedger()
+	require.NoError(t, err)
+	require.Zero(t, result.Imbalance)
+	for _, drift := range result.Drifts {
+		t.Errorf("ledger drift: %+v", *drift)
+	}
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"one-api/common"
+	"one-api/common/metrics"
+	"strconv"
+)
+
+// RegisterMetricsCollectors 注册需要在抓取时查询数据库的指标
+func RegisterMetricsCollectors() {
+	metrics.NewGaugeFunc("chat_api_channels", "Channels by status.", func() []metrics.Sample {
+		var rows []struct {
+			Status int
+			Count  int64
+		}
+		DB.Model(&Channel{}).Select("status, count(*) as count").Group("status").Scan(&rows)
+		samples := make([]metrics.Sample, 0, len(rows))
+		for _, row := range rows {
+			samples = append(samples, metrics.Sample{LabelValues: []string{channelStatusName(row.Status)}, Value: float64(row.Count)})
+		}
+		return samples
+	}, "status")
+	metrics.NewGaugeFunc("chat_api_channel_balance", "Last fetched upstream balance of each channel in USD.", func() []metrics.Sample {
+		var channels []*Channel
+		DB.Select("id", "name", "balance").Where("balance_updated_time > 0").Find(&channels)
+		samples := make([]metrics.Sample, 0, len(channels))
+		for _, channel := range channels {
+			samples = append(samples, metrics.Sample{LabelValues: []string{strconv.Itoa(channel.Id), channel.Name}, Value: channel.Balance})
+		}
+		return samples
+	}, "channel", "name")
+	metrics.NewGaugeFunc("chat_api_midjourney_pending_tasks", "Unfinished Midjourney tasks waiting to be polled.", func() []metrics.Sample {
+		var count int64
+		DB.Model(&Midjourney{}).Where("progress != ?", "100%").Count(&count)
+		return []metrics.Sample{{Value: float64(count)}}
+	})
+}
+
+func channelStatusName(status int) string {
+	switch status {
+	case common.ChannelStatusEnabled:
+		return "enabled"
+	case common.ChannelStatusManuallyDisabled:
+		return "manually_disabled"
+	case common.ChannelStatusAutoDisabled:
+		return "auto_disabled"
+	}
+	return "unknown"
+}
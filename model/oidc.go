@@ -0,0 +1,159 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"regexp"
+)
+
+var oidcSlugPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// OidcProvider 通用 OIDC 登录提供方，Slug 用于回调地址 /oauth/oidc/{slug}
+type OidcProvider struct {
+	Id               int    `json:"id"`
+	Slug             string `json:"slug" gorm:"type:varchar(32);uniqueIndex"`
+	Name             string `json:"name" gorm:"type:varchar(64)"`
+	Status           int    `json:"status" gorm:"default:1"`
+	DiscoveryUrl     string `json:"discovery_url" gorm:"type:varchar(512)"` // issuer 地址或完整的 .well-known/openid-configuration 地址
+	ClientId         string `json:"client_id" gorm:"type:varchar(255)"`
+	ClientSecret     string `json:"client_secret" gorm:"type:varchar(255)"`
+	Scopes           string `json:"scopes" gorm:"type:varchar(255);default:'openid profile email'"`
+	UsernameClaim    string `json:"username_claim" gorm:"type:varchar(64);default:'preferred_username'"`
+	DisplayNameClaim string `json:"display_name_claim" gorm:"type:varchar(64);default:'name'"`
+	EmailClaim       string `json:"email_claim" gorm:"type:varchar(64);default:'email'"`
+	GroupClaim       string `json:"group_claim" gorm:"type:varchar(64)"` // 可选，支持 a.b 形式的嵌套字段，值可以是字符串或数组
+	GroupMapping     string `json:"group_mapping" gorm:"type:text"`      // JSON object，声明值到用户分组的映射，如 {"vip-users":"vip"}，按声明值顺序取第一个匹配
+	CreatedTime      int64  `json:"created_time" gorm:"bigint"`
+}
+
+// UserOidcBinding 用户与 OIDC 账户的绑定，同一提供方的 subject 只能绑定一个用户
+type UserOidcBinding struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	ProviderId  int    `json:"provider_id" gorm:"uniqueIndex:idx_oidc_subject"`
+	Subject     string `json:"subject" gorm:"type:varchar(255);uniqueIndex:idx_oidc_subject"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+func (provider *OidcProvider) Validate() error {
+	if !oidcSlugPattern.MatchString(provider.Slug) {
+		return errors.New("标识只能包含小写字母、数字、下划线和短横线，且不超过 32 个字符")
+	}
+	if provider.DiscoveryUrl == "" || provider.ClientId == "" {
+		return errors.New("发现地址和 Client ID 不能为空")
+	}
+	if provider.GroupMapping != "" {
+		var mapping map[string]string
+		if err := json.Unmarshal([]byte(provider.GroupMapping), &mapping); err != nil {
+			return errors.New("分组映射必须是 JSON 对象")
+		}
+	}
+	return nil
+}
+
+// MapGroup 按分组映射返回声明值对应的分组，没有匹配时返回空
+func (provider *OidcProvider) MapGroup(values []string) string {
+	if provider.GroupMapping == "" {
+		return ""
+	}
+	var mapping map[string]string
+	if err := json.Unmarshal([]byte(provider.GroupMapping), &mapping); err != nil {
+		return ""
+	}
+	for _, value := range values {
+		if group, ok := mapping[value]; ok {
+			return group
+		}
+	}
+	return ""
+}
+
+func GetAllOidcProviders() (providers []*OidcProvider, err error) {
+	err = DB.Order("id asc").Find(&providers).Error
+	return providers, err
+}
+
+// GetEnabledOidcProviders 登录页展示的提供方，只包含公开信息
+func GetEnabledOidcProviders() (providers []*OidcProvider) {
+	DB.Select("id", "slug", "name").Where("status = ?", common.OidcProviderStatusEnabled).Order("id asc").Find(&providers)
+	return providers
+}
+
+func GetOidcProviderById(id int) (*OidcProvider, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	provider := OidcProvider{}
+	err := DB.First(&provider, "id = ?", id).Error
+	return &provider, err
+}
+
+func GetOidcProviderBySlug(slug string) (*OidcProvider, error) {
+	provider := OidcProvider{}
+	err := DB.First(&provider, "slug = ? and status = ?", slug, common.OidcProviderStatusEnabled).Error
+	if err != nil {
+		return nil, errors.New("登录方式不存在或未启用")
+	}
+	return &provider, nil
+}
+
+func (provider *OidcProvider) Insert() error {
+	provider.CreatedTime = common.GetTimestamp()
+	return DB.Create(provider).Error
+}
+
+func (provider *OidcProvider) Update() error {
+	return DB.Model(provider).Select("slug", "name", "status", "discovery_url", "client_id", "client_secret", "scopes",
+		"username_claim", "display_name_claim", "email_claim", "group_claim", "group_mapping").Updates(provider).Error
+}
+
+func DeleteOidcProviderById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	err := DB.Where("provider_id = ?", id).Delete(&UserOidcBinding{}).Error
+	if err != nil {
+		return err
+	}
+	return DB.Delete(&OidcProvider{}, "id = ?", id).Error
+}
+
+// GetUserIdByOidcSubject 返回已绑定该 OIDC 账户的用户，未绑定时返回 0
+func GetUserIdByOidcSubject(providerId int, subject string) (userId int) {
+	DB.Model(&UserOidcBinding{}).Where("provider_id = ? and subject = ?", providerId, subject).Select("user_id").Find(&userId)
+	return userId
+}
+
+func BindOidcSubject(userId int, providerId int, subject string) error {
+	if GetUserIdByOidcSubject(providerId, subject) != 0 {
+		return errors.New("该账户已被绑定")
+	}
+	var count int64
+	DB.Model(&UserOidcBinding{}).Where("user_id = ? and provider_id = ?", userId, providerId).Count(&count)
+	if count > 0 {
+		return errors.New("已绑定该登录方式的其他账户")
+	}
+	return DB.Create(&UserOidcBinding{
+		UserId:      userId,
+		ProviderId:  providerId,
+		Subject:     subject,
+		CreatedTime: common.GetTimestamp(),
+	}).Error
+}
+
+// UpdateUserGroupFromOidc 按 OIDC 分组映射更新用户分组。用户有生效中的订阅或限时分组时不覆盖，
+// 否则到期降级会把分组改回去，返回值表示是否已更新
+func UpdateUserGroupFromOidc(userId int, group string) (bool, error) {
+	activeSubscription := DB.Model(&Subscription{}).Select("1").Where("user_id = ? and status = ?", userId, SubscriptionStatusActive)
+	activeGrant := DB.Model(&GroupGrant{}).Select("1").Where("user_id = ? and status = ?", userId, GroupGrantStatusActive)
+	result := DB.Model(&User{}).Where("id = ? and not exists (?) and not exists (?)", userId, activeSubscription, activeGrant).Update("group", group)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	CacheDeleteUserGroup(userId)
+	return true, nil
+}
@@ -26,6 +26,7 @@ func InitOptionMap() {
 	common.OptionMap["FileDownloadPermission"] = strconv.Itoa(common.FileDownloadPermission)
 	common.OptionMap["ImageUploadPermission"] = strconv.Itoa(common.ImageUploadPermission)
 	common.OptionMap["ImageDownloadPermission"] = strconv.Itoa(common.ImageDownloadPermission)
+	common.OptionMap["TwoFactorRequiredPermission"] = strconv.Itoa(common.TwoFactorRequiredPermission)
 	common.OptionMap["PasswordLoginEnabled"] = strconv.FormatBool(common.PasswordLoginEnabled)
 	common.OptionMap["PasswordRegisterEnabled"] = strconv.FormatBool(common.PasswordRegisterEnabled)
 	common.OptionMap["EmailVerificationEnabled"] = strconv.FormatBool(common.EmailVerificationEnabled)
@@ -60,6 +61,11 @@ func InitOptionMap() {
 	common.OptionMap["PayAddress"] = ""
 	common.OptionMap["EpayId"] = ""
 	common.OptionMap["EpayKey"] = ""
+	common.OptionMap["StripeEnabled"] = strconv.FormatBool(common.StripeEnabled)
+	common.OptionMap["StripeApiAddress"] = common.StripeApiAddress
+	common.OptionMap["StripeApiSecret"] = ""
+	common.OptionMap["StripeWebhookSecret"] = ""
+	common.OptionMap["StripeCurrency"] = common.StripeCurrency
 	common.OptionMap["Price"] = strconv.FormatFloat(common.Price, 'f', -1, 64)
 	common.OptionMap["TopupGroupRatio"] = common.TopupGroupRatio2JSONString()
 	common.OptionMap["TopupRatio"] = common.TopupRatioJSONString()
@@ -81,6 +87,7 @@ func InitOptionMap() {
 	common.OptionMap["GroupRatio"] = common.GroupRatio2JSONString()
 	common.OptionMap["CompletionRatio"] = common.CompletionRatio2JSONString()
 	common.OptionMap["TopUpLink"] = common.TopUpLink
+	common.OptionMap["RefundClawbackPolicy"] = common.RefundClawbackPolicy
 	common.OptionMap["ChatLink"] = common.ChatLink
 	common.OptionMap["QuotaPerUnit"] = strconv.FormatFloat(common.QuotaPerUnit, 'f', -1, 64)
 	common.OptionMap["RetryTimes"] = strconv.Itoa(common.RetryTimes)
@@ -100,6 +107,14 @@ func InitOptionMap() {
 	common.OptionMap["VipUserGroup"] = common.VipUserGroup
 	common.OptionMap["MiniQuota"] = strconv.FormatFloat(common.MiniQuota, 'f', -1, 64)
 	common.OptionMap["ProporTions"] = strconv.Itoa(common.ProporTions)
+	common.OptionMap["AffCommissionEnabled"] = strconv.FormatBool(common.AffCommissionEnabled)
+	common.OptionMap["AffCommissionBase"] = common.AffCommissionBase
+	common.OptionMap["AffCommissionRates"] = common.AffCommissionRates
+	common.OptionMap["AffCommissionHoldDays"] = strconv.Itoa(common.AffCommissionHoldDays)
+	common.OptionMap["LoginMaxFailures"] = strconv.Itoa(common.LoginMaxFailures)
+	common.OptionMap["LoginLockoutMinutes"] = strconv.Itoa(common.LoginLockoutMinutes)
+	common.OptionMap["LoginTurnstileThreshold"] = strconv.Itoa(common.LoginTurnstileThreshold)
+	common.OptionMap["AffFraudCheckEnabled"] = strconv.FormatBool(common.AffFraudCheckEnabled)
 	common.OptionMap["RedempTionCount"] = strconv.Itoa(common.RedempTionCount)
 
 	common.OptionMapRWMutex.Unlock()
@@ -137,7 +152,12 @@ func UpdateOption(key string, value string) error {
 	// otherwise it will execute Update (with all fields).
 	DB.Save(&option)
 	// Update OptionMap
-	return updateOptionMap(key, value)
+	err := updateOptionMap(key, value)
+	if err != nil {
+		return err
+	}
+	PublishCacheInvalidation(InvalidationTypeOption, key)
+	return nil
 }
 
 func updateOptionMap(key string, value string) (err error) {
@@ -155,6 +175,8 @@ func updateOptionMap(key string, value string) (err error) {
 			common.ImageUploadPermission = intValue
 		case "ImageDownloadPermission":
 			common.ImageDownloadPermission = intValue
+		case "TwoFactorRequiredPermission":
+			common.TwoFactorRequiredPermission = intValue
 		}
 	}
 	if strings.HasSuffix(key, "Enabled") {
@@ -194,6 +216,8 @@ func updateOptionMap(key string, value string) (err error) {
 			common.Zfb = boolValue
 		case "YzfWx":
 			common.Wx = boolValue
+		case "StripeEnabled":
+			common.StripeEnabled = boolValue
 		case "GroupEnable":
 			common.GroupEnable = boolValue
 		case "LogContentEnabled":
@@ -208,6 +232,10 @@ func updateOptionMap(key string, value string) (err error) {
 			common.TopupRatioEnabled = boolValue
 		case "TopupAmountEnabled":
 			common.TopupAmountEnabled = boolValue
+		case "AffCommissionEnabled":
+			common.AffCommissionEnabled = boolValue
+		case "AffFraudCheckEnabled":
+			common.AffFraudCheckEnabled = boolValue
 		}
 	}
 	switch key {
@@ -232,6 +260,14 @@ func updateOptionMap(key string, value string) (err error) {
 		common.EpayId = value
 	case "EpayKey":
 		common.EpayKey = value
+	case "StripeApiAddress":
+		common.StripeApiAddress = strings.TrimSuffix(value, "/")
+	case "StripeApiSecret":
+		common.StripeApiSecret = value
+	case "StripeWebhookSecret":
+		common.StripeWebhookSecret = value
+	case "StripeCurrency":
+		common.StripeCurrency = strings.ToLower(value)
 	case "Price":
 		common.Price, _ = strconv.ParseFloat(value, 64)
 	case "MiniQuota":
@@ -280,6 +316,18 @@ func updateOptionMap(key string, value string) (err error) {
 		common.DataExportInterval, _ = strconv.Atoi(value)
 	case "ProporTions":
 		common.ProporTions, _ = strconv.Atoi(value)
+	case "AffCommissionBase":
+		common.AffCommissionBase = value
+	case "AffCommissionRates":
+		common.AffCommissionRates = value
+	case "AffCommissionHoldDays":
+		common.AffCommissionHoldDays, _ = strconv.Atoi(value)
+	case "LoginMaxFailures":
+		common.LoginMaxFailures, _ = strconv.Atoi(value)
+	case "LoginLockoutMinutes":
+		common.LoginLockoutMinutes, _ = strconv.Atoi(value)
+	case "LoginTurnstileThreshold":
+		common.LoginTurnstileThreshold, _ = strconv.Atoi(value)
 	case "RedempTionCount":
 		common.RedempTionCount, _ = strconv.Atoi(value)
 	case "ModelRatio":
@@ -292,6 +340,8 @@ func updateOptionMap(key string, value string) (err error) {
 		err = common.UpdateCompletionRatioByJSONString(value)
 	case "TopUpLink":
 		common.TopUpLink = value
+	case "RefundClawbackPolicy":
+		common.RefundClawbackPolicy = value
 	case "ChatLink":
 		common.ChatLink = value
 	case "ChannelDisableThreshold":
@@ -0,0 +1,313 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+// 组织成员角色
+const (
+	OrgRoleOwner   = "owner"   // 拥有全部权限
+	OrgRoleAdmin   = "admin"   // 管理成员
+	OrgRoleBilling = "billing" // 为额度池充值并查看全部用量
+	OrgRoleMember  = "member"  // 创建从额度池扣费的令牌
+)
+
+var orgRoles = map[string]bool{
+	OrgRoleOwner:   true,
+	OrgRoleAdmin:   true,
+	OrgRoleBilling: true,
+	OrgRoleMember:  true,
+}
+
+// Organization 组织持有共享额度池，成员创建的组织令牌从额度池扣费
+type Organization struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64)"`
+	OwnerId     int    `json:"owner_id" gorm:"index"`
+	Quota       int    `json:"quota" gorm:"default:0"`
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	Role        string `json:"role,omitempty" gorm:"-:all"` // 当前用户在组织中的角色，仅用于接口返回
+}
+
+// OrgMember 组织成员，QuotaCap 为成员可使用额度池的上限，0 表示不限
+type OrgMember struct {
+	Id          int    `json:"id"`
+	OrgId       int    `json:"org_id" gorm:"uniqueIndex:idx_org_member"`
+	UserId      int    `json:"user_id" gorm:"uniqueIndex:idx_org_member;index"`
+	Username    string `json:"username" gorm:"-:all"`
+	Role        string `json:"role" gorm:"type:varchar(16)"`
+	QuotaCap    int    `json:"quota_cap" gorm:"default:0"`
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+func (organization *Organization) Insert() error {
+	organization.CreatedTime = common.GetTimestamp()
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(organization).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&OrgMember{
+			OrgId:       organization.Id,
+			UserId:      organization.OwnerId,
+			Role:        OrgRoleOwner,
+			CreatedTime: organization.CreatedTime,
+		}).Error
+	})
+}
+
+func (organization *Organization) Update() error {
+	return DB.Model(organization).Select("name").Updates(organization).Error
+}
+
+// DeleteOrganizationById 额度池中仍有余额的组织不能删除，需先全部用完或由管理员调整
+func DeleteOrganizationById(id int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? and quota = 0", id).Delete(&Organization{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("组织额度池中仍有余额，无法删除")
+		}
+		return tx.Where("org_id = ?", id).Delete(&OrgMember{}).Error
+	})
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	organization := Organization{}
+	err := DB.First(&organization, "id = ?", id).Error
+	return &organization, err
+}
+
+func GetAllOrganizations(startIdx int, num int) (organizations []*Organization, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&organizations).Error
+	return organizations, err
+}
+
+// GetUserOrganizations 获取用户加入的全部组织，并带上用户在各组织中的角色
+func GetUserOrganizations(userId int) (organizations []*Organization, err error) {
+	var members []*OrgMember
+	err = DB.Where("user_id = ?", userId).Find(&members).Error
+	if err != nil || len(members) == 0 {
+		return organizations, err
+	}
+	roles := make(map[int]string, len(members))
+	ids := make([]int, 0, len(members))
+	for _, member := range members {
+		roles[member.OrgId] = member.Role
+		ids = append(ids, member.OrgId)
+	}
+	err = DB.Where("id in ?", ids).Order("id desc").Find(&organizations).Error
+	for _, organization := range organizations {
+		organization.Role = roles[organization.Id]
+	}
+	return organizations, err
+}
+
+func GetOrgMember(orgId int, userId int) (*OrgMember, error) {
+	member := OrgMember{}
+	err := DB.First(&member, "org_id = ? and user_id = ?", orgId, userId).Error
+	if err != nil {
+		return nil, errors.New("不是该组织的成员")
+	}
+	return &member, nil
+}
+
+func GetOrgMembers(orgId int) (members []*OrgMember, err error) {
+	err = DB.Where("org_id = ?", orgId).Order("id asc").Find(&members).Error
+	for _, member := range members {
+		member.Username = GetUsernameById(member.UserId)
+	}
+	return members, err
+}
+
+func ValidateOrgRole(role string) error {
+	if !orgRoles[role] {
+		return fmt.Errorf("无效的组织角色：%s", role)
+	}
+	return nil
+}
+
+// CanManageOrgMembers 所有者和管理员可以管理成员
+func (member *OrgMember) CanManageOrgMembers() bool {
+	return member.Role == OrgRoleOwner || member.Role == OrgRoleAdmin
+}
+
+// CanViewOrgUsage 所有者、管理员和财务可以查看全部成员的用量
+func (member *OrgMember) CanViewOrgUsage() bool {
+	return member.Role != OrgRoleMember
+}
+
+// CanFundOrg 所有者和财务可以为额度池充值
+func (member *OrgMember) CanFundOrg() bool {
+	return member.Role == OrgRoleOwner || member.Role == OrgRoleBilling
+}
+
+// CanCreateOrgToken 财务角色只负责付费，不能创建令牌
+func (member *OrgMember) CanCreateOrgToken() bool {
+	return member.Role != OrgRoleBilling
+}
+
+func (member *OrgMember) Insert() error {
+	member.CreatedTime = common.GetTimestamp()
+	return DB.Create(member).Error
+}
+
+// Update 修改成员角色和额度上限，组织至少保留一个所有者
+func (member *OrgMember) Update() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if member.Role != OrgRoleOwner {
+			if err := checkOrgKeepsOwner(tx, member.OrgId, member.UserId); err != nil {
+				return err
+			}
+		}
+		return tx.Model(member).Select("role", "quota_cap").Updates(member).Error
+	})
+}
+
+func DeleteOrgMember(orgId int, userId int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkOrgKeepsOwner(tx, orgId, userId); err != nil {
+			return err
+		}
+		return tx.Where("org_id = ? and user_id = ?", orgId, userId).Delete(&OrgMember{}).Error
+	})
+}
+
+// checkOrgKeepsOwner 检查除 userId 外组织是否还有其他所有者
+func checkOrgKeepsOwner(tx *gorm.DB, orgId int, userId int) error {
+	var count int64
+	err := tx.Model(&OrgMember{}).Where("org_id = ? and role = ? and user_id <> ?", orgId, OrgRoleOwner, userId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	var role string
+	err = tx.Model(&OrgMember{}).Where("org_id = ? and user_id = ?", orgId, userId).Select("role").Find(&role).Error
+	if err != nil {
+		return err
+	}
+	if role == OrgRoleOwner && count == 0 {
+		return errors.New("组织至少需要保留一个所有者")
+	}
+	return nil
+}
+
+// FundOrganization 从用户个人余额划转额度到组织额度池
+func FundOrganization(orgId int, userId int, quota int) error {
+	if quota <= 0 {
+		return errors.New("划转额度必须大于 0")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ? and quota >= ?", userId, quota).Update("quota", gorm.Expr("quota - ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("个人余额不足")
+		}
+		if err := drawQuotaLots(tx, userId, quota); err != nil {
+			return err
+		}
+		if err := tx.Model(&Organization{}).Where("id = ?", orgId).Update("quota", gorm.Expr("quota + ?", quota)).Error; err != nil {
+			return err
+		}
+		return recordLedger(tx, LedgerRef{Type: LedgerTypeOrgFund, RefType: LedgerRefOrg, RefId: orgId}, userPosting(userId, -quota), orgPosting(orgId, quota))
+	})
+	if err != nil {
+		return err
+	}
+	if err := CacheUpdateUserQuota(userId); err != nil {
+		common.SysError("failed to update user quota cache: " + err.Error())
+	}
+	RecordLog(userId, LogTypeManage, 0, fmt.Sprintf("向组织 %d 额度池划转 %s", orgId, common.LogQuota(quota)))
+	return nil
+}
+
+// AdjustOrganizationQuota 管理员直接调整组织额度池
+func AdjustOrganizationQuota(orgId int, delta int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Organization{}).Where("id = ?", orgId).Update("quota", gorm.Expr("quota + ?", delta)).Error; err != nil {
+			return err
+		}
+		return recordLedger(tx, LedgerRef{Type: LedgerTypeAdjust, RefType: LedgerRefOrg, RefId: orgId}, orgPosting(orgId, delta))
+	})
+}
+
+// GetOrgMemberAvailableQuota 成员当前可用的组织额度，取额度池余额与成员剩余上限中的较小值
+func GetOrgMemberAvailableQuota(orgId int, userId int) (int, error) {
+	member, err := GetOrgMember(orgId, userId)
+	if err != nil {
+		return 0, err
+	}
+	var quota int
+	err = DB.Model(&Organization{}).Where("id = ?", orgId).Select("quota").Find(&quota).Error
+	if err != nil {
+		return 0, err
+	}
+	if member.QuotaCap > 0 && member.QuotaCap-member.UsedQuota < quota {
+		quota = member.QuotaCap - member.UsedQuota
+	}
+	return quota, nil
+}
+
+// CacheGetPayerQuota 组织令牌返回成员可用的组织额度，其他令牌返回用户余额
+func CacheGetPayerQuota(ctx context.Context, userId int, orgId int) (int, error) {
+	if orgId == 0 {
+		return CacheGetUserQuota(ctx, userId)
+	}
+	return GetOrgMemberAvailableQuota(orgId, userId)
+}
+
+// consumeOrgTokenQuota 在同一事务中扣减组织令牌额度和组织额度池并计入成员用量，quota 为负时退回。
+// 扣费时以条件更新检查额度池余额和成员额度上限，任一不足则整体回滚
+func consumeOrgTokenQuota(ctx context.Context, token *Token, quota int, ref LedgerRef) error {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if !token.UnlimitedQuota {
+			err := changeTokenQuota(tx, token.Id, -quota, ref)
+			if err != nil {
+				return err
+			}
+		}
+		org := tx.Model(&Organization{}).Where("id = ?", token.OrgId)
+		member := tx.Model(&OrgMember{}).Where("org_id = ? and user_id = ?", token.OrgId, token.UserId)
+		if quota > 0 {
+			org = org.Where("quota >= ?", quota)
+			member = member.Where("(quota_cap <= 0 or used_quota + ? <= quota_cap)", quota)
+		}
+		result := org.Updates(map[string]interface{}{
+			"quota":      gorm.Expr("quota - ?", quota),
+			"used_quota": gorm.Expr("used_quota + ?", quota),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 && quota > 0 {
+			return errors.New("组织额度不足")
+		}
+		result = member.Update("used_quota", gorm.Expr("used_quota + ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 && quota > 0 {
+			return errors.New("超出成员可用的组织额度")
+		}
+		return recordLedger(tx, ref, orgPosting(token.OrgId, -quota))
+	})
+}
+
+// getTokenOrgId 获取令牌所属组织，用于日志和数据看板归属
+func getTokenOrgId(tokenId int) (orgId int) {
+	if tokenId == 0 {
+		return 0
+	}
+	DB.Model(&Token{}).Where("id = ?", tokenId).Select("org_id").Find(&orgId)
+	return orgId
+}
@@ -0,0 +1,121 @@
+package model
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestOrg(t *testing.T, ownerId int, quota int) *Organization {
+	t.Helper()
+	org := &Organization{Name: "test", OwnerId: ownerId}
+	require.NoError(t, org.Insert())
+	require.NoError(t, AdjustOrganizationQuota(org.Id, quota))
+	return org
+}
+
+func createTestOrgToken(t *testing.T, orgId int, userId int, remainQuota int) *Token {
+	t.Helper()
+	token := createTestToken(t, userId, remainQuota)
+	require.NoError(t, DB.Model(token).Update("org_id", orgId).Error)
+	return token
+}
+
+func getTestOrg(t *testing.T, id int) *Organization {
+	t.Helper()
+	org, err := GetOrganizationById(id)
+	require.NoError(t, err)
+	return org
+}
+
+func getTestOrgMemberUsed(t *testing.T, orgId int, userId int) int {
+	t.Helper()
+	member, err := GetOrgMember(orgId, userId)
+	require.NoError(t, err)
+	return member.UsedQuota
+}
+
+func TestOrgTokenPoolLimit(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "org_owner")
+	org := createTestOrg(t, owner.Id, 500)
+	token := createTestOrgToken(t, org.Id, owner.Id, 1000)
+	ctx := context.Background()
+
+	require.NoError(t, PreConsumeTokenQuota(ctx, token.Id, 300, "gpt-4"))
+	// 额度池不足时令牌额度也不扣减
+	assert.EqualError(t, PreConsumeTokenQuota(ctx, token.Id, 300, "gpt-4"), "组织额度不足")
+	assert.Equal(t, 700, getTestTokenRemainQuota(t, token.Id))
+	assert.Equal(t, 200, getTestOrg(t, org.Id).Quota)
+
+	// 结算时补扣同样受额度池限制，退回不受限制
+	assert.Error(t, PostConsumeTokenQuota(ctx, token.Id, 300))
+	require.NoError(t, PostConsumeTokenQuota(ctx, token.Id, -100))
+	assert.Equal(t, 800, getTestTokenRemainQuota(t, token.Id))
+	org = getTestOrg(t, org.Id)
+	assert.Equal(t, 300, org.Quota)
+	assert.Equal(t, 200, org.UsedQuota)
+	assert.Equal(t, 200, getTestOrgMemberUsed(t, org.Id, owner.Id))
+	requireLedgerBalanced(t)
+}
+
+func TestOrgTokenMemberCap(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "org_owner")
+	user := createTestUser(t, "org_member")
+	org := createTestOrg(t, owner.Id, 1000)
+	require.NoError(t, (&OrgMember{OrgId: org.Id, UserId: user.Id, Role: OrgRoleMember, QuotaCap: 250}).Insert())
+	token := createTestOrgToken(t, org.Id, user.Id, 1000)
+	ctx := context.Background()
+
+	require.NoError(t, PreConsumeTokenQuota(ctx, token.Id, 200, "gpt-4"))
+	assert.Error(t, PreConsumeTokenQuota(ctx, token.Id, 100, "gpt-4"))
+	assert.Error(t, PostConsumeTokenQuota(ctx, token.Id, 100))
+	assert.Equal(t, 800, getTestOrg(t, org.Id).Quota)
+	assert.Equal(t, 800, getTestTokenRemainQuota(t, token.Id))
+
+	require.NoError(t, PostConsumeTokenQuota(ctx, token.Id, -50))
+	require.NoError(t, PreConsumeTokenQuota(ctx, token.Id, 100, "gpt-4"))
+	assert.Equal(t, 250, getTestOrgMemberUsed(t, org.Id, user.Id))
+	assert.Equal(t, 750, getTestOrg(t, org.Id).Quota)
+
+	// 已移出组织的成员不能继续使用组织令牌
+	require.NoError(t, DeleteOrgMember(org.Id, user.Id))
+	assert.Error(t, PreConsumeTokenQuota(ctx, token.Id, 1, "gpt-4"))
+	requireLedgerBalanced(t)
+}
+
+func TestOrgTokenConcurrentPreConsume(t *testing.T) {
+	setupTestDB(t)
+	owner := createTestUser(t, "org_owner")
+	org := createTestOrg(t, owner.Id, 500)
+	token := createTestOrgToken(t, org.Id, owner.Id, 10000)
+
+	// 并发预扣时额度池不会被扣成负数，令牌、额度池和成员用量保持一致
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = PreConsumeTokenQuota(context.Background(), token.Id, 100, "gpt-4")
+		}(i)
+	}
+	wg.Wait()
+	consumed := 0
+	for _, err := range errs {
+		if err == nil {
+			consumed++
+		}
+	}
+	assert.LessOrEqual(t, consumed, 5)
+	org = getTestOrg(t, org.Id)
+	assert.Equal(t, 500-100*consumed, org.Quota)
+	assert.Equal(t, 100*consumed, org.UsedQuota)
+	assert.Equal(t, 100*consumed, getTestOrgMemberUsed(t, org.Id, owner.Id))
+	assert.Equal(t, 10000-100*consumed, getTestTokenRemainQuota(t, token.Id))
+	requireLedgerBalanced(t)
+}
@@ -0,0 +1,157 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strings"
+)
+
+// PersonalAccessTokenPrefix 管理接口个人访问令牌的前缀，用于与旧的 access token 区分
+const PersonalAccessTokenPrefix = "pat-"
+
+// personalAccessTokenSecretLength 前缀之后的随机部分长度，62 进制 40 位约 238 位熵
+const personalAccessTokenSecretLength = 40
+
+// 用户自助接口的授权范围，管理接口的授权范围与权限同名，见 AllPermissions
+const (
+	ScopeSelf   = "self"
+	ScopeTokens = "tokens"
+	ScopeOrg    = "org"
+)
+
+// AllScopes 全部可授予个人访问令牌的范围，授予时需加 ":read" 或 ":write" 后缀，write 包含 read
+var AllScopes = append([]string{ScopeSelf, ScopeTokens, ScopeOrg}, AllPermissions...)
+
+// PersonalAccessToken 管理接口的个人访问令牌，只保存哈希，完整令牌仅在创建时返回一次
+type PersonalAccessToken struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	Name         string `json:"name" gorm:"type:varchar(64)"`
+	KeyHash      string `json:"-" gorm:"type:char(64);uniqueIndex"`
+	Prefix       string `json:"prefix" gorm:"type:varchar(16)"`        // 令牌开头的几位，便于辨认
+	Scopes       string `json:"scopes" gorm:"type:text"`               // JSON array，如 ["tokens:write","logs:read"]
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint;default:-1"` // -1 表示永不过期
+	LastUsedTime int64  `json:"last_used_time" gorm:"bigint"`
+	LastUsedIp   string `json:"last_used_ip" gorm:"type:varchar(64)"`
+	RevokedTime  int64  `json:"revoked_time" gorm:"bigint;default:0"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+}
+
+func hashAccessKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateScopes 校验授权范围 JSON 数组中的每一项
+func ValidateScopes(scopes string) error {
+	var list []string
+	if err := json.Unmarshal([]byte(scopes), &list); err != nil {
+		return errors.New("授权范围必须是 JSON 数组")
+	}
+	if len(list) == 0 {
+		return errors.New("至少需要一个授权范围")
+	}
+	for _, scope := range list {
+		area, access, found := strings.Cut(scope, ":")
+		if !found || (access != "read" && access != "write") {
+			return fmt.Errorf("授权范围格式应为 <范围>:read 或 <范围>:write：%s", scope)
+		}
+		known := false
+		for _, s := range AllScopes {
+			if s == area {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("未知的授权范围：%s", scope)
+		}
+	}
+	return nil
+}
+
+// Insert 生成令牌并保存哈希，返回完整令牌
+func (pat *PersonalAccessToken) Insert() (string, error) {
+	secret, err := common.GetSecureRandomString(personalAccessTokenSecretLength)
+	if err != nil {
+		return "", err
+	}
+	key := PersonalAccessTokenPrefix + secret
+	pat.KeyHash = hashAccessKey(key)
+	pat.Prefix = key[:len(PersonalAccessTokenPrefix)+6]
+	pat.CreatedTime = common.GetTimestamp()
+	err = DB.Create(pat).Error
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func GetUserPersonalAccessTokens(userId int) (pats []*PersonalAccessToken, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Find(&pats).Error
+	return pats, err
+}
+
+// RevokePersonalAccessToken 吊销令牌，吊销后立即失效且不可恢复
+func RevokePersonalAccessToken(id int, userId int) error {
+	result := DB.Model(&PersonalAccessToken{}).Where("id = ? and user_id = ? and revoked_time = 0", id, userId).
+		Update("revoked_time", common.GetTimestamp())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("令牌不存在或已吊销")
+	}
+	return nil
+}
+
+// HasScope 检查令牌是否拥有任一范围，write 为 true 时需要 write 授权
+func (pat *PersonalAccessToken) HasScope(write bool, areas ...string) bool {
+	var list []string
+	_ = json.Unmarshal([]byte(pat.Scopes), &list)
+	for _, scope := range list {
+		area, access, _ := strings.Cut(scope, ":")
+		if write && access != "write" {
+			continue
+		}
+		for _, required := range areas {
+			if area == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidatePersonalAccessToken 校验个人访问令牌并返回所属用户，同时记录最近使用时间和 IP
+func ValidatePersonalAccessToken(key string, ip string) (*User, *PersonalAccessToken, error) {
+	key = strings.TrimPrefix(key, "Bearer ")
+	pat := PersonalAccessToken{}
+	err := DB.Where("key_hash = ?", hashAccessKey(key)).First(&pat).Error
+	if err != nil {
+		return nil, nil, errors.New("access token 无效")
+	}
+	now := common.GetTimestamp()
+	if pat.RevokedTime != 0 {
+		return nil, nil, errors.New("access token 已吊销")
+	}
+	if pat.ExpiredTime != -1 && pat.ExpiredTime < now {
+		return nil, nil, errors.New("access token 已过期")
+	}
+	user, err := GetUserById(pat.UserId, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 一分钟内重复使用不再写库
+	if now-pat.LastUsedTime >= 60 || pat.LastUsedIp != ip {
+		err = DB.Model(&pat).Updates(map[string]interface{}{"last_used_time": now, "last_used_ip": ip}).Error
+		if err != nil {
+			common.SysError("failed to update access token last used time: " + err.Error())
+		}
+	}
+	return user, &pat, nil
+}
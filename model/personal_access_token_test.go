@@ -0,0 +1,32 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersonalAccessTokenKey(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "pat-owner")
+	first := &PersonalAccessToken{UserId: user.Id, Name: "a", Scopes: `["self:read"]`, ExpiredTime: -1}
+	firstKey, err := first.Insert()
+	require.NoError(t, err)
+	second := &PersonalAccessToken{UserId: user.Id, Name: "b", Scopes: `["self:read"]`, ExpiredTime: -1}
+	secondKey, err := second.Insert()
+	require.NoError(t, err)
+
+	assert.NotEqual(t, firstKey, secondKey)
+	for _, key := range []string{firstKey, secondKey} {
+		assert.True(t, strings.HasPrefix(key, PersonalAccessTokenPrefix))
+		assert.Len(t, key, len(PersonalAccessTokenPrefix)+personalAccessTokenSecretLength)
+	}
+	assert.Equal(t, firstKey[:len(PersonalAccessTokenPrefix)+6], first.Prefix)
+	assert.Equal(t, hashAccessKey(firstKey), first.KeyHash)
+	owner, pat, err := ValidatePersonalAccessToken("Bearer "+firstKey, "127.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, user.Id, owner.Id)
+	assert.Equal(t, first.Id, pat.Id)
+}
@@ -0,0 +1,280 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 账户类型，system 为所有额度发放与消耗的对手方
+const (
+	LedgerAccountUser   = "user"  // users.quota
+	LedgerAccountAff    = "aff"   // users.aff_quota
+	LedgerAccountToken  = "token" // tokens.remain_quota
+	LedgerAccountOrg    = "org"   // organizations.quota
+	LedgerAccountSystem = "system"
+)
+
+// 分录类型
+const (
+	LedgerTypeOpening            = "opening"
+	LedgerTypeRegister           = "register"
+	LedgerTypeTopup              = QuotaLotSourceTopup
+	LedgerTypeRedemption         = QuotaLotSourceRedemption
+	LedgerTypeInvite             = QuotaLotSourceInvite
+	LedgerTypeRefill             = QuotaLotSourceRefill
+	LedgerTypeCommission         = "commission"
+	LedgerTypeConsume            = "consume"
+	LedgerTypeRefund             = "refund"
+	LedgerTypeExpire             = "expire"
+	LedgerTypeAffTransfer        = "aff_transfer"
+	LedgerTypeWithdrawal         = "withdrawal"
+	LedgerTypeWithdrawalRevert   = "withdrawal_revert"
+	LedgerTypeAdjust             = "adjust"
+	LedgerTypeClawback           = "clawback"            // 充值退款扣回
+	LedgerTypeCommissionReversal = "commission_reversal" // 充值退款冲回返佣
+	LedgerTypeOrgFund            = "org_fund"            // 个人余额划转到组织额度池
+)
+
+// 关联单据类型
+const (
+	LedgerRefTopup        = "topup"
+	LedgerRefRedemption   = "redemption"
+	LedgerRefWithdrawal   = "withdrawal"
+	LedgerRefRefill       = "refill"
+	LedgerRefSubscription = "subscription"
+	LedgerRefLot          = "lot"
+	LedgerRefToken        = "token"
+	LedgerRefUser         = "user"
+	LedgerRefOrg          = "org"
+	LedgerRefMidjourney   = "midjourney"
+	LedgerRefBatch        = "batch" // 批量更新合并后的消费，无法关联到单次请求
+)
+
+// QuotaLedgerEntry 额度流水，只追加不修改。同一笔变动的各分录 TxId 相同且 Delta 合计为 0
+type QuotaLedgerEntry struct {
+	Id          int    `json:"id"`
+	TxId        string `json:"tx_id" gorm:"type:varchar(32);index"`
+	AccountType string `json:"account_type" gorm:"type:varchar(16);index:idx_ledger_account"`
+	AccountId   int    `json:"account_id" gorm:"index:idx_ledger_account"`
+	Type        string `json:"type" gorm:"type:varchar(32);index"`
+	RefType     string `json:"ref_type" gorm:"type:varchar(16)"`
+	RefId       int    `json:"ref_id"`
+	Delta       int    `json:"delta"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// LedgerRef 额度变动的分录类型及关联单据
+type LedgerRef struct {
+	Type    string
+	RefType string
+	RefId   int
+}
+
+type ledgerPosting struct {
+	AccountType string
+	AccountId   int
+	Delta       int
+}
+
+func userPosting(id int, delta int) ledgerPosting {
+	return ledgerPosting{AccountType: LedgerAccountUser, AccountId: id, Delta: delta}
+}
+
+func affPosting(id int, delta int) ledgerPosting {
+	return ledgerPosting{AccountType: LedgerAccountAff, AccountId: id, Delta: delta}
+}
+
+func tokenPosting(id int, delta int) ledgerPosting {
+	return ledgerPosting{AccountType: LedgerAccountToken, AccountId: id, Delta: delta}
+}
+
+func orgPosting(id int, delta int) ledgerPosting {
+	return ledgerPosting{AccountType: LedgerAccountOrg, AccountId: id, Delta: delta}
+}
+
+// recordLedger 在额度变动所在事务中写入流水，借贷不平的部分记入 system 账户
+func recordLedger(tx *gorm.DB, ref LedgerRef, postings ...ledgerPosting) error {
+	txId := common.GetUUID()
+	now := common.GetTimestamp()
+	entries := make([]*QuotaLedgerEntry, 0, len(postings)+1)
+	sum := 0
+	for _, posting := range postings {
+		if posting.Delta == 0 {
+			continue
+		}
+		sum += posting.Delta
+		entries = append(entries, &QuotaLedgerEntry{
+			TxId:        txId,
+			AccountType: posting.AccountType,
+			AccountId:   posting.AccountId,
+			Type:        ref.Type,
+			RefType:     ref.RefType,
+			RefId:       ref.RefId,
+			Delta:       posting.Delta,
+			CreatedAt:   now,
+		})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if sum != 0 {
+		entries = append(entries, &QuotaLedgerEntry{
+			TxId:        txId,
+			AccountType: LedgerAccountSystem,
+			Type:        ref.Type,
+			RefType:     ref.RefType,
+			RefId:       ref.RefId,
+			Delta:       -sum,
+			CreatedAt:   now,
+		})
+	}
+	return tx.Create(&entries).Error
+}
+
+func GetQuotaLedgerEntries(accountType string, accountId int, entryType string, startIdx int, num int) (entries []*QuotaLedgerEntry, err error) {
+	tx := DB
+	if accountType != "" {
+		tx = tx.Where("account_type = ?", accountType)
+	}
+	if accountId != 0 {
+		tx = tx.Where("account_id = ?", accountId)
+	}
+	if entryType != "" {
+		tx = tx.Where("type = ?", entryType)
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&entries).Error
+	return entries, err
+}
+
+// LedgerDrift 账户余额与流水合计不一致的记录
+type LedgerDrift struct {
+	AccountType   string `json:"account_type"`
+	AccountId     int    `json:"account_id"`
+	Balance       int    `json:"balance"`
+	LedgerBalance int    `json:"ledger_balance"`
+	Drift         int    `json:"drift"`
+}
+
+type LedgerReconciliation struct {
+	CheckedAt int64          `json:"checked_at"`
+	Imbalance int            `json:"imbalance"` // 全部分录合计，正常应为 0
+	Drifts    []*LedgerDrift `json:"drifts"`
+}
+
+// ledgerBalanceSource 账户类型对应的余额字段
+type ledgerBalanceSource struct {
+	accountType string
+	table       string
+	column      string
+	condition   string
+}
+
+var ledgerBalanceSources = []ledgerBalanceSource{
+	{LedgerAccountUser, "users", "quota", "users.deleted_at IS NULL"},
+	{LedgerAccountAff, "users", "aff_quota", "users.deleted_at IS NULL"},
+	{LedgerAccountToken, "tokens", "remain_quota", "1 = 1"},
+	{LedgerAccountOrg, "organizations", "quota", "1 = 1"},
+}
+
+// ledgerDriftQuery 查询余额与流水合计不一致的账户
+func ledgerDriftQuery(db *gorm.DB, source ledgerBalanceSource) *gorm.DB {
+	ledger := db.Model(&QuotaLedgerEntry{}).Select("account_id, SUM(delta) AS total").
+		Where("account_type = ?", source.accountType).Group("account_id")
+	return db.Table(source.table).
+		Select(fmt.Sprintf("%s.id AS account_id, %s.%s AS balance, COALESCE(l.total, 0) AS ledger_balance", source.table, source.table, source.column)).
+		Joins(fmt.Sprintf("LEFT JOIN (?) l ON l.account_id = %s.id", source.table), ledger).
+		Where(source.condition).
+		Where(fmt.Sprintf("%s.%s <> COALESCE(l.total, 0)", source.table, source.column))
+}
+
+// ReconcileQuotaLedger 核对用户余额、邀请余额、令牌剩余额度和组织额度池与流水合计是否一致
+func ReconcileQuotaLedger() (*LedgerReconciliation, error) {
+	result := &LedgerReconciliation{CheckedAt: common.GetTimestamp(), Drifts: make([]*LedgerDrift, 0)}
+	err := DB.Model(&QuotaLedgerEntry{}).Select("COALESCE(SUM(delta), 0)").Scan(&result.Imbalance).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, check := range ledgerBalanceSources {
+		var drifts []*LedgerDrift
+		err = ledgerDriftQuery(DB, check).Scan(&drifts).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, drift := range drifts {
+			drift.AccountType = check.accountType
+			drift.Drift = drift.Balance - drift.LedgerBalance
+		}
+		result.Drifts = append(result.Drifts, drifts...)
+	}
+	return result, nil
+}
+
+// ReconcileQuotaLedgerJob 定期核对流水，发现差异时写入系统日志
+func ReconcileQuotaLedgerJob() error {
+	result, err := ReconcileQuotaLedger()
+	if err != nil {
+		return err
+	}
+	if result.Imbalance != 0 || len(result.Drifts) > 0 {
+		common.SysError(fmt.Sprintf("quota ledger reconciliation found %d drifted accounts, imbalance %d", len(result.Drifts), result.Imbalance))
+	}
+	return nil
+}
+
+// quotaLedgerOpenedOption 期初分录写入完成的标记，与期初分录在同一事务中写入
+const quotaLedgerOpenedOption = "QuotaLedgerOpened"
+
+// openQuotaLedger 为现有余额写入期初分录，之后的变动都从期初开始累计。
+// 全部期初分录与完成标记在同一事务中写入，中途失败下次启动时整体重做；
+// 多个节点同时启动时只有先写入标记的节点会继续，其余节点等待其提交后直接返回
+func openQuotaLedger(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		marker := &Option{Key: quotaLedgerOpenedOption, Value: strconv.FormatInt(common.GetTimestamp(), 10)}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(marker)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		common.SysLog("writing opening balances to quota ledger")
+		for _, source := range ledgerBalanceSources {
+			err := openLedgerAccounts(tx, source)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// openLedgerAccounts 为还没有期初分录的账户补记余额与已有流水合计之差
+func openLedgerAccounts(tx *gorm.DB, source ledgerBalanceSource) error {
+	var drifts []*LedgerDrift
+	opened := tx.Model(&QuotaLedgerEntry{}).Select("account_id").
+		Where("account_type = ? and type = ?", source.accountType, LedgerTypeOpening)
+	err := ledgerDriftQuery(tx, source).Where(fmt.Sprintf("%s.id NOT IN (?)", source.table), opened).
+		Scan(&drifts).Error
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(drifts); start += 500 {
+		end := start + 500
+		if end > len(drifts) {
+			end = len(drifts)
+		}
+		postings := make([]ledgerPosting, 0, end-start)
+		for _, drift := range drifts[start:end] {
+			postings = append(postings, ledgerPosting{AccountType: source.accountType, AccountId: drift.AccountId, Delta: drift.Balance - drift.LedgerBalance})
+		}
+		err = recordLedger(tx, LedgerRef{Type: LedgerTypeOpening}, postings...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
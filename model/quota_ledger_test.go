@@ -0,0 +1,83 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileQuotaLedger(t *testing.T) {
+	cases := []struct {
+		name          string
+		tamper        func(t *testing.T, userId int)
+		wantImbalance int
+		wantDrift     int
+	}{
+		{"balanced", func(t *testing.T, userId int) {}, 0, 0},
+		{"balance changed without ledger", func(t *testing.T, userId int) {
+			require.NoError(t, DB.Model(&User{}).Where("id = ?", userId).Update("quota", 1000).Error)
+		}, 0, 1000 - 70},
+		{"unbalanced entry", func(t *testing.T, userId int) {
+			require.NoError(t, DB.Create(&QuotaLedgerEntry{TxId: "manual", AccountType: LedgerAccountUser, AccountId: userId, Type: LedgerTypeAdjust, Delta: 5}).Error)
+		}, 5, -5},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setupTestDB(t)
+			user := createTestUser(t, "ledger_user")
+			require.NoError(t, GrantUserQuota(user.Id, 100, QuotaLotSourceTopup, 1, 0))
+			ref := LedgerRef{Type: LedgerTypeConsume, RefType: LedgerRefToken, RefId: 1}
+			require.NoError(t, DecreaseUserQuota(user.Id, 50, ref))
+			require.NoError(t, IncreaseUserQuota(user.Id, 20, ref))
+			tc.tamper(t, user.Id)
+
+			result, err := ReconcileQuotaLedger()
+			require.NoError(t, err)
+			require.Equal(t, tc.wantImbalance, result.Imbalance)
+			if tc.wantDrift == 0 {
+				require.Empty(t, result.Drifts)
+				return
+			}
+			require.Len(t, result.Drifts, 1)
+			require.Equal(t, LedgerAccountUser, result.Drifts[0].AccountType)
+			require.Equal(t, user.Id, result.Drifts[0].AccountId)
+			require.Equal(t, tc.wantDrift, result.Drifts[0].Drift)
+		})
+	}
+}
+
+func TestRootAccountOpeningEntry(t *testing.T) {
+	setupTestDB(t)
+	var root User
+	require.NoError(t, DB.Where("username = ?", "root").First(&root).Error)
+	var total int
+	require.NoError(t, DB.Model(&QuotaLedgerEntry{}).Select("COALESCE(SUM(delta), 0)").
+		Where("account_type = ? and account_id = ?", LedgerAccountUser, root.Id).Scan(&total).Error)
+	require.Equal(t, root.Quota, total)
+}
+
+func TestOpenQuotaLedger(t *testing.T) {
+	setupTestDB(t)
+	legacy := createTestUser(t, "legacy_user")
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", legacy.Id).
+		Updates(map[string]interface{}{"quota": 300, "aff_quota": 40}).Error)
+	active := createTestUser(t, "active_user")
+	require.NoError(t, GrantUserQuota(active.Id, 100, QuotaLotSourceTopup, 1, 0))
+
+	// 启动时已写入完成标记，不再补记期初分录
+	require.NoError(t, openQuotaLedger(DB))
+	result, err := ReconcileQuotaLedger()
+	require.NoError(t, err)
+	require.Len(t, result.Drifts, 2)
+
+	// 标记缺失时补记没有期初分录的账户，重复执行不会重复补记
+	require.NoError(t, DB.Delete(&Option{Key: quotaLedgerOpenedOption}).Error)
+	require.NoError(t, openQuotaLedger(DB))
+	require.NoError(t, openQuotaLedger(DB))
+	requireLedgerBalanced(t)
+	var openings int64
+	require.NoError(t, DB.Model(&QuotaLedgerEntry{}).
+		Where("type = ? and account_id = ? and account_type <> ?", LedgerTypeOpening, legacy.Id, LedgerAccountSystem).
+		Count(&openings).Error)
+	require.EqualValues(t, 2, openings)
+}
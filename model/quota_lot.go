@@ -0,0 +1,246 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	QuotaLotSourceTopup        = "topup"
+	QuotaLotSourceRedemption   = "redemption"
+	QuotaLotSourceInvite       = "invite"
+	QuotaLotSourceRefill       = "refill"
+	QuotaLotSourceSubscription = "subscription"
+)
+
+// QuotaLot 每笔发放的额度是一个额度包，消费时优先扣减最早过期的额度包，过期时只扣除未用完的部分
+type QuotaLot struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	Source    string `json:"source" gorm:"type:varchar(32)"`
+	SourceId  int    `json:"source_id"`
+	Amount    int    `json:"amount"`
+	Remaining int    `json:"remaining"`
+	ExpiresAt int64  `json:"expires_at" gorm:"bigint;default:0"` // 0 means never expired
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+}
+
+// RechargeRecord 旧版充值有效期记录，仅用于迁移到额度包
+type RechargeRecord struct {
+	ID        uint  `gorm:"primaryKey" json:"id"`
+	UserID    uint  `gorm:"index" json:"user_id"`
+	Amount    int   `json:"amount"`
+	StartDate int64 `json:"start_date"`
+	EndDate   int64 `json:"end_date"` // -1 means never expired
+	CreatedAt int64 `json:"created_at"`
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// QuotaLotExpiresAt 按有效天数计算额度包过期时间，天数不大于 0 表示永不过期
+func QuotaLotExpiresAt(days int) int64 {
+	if days <= 0 {
+		return 0
+	}
+	return time.Now().Unix() + int64(days)*24*60*60
+}
+
+func createQuotaLot(tx *gorm.DB, userId int, quota int, source string, sourceId int, expiresAt int64) error {
+	if quota <= 0 {
+		return nil
+	}
+	return tx.Create(&QuotaLot{
+		UserId:    userId,
+		Source:    source,
+		SourceId:  sourceId,
+		Amount:    quota,
+		Remaining: quota,
+		ExpiresAt: expiresAt,
+		CreatedAt: common.GetTimestamp(),
+	}).Error
+}
+
+// GrantUserQuota 增加用户额度并记录对应的额度包
+func GrantUserQuota(userId int, quota int, source string, sourceId int, expiresAt int64) error {
+	if quota <= 0 {
+		return nil
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		return grantUserQuota(tx, userId, quota, source, sourceId, expiresAt)
+	})
+	if err != nil {
+		return err
+	}
+	if err := CacheUpdateUserQuota(userId); err != nil {
+		common.SysError("failed to update user quota cache: " + err.Error())
+	}
+	return nil
+}
+
+func grantUserQuota(tx *gorm.DB, userId int, quota int, source string, sourceId int, expiresAt int64) error {
+	err := tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", quota)).Error
+	if err != nil {
+		return err
+	}
+	err = createQuotaLot(tx, userId, quota, source, sourceId, expiresAt)
+	if err != nil {
+		return err
+	}
+	// 额度包来源即流水类型，邀请赠送关联的是邀请人
+	refType := source
+	if source == QuotaLotSourceInvite {
+		refType = LedgerRefUser
+	}
+	return recordLedger(tx, LedgerRef{Type: source, RefType: refType, RefId: sourceId}, userPosting(userId, quota))
+}
+
+// drawQuotaLots 按过期时间从早到晚扣减用户的额度包，永不过期的额度包最后扣减，
+// 额度包不足的部分从未记入额度包的余额（如注册赠送、管理员调整）中扣除
+func drawQuotaLots(tx *gorm.DB, userId int, quota int) error {
+	var lots []*QuotaLot
+	err := tx.Where("user_id = ? and remaining > 0 and (expires_at = 0 or expires_at > ?)", userId, common.GetTimestamp()).
+		Order("CASE WHEN expires_at = 0 THEN 1 ELSE 0 END, expires_at, id").Find(&lots).Error
+	if err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		if quota <= 0 {
+			break
+		}
+		take := lot.Remaining
+		if take > quota {
+			take = quota
+		}
+		result := tx.Model(&QuotaLot{}).Where("id = ? and remaining >= ?", lot.Id, take).
+			Update("remaining", gorm.Expr("remaining - ?", take))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			quota -= take
+		}
+	}
+	return nil
+}
+
+// restoreQuotaLots 是 drawQuotaLots 的逆操作，退款时按扣减的相反顺序把额度补回未过期的额度包，
+// 每个额度包最多补回到发放数量，补不回的部分留在未记入额度包的余额中
+func restoreQuotaLots(tx *gorm.DB, userId int, quota int) error {
+	var lots []*QuotaLot
+	err := tx.Where("user_id = ? and remaining < amount and (expires_at = 0 or expires_at > ?)", userId, common.GetTimestamp()).
+		Order("CASE WHEN expires_at = 0 THEN 0 ELSE 1 END, expires_at desc, id desc").Find(&lots).Error
+	if err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		if quota <= 0 {
+			break
+		}
+		give := lot.Amount - lot.Remaining
+		if give > quota {
+			give = quota
+		}
+		result := tx.Model(&QuotaLot{}).Where("id = ? and remaining + ? <= amount", lot.Id, give).
+			Update("remaining", gorm.Expr("remaining + ?", give))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			quota -= give
+		}
+	}
+	return nil
+}
+
+func GetUserQuotaLots(userId int, startIdx int, num int) (lots []*QuotaLot, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Limit(num).Offset(startIdx).Find(&lots).Error
+	return lots, err
+}
+
+// ExpireQuotaLots 扣除已过期额度包中未用完的额度
+func ExpireQuotaLots() error {
+	var lots []*QuotaLot
+	err := DB.Where("expires_at > 0 and expires_at <= ? and remaining > 0", common.GetTimestamp()).Find(&lots).Error
+	if err != nil {
+		return err
+	}
+	for _, lot := range lots {
+		var expired, deducted int
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			// 先读取剩余额度再清零，并发消费时以清零前的最新值为准
+			err := tx.Model(&QuotaLot{}).Where("id = ?", lot.Id).Select("remaining").Find(&expired).Error
+			if err != nil || expired <= 0 {
+				return err
+			}
+			result := tx.Model(&QuotaLot{}).Where("id = ? and remaining = ?", lot.Id, expired).Update("remaining", 0)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			// 余额不足时最多扣到 0
+			var quota int
+			err = tx.Model(&User{}).Where("id = ?", lot.UserId).Select("quota").Find(&quota).Error
+			if err != nil {
+				return err
+			}
+			deducted = expired
+			if quota < deducted {
+				deducted = quota
+			}
+			if deducted <= 0 {
+				deducted = 0
+				return nil
+			}
+			err = tx.Model(&User{}).Where("id = ?", lot.UserId).Update("quota", gorm.Expr("quota - ?", deducted)).Error
+			if err != nil {
+				return err
+			}
+			return recordLedger(tx, LedgerRef{Type: LedgerTypeExpire, RefType: LedgerRefLot, RefId: lot.Id}, userPosting(lot.UserId, -deducted))
+		})
+		if err != nil {
+			return err
+		}
+		if deducted <= 0 {
+			continue
+		}
+		if err := CacheUpdateUserQuota(lot.UserId); err != nil {
+			common.SysError("failed to update user quota cache: " + err.Error())
+		}
+		RecordLog(lot.UserId, LogTypeSystem, 0, fmt.Sprintf("额度包 #%d 已过期，扣除未使用额度 %s", lot.Id, common.LogQuota(deducted)))
+	}
+	return nil
+}
+
+// migrateRechargeRecords 将旧版充值记录中未用完的额度转换为额度包
+func migrateRechargeRecords(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&RechargeRecord{}) {
+		return nil
+	}
+	common.SysLog("migrating recharge records to quota lots")
+	return db.Transaction(func(tx *gorm.DB) error {
+		var records []*RechargeRecord
+		err := tx.Where("amount > 0").Find(&records).Error
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			expiresAt := record.EndDate
+			if expiresAt < 0 {
+				expiresAt = 0
+			}
+			err = tx.Create(&QuotaLot{
+				UserId:    int(record.UserID),
+				Source:    QuotaLotSourceTopup,
+				Amount:    record.Amount,
+				Remaining: record.Amount,
+				ExpiresAt: expiresAt,
+				CreatedAt: record.StartDate,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Migrator().DropTable(&RechargeRecord{})
+	})
+}
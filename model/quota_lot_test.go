@@ -0,0 +1,114 @@
+package model
+
+import (
+	"context"
+	"one-api/common"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrawAndRestoreQuotaLots(t *testing.T) {
+	now := common.GetTimestamp()
+	cases := []struct {
+		name          string
+		consume       int
+		refund        int
+		wantSoon      int
+		wantLater     int
+		wantForever   int
+		wantUserQuota int
+	}{
+		{"consume from earliest lot", 30, 0, 70, 100, 100, 320},
+		{"consume across lots", 150, 0, 0, 50, 100, 200},
+		{"consume beyond lots uses untracked balance", 350, 0, 0, 0, 0, 0},
+		{"full refund restores every lot", 150, 150, 100, 100, 100, 350},
+		{"partial refund restores latest drawn lot first", 150, 30, 0, 80, 100, 230},
+		{"refund larger than drawn keeps lots capped", 30, 80, 100, 100, 100, 400},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setupTestDB(t)
+			user := createTestUser(t, "lot_user")
+			ref := LedgerRef{Type: LedgerTypeAdjust, RefType: LedgerRefUser, RefId: user.Id}
+			require.NoError(t, increaseUserQuota(context.Background(), user.Id, 50, ref))
+			require.NoError(t, GrantUserQuota(user.Id, 100, QuotaLotSourceTopup, 1, 0))
+			require.NoError(t, GrantUserQuota(user.Id, 100, QuotaLotSourceTopup, 2, now+2*24*3600))
+			require.NoError(t, GrantUserQuota(user.Id, 100, QuotaLotSourceTopup, 3, now+24*3600))
+			var lots []*QuotaLot
+			require.NoError(t, DB.Where("user_id = ?", user.Id).Order("source_id").Find(&lots).Error)
+			require.Len(t, lots, 3)
+			forever, later, soon := lots[0], lots[1], lots[2]
+
+			consumeRef := LedgerRef{Type: LedgerTypeConsume, RefType: LedgerRefToken, RefId: 1}
+			require.NoError(t, DecreaseUserQuota(user.Id, tc.consume, consumeRef))
+			if tc.refund > 0 {
+				require.NoError(t, IncreaseUserQuota(user.Id, tc.refund, consumeRef))
+			}
+			require.Equal(t, tc.wantSoon, getTestLotRemaining(t, soon.Id))
+			require.Equal(t, tc.wantLater, getTestLotRemaining(t, later.Id))
+			require.Equal(t, tc.wantForever, getTestLotRemaining(t, forever.Id))
+			require.Equal(t, tc.wantUserQuota, getTestUserQuota(t, user.Id))
+			requireLedgerBalanced(t)
+		})
+	}
+}
+
+func TestRestoreQuotaLotsSkipsExpiredLots(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "lot_user")
+	require.NoError(t, GrantUserQuota(user.Id, 100, QuotaLotSourceTopup, 1, 0))
+	expired := &QuotaLot{UserId: user.Id, Source: QuotaLotSourceTopup, SourceId: 2, Amount: 100, Remaining: 0, ExpiresAt: common.GetTimestamp() - 1}
+	require.NoError(t, DB.Create(expired).Error)
+	ref := LedgerRef{Type: LedgerTypeConsume, RefType: LedgerRefToken, RefId: 1}
+	require.NoError(t, DecreaseUserQuota(user.Id, 40, ref))
+	require.NoError(t, IncreaseUserQuota(user.Id, 100, ref))
+	require.Equal(t, 0, getTestLotRemaining(t, expired.Id))
+	var lot QuotaLot
+	require.NoError(t, DB.Where("user_id = ? and source_id = ?", user.Id, 1).First(&lot).Error)
+	require.Equal(t, 100, lot.Remaining)
+}
+
+func TestBatchUpdateRestoresQuotaLots(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "lot_user")
+	require.NoError(t, GrantUserQuota(user.Id, 100, QuotaLotSourceTopup, 1, 0))
+	ref := LedgerRef{Type: LedgerTypeConsume, RefType: LedgerRefToken, RefId: 1}
+	require.NoError(t, DecreaseUserQuota(user.Id, 60, ref))
+	common.BatchUpdateEnabled = true
+	defer func() { common.BatchUpdateEnabled = false }()
+	require.NoError(t, IncreaseUserQuota(user.Id, 50, ref))
+	require.NoError(t, DecreaseUserQuota(user.Id, 10, ref))
+	batchUpdate()
+	var lot QuotaLot
+	require.NoError(t, DB.Where("user_id = ?", user.Id).First(&lot).Error)
+	require.Equal(t, 80, lot.Remaining)
+	require.Equal(t, 80, getTestUserQuota(t, user.Id))
+	requireLedgerBalanced(t)
+}
+
+func TestResetRefillDrawsQuotaLots(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "lot_user")
+	now := common.GetTimestamp()
+	require.NoError(t, GrantUserQuota(user.Id, 500, QuotaLotSourceTopup, 1, 0))
+	require.NoError(t, GrantUserQuota(user.Id, 1000, QuotaLotSourceTopup, 2, now+24*3600))
+	var lots []*QuotaLot
+	require.NoError(t, DB.Where("user_id = ?", user.Id).Order("source_id").Find(&lots).Error)
+	require.Len(t, lots, 2)
+	forever, soon := lots[0], lots[1]
+
+	// 重置为 300 时先扣减最早过期的额度包
+	schedule := &RefillSchedule{TargetType: RefillTargetUser, TargetId: user.Id, Amount: 300, Period: RefillPeriodDay, Mode: RefillModeReset, Status: common.RefillScheduleStatusEnabled, NextRunAt: now}
+	require.NoError(t, DB.Create(schedule).Error)
+	require.NoError(t, applyRefill(schedule))
+	require.Equal(t, 300, getTestUserQuota(t, user.Id))
+	require.Equal(t, 0, getTestLotRemaining(t, soon.Id))
+	require.Equal(t, 300, getTestLotRemaining(t, forever.Id))
+
+	// 已被重置扣完的额度包过期时不再扣减余额
+	require.NoError(t, DB.Model(&QuotaLot{}).Where("id = ?", soon.Id).Update("expires_at", now-1).Error)
+	require.NoError(t, ExpireQuotaLots())
+	require.Equal(t, 300, getTestUserQuota(t, user.Id))
+	requireLedgerBalanced(t)
+}
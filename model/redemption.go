@@ -4,10 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"one-api/common"
-	"strconv"
-	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	RedemptionTypeQuota        = "quota"        // 发放额度，按 RedempTionCount 天过期
+	RedemptionTypeGroup        = "group"        // 切换到 UpgradeGroup 分组 Days 天
+	RedemptionTypeSubscription = "subscription" // 发放 Days 天后过期的额度包
 )
 
 type Redemption struct {
@@ -16,13 +21,29 @@ type Redemption struct {
 	Key          string `json:"key" gorm:"type:char(32);uniqueIndex"`
 	Status       int    `json:"status" gorm:"default:1"`
 	Name         string `json:"name" gorm:"index"`
+	Batch        string `json:"batch" gorm:"type:varchar(32);index"` // 同一次批量生成的兑换码共用批次号
+	Type         string `json:"type" gorm:"type:varchar(16);default:'quota'"`
 	Quota        int    `json:"quota" gorm:"default:100"`
+	UpgradeGroup string `json:"upgrade_group" gorm:"type:varchar(32)"`
+	Days         int    `json:"days"`
+	OnePerUser   bool   `json:"one_per_user"`                          // 同一批次每个用户只能兑换一个
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
 	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
 	RedeemedTime int64  `json:"redeemed_time" gorm:"bigint"`
 	Count        int    `json:"count" gorm:"-:all"` // only for api request
 	UsedUserId   int    `json:"used_user_id"`
 }
 
+// RedemptionBatch 批量生成的兑换码汇总
+type RedemptionBatch struct {
+	Batch       string `json:"batch"`
+	Name        string `json:"name"`
+	Total       int    `json:"total"`
+	Used        int    `json:"used"`
+	Disabled    int    `json:"disabled"`
+	CreatedTime int64  `json:"created_time"`
+}
+
 func GetAllRedemptions(startIdx int, num int) ([]*Redemption, error) {
 	var redemptions []*Redemption
 	var err error
@@ -45,61 +66,98 @@ func GetRedemptionById(id int) (*Redemption, error) {
 	return &redemption, err
 }
 
-func Redeem(key string, userId int) (quota int, err error) {
+func Redeem(key string, userId int) (redemption *Redemption, err error) {
 	if key == "" {
-		return 0, errors.New("未提供兑换码")
+		return nil, errors.New("未提供兑换码")
 	}
 	if userId == 0 {
-		return 0, errors.New("无效的 user id")
+		return nil, errors.New("无效的 user id")
 	}
-	redemption := &Redemption{}
+	redemption = &Redemption{}
 
 	keyCol := "`key`"
 	if common.UsingPostgreSQL {
 		keyCol = `"key"`
 	}
-	RedempTionCount := strconv.Itoa(common.RedempTionCount)
 
 	err = DB.Transaction(func(tx *gorm.DB) error {
-		err := tx.Set("gorm:query_option", "FOR UPDATE").Where(keyCol+" = ?", key).First(redemption).Error
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(keyCol+" = ?", key).First(redemption).Error
 		if err != nil {
 			return errors.New("无效的兑换码")
 		}
 		if redemption.Status != common.RedemptionCodeStatusEnabled {
 			return errors.New("该兑换码已被使用")
 		}
-
-		// 更新用户的总配额
-		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
-		if err != nil {
-			return err
+		if redemption.ExpiredTime != -1 && redemption.ExpiredTime < common.GetTimestamp() {
+			return errors.New("该兑换码已过期")
+		}
+		if redemption.OnePerUser && redemption.Batch != "" {
+			// 锁住用户行，避免同一用户并发兑换同批次的不同兑换码
+			err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&User{}, userId).Error
+			if err != nil {
+				return err
+			}
+			var count int64
+			err = tx.Model(&Redemption{}).Where("batch = ? and used_user_id = ? and status = ?", redemption.Batch, userId, common.RedemptionCodeStatusUsed).Count(&count).Error
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return errors.New("每个用户只能兑换一个该批次的兑换码")
+			}
 		}
 
-		err = redemptionIncreaseRechargeQuota(tx, userId, RedempTionCount, redemption.Quota)
+		switch redemption.Type {
+		case RedemptionTypeGroup:
+			_, err = grantUserGroup(tx, userId, redemption.UpgradeGroup, redemption.Days, GroupGrantSourceRedemption, redemption.Id)
+		case RedemptionTypeSubscription:
+			if redemption.Days <= 0 {
+				return errors.New("兑换码未设置有效天数")
+			}
+			err = grantUserQuota(tx, userId, redemption.Quota, QuotaLotSourceRedemption, redemption.Id, QuotaLotExpiresAt(redemption.Days))
+		default:
+			// RedempTionCount 不大于 0 时兑换的额度永不过期
+			err = grantUserQuota(tx, userId, redemption.Quota, QuotaLotSourceRedemption, redemption.Id, QuotaLotExpiresAt(common.RedempTionCount))
+		}
 		if err != nil {
 			return err
 		}
-		// 标记兑换码为已用
+		// 标记兑换码为已用，按状态条件更新，不支持行锁的数据库上并发兑换也只有一个成功
 		redemption.RedeemedTime = common.GetTimestamp()
 		redemption.Status = common.RedemptionCodeStatusUsed
 		redemption.UsedUserId = userId
-		err = tx.Save(redemption).Error
-		if err != nil {
-			return err
+		result := tx.Model(&Redemption{}).Where("id = ? and status = ?", redemption.Id, common.RedemptionCodeStatusEnabled).Updates(map[string]interface{}{
+			"redeemed_time": redemption.RedeemedTime,
+			"status":        redemption.Status,
+			"used_user_id":  redemption.UsedUserId,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("该兑换码已被使用")
 		}
 
 		return nil
 	})
 
 	if err != nil {
-		return 0, errors.New("兑换失败，" + err.Error())
+		return nil, errors.New("兑换失败，" + err.Error())
 	}
 
 	// 这里可以记录日志和其他相关的操作
+	if redemption.Type == RedemptionTypeGroup {
+		CacheDeleteUserGroup(userId)
+		RecordLog(userId, LogTypeTopup, 0, fmt.Sprintf("通过兑换码升级为 %s 分组 %d 天", redemption.UpgradeGroup, redemption.Days))
+		return redemption, nil
+	}
+	if err := CacheUpdateUserQuota(userId); err != nil {
+		common.SysError("failed to update user quota cache: " + err.Error())
+	}
 	RecordLog(userId, LogTypeTopup, redemption.Quota, fmt.Sprintf("通过兑换码充值 %s", common.LogQuota(redemption.Quota)))
-	VipInsert(userId, redemption.Quota)
+	VipInsert(userId, redemption.Quota, LedgerRefRedemption, redemption.Id)
 
-	return redemption.Quota, nil
+	return redemption, nil
 }
 
 func (redemption *Redemption) Insert() error {
@@ -116,7 +174,7 @@ func (redemption *Redemption) SelectUpdate() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (redemption *Redemption) Update() error {
 	var err error
-	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time").Updates(redemption).Error
+	err = DB.Model(redemption).Select("name", "status", "type", "quota", "upgrade_group", "days", "one_per_user", "expired_time", "redeemed_time").Updates(redemption).Error
 	return err
 }
 
@@ -138,33 +196,56 @@ func DeleteRedemptionById(id int) (err error) {
 	return redemption.Delete()
 }
 
-func redemptionIncreaseRechargeQuota(tx *gorm.DB, id int, topupratio string, quota int) (err error) {
-	var endQuotaAt int64
-
-	// 如果topupratio不为空且不是"-1"，计算结束时间戳；如果是"-1"，则设置无限期。
-	if topupratio != "" && topupratio != "-1" {
-		days, convErr := strconv.Atoi(topupratio)
-		if convErr != nil {
-			return convErr
+// Validate 检查兑换码的发放内容是否合法
+func (redemption *Redemption) Validate() error {
+	switch redemption.Type {
+	case "", RedemptionTypeQuota:
+		redemption.Type = RedemptionTypeQuota
+	case RedemptionTypeGroup:
+		if redemption.UpgradeGroup == "" || redemption.Days <= 0 {
+			return errors.New("升级分组的兑换码必须设置分组和天数")
 		}
-		currentTime := time.Now().Unix()
-		endQuotaAt = currentTime + int64(days*24*60*60)
-	} else if topupratio == "-1" {
-		endQuotaAt = -1 // 表示无限期
-	}
-
-	// 启动一个事务处理增加配额和插入充值记录
-	if topupratio != "" {
-		record := &RechargeRecord{
-			UserID:    uint(id),
-			Amount:    quota,
-			StartDate: time.Now().Unix(), // 使用当前时间作为StartDate
-			EndDate:   endQuotaAt,        // 根据topupratio设置EndDate
+		if _, ok := common.GroupRatio[redemption.UpgradeGroup]; !ok {
+			return errors.New("分组不存在")
 		}
-		if err := tx.Create(&record).Error; err != nil {
-			return err
+	case RedemptionTypeSubscription:
+		if redemption.Days <= 0 {
+			return errors.New("额度包兑换码必须设置有效天数")
 		}
+	default:
+		return errors.New("未知的兑换码类型")
 	}
+	if redemption.ExpiredTime == 0 {
+		redemption.ExpiredTime = -1
+	}
+	return nil
+}
 
-	return err
+func GetRedemptionBatches(startIdx int, num int) (batches []*RedemptionBatch, err error) {
+	err = DB.Model(&Redemption{}).
+		Select("batch, MAX(name) AS name, COUNT(*) AS total, "+
+			"SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS used, "+
+			"SUM(CASE WHEN status = ? THEN 1 ELSE 0 END) AS disabled, "+
+			"MIN(created_time) AS created_time", common.RedemptionCodeStatusUsed, common.RedemptionCodeStatusDisabled).
+		Where("batch <> ''").Group("batch").Order("created_time desc").Limit(num).Offset(startIdx).
+		Scan(&batches).Error
+	return batches, err
+}
+
+func GetRedemptionsByBatch(batch string) (redemptions []*Redemption, err error) {
+	if batch == "" {
+		return nil, errors.New("批次号为空！")
+	}
+	err = DB.Where("batch = ?", batch).Order("id asc").Find(&redemptions).Error
+	return redemptions, err
+}
+
+// DisableRedemptionBatch 停用批次中所有未使用的兑换码，返回停用的个数
+func DisableRedemptionBatch(batch string) (int64, error) {
+	if batch == "" {
+		return 0, errors.New("批次号为空！")
+	}
+	result := DB.Model(&Redemption{}).Where("batch = ? and status = ?", batch, common.RedemptionCodeStatusEnabled).
+		Update("status", common.RedemptionCodeStatusDisabled)
+	return result.RowsAffected, result.Error
 }
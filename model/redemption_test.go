@@ -0,0 +1,73 @@
+package model
+
+import (
+	"one-api/common"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestRedemption(t *testing.T, redemption *Redemption) *Redemption {
+	t.Helper()
+	redemption.Key = common.GetUUID()
+	redemption.Status = common.RedemptionCodeStatusEnabled
+	redemption.CreatedTime = common.GetTimestamp()
+	require.NoError(t, DB.Create(redemption).Error)
+	return redemption
+}
+
+func TestRedeemOnce(t *testing.T) {
+	setupTestDB(t)
+	redemption := createTestRedemption(t, &Redemption{Name: "once", Type: RedemptionTypeQuota, Quota: 500})
+	users := []*User{createTestUser(t, "redeemer-1"), createTestUser(t, "redeemer-2"), createTestUser(t, "redeemer-3")}
+
+	// 并发兑换同一个兑换码只有一个成功
+	var wg sync.WaitGroup
+	errs := make([]error, len(users))
+	for i, user := range users {
+		wg.Add(1)
+		go func(i int, userId int) {
+			defer wg.Done()
+			_, errs[i] = Redeem(redemption.Key, userId)
+		}(i, user.Id)
+	}
+	wg.Wait()
+	total := 0
+	redeemed := 0
+	for i, user := range users {
+		if errs[i] == nil {
+			redeemed++
+		}
+		total += getTestUserQuota(t, user.Id)
+	}
+	assert.LessOrEqual(t, redeemed, 1)
+	assert.Equal(t, 500*redeemed, total)
+
+	_, err := Redeem(redemption.Key, users[0].Id)
+	assert.Error(t, err)
+	requireLedgerBalanced(t)
+}
+
+func TestRedeemOnePerUser(t *testing.T) {
+	setupTestDB(t)
+	first := createTestRedemption(t, &Redemption{Name: "batch", Batch: "b1", Type: RedemptionTypeQuota, Quota: 100, OnePerUser: true})
+	second := createTestRedemption(t, &Redemption{Name: "batch", Batch: "b1", Type: RedemptionTypeQuota, Quota: 100, OnePerUser: true})
+	user := createTestUser(t, "batch-redeemer")
+	other := createTestUser(t, "other-redeemer")
+
+	_, err := Redeem(first.Key, user.Id)
+	require.NoError(t, err)
+	_, err = Redeem(second.Key, user.Id)
+	assert.Error(t, err)
+	_, err = Redeem(second.Key, other.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 100, getTestUserQuota(t, user.Id))
+	assert.Equal(t, 100, getTestUserQuota(t, other.Id))
+	var saved Redemption
+	require.NoError(t, DB.First(&saved, first.Id).Error)
+	assert.Equal(t, common.RedemptionCodeStatusUsed, saved.Status)
+	assert.Equal(t, user.Id, saved.UsedUserId)
+	assert.NotZero(t, saved.RedeemedTime)
+}
@@ -0,0 +1,349 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RefillTargetUser  = "user"
+	RefillTargetToken = "token"
+)
+
+const (
+	RefillPeriodDay   = "day"
+	RefillPeriodWeek  = "week"
+	RefillPeriodMonth = "month"
+)
+
+const (
+	RefillModeReset      = "reset"      // 将余额重置为 Amount
+	RefillModeAccumulate = "accumulate" // 在余额上增加 Amount，不超过 Cap
+)
+
+// RefillSchedule 定期为用户或令牌补充额度
+// 按日补充时 Day 无意义；按周补充时 Day 为星期几（0 为周日）；按月补充时 Day 为几号（1-28）
+type RefillSchedule struct {
+	Id          int    `json:"id"`
+	TargetType  string `json:"target_type" gorm:"type:varchar(16);index:idx_refill_target"`
+	TargetId    int    `json:"target_id" gorm:"index:idx_refill_target"`
+	Amount      int    `json:"amount"`
+	Period      string `json:"period" gorm:"type:varchar(16)"`
+	Day         int    `json:"day" gorm:"default:0"`
+	Hour        int    `json:"hour" gorm:"default:0"`
+	Mode        string `json:"mode" gorm:"type:varchar(16)"`
+	Cap         int    `json:"cap" gorm:"default:0"` // 累加模式下的余额上限，0 表示不限
+	Status      int    `json:"status" gorm:"default:1"`
+	NextRunAt   int64  `json:"next_run_at" gorm:"bigint;index"`
+	LastRunAt   int64  `json:"last_run_at" gorm:"bigint"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// RefillPreview 即将执行的补充及补充后的预计余额
+type RefillPreview struct {
+	ScheduleId   int    `json:"schedule_id"`
+	TargetType   string `json:"target_type"`
+	TargetId     int    `json:"target_id"`
+	RunAt        int64  `json:"run_at"`
+	Mode         string `json:"mode"`
+	Amount       int    `json:"amount"`
+	CurrentQuota int    `json:"current_quota"`
+	QuotaAfter   int    `json:"quota_after"`
+}
+
+func (schedule *RefillSchedule) Validate() error {
+	if schedule.TargetType != RefillTargetUser && schedule.TargetType != RefillTargetToken {
+		return errors.New("补充对象类型无效")
+	}
+	if schedule.Amount <= 0 {
+		return errors.New("补充额度必须大于 0")
+	}
+	if schedule.Mode != RefillModeReset && schedule.Mode != RefillModeAccumulate {
+		return errors.New("补充方式无效")
+	}
+	if schedule.Cap < 0 {
+		return errors.New("额度上限不能为负数")
+	}
+	if schedule.Hour < 0 || schedule.Hour > 23 {
+		return errors.New("补充时间无效")
+	}
+	switch schedule.Period {
+	case RefillPeriodDay:
+	case RefillPeriodWeek:
+		if schedule.Day < 0 || schedule.Day > 6 {
+			return errors.New("按周补充时日期应为 0-6")
+		}
+	case RefillPeriodMonth:
+		if schedule.Day < 1 || schedule.Day > 28 {
+			return errors.New("按月补充时日期应为 1-28")
+		}
+	default:
+		return errors.New("补充周期无效")
+	}
+	var count int64
+	if schedule.TargetType == RefillTargetUser {
+		DB.Model(&User{}).Where("id = ?", schedule.TargetId).Count(&count)
+	} else {
+		DB.Model(&Token{}).Where("id = ?", schedule.TargetId).Count(&count)
+	}
+	if count == 0 {
+		return errors.New("补充对象不存在")
+	}
+	return nil
+}
+
+// nextRunAfter 返回严格晚于 t 的下一次补充时间
+func (schedule *RefillSchedule) nextRunAfter(t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch schedule.Period {
+	case RefillPeriodWeek:
+		today := time.Date(year, month, day, schedule.Hour, 0, 0, 0, t.Location())
+		next := today.AddDate(0, 0, (schedule.Day-int(t.Weekday())+7)%7)
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 7)
+		}
+		return next
+	case RefillPeriodMonth:
+		next := time.Date(year, month, schedule.Day, schedule.Hour, 0, 0, 0, t.Location())
+		if !next.After(t) {
+			next = next.AddDate(0, 1, 0)
+		}
+		return next
+	}
+	next := time.Date(year, month, day, schedule.Hour, 0, 0, 0, t.Location())
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+func GetAllRefillSchedules(targetType string, targetId int, startIdx int, num int) (schedules []*RefillSchedule, err error) {
+	tx := DB.Model(&RefillSchedule{})
+	if targetType != "" {
+		tx = tx.Where("target_type = ?", targetType)
+	}
+	if targetId != 0 {
+		tx = tx.Where("target_id = ?", targetId)
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&schedules).Error
+	return schedules, err
+}
+
+func GetRefillScheduleById(id int) (*RefillSchedule, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	schedule := RefillSchedule{}
+	err := DB.First(&schedule, "id = ?", id).Error
+	return &schedule, err
+}
+
+func (schedule *RefillSchedule) Insert() error {
+	schedule.CreatedTime = common.GetTimestamp()
+	schedule.NextRunAt = schedule.nextRunAfter(time.Now()).Unix()
+	return DB.Create(schedule).Error
+}
+
+// Update 修改周期后重新计算下一次补充时间
+func (schedule *RefillSchedule) Update() error {
+	schedule.NextRunAt = schedule.nextRunAfter(time.Now()).Unix()
+	return DB.Model(schedule).Select("amount", "period", "day", "hour", "mode", "cap", "status", "next_run_at").Updates(schedule).Error
+}
+
+func DeleteRefillScheduleById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	return DB.Delete(&RefillSchedule{}, "id = ?", id).Error
+}
+
+// ApplyDueRefills 执行所有到期的补充，由 leader 定期调用
+func ApplyDueRefills() error {
+	var schedules []*RefillSchedule
+	err := DB.Where("status = ? and next_run_at <= ?", common.RefillScheduleStatusEnabled, common.GetTimestamp()).Find(&schedules).Error
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, schedule := range schedules {
+		err = applyRefill(schedule)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to apply refill schedule %d: %s", schedule.Id, err.Error()))
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// applyRefill 通过条件更新 next_run_at 认领本次补充，与额度变更、日志写入在同一事务中，
+// 同一时间点的补充只会执行一次；停机错过的多个周期只补一次
+func applyRefill(schedule *RefillSchedule) error {
+	now := time.Now()
+	scheduledAt := schedule.NextRunAt
+	nextRunAt := schedule.nextRunAfter(now).Unix()
+	var delta int
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&RefillSchedule{}).Where("id = ? and next_run_at = ?", schedule.Id, scheduledAt).
+			Updates(map[string]interface{}{"next_run_at": nextRunAt, "last_run_at": now.Unix()})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 已被其他节点执行
+			return nil
+		}
+		var err error
+		var before, after int
+		if schedule.TargetType == RefillTargetUser {
+			before, after, err = refillQuota(tx, &User{}, "quota", schedule)
+		} else {
+			before, after, err = refillQuota(tx, &Token{}, "remain_quota", schedule)
+		}
+		if err != nil {
+			return err
+		}
+		delta = after - before
+		ref := LedgerRef{Type: LedgerTypeRefill, RefType: LedgerRefRefill, RefId: schedule.Id}
+		if schedule.TargetType == RefillTargetUser {
+			if delta < 0 {
+				// 重置模式下调余额时同步从额度包扣减，避免额度包过期时再次扣除
+				err = drawQuotaLots(tx, schedule.TargetId, -delta)
+			} else {
+				err = createQuotaLot(tx, schedule.TargetId, delta, QuotaLotSourceRefill, schedule.Id, 0)
+			}
+			if err == nil {
+				err = recordLedger(tx, ref, userPosting(schedule.TargetId, delta))
+			}
+		} else {
+			err = recordLedger(tx, ref, tokenPosting(schedule.TargetId, delta))
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Create(refillLog(tx, schedule, before, after)).Error
+	})
+	if err != nil || delta == 0 {
+		return err
+	}
+	if schedule.TargetType == RefillTargetUser {
+		if err := CacheUpdateUserQuota(schedule.TargetId); err != nil {
+			common.SysError("failed to update user quota cache: " + err.Error())
+		}
+		if delta > 0 {
+			LogQuotaData(schedule.TargetId, GetUsernameById(schedule.TargetId), 0, LogTypeTopup, 0, "", delta, common.GetTimestamp())
+		}
+		return nil
+	}
+	token, err := GetTokenById(schedule.TargetId)
+	if err != nil {
+		return err
+	}
+	// 额度耗尽而停用的令牌在补充后恢复可用
+	if token.Status == common.TokenStatusExhausted && token.RemainQuota > 0 {
+		token.Status = common.TokenStatusEnabled
+		return token.SelectUpdate()
+	}
+	token.invalidateCache()
+	return nil
+}
+
+func refillQuota(tx *gorm.DB, model interface{}, column string, schedule *RefillSchedule) (before int, after int, err error) {
+	err = tx.Model(model).Where("id = ?", schedule.TargetId).Select(column).Find(&before).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	var value interface{}
+	switch {
+	case schedule.Mode == RefillModeReset:
+		value = schedule.Amount
+	case schedule.Cap > 0:
+		if before >= schedule.Cap {
+			return before, before, nil
+		}
+		value = gorm.Expr("CASE WHEN "+column+" + ? > ? THEN ? ELSE "+column+" + ? END", schedule.Amount, schedule.Cap, schedule.Cap, schedule.Amount)
+	default:
+		value = gorm.Expr(column+" + ?", schedule.Amount)
+	}
+	err = tx.Model(model).Where("id = ?", schedule.TargetId).Update(column, value).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	err = tx.Model(model).Where("id = ?", schedule.TargetId).Select(column).Find(&after).Error
+	return before, after, err
+}
+
+func refillLog(tx *gorm.DB, schedule *RefillSchedule, before int, after int) *Log {
+	log := &Log{CreatedAt: common.GetTimestamp()}
+	content := fmt.Sprintf("定期额度补充（%s），余额从 %s 变为 %s", refillModeName(schedule.Mode), common.LogQuota(before), common.LogQuota(after))
+	if schedule.TargetType == RefillTargetUser {
+		log.UserId = schedule.TargetId
+		log.Type = LogTypeTopup
+		log.Quota = after - before
+	} else {
+		// 令牌额度不计入用户余额，记为系统日志
+		var token Token
+		tx.Select("user_id", "name").First(&token, "id = ?", schedule.TargetId)
+		log.UserId = token.UserId
+		log.Type = LogTypeSystem
+		log.TokenName = token.Name
+		content = fmt.Sprintf("令牌「%s」%s", token.Name, content)
+	}
+	tx.Model(&User{}).Where("id = ?", log.UserId).Select("username").Find(&log.Username)
+	log.Multiplier = content
+	return log
+}
+
+func refillModeName(mode string) string {
+	if mode == RefillModeReset {
+		return "重置"
+	}
+	return "累加"
+}
+
+// PreviewRefills 返回截止时间前将要执行的补充，预计余额按当前余额逐次推算
+func PreviewRefills(until time.Time) ([]*RefillPreview, error) {
+	var schedules []*RefillSchedule
+	err := DB.Where("status = ? and next_run_at <= ?", common.RefillScheduleStatusEnabled, until.Unix()).Order("next_run_at asc").Find(&schedules).Error
+	if err != nil {
+		return nil, err
+	}
+	previews := make([]*RefillPreview, 0)
+	for _, schedule := range schedules {
+		var quota int
+		if schedule.TargetType == RefillTargetUser {
+			quota, _ = GetUserQuota(schedule.TargetId)
+		} else {
+			DB.Model(&Token{}).Where("id = ?", schedule.TargetId).Select("remain_quota").Find(&quota)
+		}
+		runAt := time.Unix(schedule.NextRunAt, 0)
+		for !runAt.After(until) {
+			after := quota + schedule.Amount
+			if schedule.Mode == RefillModeReset {
+				after = schedule.Amount
+			} else if schedule.Cap > 0 && after > schedule.Cap {
+				after = common.Max(quota, schedule.Cap)
+			}
+			previews = append(previews, &RefillPreview{
+				ScheduleId:   schedule.Id,
+				TargetType:   schedule.TargetType,
+				TargetId:     schedule.TargetId,
+				RunAt:        runAt.Unix(),
+				Mode:         schedule.Mode,
+				Amount:       schedule.Amount,
+				CurrentQuota: quota,
+				QuotaAfter:   after,
+			})
+			quota = after
+			runAt = schedule.nextRunAfter(runAt)
+		}
+	}
+	sort.Slice(previews, func(i, j int) bool {
+		return previews[i].RunAt < previews[j].RunAt
+	})
+	return previews, nil
+}
@@ -0,0 +1,246 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"os"
+	"time"
+)
+
+// UserSessionTTL 会话有效期，与 cookie 的默认有效期一致，每次访问后顺延
+const UserSessionTTL = 30 * 24 * 3600
+
+// UserSession 服务端保存的登录会话，cookie 中只保存会话标识，Id 为标识的哈希
+type UserSession struct {
+	Id           string `json:"id" gorm:"type:char(64);primaryKey"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	Ip           string `json:"ip" gorm:"type:varchar(64)"`
+	UserAgent    string `json:"user_agent" gorm:"type:varchar(255)"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+	LastSeenTime int64  `json:"last_seen_time" gorm:"bigint"`
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint;index"`
+	Current      bool   `json:"current" gorm:"-"`
+}
+
+type sessionStore interface {
+	save(s *UserSession) error
+	get(id string) (*UserSession, error)
+	list(userId int) ([]*UserSession, error)
+	delete(userId int, ids ...string) error
+}
+
+var userSessionStore sessionStore = dbSessionStore{}
+
+// InitSessionStore 启用 Redis 时默认把会话保存在 Redis，SESSION_STORE=db 时保存在数据库
+func InitSessionStore() {
+	if common.RedisEnabled && os.Getenv("SESSION_STORE") != "db" {
+		userSessionStore = redisSessionStore{}
+		common.SysLog("session store: redis")
+		return
+	}
+	userSessionStore = dbSessionStore{}
+	common.SysLog("session store: database")
+}
+
+type dbSessionStore struct{}
+
+func (dbSessionStore) save(s *UserSession) error {
+	return DB.Save(s).Error
+}
+
+func (dbSessionStore) get(id string) (*UserSession, error) {
+	s := UserSession{}
+	err := DB.First(&s, "id = ?", id).Error
+	return &s, err
+}
+
+func (dbSessionStore) list(userId int) (sessions []*UserSession, err error) {
+	err = DB.Where("user_id = ? and expired_time > ?", userId, common.GetTimestamp()).Order("last_seen_time desc").Find(&sessions).Error
+	return sessions, err
+}
+
+func (dbSessionStore) delete(userId int, ids ...string) error {
+	tx := DB.Where("user_id = ?", userId)
+	if len(ids) > 0 {
+		tx = tx.Where("id in ?", ids)
+	}
+	return tx.Delete(&UserSession{}).Error
+}
+
+// redisSessionStore 每个会话一个 key，另用集合记录用户的全部会话
+type redisSessionStore struct{}
+
+func redisSessionKey(id string) string {
+	return "user_session:" + id
+}
+
+func redisUserSessionsKey(userId int) string {
+	return fmt.Sprintf("user_sessions:%d", userId)
+}
+
+func (redisSessionStore) save(s *UserSession) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(s.ExpiredTime-common.GetTimestamp()) * time.Second
+	ctx := context.Background()
+	pipe := common.RDB.TxPipeline()
+	pipe.Set(ctx, redisSessionKey(s.Id), string(data), ttl)
+	pipe.SAdd(ctx, redisUserSessionsKey(s.UserId), s.Id)
+	pipe.Expire(ctx, redisUserSessionsKey(s.UserId), ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (redisSessionStore) get(id string) (*UserSession, error) {
+	data, err := common.RedisGet(redisSessionKey(id))
+	if err != nil {
+		return nil, err
+	}
+	s := UserSession{}
+	err = json.Unmarshal([]byte(data), &s)
+	return &s, err
+}
+
+func (store redisSessionStore) list(userId int) ([]*UserSession, error) {
+	ctx := context.Background()
+	ids, err := common.RDB.SMembers(ctx, redisUserSessionsKey(userId)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*UserSession, 0, len(ids))
+	for _, id := range ids {
+		s, err := store.get(id)
+		if err != nil {
+			// 已过期的会话顺便从集合中移除
+			common.RDB.SRem(ctx, redisUserSessionsKey(userId), id)
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+func (store redisSessionStore) delete(userId int, ids ...string) error {
+	ctx := context.Background()
+	if len(ids) == 0 {
+		var err error
+		ids, err = common.RDB.SMembers(ctx, redisUserSessionsKey(userId)).Result()
+		if err != nil {
+			return err
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(ids))
+	members := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, redisSessionKey(id))
+		members = append(members, id)
+	}
+	pipe := common.RDB.TxPipeline()
+	pipe.Del(ctx, keys...)
+	pipe.SRem(ctx, redisUserSessionsKey(userId), members...)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// UserSessionId 由 cookie 中的会话标识得到会话 Id
+func UserSessionId(sid string) string {
+	return hashAccessKey(sid)
+}
+
+// CreateUserSession 登录成功后创建会话，返回写入 cookie 的会话标识
+func CreateUserSession(userId int, ip string, userAgent string) (string, error) {
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	sid := common.GetUUID()
+	now := common.GetTimestamp()
+	err := userSessionStore.save(&UserSession{
+		Id:           UserSessionId(sid),
+		UserId:       userId,
+		Ip:           ip,
+		UserAgent:    userAgent,
+		CreatedTime:  now,
+		LastSeenTime: now,
+		ExpiredTime:  now + UserSessionTTL,
+	})
+	if err != nil {
+		return "", err
+	}
+	return sid, nil
+}
+
+// ValidateUserSession 校验会话是否仍然有效并返回会话所属用户，同时顺延有效期、记录最近访问的 IP
+func ValidateUserSession(sid string, ip string) (int, bool) {
+	if sid == "" {
+		return 0, false
+	}
+	s, err := userSessionStore.get(UserSessionId(sid))
+	now := common.GetTimestamp()
+	if err != nil || s.ExpiredTime < now {
+		return 0, false
+	}
+	// 一分钟内重复访问不再写入
+	if now-s.LastSeenTime >= 60 || s.Ip != ip {
+		s.LastSeenTime = now
+		s.Ip = ip
+		s.ExpiredTime = now + UserSessionTTL
+		if err := userSessionStore.save(s); err != nil {
+			common.SysError("failed to update user session: " + err.Error())
+		}
+	}
+	return s.UserId, true
+}
+
+func GetUserSessions(userId int) ([]*UserSession, error) {
+	return userSessionStore.list(userId)
+}
+
+func RevokeUserSession(userId int, id string) error {
+	if id == "" {
+		return errors.New("id 为空！")
+	}
+	return userSessionStore.delete(userId, id)
+}
+
+// RevokeUserSessionBySid 按 cookie 中的会话标识吊销会话，用于退出登录
+func RevokeUserSessionBySid(sid string) error {
+	s, err := userSessionStore.get(UserSessionId(sid))
+	if err != nil {
+		return err
+	}
+	return userSessionStore.delete(s.UserId, s.Id)
+}
+
+// RevokeUserSessions 吊销用户的全部会话，exceptId 非空时保留该会话
+func RevokeUserSessions(userId int, exceptId string) error {
+	if exceptId == "" {
+		return userSessionStore.delete(userId)
+	}
+	sessions, err := userSessionStore.list(userId)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(sessions))
+	for _, s := range sessions {
+		if s.Id != exceptId {
+			ids = append(ids, s.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return userSessionStore.delete(userId, ids...)
+}
+
+// CleanExpiredUserSessions 清理数据库中已过期的会话，Redis 中的会话自动过期
+func CleanExpiredUserSessions() error {
+	return DB.Where("expired_time < ?", common.GetTimestamp()).Delete(&UserSession{}).Error
+}
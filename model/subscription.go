@@ -0,0 +1,597 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SubscriptionStatusActive   = "active"
+	SubscriptionStatusExpired  = "expired"
+	SubscriptionStatusReplaced = "replaced" // 购买其他套餐后被替换
+	SubscriptionStatusRefunded = "refunded" // 当前周期的订单已退款
+)
+
+// SubscriptionPlan 订阅套餐：每个周期发放 Quota 额度（周期结束时过期），订阅期间切换到 Group 分组，
+// ModelAllowances 限制每个周期内单个模型可消耗的额度
+type SubscriptionPlan struct {
+	Id              int     `json:"id"`
+	Name            string  `json:"name" gorm:"type:varchar(64)"`
+	Description     string  `json:"description"`
+	Status          int     `json:"status" gorm:"default:1"`
+	Price           float64 `json:"price"`
+	PeriodDays      int     `json:"period_days"`
+	Quota           int     `json:"quota"`
+	Group           string  `json:"group" gorm:"column:plan_group;type:varchar(32)"`
+	ModelAllowances string  `json:"model_allowances" gorm:"type:text"` // JSON 格式，如 [{"model":"gpt-4","limit":500000}]
+	CreatedTime     int64   `json:"created_time" gorm:"bigint"`
+}
+
+// ModelAllowance 套餐每个周期内某个模型可消耗的额度
+type ModelAllowance struct {
+	Model string `json:"model"`
+	Limit int    `json:"limit"`
+}
+
+// Subscription 用户订阅，已预付到 ExpiresAt，PeriodStart、PeriodEnd 为当前计费周期
+type Subscription struct {
+	Id          int               `json:"id"`
+	UserId      int               `json:"user_id" gorm:"index"`
+	PlanId      int               `json:"plan_id" gorm:"index"`
+	Status      string            `json:"status" gorm:"type:varchar(16);index"`
+	Cancelled   bool              `json:"cancelled"` // 用户取消续费，到期后不再提醒
+	Reminded    bool              `json:"reminded"`  // 本次到期前已发送续费提醒
+	PeriodStart int64             `json:"period_start" gorm:"bigint"`
+	PeriodEnd   int64             `json:"period_end" gorm:"bigint;index"`
+	ExpiresAt   int64             `json:"expires_at" gorm:"bigint;index"`
+	CreatedTime int64             `json:"created_time" gorm:"bigint"`
+	Plan        *SubscriptionPlan `json:"plan,omitempty" gorm:"-:all"`
+}
+
+// SubscriptionUsage 订阅在当前周期内各模型的用量，进入新周期时重置
+type SubscriptionUsage struct {
+	Id             int    `json:"-"`
+	SubscriptionId int    `json:"-" gorm:"uniqueIndex:idx_subscription_usage"`
+	Model          string `json:"model" gorm:"type:varchar(255);uniqueIndex:idx_subscription_usage"`
+	PeriodStart    int64  `json:"period_start" gorm:"bigint"`
+	Used           int    `json:"used" gorm:"default:0"`
+	Limit          int    `json:"limit" gorm:"-"`
+}
+
+func ParseModelAllowances(allowances string) ([]ModelAllowance, error) {
+	if allowances == "" {
+		return nil, nil
+	}
+	var result []ModelAllowance
+	err := json.Unmarshal([]byte(allowances), &result)
+	return result, err
+}
+
+// Validate 检查套餐配置是否合法
+func (plan *SubscriptionPlan) Validate() error {
+	if plan.Name == "" || len(plan.Name) > 64 {
+		return errors.New("套餐名称长度必须在1-64之间")
+	}
+	if plan.Price <= 0 {
+		return errors.New("套餐价格必须大于 0")
+	}
+	if plan.PeriodDays <= 0 {
+		return errors.New("套餐周期天数必须大于 0")
+	}
+	if plan.Quota < 0 {
+		return errors.New("套餐额度不能为负数")
+	}
+	if plan.Group != "" {
+		if _, ok := common.GroupRatio[plan.Group]; !ok {
+			return errors.New("分组不存在")
+		}
+	}
+	allowances, err := ParseModelAllowances(plan.ModelAllowances)
+	if err != nil {
+		return errors.New("模型额度格式错误")
+	}
+	seen := make(map[string]bool)
+	for _, allowance := range allowances {
+		if allowance.Model == "" || allowance.Limit <= 0 {
+			return errors.New("模型额度必须指定模型且额度大于 0")
+		}
+		if seen[allowance.Model] {
+			return errors.New("同一模型只能设置一个额度")
+		}
+		seen[allowance.Model] = true
+	}
+	return nil
+}
+
+func (plan *SubscriptionPlan) GetModelAllowances() []ModelAllowance {
+	allowances, err := ParseModelAllowances(plan.ModelAllowances)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to parse model allowances of plan %d: %s", plan.Id, err.Error()))
+		return nil
+	}
+	return allowances
+}
+
+func GetAllPlans(enabledOnly bool) (plans []*SubscriptionPlan, err error) {
+	query := DB.Order("price asc, id asc")
+	if enabledOnly {
+		query = query.Where("status = ?", common.PlanStatusEnabled)
+	}
+	err = query.Find(&plans).Error
+	return plans, err
+}
+
+func GetPlanById(id int) (*SubscriptionPlan, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	plan := SubscriptionPlan{}
+	err := DB.First(&plan, "id = ?", id).Error
+	return &plan, err
+}
+
+func (plan *SubscriptionPlan) Insert() error {
+	return DB.Create(plan).Error
+}
+
+func (plan *SubscriptionPlan) Update() error {
+	return DB.Model(plan).Select("name", "description", "status", "price", "period_days", "quota", "plan_group", "model_allowances").Updates(plan).Error
+}
+
+// DeletePlanById 仍有生效中的订阅时不允许删除，可先停用套餐
+func DeletePlanById(id int) error {
+	var count int64
+	err := DB.Model(&Subscription{}).Where("plan_id = ? and status = ?", id, SubscriptionStatusActive).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("该套餐仍有生效中的订阅，请先停用套餐")
+	}
+	return DB.Delete(&SubscriptionPlan{}, "id = ?", id).Error
+}
+
+func getActiveSubscription(tx *gorm.DB, userId int) (*Subscription, error) {
+	var subscription Subscription
+	err := tx.Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).Order("id desc").Limit(1).Find(&subscription).Error
+	if err != nil || subscription.Id == 0 {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// GetUserSubscription 返回用户生效中的订阅及其套餐，没有时返回 nil
+func GetUserSubscription(userId int) (*Subscription, error) {
+	subscription, err := getActiveSubscription(DB, userId)
+	if err != nil || subscription == nil {
+		return nil, err
+	}
+	subscription.Plan, err = GetPlanById(subscription.PlanId)
+	if err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func subscriptionCacheKey(userId int) string {
+	return fmt.Sprintf("user_subscription:%d", userId)
+}
+
+// CacheGetUserSubscription 转发请求时检查模型额度用，没有订阅时缓存空对象
+func CacheGetUserSubscription(userId int) (*Subscription, error) {
+	if !common.RedisEnabled {
+		return GetUserSubscription(userId)
+	}
+	subscription := &Subscription{}
+	value, err := common.RedisGet(subscriptionCacheKey(userId))
+	if err == nil && json.Unmarshal([]byte(value), subscription) == nil {
+		if subscription.Id == 0 {
+			return nil, nil
+		}
+		return subscription, nil
+	}
+	subscription, err = GetUserSubscription(userId)
+	if err != nil {
+		return nil, err
+	}
+	cached := subscription
+	if cached == nil {
+		cached = &Subscription{}
+	}
+	jsonBytes, _ := json.Marshal(cached)
+	err = common.RedisSet(subscriptionCacheKey(userId), string(jsonBytes), time.Duration(UserId2GroupCacheSeconds)*time.Second)
+	if err != nil {
+		common.SysError("Redis set user subscription error: " + err.Error())
+	}
+	return subscription, nil
+}
+
+func cacheDeleteUserSubscription(userId int) {
+	if !common.RedisEnabled {
+		return
+	}
+	err := common.RedisDel(subscriptionCacheKey(userId))
+	if err != nil {
+		common.SysError("Redis delete user subscription error: " + err.Error())
+	}
+}
+
+// activateSubscription 订阅订单支付成功：同一套餐顺延到期时间，否则替换原订阅并立即开始新周期，返回本次发放的额度
+func activateSubscription(tx *gorm.DB, userId int, plan *SubscriptionPlan) (*Subscription, int, error) {
+	now := common.GetTimestamp()
+	period := int64(plan.PeriodDays) * 24 * 60 * 60
+	active, err := getActiveSubscription(tx, userId)
+	if err != nil {
+		return nil, 0, err
+	}
+	if active != nil && active.PlanId == plan.Id {
+		active.ExpiresAt += period
+		active.Reminded = false
+		active.Cancelled = false
+		err = tx.Model(&Subscription{}).Where("id = ?", active.Id).
+			Updates(map[string]interface{}{"expires_at": active.ExpiresAt, "reminded": false, "cancelled": false}).Error
+		return active, 0, err
+	}
+	if active != nil {
+		err = tx.Model(&Subscription{}).Where("id = ?", active.Id).Update("status", SubscriptionStatusReplaced).Error
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	subscription := &Subscription{
+		UserId:      userId,
+		PlanId:      plan.Id,
+		Status:      SubscriptionStatusActive,
+		PeriodStart: now,
+		PeriodEnd:   now + period,
+		ExpiresAt:   now + period,
+		CreatedTime: now,
+	}
+	err = tx.Create(subscription).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	if plan.Group != "" {
+		err = tx.Model(&User{}).Where("id = ?", userId).Update("group", plan.Group).Error
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if plan.Quota > 0 {
+		err = grantUserQuota(tx, userId, plan.Quota, QuotaLotSourceSubscription, subscription.Id, subscription.PeriodEnd)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return subscription, plan.Quota, nil
+}
+
+// completeSubscriptionTopUp 订阅订单支付成功，开通或续费套餐
+func completeSubscriptionTopUp(topUp *TopUp, providerTradeNo string, paidMoney float64) (bool, error) {
+	plan, err := GetPlanById(topUp.PlanId)
+	if err != nil {
+		return false, err
+	}
+	completed := false
+	var subscription *Subscription
+	quota := 0
+	err = DB.Transaction(func(tx *gorm.DB) (err error) {
+		completed, err = markTopUpPaid(tx, topUp, providerTradeNo, paidMoney)
+		if err != nil || !completed {
+			return err
+		}
+		subscription, quota, err = activateSubscription(tx, topUp.UserId, plan)
+		return err
+	})
+	if err != nil || !completed {
+		return false, err
+	}
+	cacheDeleteUserSubscription(topUp.UserId)
+	CacheDeleteUserGroup(topUp.UserId)
+	if err := CacheUpdateUserQuota(topUp.UserId); err != nil {
+		common.SysError("failed to update user quota cache: " + err.Error())
+	}
+	content := fmt.Sprintf("订阅套餐「%s」开通成功，发放额度 %s，支付金额：%.2f", plan.Name, common.LogQuota(quota), topUp.Money)
+	if quota == 0 {
+		content = fmt.Sprintf("订阅套餐「%s」续费成功，到期时间 %s，支付金额：%.2f", plan.Name,
+			time.Unix(subscription.ExpiresAt, 0).Format("2006-01-02 15:04:05"), topUp.Money)
+	}
+	RecordLog(topUp.UserId, LogTypeTopup, quota, content)
+	_ = VipInsert(topUp.UserId, plan.Quota, LedgerRefTopup, topUp.Id)
+	return true, nil
+}
+
+// SetSubscriptionCancelled 用户取消或恢复续费，取消后订阅仍生效到已付费的到期时间
+func SetSubscriptionCancelled(userId int, cancelled bool) error {
+	result := DB.Model(&Subscription{}).Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).Update("cancelled", cancelled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("当前没有生效中的订阅")
+	}
+	return nil
+}
+
+// RenewSubscriptions 进入新周期的订阅发放当期额度，到期的订阅降回 UserGroup 分组，即将到期的订阅提醒续费
+func RenewSubscriptions() error {
+	now := common.GetTimestamp()
+	var subscriptions []*Subscription
+	err := DB.Where("status = ? and period_end <= ?", SubscriptionStatusActive, now).Limit(1000).Find(&subscriptions).Error
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		if subscription.ExpiresAt > subscription.PeriodEnd {
+			err = startSubscriptionPeriod(subscription)
+		} else {
+			err = expireSubscription(subscription)
+		}
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to renew subscription %d: %s", subscription.Id, err.Error()))
+		}
+	}
+	subscriptions = nil
+	remindBefore := now + int64(common.SubscriptionRemindDays)*24*60*60
+	err = DB.Where("status = ? and cancelled = ? and reminded = ? and expires_at <= ?", SubscriptionStatusActive, false, false, remindBefore).
+		Limit(1000).Find(&subscriptions).Error
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		result := DB.Model(&Subscription{}).Where("id = ? and reminded = ?", subscription.Id, false).Update("reminded", true)
+		if result.Error == nil && result.RowsAffected == 1 {
+			notifySubscription(subscription, "订阅即将到期", fmt.Sprintf("您的订阅将于 %s 到期，到期后将恢复为普通分组，请及时续费。",
+				time.Unix(subscription.ExpiresAt, 0).Format("2006-01-02 15:04:05")))
+		}
+	}
+	return nil
+}
+
+// startSubscriptionPeriod 已续费的订阅进入下一个周期并发放当期额度
+func startSubscriptionPeriod(subscription *Subscription) error {
+	plan, err := GetPlanById(subscription.PlanId)
+	if err != nil {
+		return err
+	}
+	periodEnd := subscription.PeriodEnd + int64(plan.PeriodDays)*24*60*60
+	if periodEnd > subscription.ExpiresAt {
+		periodEnd = subscription.ExpiresAt
+	}
+	started := false
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Subscription{}).Where("id = ? and period_end = ?", subscription.Id, subscription.PeriodEnd).
+			Updates(map[string]interface{}{"period_start": subscription.PeriodEnd, "period_end": periodEnd})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		started = true
+		if plan.Quota <= 0 {
+			return nil
+		}
+		return grantUserQuota(tx, subscription.UserId, plan.Quota, QuotaLotSourceSubscription, subscription.Id, periodEnd)
+	})
+	if err != nil || !started {
+		return err
+	}
+	cacheDeleteUserSubscription(subscription.UserId)
+	if err := CacheUpdateUserQuota(subscription.UserId); err != nil {
+		common.SysError("failed to update user quota cache: " + err.Error())
+	}
+	RecordLog(subscription.UserId, LogTypeTopup, plan.Quota, fmt.Sprintf("订阅套餐「%s」进入新周期，发放额度 %s", plan.Name, common.LogQuota(plan.Quota)))
+	return nil
+}
+
+// expireSubscription 订阅到期，用户仍在套餐分组时降回 UserGroup 分组
+func expireSubscription(subscription *Subscription) error {
+	plan, err := GetPlanById(subscription.PlanId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	expired := false
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Subscription{}).Where("id = ? and status = ? and expires_at <= period_end", subscription.Id, SubscriptionStatusActive).
+			Update("status", SubscriptionStatusExpired)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		expired = true
+		return downgradeSubscriptionGroup(tx, subscription.UserId, plan)
+	})
+	if err != nil || !expired {
+		return err
+	}
+	cacheDeleteUserSubscription(subscription.UserId)
+	CacheDeleteUserGroup(subscription.UserId)
+	planName := strconv.Itoa(subscription.PlanId)
+	if plan != nil {
+		planName = plan.Name
+	}
+	RecordLog(subscription.UserId, LogTypeSystem, 0, fmt.Sprintf("订阅套餐「%s」已到期", planName))
+	notifySubscription(subscription, "订阅已到期", fmt.Sprintf("您的订阅套餐「%s」已到期，已恢复为普通分组。", planName))
+	return nil
+}
+
+// downgradeSubscriptionGroup 用户仍在套餐分组时降回 UserGroup 分组
+func downgradeSubscriptionGroup(tx *gorm.DB, userId int, plan *SubscriptionPlan) error {
+	if plan == nil || plan.Group == "" {
+		return nil
+	}
+	userGroup := common.OptionMap["UserGroup"]
+	if userGroup == "" {
+		userGroup = "default"
+	}
+	groupCol := "`group`"
+	if common.UsingPostgreSQL {
+		groupCol = `"group"`
+	}
+	return tx.Model(&User{}).Where("id = ? and "+groupCol+" = ?", userId, plan.Group).Update("group", userGroup).Error
+}
+
+// rollbackSubscriptionTopUp 订阅订单全额退款：订单是尚未开始的续费时缩短到期时间，否则终止当前订阅并降回 UserGroup 分组，
+// 返回该订单的额度是否已发放
+func rollbackSubscriptionTopUp(tx *gorm.DB, topUp *TopUp) (bool, error) {
+	plan, err := GetPlanById(topUp.PlanId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	active, err := getActiveSubscription(tx, topUp.UserId)
+	if err != nil {
+		return false, err
+	}
+	if active == nil || active.PlanId != topUp.PlanId {
+		// 订阅已到期或已被其他套餐替换
+		return true, nil
+	}
+	if plan != nil {
+		period := int64(plan.PeriodDays) * 24 * 60 * 60
+		if active.ExpiresAt-period >= active.PeriodEnd {
+			result := tx.Model(&Subscription{}).Where("id = ? and expires_at = ?", active.Id, active.ExpiresAt).
+				Update("expires_at", active.ExpiresAt-period)
+			if result.Error != nil {
+				return false, result.Error
+			}
+			if result.RowsAffected == 1 {
+				return false, nil
+			}
+		}
+	}
+	result := tx.Model(&Subscription{}).Where("id = ? and status = ?", active.Id, SubscriptionStatusActive).
+		Update("status", SubscriptionStatusRefunded)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return true, result.Error
+	}
+	return true, downgradeSubscriptionGroup(tx, topUp.UserId, plan)
+}
+
+func notifySubscription(subscription *Subscription, subject string, content string) {
+	email, err := GetUserEmail(subscription.UserId)
+	if err != nil {
+		common.SysError("failed to fetch user email: " + err.Error())
+		return
+	}
+	if email == "" {
+		return
+	}
+	link := fmt.Sprintf("%s/topup", common.ServerAddress)
+	err = common.SendEmail(subject, email, fmt.Sprintf("%s<br/>续费链接：<a href='%s'>%s</a>", content, link, link))
+	if err != nil {
+		common.SysError("failed to send email: " + err.Error())
+	}
+}
+
+func subscriptionUsageKey(subscription *Subscription, modelName string) string {
+	return fmt.Sprintf("subscription_usage:%d:%s:%d", subscription.Id, modelName, subscription.PeriodStart)
+}
+
+func getSubscriptionUsed(subscription *Subscription, modelName string) (int, error) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(subscriptionUsageKey(subscription, modelName))
+		if err != nil {
+			// key 不存在说明当前周期尚无用量
+			return 0, nil
+		}
+		return strconv.Atoi(value)
+	}
+	var usage SubscriptionUsage
+	err := DB.Where("subscription_id = ? and model = ?", subscription.Id, modelName).Limit(1).Find(&usage).Error
+	if err != nil || usage.PeriodStart != subscription.PeriodStart {
+		return 0, err
+	}
+	return usage.Used, nil
+}
+
+func addSubscriptionUsage(subscription *Subscription, modelName string, quota int) error {
+	if common.RedisEnabled {
+		ttl := time.Duration(subscription.PeriodEnd-common.GetTimestamp())*time.Second + time.Hour
+		_, err := common.RedisIncrBy(subscriptionUsageKey(subscription, modelName), int64(quota), ttl)
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		result := DB.Model(&SubscriptionUsage{}).Where("subscription_id = ? and model = ? and period_start = ?", subscription.Id, modelName, subscription.PeriodStart).
+			Update("used", gorm.Expr("used + ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 进入新周期，重置用量
+			result = DB.Model(&SubscriptionUsage{}).Where("subscription_id = ? and model = ? and period_start < ?", subscription.Id, modelName, subscription.PeriodStart).
+				Updates(map[string]interface{}{"period_start": subscription.PeriodStart, "used": quota})
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+		if result.RowsAffected == 0 {
+			err := DB.Create(&SubscriptionUsage{SubscriptionId: subscription.Id, Model: modelName, PeriodStart: subscription.PeriodStart, Used: quota}).Error
+			if err != nil {
+				// 并发创建时唯一索引冲突，重试一次累加
+				continue
+			}
+		}
+		return nil
+	}
+	return errors.New("failed to record subscription usage")
+}
+
+// CheckSubscriptionAllowance 检查本次请求是否会超出订阅套餐对该模型的周期额度
+func CheckSubscriptionAllowance(userId int, modelName string, quota int) error {
+	subscription, err := CacheGetUserSubscription(userId)
+	if err != nil || subscription == nil || subscription.Plan == nil {
+		return err
+	}
+	for _, allowance := range subscription.Plan.GetModelAllowances() {
+		if allowance.Model != modelName {
+			continue
+		}
+		used, err := getSubscriptionUsed(subscription, modelName)
+		if err != nil {
+			return err
+		}
+		if used >= allowance.Limit || used+quota > allowance.Limit {
+			return fmt.Errorf("订阅套餐本周期对模型 %s 的额度已用尽", modelName)
+		}
+	}
+	return nil
+}
+
+// RecordSubscriptionUsage 按实际扣费累加订阅套餐的模型周期用量，只记录设置了额度的模型
+func RecordSubscriptionUsage(userId int, modelName string, quota int) {
+	if quota <= 0 {
+		return
+	}
+	subscription, err := CacheGetUserSubscription(userId)
+	if err != nil || subscription == nil || subscription.Plan == nil {
+		return
+	}
+	for _, allowance := range subscription.Plan.GetModelAllowances() {
+		if allowance.Model == modelName {
+			err = addSubscriptionUsage(subscription, modelName, quota)
+			if err != nil {
+				common.SysError("failed to record subscription usage: " + err.Error())
+			}
+			return
+		}
+	}
+}
+
+// GetSubscriptionUsages 返回订阅各模型额度在当前周期内的用量
+func GetSubscriptionUsages(subscription *Subscription) []*SubscriptionUsage {
+	if subscription.Plan == nil {
+		return nil
+	}
+	var usages []*SubscriptionUsage
+	for _, allowance := range subscription.Plan.GetModelAllowances() {
+		used, err := getSubscriptionUsed(subscription, allowance.Model)
+		if err != nil {
+			common.SysError("failed to get subscription usage: " + err.Error())
+		}
+		usages = append(usages, &SubscriptionUsage{Model: allowance.Model, PeriodStart: subscription.PeriodStart, Used: used, Limit: allowance.Limit})
+	}
+	return usages
+}
@@ -1,31 +1,41 @@
 package model
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"one-api/common"
 	"one-api/common/logger"
+	"one-api/common/tracing"
 	"strings"
 
 	"gorm.io/gorm"
 )
 
+// TokenKeyPrefixLength 保存的明文前缀长度，不含 "sk-"
+const TokenKeyPrefixLength = 8
+
 type Token struct {
-	Id             int    `json:"id"`
-	UserId         int    `json:"user_id"`
-	Key            string `json:"key" gorm:"type:char(48);uniqueIndex"`
-	Status         int    `json:"status" gorm:"default:1"`
-	Name           string `json:"name" gorm:"index" `
-	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
-	AccessedTime   int64  `json:"accessed_time" gorm:"bigint"`
-	ExpiredTime    int64  `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
-	RemainQuota    int    `json:"remain_quota" gorm:"default:0"`
-	UnlimitedQuota bool   `json:"unlimited_quota" gorm:"default:false"`
-	UsedQuota      int    `json:"used_quota" gorm:"default:0"`
-	Group          string `json:"group" gorm:"type:varchar(255);"` // 添加 group 字段
-	BillingEnabled bool   `json:"billing_enabled" gorm:"default:false"`
-	Models         string `json:"models"`
-	FixedContent   string `json:"fixed_content" gorm:"type:varchar(1000);"`
+	Id             int                 `json:"id"`
+	UserId         int                 `json:"user_id"`
+	Key            string              `json:"key,omitempty" gorm:"-"` // 完整令牌只在创建时返回一次，库中只保存哈希
+	KeyHash        string              `json:"-" gorm:"type:char(64);uniqueIndex"`
+	KeyPrefix      string              `json:"key_prefix" gorm:"type:varchar(16)"` // 令牌开头的几位，便于辨认和搜索
+	Status         int                 `json:"status" gorm:"default:1"`
+	Name           string              `json:"name" gorm:"index" `
+	CreatedTime    int64               `json:"created_time" gorm:"bigint"`
+	AccessedTime   int64               `json:"accessed_time" gorm:"bigint"`
+	ExpiredTime    int64               `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
+	RemainQuota    int                 `json:"remain_quota" gorm:"default:0"`
+	UnlimitedQuota bool                `json:"unlimited_quota" gorm:"default:false"`
+	UsedQuota      int                 `json:"used_quota" gorm:"default:0"`
+	Group          string              `json:"group" gorm:"type:varchar(255);"` // 添加 group 字段
+	BillingEnabled bool                `json:"billing_enabled" gorm:"default:false"`
+	Models         string              `json:"models"`
+	FixedContent   string              `json:"fixed_content" gorm:"type:varchar(1000);"`
+	Budgets        string              `json:"budgets" gorm:"type:text"`      // JSON array of TokenBudget
+	OrgId          int                 `json:"org_id" gorm:"default:0;index"` // 非 0 时从组织额度池扣费
+	BudgetUsage    []*TokenBudgetUsage `json:"budget_usage,omitempty" gorm:"-"`
 }
 
 func GetAllUserTokens(userId int, startIdx int, num int) ([]*Token, error) {
@@ -35,19 +45,24 @@ func GetAllUserTokens(userId int, startIdx int, num int) ([]*Token, error) {
 	return tokens, err
 }
 
+// SearchUserTokens token 为完整令牌时按哈希精确匹配，否则按前缀匹配
 func SearchUserTokens(userId int, keyword string, token string) (tokens []*Token, err error) {
-	if token != "" {
-		token = strings.Trim(token, "sk-")
+	tx := DB.Where("user_id = ?", userId).Where("name LIKE ?", "%"+keyword+"%")
+	token = strings.TrimPrefix(token, "sk-")
+	if len(token) > TokenKeyPrefixLength {
+		tx = tx.Where("key_hash = ?", hashAccessKey(token))
+	} else if token != "" {
+		tx = tx.Where("key_prefix LIKE ?", token+"%")
 	}
-	err = DB.Where("user_id = ?", userId).Where("name LIKE ?", "%"+keyword+"%").Where("`key` LIKE ?", "%"+token+"%").Find(&tokens).Error
+	err = tx.Find(&tokens).Error
 	return tokens, err
 }
 
-func ValidateUserToken(key string, model string) (token *Token, err error) {
+func ValidateUserToken(ctx context.Context, key string, model string) (token *Token, err error) {
 	if key == "" {
 		return nil, errors.New("未提供令牌")
 	}
-	token, err = CacheGetTokenByKey(key)
+	token, err = CacheGetTokenByKey(ctx, key)
 	if err == nil {
 		if token.Status == common.TokenStatusExhausted {
 			return nil, errors.New("该令牌额度已用尽")
@@ -110,45 +125,91 @@ func GetTokenByIds(id int, userId int) (*Token, error) {
 }
 
 func GetTokenById(id int) (*Token, error) {
+	return getTokenById(DB, id)
+}
+
+func getTokenById(db *gorm.DB, id int) (*Token, error) {
 	if id == 0 {
 		return nil, errors.New("id 为空！")
 	}
 	token := Token{Id: id}
 	var err error = nil
-	err = DB.First(&token, "id = ?", id).Error
+	err = db.First(&token, "id = ?", id).Error
 	return &token, err
 }
 
+// Insert 保存 Key 的哈希和前缀，Key 本身不入库
 func (token *Token) Insert() error {
-	var err error
-	err = DB.Create(token).Error
-	return err
+	if len(token.Key) < TokenKeyPrefixLength {
+		return errors.New("令牌格式错误")
+	}
+	token.KeyHash = hashAccessKey(token.Key)
+	token.KeyPrefix = token.Key[:TokenKeyPrefixLength]
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(token).Error
+		if err != nil {
+			return err
+		}
+		return recordLedger(tx, LedgerRef{Type: LedgerTypeAdjust, RefType: LedgerRefToken, RefId: token.Id}, tokenPosting(token.Id, token.RemainQuota))
+	})
 }
 
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (token *Token) Update() error {
-	var err error
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "group", "billing_enabled", "models", "fixed_content").Updates(token).Error
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var oldQuota int
+		err := tx.Model(&Token{}).Where("id = ?", token.Id).Select("remain_quota").Find(&oldQuota).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "group", "billing_enabled", "models", "fixed_content", "budgets").Updates(token).Error
+		if err != nil {
+			return err
+		}
+		return recordLedger(tx, LedgerRef{Type: LedgerTypeAdjust, RefType: LedgerRefToken, RefId: token.Id}, tokenPosting(token.Id, token.RemainQuota-oldQuota))
+	})
+	if err == nil {
+		token.invalidateCache()
+	}
 	return err
 }
 
 func (token *Token) UpdateTokenBilling() error {
-	return DB.Model(token).Select("BillingEnabled").Updates(map[string]interface{}{
+	err := DB.Model(token).Select("BillingEnabled").Updates(map[string]interface{}{
 		"BillingEnabled": token.BillingEnabled,
 	}).Error
+	if err == nil {
+		token.invalidateCache()
+	}
+	return err
 }
 
 func (token *Token) SelectUpdate() error {
 	// This can update zero values
-	return DB.Model(token).Select("accessed_time", "status").Updates(token).Error
+	err := DB.Model(token).Select("accessed_time", "status").Updates(token).Error
+	if err == nil {
+		token.invalidateCache()
+	}
+	return err
 }
 
 func (token *Token) Delete() error {
 	var err error
 	err = DB.Delete(token).Error
+	if err == nil {
+		token.invalidateCache()
+	}
 	return err
 }
 
+// invalidateCache 令牌变更后清除 Redis 中的令牌缓存，避免已禁用或删除的令牌继续可用
+func (token *Token) invalidateCache() {
+	if token.KeyHash == "" {
+		return
+	}
+	PublishCacheInvalidation(InvalidationTypeToken, token.KeyHash)
+}
+
 func DeleteTokenById(id int, userId int) (err error) {
 	// Why we need userId here? In case user want to delete other's token.
 	if id == 0 || userId == 0 {
@@ -162,86 +223,120 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
-func IncreaseTokenQuota(id int, quota int) (err error) {
+func IncreaseTokenQuota(id int, quota int, ref LedgerRef) (err error) {
 
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
+	return adjustTokenQuota(context.Background(), id, quota, ref)
+}
+
+// adjustTokenQuota 按 delta 增减令牌剩余额度，转发扣费时传入请求的 context
+func adjustTokenQuota(ctx context.Context, id int, delta int, ref LedgerRef) error {
 	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, quota)
+		addNewRecord(BatchUpdateTypeTokenQuota, id, delta)
 		return nil
 	}
-	return increaseTokenQuota(id, quota)
+	if delta < 0 {
+		return decreaseTokenQuota(ctx, id, -delta, ref)
+	}
+	return increaseTokenQuota(ctx, id, delta, ref)
+}
+
+func increaseTokenQuota(ctx context.Context, id int, quota int, ref LedgerRef) (err error) {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return changeTokenQuota(tx, id, quota, ref)
+	})
 }
 
-func increaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
+// changeTokenQuota 在 tx 中按 delta 增减令牌剩余额度并记账
+func changeTokenQuota(tx *gorm.DB, id int, delta int, ref LedgerRef) error {
+	err := tx.Model(&Token{}).Where("id = ?", id).Updates(
 		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota + ?", quota),
-			"used_quota":    gorm.Expr("used_quota - ?", quota),
+			"remain_quota":  gorm.Expr("remain_quota + ?", delta),
+			"used_quota":    gorm.Expr("used_quota - ?", delta),
 			"accessed_time": common.GetTimestamp(),
 		},
 	).Error
-	return err
+	if err != nil {
+		return err
+	}
+	return recordLedger(tx, ref, tokenPosting(id, delta))
 }
 
-func DecreaseTokenQuota(id int, quota int) (err error) {
+func DecreaseTokenQuota(id int, quota int, ref LedgerRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
-	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, -quota)
-		return nil
-	}
-	return decreaseTokenQuota(id, quota)
+	return adjustTokenQuota(context.Background(), id, -quota, ref)
 }
 
-func decreaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota - ?", quota),
-			"used_quota":    gorm.Expr("used_quota + ?", quota),
-			"accessed_time": common.GetTimestamp(),
-		},
-	).Error
-	return err
+func decreaseTokenQuota(ctx context.Context, id int, quota int, ref LedgerRef) (err error) {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return changeTokenQuota(tx, id, -quota, ref)
+	})
 }
 
-func PostConsumeTokenQuota(tokenId int, quota int) (err error) {
-	token, err := GetTokenById(tokenId)
-	if quota > 0 {
-		err = DecreaseUserQuota(token.UserId, quota)
-	} else {
-		err = IncreaseUserQuota(token.UserId, -quota)
+// consumeTokenQuota 在同一事务中扣减令牌和用户额度（quota 为负时退回），避免只扣了其中一方；ctx 应已脱离请求取消
+func consumeTokenQuota(ctx context.Context, token *Token, quota int, ref LedgerRef) error {
+	if common.BatchUpdateEnabled {
+		if !token.UnlimitedQuota {
+			addNewRecord(BatchUpdateTypeTokenQuota, token.Id, -quota)
+		}
+		addNewRecord(BatchUpdateTypeUserQuota, token.UserId, -quota)
+		return nil
 	}
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if !token.UnlimitedQuota {
+			err := changeTokenQuota(tx, token.Id, -quota, ref)
+			if err != nil {
+				return err
+			}
+		}
+		return changeUserQuota(tx, token.UserId, -quota, ref)
+	})
+}
+
+// PostConsumeTokenQuota 按实际用量补扣或退还预扣的额度，可能在请求结束后执行，不随请求取消
+func PostConsumeTokenQuota(ctx context.Context, tokenId int, quota int) (err error) {
+	ctx = tracing.DetachedContext(ctx)
+	token, err := getTokenById(DB.WithContext(ctx), tokenId)
 	if err != nil {
 		return err
 	}
-	if !token.UnlimitedQuota {
-		if quota > 0 {
-			err = DecreaseTokenQuota(tokenId, quota)
-		} else {
-			err = IncreaseTokenQuota(tokenId, -quota)
-		}
-		if err != nil {
-			return err
-		}
+	ref := LedgerRef{Type: LedgerTypeConsume, RefType: LedgerRefToken, RefId: tokenId}
+	if token.OrgId != 0 {
+		return consumeOrgTokenQuota(ctx, token, quota, ref)
 	}
-	return nil
+	return consumeTokenQuota(ctx, token, quota, ref)
 }
 
-func PreConsumeTokenQuota(tokenId int, quota int) (err error) {
+// PreConsumeTokenQuota 预扣令牌额度并检查令牌预算，quota 为 0 时（信任额度不预扣）只检查预算
+func PreConsumeTokenQuota(ctx context.Context, tokenId int, quota int, modelName string) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
-	token, err := GetTokenById(tokenId)
+	// 预扣与 PostConsumeTokenQuota 的结算成对出现，不随请求取消而中途放弃
+	ctx = tracing.DetachedContext(ctx)
+	token, err := getTokenById(DB.WithContext(ctx), tokenId)
 	if err != nil {
 		return err
 	}
 	if !token.UnlimitedQuota && token.RemainQuota < quota {
 		return errors.New("令牌额度不足")
 	}
-	userQuota, err := GetUserQuota(token.UserId)
+	err = checkTokenBudgets(token, modelName, quota)
+	if err != nil {
+		return err
+	}
+	if quota == 0 {
+		return nil
+	}
+	ref := LedgerRef{Type: LedgerTypeConsume, RefType: LedgerRefToken, RefId: tokenId}
+	if token.OrgId != 0 {
+		return consumeOrgTokenQuota(ctx, token, quota, ref)
+	}
+	userQuota, err := getUserQuota(DB.WithContext(ctx), token.UserId)
 	if err != nil {
 		return err
 	}
@@ -270,12 +365,84 @@ func PreConsumeTokenQuota(tokenId int, quota int) (err error) {
 			}
 		}()
 	}
-	if !token.UnlimitedQuota {
-		err = DecreaseTokenQuota(tokenId, quota)
+	return consumeTokenQuota(ctx, token, quota, ref)
+}
+
+// hasColumn 按查询结果的列名判断表中是否有该列。迁移器的 HasColumn 和 ColumnTypes 在 SQLite 上
+// 识别不出名为 key 的列，这里直接查询一次空结果取列名；绕过预编译语句缓存，避免表结构变化后拿到旧的列
+func hasColumn(db *gorm.DB, table string, column string) (bool, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return false, err
+	}
+	rows, err := sqlDB.QueryContext(db.Statement.Context, fmt.Sprintf("SELECT * FROM %s LIMIT 0", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+	for _, name := range columns {
+		if strings.EqualFold(name, column) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// migrateTokenKeys 为旧版明文保存的令牌生成哈希和前缀，全部完成后删除明文列，中途失败下次启动时继续
+func migrateTokenKeys(db *gorm.DB) error {
+	keyCol := "`key`"
+	if common.UsingPostgreSQL {
+		keyCol = `"key"`
+	}
+	legacy, err := hasColumn(db, "tokens", "key")
+	if err != nil || !legacy {
+		return err
+	}
+	common.SysLog("migrating token keys to hashes")
+	for {
+		var rows []struct {
+			Id  int
+			Key string
+		}
+		err := db.Table("tokens").Select("id, " + keyCol).Where("key_hash IS NULL or key_hash = ''").Limit(1000).Scan(&rows).Error
 		if err != nil {
 			return err
 		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			if row.Key == "" {
+				// 空令牌无法使用，写入随机哈希以满足唯一索引
+				row.Key = "invalid-" + common.GetUUID()
+			}
+			prefix := row.Key
+			if len(prefix) > TokenKeyPrefixLength {
+				prefix = prefix[:TokenKeyPrefixLength]
+			}
+			err = db.Table("tokens").Where("id = ?", row.Id).Updates(map[string]interface{}{
+				"key_hash":   hashAccessKey(row.Key),
+				"key_prefix": prefix,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
 	}
-	err = DecreaseUserQuota(token.UserId, quota)
-	return err
+	if db.Migrator().HasIndex(&Token{}, "idx_tokens_key") {
+		err := db.Migrator().DropIndex(&Token{}, "idx_tokens_key")
+		if err != nil {
+			return err
+		}
+	}
+	err = db.Migrator().DropColumn(&Token{}, "key")
+	if err != nil {
+		return err
+	}
+	// SQLite 删除列时会重建表，重新补齐索引
+	return db.AutoMigrate(&Token{})
 }
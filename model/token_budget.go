@@ -0,0 +1,267 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TokenBudgetPeriodDay   = "day"
+	TokenBudgetPeriodWeek  = "week"
+	TokenBudgetPeriodMonth = "month"
+)
+
+var tokenBudgetPeriodNames = map[string]string{
+	TokenBudgetPeriodDay:   "每日",
+	TokenBudgetPeriodWeek:  "每周",
+	TokenBudgetPeriodMonth: "每月",
+}
+
+// 用量首次达到这些百分比时通知用户
+var tokenBudgetThresholds = []int{50, 80, 100}
+
+// TokenBudget 令牌在自然日、周（周一开始）、月内的额度上限，Model 为空时对所有模型生效
+type TokenBudget struct {
+	Period string `json:"period"`
+	Model  string `json:"model"`
+	Limit  int    `json:"limit"`
+}
+
+// TokenBudgetUsage 令牌在当前窗口内的用量，未启用 Redis 时保存在数据库，每个令牌、周期、模型一行，进入新窗口时重置
+type TokenBudgetUsage struct {
+	Id          int    `json:"-"`
+	TokenId     int    `json:"-" gorm:"uniqueIndex:idx_token_budget_usage"`
+	Period      string `json:"period" gorm:"type:varchar(16);uniqueIndex:idx_token_budget_usage"`
+	Model       string `json:"model" gorm:"type:varchar(255);uniqueIndex:idx_token_budget_usage"`
+	WindowStart int64  `json:"window_start" gorm:"bigint"`
+	Used        int    `json:"used" gorm:"default:0"`
+	Limit       int    `json:"limit" gorm:"-"`
+	WindowEnd   int64  `json:"window_end" gorm:"-"`
+}
+
+func ParseTokenBudgets(budgets string) ([]TokenBudget, error) {
+	if budgets == "" {
+		return nil, nil
+	}
+	var result []TokenBudget
+	err := json.Unmarshal([]byte(budgets), &result)
+	return result, err
+}
+
+func ValidateTokenBudgets(budgets string) error {
+	parsed, err := ParseTokenBudgets(budgets)
+	if err != nil {
+		return errors.New("令牌预算格式错误")
+	}
+	seen := make(map[string]bool)
+	for _, budget := range parsed {
+		if _, ok := tokenBudgetPeriodNames[budget.Period]; !ok {
+			return fmt.Errorf("不支持的预算周期：%s", budget.Period)
+		}
+		if budget.Limit <= 0 {
+			return errors.New("预算额度必须大于 0")
+		}
+		key := budget.Period + ":" + budget.Model
+		if seen[key] {
+			return errors.New("同一周期和模型只能设置一个预算")
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+func (token *Token) GetBudgets() []TokenBudget {
+	budgets, err := ParseTokenBudgets(token.Budgets)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to parse budgets of token %d: %s", token.Id, err.Error()))
+		return nil
+	}
+	return budgets
+}
+
+func (budget *TokenBudget) matches(modelName string) bool {
+	return budget.Model == "" || budget.Model == modelName
+}
+
+func tokenBudgetWindow(period string, now time.Time) (start time.Time, end time.Time) {
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	switch period {
+	case TokenBudgetPeriodWeek:
+		start = today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	case TokenBudgetPeriodMonth:
+		start = time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+	return today, today.AddDate(0, 0, 1)
+}
+
+func tokenBudgetKey(tokenId int, budget *TokenBudget, windowStart int64) string {
+	return fmt.Sprintf("token_budget:%d:%s:%s:%d", tokenId, budget.Period, budget.Model, windowStart)
+}
+
+func getTokenBudgetUsed(tokenId int, budget *TokenBudget, windowStart int64) (int, error) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(tokenBudgetKey(tokenId, budget, windowStart))
+		if err != nil {
+			// key 不存在说明当前窗口尚无用量
+			return 0, nil
+		}
+		return strconv.Atoi(value)
+	}
+	var usage TokenBudgetUsage
+	err := DB.Where(&TokenBudgetUsage{TokenId: tokenId, Period: budget.Period}).Where("model = ?", budget.Model).Limit(1).Find(&usage).Error
+	if err != nil || usage.WindowStart != windowStart {
+		return 0, err
+	}
+	return usage.Used, nil
+}
+
+// addTokenBudgetUsage 累加窗口用量，返回累加前后的用量
+func addTokenBudgetUsage(tokenId int, budget *TokenBudget, windowStart int64, windowEnd int64, quota int) (before int, after int, err error) {
+	if common.RedisEnabled {
+		ttl := time.Duration(windowEnd-common.GetTimestamp())*time.Second + time.Hour
+		value, err := common.RedisIncrBy(tokenBudgetKey(tokenId, budget, windowStart), int64(quota), ttl)
+		if err != nil {
+			return 0, 0, err
+		}
+		return int(value) - quota, int(value), nil
+	}
+	for i := 0; i < 2; i++ {
+		query := DB.Model(&TokenBudgetUsage{}).Where("token_id = ? and period = ? and model = ?", tokenId, budget.Period, budget.Model)
+		result := query.Where("window_start = ?", windowStart).Update("used", gorm.Expr("used + ?", quota))
+		if result.Error != nil {
+			return 0, 0, result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 进入新窗口，重置用量
+			result = DB.Model(&TokenBudgetUsage{}).Where("token_id = ? and period = ? and model = ? and window_start < ?", tokenId, budget.Period, budget.Model, windowStart).
+				Updates(map[string]interface{}{"window_start": windowStart, "used": quota})
+			if result.Error != nil {
+				return 0, 0, result.Error
+			}
+		}
+		if result.RowsAffected == 0 {
+			err = DB.Create(&TokenBudgetUsage{TokenId: tokenId, Period: budget.Period, Model: budget.Model, WindowStart: windowStart, Used: quota}).Error
+			if err != nil {
+				// 并发创建时唯一索引冲突，重试一次累加
+				continue
+			}
+		}
+		after, err = getTokenBudgetUsed(tokenId, budget, windowStart)
+		return after - quota, after, err
+	}
+	return 0, 0, err
+}
+
+// checkTokenBudgets 检查本次请求是否会超出令牌的预算，quota 为 0 时仅检查预算是否已用尽
+func checkTokenBudgets(token *Token, modelName string, quota int) error {
+	now := time.Now()
+	for _, budget := range token.GetBudgets() {
+		if !budget.matches(modelName) {
+			continue
+		}
+		start, _ := tokenBudgetWindow(budget.Period, now)
+		used, err := getTokenBudgetUsed(token.Id, &budget, start.Unix())
+		if err != nil {
+			return err
+		}
+		if used >= budget.Limit || used+quota > budget.Limit {
+			if budget.Model != "" {
+				return fmt.Errorf("令牌对模型 %s 的%s预算已用尽", budget.Model, tokenBudgetPeriodNames[budget.Period])
+			}
+			return fmt.Errorf("令牌%s预算已用尽", tokenBudgetPeriodNames[budget.Period])
+		}
+	}
+	return nil
+}
+
+// RecordTokenBudgetUsage 按实际扣费累加令牌预算窗口用量，跨过通知阈值时提醒用户
+func RecordTokenBudgetUsage(tokenId int, modelName string, quota int) {
+	if quota <= 0 {
+		return
+	}
+	token, err := GetTokenById(tokenId)
+	if err != nil {
+		common.SysError("failed to get token for budget usage: " + err.Error())
+		return
+	}
+	now := time.Now()
+	for _, budget := range token.GetBudgets() {
+		if !budget.matches(modelName) {
+			continue
+		}
+		start, end := tokenBudgetWindow(budget.Period, now)
+		before, after, err := addTokenBudgetUsage(tokenId, &budget, start.Unix(), end.Unix(), quota)
+		if err != nil {
+			common.SysError("failed to record token budget usage: " + err.Error())
+			continue
+		}
+		for _, threshold := range tokenBudgetThresholds {
+			if before*100 < budget.Limit*threshold && after*100 >= budget.Limit*threshold {
+				go notifyTokenBudget(token, budget, after, threshold)
+			}
+		}
+	}
+}
+
+func notifyTokenBudget(token *Token, budget TokenBudget, used int, threshold int) {
+	scope := tokenBudgetPeriodNames[budget.Period] + "预算"
+	if budget.Model != "" {
+		scope = fmt.Sprintf("模型 %s 的%s", budget.Model, scope)
+	}
+	content := fmt.Sprintf("令牌「%s」%s已使用 %d%%，已用 %s，预算 %s", token.Name, scope, threshold, common.LogQuota(used), common.LogQuota(budget.Limit))
+	RecordLog(token.UserId, LogTypeSystem, 0, content)
+	email, err := GetUserEmail(token.UserId)
+	if err != nil {
+		common.SysError("failed to fetch user email: " + err.Error())
+		return
+	}
+	if email == "" {
+		return
+	}
+	subject := fmt.Sprintf("令牌「%s」%s已使用 %d%%", token.Name, scope, threshold)
+	err = common.SendEmail(subject, email, content)
+	if err != nil {
+		common.SysError("failed to send email: " + err.Error())
+	}
+}
+
+// GetTokenBudgetUsages 返回令牌各预算在当前窗口内的用量
+func GetTokenBudgetUsages(token *Token) []*TokenBudgetUsage {
+	budgets := token.GetBudgets()
+	if len(budgets) == 0 {
+		return nil
+	}
+	now := time.Now()
+	usages := make([]*TokenBudgetUsage, 0, len(budgets))
+	for _, budget := range budgets {
+		start, end := tokenBudgetWindow(budget.Period, now)
+		used, err := getTokenBudgetUsed(token.Id, &budget, start.Unix())
+		if err != nil {
+			common.SysError("failed to get token budget usage: " + err.Error())
+		}
+		usages = append(usages, &TokenBudgetUsage{
+			Period:      budget.Period,
+			Model:       budget.Model,
+			WindowStart: start.Unix(),
+			WindowEnd:   end.Unix(),
+			Used:        used,
+			Limit:       budget.Limit,
+		})
+	}
+	return usages
+}
+
+func FillTokenBudgetUsages(tokens []*Token) {
+	for _, token := range tokens {
+		token.BudgetUsage = GetTokenBudgetUsages(token)
+	}
+}
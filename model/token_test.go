@@ -0,0 +1,113 @@
+package model
+
+import (
+	"context"
+	"one-api/common"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireTokenKeyColumn(t *testing.T, want bool) {
+	t.Helper()
+	legacy, err := hasColumn(DB, "tokens", "key")
+	require.NoError(t, err)
+	require.Equal(t, want, legacy)
+}
+
+func TestMigrateTokenKeys(t *testing.T) {
+	cases := []struct {
+		name       string
+		key        string
+		wantPrefix string
+		lookup     bool
+	}{
+		{"plain key", "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFGHIJKL", "abcdefgh", true},
+		{"short key", "abc", "abc", true},
+		{"empty key", "", "invalid-", false},
+	}
+	setupTestDB(t)
+	requireTokenKeyColumn(t, false)
+	// 模拟旧版表结构：明文 key 列且尚未生成哈希
+	require.NoError(t, DB.Exec("ALTER TABLE tokens ADD COLUMN `key` char(48)").Error)
+	requireTokenKeyColumn(t, true)
+	for i, tc := range cases {
+		require.NoError(t, DB.Exec("INSERT INTO tokens (id, user_id, `key`, key_hash, status, name, expired_time, remain_quota, unlimited_quota) VALUES (?, 1, ?, NULL, 1, ?, -1, 0, 1)", i+1, tc.key, tc.name).Error)
+	}
+
+	require.NoError(t, migrateTokenKeys(DB))
+	requireTokenKeyColumn(t, false)
+	// 再次执行时旧列已删除，直接跳过
+	require.NoError(t, migrateTokenKeys(DB))
+	// 查询表结构失败时返回错误，不当作已迁移
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Error(t, migrateTokenKeys(DB.WithContext(ctx)))
+
+	for i, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var token Token
+			require.NoError(t, DB.First(&token, i+1).Error)
+			require.Len(t, token.KeyHash, 64)
+			require.Contains(t, token.KeyPrefix, tc.wantPrefix)
+			if tc.lookup {
+				require.Equal(t, hashAccessKey(tc.key), token.KeyHash)
+				found, err := CacheGetTokenByKey(context.Background(), tc.key)
+				require.NoError(t, err)
+				require.Equal(t, i+1, found.Id)
+			}
+		})
+	}
+}
+
+func TestInsertTokenStoresHashOnly(t *testing.T) {
+	setupTestDB(t)
+	token := &Token{UserId: 1, Name: "new", Key: "0123456789abcdef0123456789abcdef0123456789abcdef", ExpiredTime: -1, UnlimitedQuota: true}
+	require.NoError(t, token.Insert())
+	var stored Token
+	require.NoError(t, DB.First(&stored, token.Id).Error)
+	require.Empty(t, stored.Key)
+	require.Equal(t, "01234567", stored.KeyPrefix)
+	require.Equal(t, hashAccessKey(token.Key), stored.KeyHash)
+	tokens, err := SearchUserTokens(1, "", "01234567")
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	tokens, err = SearchUserTokens(1, "", token.Key)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+}
+
+func createTestToken(t *testing.T, userId int, remainQuota int) *Token {
+	t.Helper()
+	token := &Token{UserId: userId, Name: "test", Key: common.GetRandomString(48), Status: common.TokenStatusEnabled, ExpiredTime: -1, RemainQuota: remainQuota}
+	require.NoError(t, token.Insert())
+	return token
+}
+
+func getTestTokenRemainQuota(t *testing.T, id int) int {
+	t.Helper()
+	var quota int
+	require.NoError(t, DB.Model(&Token{}).Where("id = ?", id).Select("remain_quota").Find(&quota).Error)
+	return quota
+}
+
+func TestConsumeTokenQuotaAfterCancel(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "consumer")
+	require.NoError(t, GrantUserQuota(user.Id, 1000, QuotaLotSourceTopup, 1, 0))
+	token := createTestToken(t, user.Id, 500)
+
+	// 客户端断开后请求的 context 已取消，令牌和用户仍在同一事务中一起扣减
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, PreConsumeTokenQuota(ctx, token.Id, 100, "gpt-4"))
+	require.NoError(t, PostConsumeTokenQuota(ctx, token.Id, 50))
+	require.NoError(t, PostConsumeTokenQuota(ctx, token.Id, -30))
+	require.Equal(t, 380, getTestTokenRemainQuota(t, token.Id))
+	require.Equal(t, 880, getTestUserQuota(t, user.Id))
+
+	require.Error(t, PreConsumeTokenQuota(context.Background(), token.Id, 400, "gpt-4"))
+	require.Equal(t, 380, getTestTokenRemainQuota(t, token.Id))
+	require.Equal(t, 880, getTestUserQuota(t, user.Id))
+	requireLedgerBalanced(t)
+}
@@ -1,16 +1,44 @@
 package model
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"one-api/common"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TopUpStatusPending  = "pending"
+	TopUpStatusSuccess  = "success"
+	TopUpStatusExpired  = "expired"
+	TopUpStatusMismatch = "mismatch" // 支付渠道返回的实付金额与订单金额不符，需人工处理
+	TopUpStatusRefunded = "refunded" // 已全额退款
+)
+
+// topUpOpenStatuses 尚未发放额度的订单状态，过期后才到账的支付仍可完成
+var topUpOpenStatuses = []string{TopUpStatusPending, TopUpStatusExpired, TopUpStatusMismatch}
 
 type TopUp struct {
-	Id         int     `json:"id"`
-	UserId     int     `json:"user_id" gorm:"index"`
-	Amount     int     `json:"amount"`
-	Money      float64 `json:"money"`
-	TopupRatio string  `json:"topup_ratio"`
-	TradeNo    string  `json:"trade_no"`
-	CreateTime int64   `json:"create_time"`
-	Status     string  `json:"status"`
+	Id              int     `json:"id"`
+	UserId          int     `json:"user_id" gorm:"index"`
+	Amount          int     `json:"amount"`
+	Money           float64 `json:"money"`
+	TopupRatio      string  `json:"topup_ratio"`
+	TradeNo         string  `json:"trade_no"`
+	Provider        string  `json:"provider" gorm:"type:varchar(32);default:'epay'"`
+	ProviderTradeNo string  `json:"provider_trade_no" gorm:"type:varchar(255)"`
+	PaidMoney       float64 `json:"paid_money"` // 支付渠道返回的实付金额
+	RefundedMoney   float64 `json:"refunded_money"`
+	CouponId        int     `json:"coupon_id"`
+	Discount        float64 `json:"discount"`    // 优惠码减免的金额
+	BonusQuota      int     `json:"bonus_quota"` // 优惠码赠送的额度
+	Quota           int     `json:"quota"`       // 按实付金额确认的金额不符订单实际发放的额度，为 0 时按 Amount 计算
+	PlanId          int     `json:"plan_id"`     // 订阅套餐订单，支付后开通或续费套餐而不是发放额度
+	CreateTime      int64   `json:"create_time"`
+	Status          string  `json:"status"`
 }
 
 type TopUpQueryParams struct {
@@ -111,3 +139,201 @@ func (topup *TopUp) Delete() error {
 	err = DB.Delete(topup).Error
 	return err
 }
+
+// CompleteTopUp 将待支付订单标记为成功并发放额度，paidMoney 为支付渠道返回的实付金额，
+// 重复回调时只会成功一次，返回本次是否实际完成了订单
+func CompleteTopUp(topUp *TopUp, providerTradeNo string, paidMoney float64) (bool, error) {
+	if topUp.PlanId != 0 {
+		return completeSubscriptionTopUp(topUp, providerTradeNo, paidMoney)
+	}
+	quota := topUp.baseQuota()
+	// 充值档位即额度有效天数，-1 表示永不过期
+	days, _ := strconv.Atoi(topUp.TopupRatio)
+	completed := false
+	err := DB.Transaction(func(tx *gorm.DB) (err error) {
+		completed, err = markTopUpPaid(tx, topUp, providerTradeNo, paidMoney)
+		if err != nil || !completed {
+			return err
+		}
+		err = useTopUpCoupon(tx, topUp)
+		if err != nil {
+			return err
+		}
+		return grantUserQuota(tx, topUp.UserId, quota+topUp.BonusQuota, QuotaLotSourceTopup, topUp.Id, QuotaLotExpiresAt(days))
+	})
+	if err != nil || !completed {
+		return false, err
+	}
+	if err := CacheUpdateUserQuota(topUp.UserId); err != nil {
+		common.SysError("failed to update user quota cache: " + err.Error())
+	}
+	content := fmt.Sprintf("在线充值成功，充值: %v，支付金额：%.2f", common.LogQuota(quota), topUp.Money)
+	if topUp.CouponId != 0 {
+		content += fmt.Sprintf("，优惠码减免 %.2f，赠送 %v", topUp.Discount, common.LogQuota(topUp.BonusQuota))
+	}
+	RecordLog(topUp.UserId, LogTypeTopup, quota+topUp.BonusQuota, content)
+	_ = VipInsert(topUp.UserId, quota, LedgerRefTopup, topUp.Id)
+	GroupEnable, _ := strconv.ParseBool(common.OptionMap["GroupEnable"])
+	if GroupEnable {
+		err = VipUserQuota(topUp.UserId)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to update user group for top-up %s: %s", topUp.TradeNo, err.Error()))
+		}
+	}
+	return true, nil
+}
+
+// markTopUpPaid 以条件更新将未完成的订单标记为已支付，返回本次是否实际更新。
+// 金额不符的订单同时写入确认后的金额和额度，已占用退款金额的订单不会再完成
+func markTopUpPaid(tx *gorm.DB, topUp *TopUp, providerTradeNo string, paidMoney float64) (bool, error) {
+	updates := map[string]interface{}{"status": TopUpStatusSuccess, "paid_money": paidMoney}
+	if topUp.Status == TopUpStatusMismatch {
+		updates["money"] = topUp.Money
+		updates["quota"] = topUp.Quota
+		updates["bonus_quota"] = topUp.BonusQuota
+	}
+	if providerTradeNo != "" {
+		updates["provider_trade_no"] = providerTradeNo
+	}
+	result := tx.Model(&TopUp{}).Where("id = ? and status in ? and refunded_money = 0", topUp.Id, topUpOpenStatuses).Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	topUp.Status = TopUpStatusSuccess
+	topUp.PaidMoney = paidMoney
+	if providerTradeNo != "" {
+		topUp.ProviderTradeNo = providerTradeNo
+	}
+	return true, nil
+}
+
+// baseQuota 充值订单发放的额度，不含优惠码赠送
+func (topUp *TopUp) baseQuota() int {
+	if topUp.Quota != 0 {
+		return topUp.Quota
+	}
+	return int(float64(topUp.Amount) * common.QuotaPerUnit)
+}
+
+// grantedQuota 订单支付后发放的额度，订阅订单为套餐单个周期的额度
+func (topUp *TopUp) grantedQuota() int {
+	if topUp.PlanId != 0 {
+		plan, err := GetPlanById(topUp.PlanId)
+		if err != nil {
+			return 0
+		}
+		return plan.Quota
+	}
+	return topUp.baseQuota() + topUp.BonusQuota
+}
+
+// FlagTopUpAmountMismatch 标记实付金额不符的订单，返回是否为首次标记
+func FlagTopUpAmountMismatch(topUp *TopUp, paidMoney float64) (bool, error) {
+	result := DB.Model(&TopUp{}).Where("id = ? and status in ?", topUp.Id, []string{TopUpStatusPending, TopUpStatusExpired}).
+		Updates(map[string]interface{}{"status": TopUpStatusMismatch, "paid_money": paidMoney})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	topUp.PaidMoney = paidMoney
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	topUp.Status = TopUpStatusMismatch
+	return true, nil
+}
+
+// SetTopUpProviderTradeNo 渠道下单成功后记录渠道订单号，回调已记录时不覆盖
+func SetTopUpProviderTradeNo(topUp *TopUp, providerTradeNo string) error {
+	err := DB.Model(&TopUp{}).Where("id = ? and (provider_trade_no = '' or provider_trade_no is null)", topUp.Id).
+		Update("provider_trade_no", providerTradeNo).Error
+	if err != nil {
+		return err
+	}
+	topUp.ProviderTradeNo = providerTradeNo
+	return nil
+}
+
+// AcceptTopUpMismatch 管理员按实付金额确认金额不符的订单：充值订单按实付比例发放额度，订阅订单须足额支付，
+// 返回本次是否实际完成了订单
+func AcceptTopUpMismatch(topUp *TopUp) (bool, error) {
+	if topUp.Status != TopUpStatusMismatch {
+		return false, errors.New("订单不是待处理的金额不符订单")
+	}
+	if topUp.PaidMoney <= 0 {
+		return false, errors.New("订单实付金额为 0，无法确认")
+	}
+	if topUp.PlanId != 0 && topUp.PaidMoney+0.001 < topUp.Money {
+		return false, errors.New("订阅订单实付金额不足，只能退款")
+	}
+	if topUp.PlanId == 0 {
+		ratio := topUp.PaidMoney / topUp.Money
+		topUp.Quota = int(math.Round(float64(topUp.baseQuota()) * ratio))
+		topUp.BonusQuota = int(math.Round(float64(topUp.BonusQuota) * ratio))
+	}
+	topUp.Money = topUp.PaidMoney
+	completed, err := CompleteTopUp(topUp, "", topUp.PaidMoney)
+	if err != nil || !completed {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReserveTopUpMismatchRefund 退回金额不符订单的实付金额前先占用，避免与确认或重复退款并发
+func ReserveTopUpMismatchRefund(topUp *TopUp) error {
+	result := DB.Model(&TopUp{}).Where("id = ? and status = ? and refunded_money = 0", topUp.Id, TopUpStatusMismatch).
+		Update("refunded_money", topUp.PaidMoney)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("订单不是待处理的金额不符订单或正在退款")
+	}
+	topUp.RefundedMoney = topUp.PaidMoney
+	return nil
+}
+
+// CloseTopUpMismatch 金额不符的订单已退回实付金额，关闭订单并退回占用的优惠码次数，refund 中需填好操作人和原因
+func CloseTopUpMismatch(topUp *TopUp, refund *TopUpRefund) error {
+	refund.TopUpId = topUp.Id
+	refund.UserId = topUp.UserId
+	refund.Money = topUp.PaidMoney
+	refund.CreatedAt = common.GetTimestamp()
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&TopUp{}).Where("id = ? and status = ?", topUp.Id, TopUpStatusMismatch).Update("status", TopUpStatusRefunded)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("订单不是待处理的金额不符订单")
+		}
+		err := releaseTopUpCoupon(tx, topUp)
+		if err != nil {
+			return err
+		}
+		return tx.Create(refund).Error
+	})
+	if err != nil {
+		return err
+	}
+	topUp.Status = TopUpStatusRefunded
+	RecordLog(topUp.UserId, LogTypeManage, 0, fmt.Sprintf("订单 %s 实付金额 %.2f 与应付 %.2f 不符，已退回实付金额", topUp.TradeNo, topUp.PaidMoney, topUp.Money))
+	return nil
+}
+
+// ExpireTopUp 关闭未支付的订单并退回占用的优惠码次数
+func ExpireTopUp(topUp *TopUp) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&TopUp{}).Where("id = ? and status = ?", topUp.Id, TopUpStatusPending).Update("status", TopUpStatusExpired)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		topUp.Status = TopUpStatusExpired
+		return releaseTopUpCoupon(tx, topUp)
+	})
+}
+
+// GetPendingTopUps 返回创建时间早于 before 的待支付订单
+func GetPendingTopUps(before int64, limit int) (topUps []*TopUp, err error) {
+	err = DB.Where("status = ? and create_time <= ?", TopUpStatusPending, before).Order("id asc").Limit(limit).Find(&topUps).Error
+	return topUps, err
+}
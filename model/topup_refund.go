@@ -0,0 +1,230 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"one-api/common"
+
+	"gorm.io/gorm"
+)
+
+// 退款扣回额度时余额不足的处理方式
+const (
+	RefundPolicyNegative = "negative" // 允许余额为负
+	RefundPolicySuspend  = "suspend"  // 扣到 0 为止并封禁用户
+)
+
+// TopUpRefund 充值退款及拒付的审计记录
+type TopUpRefund struct {
+	Id                 int     `json:"id"`
+	TopUpId            int     `json:"top_up_id" gorm:"index"`
+	UserId             int     `json:"user_id" gorm:"index"`
+	OperatorId         int     `json:"operator_id"`
+	Money              float64 `json:"money"`
+	Quota              int     `json:"quota"`    // 按退款比例应扣回的额度
+	Deducted           int     `json:"deducted"` // 实际扣回的额度
+	CommissionReversed int     `json:"commission_reversed"`
+	Chargeback         bool    `json:"chargeback"` // 拒付由支付渠道发起，不再调用渠道退款
+	Suspended          bool    `json:"suspended"`
+	Reason             string  `json:"reason" gorm:"type:varchar(255)"`
+	CreatedAt          int64   `json:"created_at" gorm:"bigint"`
+}
+
+// ReserveTopUpRefund 调用支付渠道退款前先占用可退金额，避免并发重复退款
+func ReserveTopUpRefund(topUp *TopUp, money float64) error {
+	if money <= 0 {
+		return errors.New("退款金额必须大于 0")
+	}
+	result := DB.Model(&TopUp{}).
+		Where("id = ? and status in ? and refunded_money + ? <= money + 0.001", topUp.Id, []string{TopUpStatusSuccess, TopUpStatusRefunded}, money).
+		Update("refunded_money", gorm.Expr("refunded_money + ?", money))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("订单未支付成功或退款金额超过可退金额")
+	}
+	return nil
+}
+
+// ReleaseTopUpRefund 支付渠道退款失败时释放占用的金额
+func ReleaseTopUpRefund(topUp *TopUp, money float64) error {
+	return DB.Model(&TopUp{}).Where("id = ?", topUp.Id).Update("refunded_money", gorm.Expr("refunded_money - ?", money)).Error
+}
+
+// ApplyTopUpRefund 按退款比例扣回用户额度并冲回邀请返佣，订阅订单全额退款时撤销对应的订阅周期，refund 中需填好金额、操作人和原因
+func ApplyTopUpRefund(topUp *TopUp, refund *TopUpRefund) error {
+	ratio := refund.Money / topUp.Money
+	if ratio > 1 {
+		ratio = 1
+	}
+	refund.TopUpId = topUp.Id
+	refund.UserId = topUp.UserId
+	refund.Quota = int(math.Round(float64(topUp.grantedQuota()) * ratio))
+	refund.CreatedAt = common.GetTimestamp()
+	ref := LedgerRef{Type: LedgerTypeClawback, RefType: LedgerRefTopup, RefId: topUp.Id}
+	rolledBack := false
+	disabled := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&TopUp{}).Where("id = ? and status = ? and refunded_money >= money - 0.001", topUp.Id, TopUpStatusSuccess).
+			Update("status", TopUpStatusRefunded)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 && topUp.PlanId != 0 {
+			granted, err := rollbackSubscriptionTopUp(tx, topUp)
+			if err != nil {
+				return err
+			}
+			rolledBack = true
+			if !granted {
+				// 尚未开始的续费周期没有发放过额度
+				refund.Quota = 0
+			}
+		}
+		var quota int
+		err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Select("quota").Find(&quota).Error
+		if err != nil {
+			return err
+		}
+		refund.Deducted = refund.Quota
+		if common.RefundClawbackPolicy == RefundPolicySuspend && quota < refund.Quota {
+			refund.Deducted = quota
+			if refund.Deducted < 0 {
+				refund.Deducted = 0
+			}
+			refund.Suspended = true
+		}
+		if refund.Deducted > 0 {
+			err = tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota - ?", refund.Deducted)).Error
+			if err != nil {
+				return err
+			}
+			err = clawbackQuotaLots(tx, topUp, refund.Deducted)
+			if err != nil {
+				return err
+			}
+			err = recordLedger(tx, ref, userPosting(topUp.UserId, -refund.Deducted))
+			if err != nil {
+				return err
+			}
+		}
+		if refund.Suspended {
+			result := tx.Model(&User{}).Where("id = ? and role < ?", topUp.UserId, common.RoleRootUser).Update("status", common.UserStatusDisabled)
+			if result.Error != nil {
+				return result.Error
+			}
+			disabled = result.RowsAffected > 0
+		}
+		refund.CommissionReversed, err = reverseCommission(tx, topUp, ratio)
+		if err != nil {
+			return err
+		}
+		return tx.Create(refund).Error
+	})
+	if err != nil {
+		return err
+	}
+	if err := CacheUpdateUserQuota(topUp.UserId); err != nil {
+		common.SysError("failed to update user quota cache: " + err.Error())
+	}
+	if rolledBack {
+		cacheDeleteUserSubscription(topUp.UserId)
+		CacheDeleteUserGroup(topUp.UserId)
+	}
+	if disabled {
+		// 与管理员禁用用户一致，立即让状态缓存和全部会话失效
+		CacheDeleteUserEnabled(topUp.UserId)
+		if err := RevokeUserSessions(topUp.UserId, ""); err != nil {
+			common.SysError("failed to revoke user sessions: " + err.Error())
+		}
+	}
+	content := fmt.Sprintf("订单 %s 退款 %.2f，扣回额度 %s", topUp.TradeNo, refund.Money, common.LogQuota(refund.Deducted))
+	if refund.Chargeback {
+		content = fmt.Sprintf("订单 %s 拒付 %.2f，扣回额度 %s", topUp.TradeNo, refund.Money, common.LogQuota(refund.Deducted))
+	}
+	if rolledBack {
+		content += "，已撤销对应的订阅周期"
+	}
+	if refund.Suspended {
+		content += "，余额不足已封禁账户"
+	}
+	RecordLog(topUp.UserId, LogTypeManage, 0, content)
+	return nil
+}
+
+// clawbackQuotaLots 优先扣减该订单对应额度包的剩余额度，不足部分按过期顺序扣减其他额度包
+func clawbackQuotaLots(tx *gorm.DB, topUp *TopUp, quota int) error {
+	var lot QuotaLot
+	err := tx.Where("user_id = ? and source = ? and source_id = ?", topUp.UserId, QuotaLotSourceTopup, topUp.Id).Limit(1).Find(&lot).Error
+	if err != nil {
+		return err
+	}
+	if lot.Id != 0 && lot.Remaining > 0 {
+		take := lot.Remaining
+		if take > quota {
+			take = quota
+		}
+		result := tx.Model(&QuotaLot{}).Where("id = ? and remaining >= ?", lot.Id, take).Update("remaining", gorm.Expr("remaining - ?", take))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 1 {
+			quota -= take
+		}
+	}
+	if quota <= 0 {
+		return nil
+	}
+	return drawQuotaLots(tx, topUp.UserId, quota)
+}
+
+// reverseCommission 按退款比例冲回该订单给邀请人的返佣。返佣引擎生成的按返佣明细冲回，
+// 旧的直接返现没有明细，按流水中的返现金额从邀请余额扣回（可能因此为负）
+func reverseCommission(tx *gorm.DB, topUp *TopUp, ratio float64) (int, error) {
+	var count int64
+	err := tx.Model(&AffCommission{}).Where("ref_type = ? and ref_id = ?", LedgerRefTopup, topUp.Id).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return reverseAffCommissions(tx, LedgerRefTopup, topUp.Id, ratio)
+	}
+	var commissions []struct {
+		AccountId int
+		Total     int
+	}
+	err = tx.Model(&QuotaLedgerEntry{}).Select("account_id, SUM(delta) AS total").
+		Where("account_type = ? and type = ? and ref_type = ? and ref_id = ?", LedgerAccountAff, LedgerTypeCommission, LedgerRefTopup, topUp.Id).
+		Group("account_id").Scan(&commissions).Error
+	if err != nil {
+		return 0, err
+	}
+	reversed := 0
+	for _, commission := range commissions {
+		amount := int(math.Round(float64(commission.Total) * ratio))
+		if amount <= 0 {
+			continue
+		}
+		err = tx.Model(&User{}).Where("id = ?", commission.AccountId).Updates(map[string]interface{}{
+			"aff_quota":   gorm.Expr("aff_quota - ?", amount),
+			"aff_history": gorm.Expr("aff_history - ?", amount),
+		}).Error
+		if err != nil {
+			return 0, err
+		}
+		err = recordLedger(tx, LedgerRef{Type: LedgerTypeCommissionReversal, RefType: LedgerRefTopup, RefId: topUp.Id}, affPosting(commission.AccountId, -amount))
+		if err != nil {
+			return 0, err
+		}
+		RecordLog(commission.AccountId, LogTypeSystem, 0, fmt.Sprintf("邀请用户充值退款，扣回返现 %s", common.LogQuota(amount)))
+		reversed += amount
+	}
+	return reversed, nil
+}
+
+func GetTopUpRefunds(topUpId int) (refunds []*TopUpRefund, err error) {
+	err = DB.Where("top_up_id = ?", topUpId).Order("id desc").Find(&refunds).Error
+	return refunds, err
+}
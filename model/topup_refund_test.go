@@ -0,0 +1,177 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestTopUp(t *testing.T, topUp *TopUp) *TopUp {
+	t.Helper()
+	topUp.TradeNo = common.GetRandomString(16)
+	topUp.CreateTime = common.GetTimestamp()
+	topUp.Status = TopUpStatusPending
+	require.NoError(t, topUp.Insert())
+	return topUp
+}
+
+func refundTestTopUp(t *testing.T, topUp *TopUp, money float64) *TopUpRefund {
+	t.Helper()
+	require.NoError(t, ReserveTopUpRefund(topUp, money))
+	refund := &TopUpRefund{Money: money, Reason: "test"}
+	require.NoError(t, ApplyTopUpRefund(topUp, refund))
+	return refund
+}
+
+func TestRefundTopUpClawback(t *testing.T) {
+	setupTestDB(t)
+	// 直接返现按 OptionMap 中的 ProporTions 计算
+	InitOptionMap()
+	unit := int(common.QuotaPerUnit)
+	inviter := createTestUser(t, "legacy-inviter")
+	user := createTestUser(t, "refunded")
+	require.NoError(t, DB.Model(user).Update("inviter_id", inviter.Id).Error)
+	topUp := createTestTopUp(t, &TopUp{UserId: user.Id, Amount: 10, Money: 10, TopupRatio: "-1"})
+	_, err := CompleteTopUp(topUp, "", topUp.Money)
+	require.NoError(t, err)
+	topUp = GetTopUpById(topUp.Id)
+	commission := 10 * unit * common.ProporTions / 100
+	require.Equal(t, commission, getTestAffQuota(t, inviter.Id))
+
+	// 部分退款按比例扣回额度并冲回直接返现
+	refund := refundTestTopUp(t, topUp, 3)
+	assert.Equal(t, 3*unit, refund.Quota)
+	assert.Equal(t, 3*unit, refund.Deducted)
+	assert.Equal(t, commission*3/10, refund.CommissionReversed)
+	assert.Equal(t, 7*unit, getTestUserQuota(t, user.Id))
+	assert.Equal(t, commission*7/10, getTestAffQuota(t, inviter.Id))
+	assert.Equal(t, TopUpStatusSuccess, GetTopUpById(topUp.Id).Status)
+	// 超过剩余可退金额的退款被拒绝
+	assert.Error(t, ReserveTopUpRefund(topUp, 8))
+
+	// 余额已被用掉时默认允许扣成负数
+	require.NoError(t, DecreaseUserQuota(user.Id, 5*unit, LedgerRef{Type: LedgerTypeConsume, RefType: LedgerRefUser, RefId: user.Id}))
+	refund = refundTestTopUp(t, topUp, 4)
+	assert.Equal(t, 4*unit, refund.Deducted)
+	assert.False(t, refund.Suspended)
+	assert.Equal(t, -2*unit, getTestUserQuota(t, user.Id))
+
+	// suspend 策略只扣到 0 并封禁用户
+	common.RefundClawbackPolicy = RefundPolicySuspend
+	defer func() { common.RefundClawbackPolicy = RefundPolicyNegative }()
+	require.NoError(t, IncreaseUserQuota(user.Id, 3*unit, LedgerRef{Type: LedgerTypeRefund, RefType: LedgerRefUser, RefId: user.Id}))
+	sid, err := CreateUserSession(user.Id, "127.0.0.1", "test")
+	require.NoError(t, err)
+	refund = refundTestTopUp(t, topUp, 3)
+	assert.Equal(t, 3*unit, refund.Quota)
+	assert.Equal(t, unit, refund.Deducted)
+	assert.True(t, refund.Suspended)
+	assert.Zero(t, getTestUserQuota(t, user.Id))
+	var status int
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", user.Id).Select("status").Find(&status).Error)
+	assert.Equal(t, common.UserStatusDisabled, status)
+	// 封禁后已登录的会话立即失效
+	_, ok := ValidateUserSession(sid, "127.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, TopUpStatusRefunded, GetTopUpById(topUp.Id).Status)
+	assert.Zero(t, getTestAffQuota(t, inviter.Id))
+
+	refunds, err := GetTopUpRefunds(topUp.Id)
+	require.NoError(t, err)
+	assert.Len(t, refunds, 3)
+	requireLedgerBalanced(t)
+}
+
+func TestRefundSubscriptionTopUp(t *testing.T) {
+	setupTestDB(t)
+	plan := &SubscriptionPlan{Name: "pro", Status: 1, Price: 10, PeriodDays: 30, Quota: 1000, Group: "vip"}
+	require.NoError(t, plan.Insert())
+	user := createTestUser(t, "subscriber")
+
+	first := createTestTopUp(t, &TopUp{UserId: user.Id, Money: 10, PlanId: plan.Id})
+	completed, err := CompleteTopUp(first, "", first.Money)
+	require.NoError(t, err)
+	require.True(t, completed)
+	subscription, err := GetUserSubscription(user.Id)
+	require.NoError(t, err)
+	require.NotNil(t, subscription)
+	expiresAt := subscription.ExpiresAt
+
+	// 同一套餐续费只顺延到期时间，退款时撤销顺延且不扣回额度
+	renewal := createTestTopUp(t, &TopUp{UserId: user.Id, Money: 10, PlanId: plan.Id})
+	completed, err = CompleteTopUp(renewal, "", renewal.Money)
+	require.NoError(t, err)
+	require.True(t, completed)
+	refund := refundTestTopUp(t, renewal, 10)
+	assert.Zero(t, refund.Deducted)
+	subscription, err = GetUserSubscription(user.Id)
+	require.NoError(t, err)
+	require.NotNil(t, subscription)
+	assert.Equal(t, expiresAt, subscription.ExpiresAt)
+	assert.Equal(t, 1000, getTestUserQuota(t, user.Id))
+	group, err := GetUserGroup(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "vip", group)
+
+	// 部分退款只按比例扣回额度，订阅继续生效
+	refund = refundTestTopUp(t, first, 4)
+	assert.Equal(t, 400, refund.Deducted)
+	subscription, err = GetUserSubscription(user.Id)
+	require.NoError(t, err)
+	require.NotNil(t, subscription)
+
+	// 退完剩余金额后终止当前周期并降回普通分组
+	refund = refundTestTopUp(t, first, 6)
+	assert.Equal(t, 600, refund.Deducted)
+	subscription, err = GetUserSubscription(user.Id)
+	require.NoError(t, err)
+	assert.Nil(t, subscription)
+	group, err = GetUserGroup(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "default", group)
+	assert.Zero(t, getTestUserQuota(t, user.Id))
+	assert.Equal(t, TopUpStatusRefunded, GetTopUpById(first.Id).Status)
+	requireLedgerBalanced(t)
+}
+
+func TestRefundReversesReleasedCommission(t *testing.T) {
+	setupTestDB(t)
+	common.AffCommissionEnabled = true
+	common.AffCommissionBase = AffCommissionBaseTopup
+	common.AffCommissionHoldDays = 7
+	common.ProporTions = 10
+	defer func() {
+		common.AffCommissionEnabled = false
+		common.AffCommissionHoldDays = 0
+	}()
+	inviter := createTestUser(t, "inviter")
+	invitee := createTestUser(t, "invitee")
+	require.NoError(t, DB.Model(invitee).Update("inviter_id", inviter.Id).Error)
+	topUp := createTestTopUp(t, &TopUp{UserId: invitee.Id, Amount: 10, Money: 10, TopupRatio: "-1"})
+	_, err := CompleteTopUp(topUp, "", topUp.Money)
+	require.NoError(t, err)
+	var commission AffCommission
+	require.NoError(t, DB.Where("ref_type = ? and ref_id = ?", LedgerRefTopup, topUp.Id).First(&commission).Error)
+	original := commission.Amount
+	require.Equal(t, int(common.QuotaPerUnit), original)
+
+	// 冻结期内部分退款直接扣减冻结金额
+	refund := refundTestTopUp(t, GetTopUpById(topUp.Id), 4)
+	assert.Equal(t, original*4/10, refund.CommissionReversed)
+	require.NoError(t, releaseAffCommission(&commission))
+	var affQuota int
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", inviter.Id).Select("aff_quota").Find(&affQuota).Error)
+	assert.Equal(t, original*6/10, affQuota)
+
+	// 到账后再退剩余金额，按原始返佣计算，扣回全部已到账的返佣
+	refund = refundTestTopUp(t, GetTopUpById(topUp.Id), 6)
+	assert.Equal(t, original*6/10, refund.CommissionReversed)
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", inviter.Id).Select("aff_quota").Find(&affQuota).Error)
+	assert.Zero(t, affQuota)
+	require.NoError(t, DB.First(&commission, commission.Id).Error)
+	assert.Equal(t, AffCommissionStatusReversed, commission.Status)
+	assert.Zero(t, commission.Amount)
+	requireLedgerBalanced(t)
+}
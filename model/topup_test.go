@@ -0,0 +1,109 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompleteTopUpOnce(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "payer")
+	topUp := createTestTopUp(t, &TopUp{UserId: user.Id, Amount: 2, Money: 14, TopupRatio: "-1"})
+
+	require.NoError(t, SetTopUpProviderTradeNo(topUp, "provider-1"))
+	completed, err := CompleteTopUp(topUp, "provider-1", 14.005)
+	require.NoError(t, err)
+	assert.True(t, completed)
+	// 重复回调不再发放额度
+	completed, err = CompleteTopUp(GetTopUpById(topUp.Id), "provider-1", 14.005)
+	require.NoError(t, err)
+	assert.False(t, completed)
+
+	assert.Equal(t, int(2*common.QuotaPerUnit), getTestUserQuota(t, user.Id))
+	saved := GetTopUpById(topUp.Id)
+	assert.Equal(t, TopUpStatusSuccess, saved.Status)
+	assert.Equal(t, "provider-1", saved.ProviderTradeNo)
+	assert.Equal(t, 14.005, saved.PaidMoney)
+	// 回调已记录的渠道订单号不会被下单结果覆盖
+	require.NoError(t, SetTopUpProviderTradeNo(saved, "provider-2"))
+	assert.Equal(t, "provider-1", GetTopUpById(topUp.Id).ProviderTradeNo)
+	requireLedgerBalanced(t)
+}
+
+func TestCompleteExpiredTopUp(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "late-payer")
+	topUp := createTestTopUp(t, &TopUp{UserId: user.Id, Amount: 1, Money: 7, TopupRatio: "-1"})
+
+	require.NoError(t, ExpireTopUp(topUp))
+	assert.Equal(t, TopUpStatusExpired, GetTopUpById(topUp.Id).Status)
+	// 已完成的订单不会被关闭
+	completed, err := CompleteTopUp(topUp, "", topUp.Money)
+	require.NoError(t, err)
+	assert.True(t, completed)
+	require.NoError(t, ExpireTopUp(GetTopUpById(topUp.Id)))
+	assert.Equal(t, TopUpStatusSuccess, GetTopUpById(topUp.Id).Status)
+	assert.Equal(t, int(common.QuotaPerUnit), getTestUserQuota(t, user.Id))
+}
+
+func TestAcceptTopUpMismatch(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "underpayer")
+	topUp := createTestTopUp(t, &TopUp{UserId: user.Id, Amount: 10, Money: 10, TopupRatio: "-1"})
+
+	flagged, err := FlagTopUpAmountMismatch(topUp, 4)
+	require.NoError(t, err)
+	assert.True(t, flagged)
+	flagged, err = FlagTopUpAmountMismatch(topUp, 4)
+	require.NoError(t, err)
+	assert.False(t, flagged)
+
+	completed, err := AcceptTopUpMismatch(GetTopUpById(topUp.Id))
+	require.NoError(t, err)
+	assert.True(t, completed)
+	_, err = AcceptTopUpMismatch(GetTopUpById(topUp.Id))
+	assert.Error(t, err)
+
+	quota := int(4 * common.QuotaPerUnit)
+	assert.Equal(t, quota, getTestUserQuota(t, user.Id))
+	saved := GetTopUpById(topUp.Id)
+	assert.Equal(t, TopUpStatusSuccess, saved.Status)
+	assert.Equal(t, 4.0, saved.Money)
+	assert.Equal(t, quota, saved.grantedQuota())
+
+	// 之后的退款按确认后的金额和额度计算
+	refund := refundTestTopUp(t, saved, 4)
+	assert.Equal(t, quota, refund.Deducted)
+	assert.Zero(t, getTestUserQuota(t, user.Id))
+	requireLedgerBalanced(t)
+}
+
+func TestRefundTopUpMismatch(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "overpayer")
+	topUp := createTestTopUp(t, &TopUp{UserId: user.Id, Amount: 1, Money: 7, TopupRatio: "-1"})
+	_, err := FlagTopUpAmountMismatch(topUp, 70)
+	require.NoError(t, err)
+
+	topUp = GetTopUpById(topUp.Id)
+	require.NoError(t, ReserveTopUpMismatchRefund(topUp))
+	assert.Error(t, ReserveTopUpMismatchRefund(GetTopUpById(topUp.Id)))
+	// 退款进行中不能再确认
+	completed, err := AcceptTopUpMismatch(GetTopUpById(topUp.Id))
+	require.NoError(t, err)
+	assert.False(t, completed)
+
+	require.NoError(t, CloseTopUpMismatch(topUp, &TopUpRefund{Reason: "test"}))
+	assert.Error(t, CloseTopUpMismatch(topUp, &TopUpRefund{Reason: "test"}))
+	saved := GetTopUpById(topUp.Id)
+	assert.Equal(t, TopUpStatusRefunded, saved.Status)
+	assert.Equal(t, 70.0, saved.RefundedMoney)
+	assert.Zero(t, getTestUserQuota(t, user.Id))
+	refunds, err := GetTopUpRefunds(topUp.Id)
+	require.NoError(t, err)
+	require.Len(t, refunds, 1)
+	assert.Equal(t, 70.0, refunds[0].Money)
+}
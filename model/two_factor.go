@@ -0,0 +1,222 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	twoFactorRecoveryCodeCount    = 10
+	TwoFactorChallengeTTL         = 5 * 60
+	TwoFactorChallengeMaxAttempts = 5
+)
+
+// UserTwoFactor 用户的 TOTP 两步验证，Enabled 为 false 时表示正在绑定、尚未确认
+type UserTwoFactor struct {
+	Id            int    `json:"id"`
+	UserId        int    `json:"user_id" gorm:"uniqueIndex"`
+	Secret        string `json:"-" gorm:"type:varchar(64)"`
+	Enabled       bool   `json:"enabled"`
+	RecoveryCodes string `json:"-" gorm:"type:text"` // JSON array，只保存恢复码的哈希
+	LastUsedStep  int64  `json:"-" gorm:"bigint;default:0"`
+	EnabledTime   int64  `json:"enabled_time" gorm:"bigint"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+}
+
+// TwoFactorChallenge 第一步登录验证通过后待完成的两步验证，保存在服务端以便限制尝试次数，Id 为挑战码的哈希
+type TwoFactorChallenge struct {
+	Id          string `json:"-" gorm:"type:char(64);primaryKey"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	Attempts    int    `json:"attempts" gorm:"default:0"`
+	ExpiredTime int64  `json:"expired_time" gorm:"bigint;index"`
+}
+
+// RecoveryCodesLeft 剩余可用的恢复码数量
+func (tf *UserTwoFactor) RecoveryCodesLeft() int {
+	var hashes []string
+	_ = json.Unmarshal([]byte(tf.RecoveryCodes), &hashes)
+	return len(hashes)
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// generateRecoveryCodes 生成一组 xxxx-xxxx 形式的恢复码，返回明文和哈希的 JSON
+func generateRecoveryCodes() ([]string, string, error) {
+	codes := make([]string, 0, twoFactorRecoveryCodeCount)
+	hashes := make([]string, 0, twoFactorRecoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < twoFactorRecoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, "", err
+		}
+		code := strings.ToLower(encoding.EncodeToString(buf))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, hashAccessKey(code))
+	}
+	data, err := json.Marshal(hashes)
+	return codes, string(data), err
+}
+
+func GetUserTwoFactor(userId int) (*UserTwoFactor, error) {
+	tf := UserTwoFactor{}
+	err := DB.First(&tf, "user_id = ?", userId).Error
+	return &tf, err
+}
+
+func IsTwoFactorEnabled(userId int) bool {
+	var count int64
+	DB.Model(&UserTwoFactor{}).Where("user_id = ? and enabled = ?", userId, true).Count(&count)
+	return count > 0
+}
+
+// StartTwoFactorEnrollment 生成新的密钥等待确认，已启用时需先关闭
+func StartTwoFactorEnrollment(userId int) (string, error) {
+	secret, err := common.GenerateTotpSecret()
+	if err != nil {
+		return "", err
+	}
+	tf, err := GetUserTwoFactor(userId)
+	if err != nil {
+		tf = &UserTwoFactor{
+			UserId:      userId,
+			Secret:      secret,
+			CreatedTime: common.GetTimestamp(),
+		}
+		return secret, DB.Create(tf).Error
+	}
+	if tf.Enabled {
+		return "", errors.New("已启用两步验证，请先关闭后再重新绑定")
+	}
+	return secret, DB.Model(tf).Update("secret", secret).Error
+}
+
+// EnableTwoFactor 用验证码确认绑定并启用，返回恢复码明文
+func EnableTwoFactor(userId int, code string) ([]string, error) {
+	tf, err := GetUserTwoFactor(userId)
+	if err != nil {
+		return nil, errors.New("请先获取两步验证密钥")
+	}
+	if tf.Enabled {
+		return nil, errors.New("已启用两步验证")
+	}
+	step := common.ValidateTotp(tf.Secret, code)
+	if step == 0 {
+		return nil, errors.New("验证码错误")
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Model(tf).Updates(map[string]interface{}{
+		"enabled":        true,
+		"recovery_codes": hashes,
+		"last_used_step": step,
+		"enabled_time":   common.GetTimestamp(),
+	}).Error
+	return codes, err
+}
+
+// VerifyTwoFactor 校验验证码或恢复码，同一验证码不能重复使用，恢复码用后作废
+func VerifyTwoFactor(userId int, code string) error {
+	tf, err := GetUserTwoFactor(userId)
+	if err != nil || !tf.Enabled {
+		return errors.New("未启用两步验证")
+	}
+	if step := common.ValidateTotp(tf.Secret, code); step != 0 {
+		result := DB.Model(&UserTwoFactor{}).Where("id = ? and last_used_step < ?", tf.Id, step).Update("last_used_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("验证码已使用，请等待下一个验证码")
+		}
+		return nil
+	}
+	var hashes []string
+	_ = json.Unmarshal([]byte(tf.RecoveryCodes), &hashes)
+	hash := hashAccessKey(normalizeRecoveryCode(code))
+	for i, h := range hashes {
+		if h != hash {
+			continue
+		}
+		remaining, _ := json.Marshal(append(hashes[:i:i], hashes[i+1:]...))
+		result := DB.Model(&UserTwoFactor{}).Where("id = ? and recovery_codes = ?", tf.Id, tf.RecoveryCodes).Update("recovery_codes", string(remaining))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("恢复码已使用")
+		}
+		return nil
+	}
+	return errors.New("验证码错误")
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码，旧的恢复码全部作废
+func RegenerateRecoveryCodes(userId int) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Model(&UserTwoFactor{}).Where("user_id = ? and enabled = ?", userId, true).Update("recovery_codes", hashes).Error
+	return codes, err
+}
+
+// DisableTwoFactor 关闭两步验证，用户自行关闭和管理员重置共用
+func DisableTwoFactor(userId int) error {
+	return DB.Where("user_id = ?", userId).Delete(&UserTwoFactor{}).Error
+}
+
+// CreateTwoFactorChallenge 为通过第一步验证的用户生成挑战码，同时清理已过期的挑战
+func CreateTwoFactorChallenge(userId int) (string, error) {
+	now := common.GetTimestamp()
+	err := DB.Where("expired_time < ?", now).Delete(&TwoFactorChallenge{}).Error
+	if err != nil {
+		common.SysError("failed to clean expired two-factor challenges: " + err.Error())
+	}
+	challenge := common.GetUUID()
+	err = DB.Create(&TwoFactorChallenge{
+		Id:          hashAccessKey(challenge),
+		UserId:      userId,
+		ExpiredTime: now + TwoFactorChallengeTTL,
+	}).Error
+	return challenge, err
+}
+
+// UseTwoFactorChallenge 占用挑战的一次尝试机会，返回对应用户和剩余次数；
+// 挑战不存在、已过期或次数用尽时删除挑战并返回错误
+func UseTwoFactorChallenge(challenge string) (userId int, attemptsLeft int, err error) {
+	id := hashAccessKey(challenge)
+	result := DB.Model(&TwoFactorChallenge{}).
+		Where("id = ? and attempts < ? and expired_time >= ?", id, TwoFactorChallengeMaxAttempts, common.GetTimestamp()).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		DeleteTwoFactorChallenge(challenge)
+		return 0, 0, errors.New("验证已过期，请重新登录")
+	}
+	var c TwoFactorChallenge
+	err = DB.First(&c, "id = ?", id).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	return c.UserId, TwoFactorChallengeMaxAttempts - c.Attempts, nil
+}
+
+// DeleteTwoFactorChallenge 验证通过或次数用尽后删除挑战，并发提交时只有删除成功的请求可以完成登录
+func DeleteTwoFactorChallenge(challenge string) bool {
+	result := DB.Where("id = ?", hashAccessKey(challenge)).Delete(&TwoFactorChallenge{})
+	return result.Error == nil && result.RowsAffected == 1
+}
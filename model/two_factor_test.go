@@ -0,0 +1,120 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"one-api/common"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testTotpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.NoError(t, err)
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(time.Now().Unix()/common.TotpPeriod+offset))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	pos := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[pos:pos+4])&0x7fffffff)%1000000)
+}
+
+func enableTestTwoFactor(t *testing.T, userId int) (string, []string) {
+	t.Helper()
+	secret, err := StartTwoFactorEnrollment(userId)
+	require.NoError(t, err)
+	codes, err := EnableTwoFactor(userId, testTotpCode(t, secret, -1))
+	require.NoError(t, err)
+	require.Len(t, codes, twoFactorRecoveryCodeCount)
+	return secret, codes
+}
+
+func TestVerifyTwoFactor(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "tf_user")
+	_, err := EnableTwoFactor(user.Id, "000000")
+	require.Error(t, err)
+	secret, codes := enableTestTwoFactor(t, user.Id)
+	require.True(t, IsTwoFactorEnabled(user.Id))
+
+	steps := []struct {
+		name    string
+		code    string
+		wantErr bool
+	}{
+		{"code used for enrollment is rejected", testTotpCode(t, secret, -1), true},
+		{"current code", testTotpCode(t, secret, 0), false},
+		{"replayed code", testTotpCode(t, secret, 0), true},
+		{"older code after newer one", testTotpCode(t, secret, -1), true},
+		{"next code", testTotpCode(t, secret, 1), false},
+		{"wrong code", "abcdef", true},
+		{"recovery code", codes[0], false},
+		{"recovery code reused", codes[0], true},
+		{"recovery code without dash in upper case", "  " + strings.ToUpper(codes[1][:4]+codes[1][5:]) + "  ", false},
+	}
+	for _, step := range steps {
+		err := VerifyTwoFactor(user.Id, step.code)
+		if step.wantErr {
+			require.Error(t, err, step.name)
+		} else {
+			require.NoError(t, err, step.name)
+		}
+	}
+	tf, err := GetUserTwoFactor(user.Id)
+	require.NoError(t, err)
+	require.Equal(t, twoFactorRecoveryCodeCount-2, tf.RecoveryCodesLeft())
+
+	newCodes, err := RegenerateRecoveryCodes(user.Id)
+	require.NoError(t, err)
+	require.Error(t, VerifyTwoFactor(user.Id, codes[2]))
+	require.NoError(t, VerifyTwoFactor(user.Id, newCodes[0]))
+
+	require.NoError(t, DisableTwoFactor(user.Id))
+	require.False(t, IsTwoFactorEnabled(user.Id))
+	require.Error(t, VerifyTwoFactor(user.Id, testTotpCode(t, secret, 0)))
+}
+
+func TestTwoFactorChallenge(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "tf_user")
+
+	challenge, err := CreateTwoFactorChallenge(user.Id)
+	require.NoError(t, err)
+	for i := 1; i <= TwoFactorChallengeMaxAttempts; i++ {
+		userId, left, err := UseTwoFactorChallenge(challenge)
+		require.NoError(t, err)
+		require.Equal(t, user.Id, userId)
+		require.Equal(t, TwoFactorChallengeMaxAttempts-i, left)
+	}
+	_, _, err = UseTwoFactorChallenge(challenge)
+	require.Error(t, err)
+	var count int64
+	require.NoError(t, DB.Model(&TwoFactorChallenge{}).Count(&count).Error)
+	require.Zero(t, count)
+
+	challenge, err = CreateTwoFactorChallenge(user.Id)
+	require.NoError(t, err)
+	_, _, err = UseTwoFactorChallenge(challenge)
+	require.NoError(t, err)
+	require.True(t, DeleteTwoFactorChallenge(challenge))
+	require.False(t, DeleteTwoFactorChallenge(challenge), "a challenge can only complete one login")
+	_, _, err = UseTwoFactorChallenge(challenge)
+	require.Error(t, err)
+
+	_, _, err = UseTwoFactorChallenge("unknown")
+	require.Error(t, err)
+
+	challenge, err = CreateTwoFactorChallenge(user.Id)
+	require.NoError(t, err)
+	require.NoError(t, DB.Model(&TwoFactorChallenge{}).Where("id = ?", hashAccessKey(challenge)).Update("expired_time", common.GetTimestamp()-1).Error)
+	_, _, err = UseTwoFactorChallenge(challenge)
+	require.Error(t, err)
+}
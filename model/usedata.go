@@ -14,6 +14,7 @@ type QuotaData struct {
 	Username  string `json:"username" gorm:"index:idx_qdt_model_user_name,priority:2;size:64;default:''"`
 	Type      int    `json:"type" gorm:"default:0"`
 	ChannelId int    `json:"channel" gorm:"index"`
+	OrgId     int    `json:"org_id" gorm:"default:0;index"`
 	ModelName string `json:"model_name" gorm:"index:idx_qdt_model_user_name,priority:1;size:64;default:''"`
 	CreatedAt int64  `json:"created_at" gorm:"bigint;index:idx_qdt_created_at,priority:2"`
 	Count     int    `json:"count" gorm:"default:0"`
@@ -37,10 +38,10 @@ func UpdateQuotaData() {
 var CacheQuotaData = make(map[string]*QuotaData)
 var CacheQuotaDataLock = sync.Mutex{}
 
-func LogQuotaDataCache(userId int, username string, LogType int, channelId int, modelName string, quota int, createdAt int64) {
+func LogQuotaDataCache(userId int, username string, orgId int, LogType int, channelId int, modelName string, quota int, createdAt int64) {
 	// 只精确到小时
 	createdAt = createdAt - (createdAt % 3600)
-	key := fmt.Sprintf("%d-%s-%d-%d-%s-%d", userId, username, LogType, channelId, modelName, createdAt)
+	key := fmt.Sprintf("%d-%s-%d-%d-%d-%s-%d", userId, username, orgId, LogType, channelId, modelName, createdAt)
 	quotaData, ok := CacheQuotaData[key]
 	if ok {
 		quotaData.Count += 1
@@ -51,6 +52,7 @@ func LogQuotaDataCache(userId int, username string, LogType int, channelId int,
 			Username:  username,
 			Type:      LogType,
 			ChannelId: channelId,
+			OrgId:     orgId,
 			ModelName: modelName,
 			CreatedAt: createdAt,
 			Count:     1,
@@ -60,10 +62,10 @@ func LogQuotaDataCache(userId int, username string, LogType int, channelId int,
 	CacheQuotaData[key] = quotaData
 }
 
-func LogQuotaData(userId int, username string, LogType int, channelId int, modelName string, quota int, createdAt int64) {
+func LogQuotaData(userId int, username string, orgId int, LogType int, channelId int, modelName string, quota int, createdAt int64) {
 	CacheQuotaDataLock.Lock()
 	defer CacheQuotaDataLock.Unlock()
-	LogQuotaDataCache(userId, username, LogType, channelId, modelName, quota, createdAt)
+	LogQuotaDataCache(userId, username, orgId, LogType, channelId, modelName, quota, createdAt)
 }
 
 func SaveQuotaDataCache() {
@@ -76,8 +78,8 @@ func SaveQuotaDataCache() {
 	// 3. 如果没有数据，就插入数据
 	for _, quotaData := range CacheQuotaData {
 		quotaDataDB := &QuotaData{}
-		DB.Table("quota_data").Where("user_id = ? and username = ? and type = ? and channel_id = ? and model_name = ? and created_at = ?",
-			quotaData.UserID, quotaData.Username, quotaData.Type, quotaData.ChannelId, quotaData.ModelName, quotaData.CreatedAt).First(quotaDataDB)
+		DB.Table("quota_data").Where("user_id = ? and username = ? and org_id = ? and type = ? and channel_id = ? and model_name = ? and created_at = ?",
+			quotaData.UserID, quotaData.Username, quotaData.OrgId, quotaData.Type, quotaData.ChannelId, quotaData.ModelName, quotaData.CreatedAt).First(quotaDataDB)
 		if quotaDataDB.Id > 0 {
 			quotaDataDB.Count += quotaData.Count
 			quotaDataDB.Quota += quotaData.Quota
@@ -104,9 +106,23 @@ func GetQuotaDataByUserId(userId int, startTime int64, endTime int64) (quotaData
 	return quotaDatas, err
 }
 
-func GetAllQuotaDates(startTime int64, endTime int64) (quotaData []*QuotaData, err error) {
+func GetAllQuotaDates(startTime int64, endTime int64, orgId int) (quotaData []*QuotaData, err error) {
 	var quotaDatas []*QuotaData
 	// 从quota_data表中查询数据
-	err = DB.Table("quota_data").Where("created_at >= ? and created_at <= ?", startTime, endTime).Find(&quotaDatas).Error
+	tx := DB.Table("quota_data").Where("created_at >= ? and created_at <= ?", startTime, endTime)
+	if orgId != 0 {
+		tx = tx.Where("org_id = ?", orgId)
+	}
+	err = tx.Find(&quotaDatas).Error
 	return quotaDatas, err
 }
+
+// GetQuotaDataByOrgId 查询组织令牌产生的数据看板数据，userId 为 0 时查询组织全部成员
+func GetQuotaDataByOrgId(orgId int, userId int, startTime int64, endTime int64) (quotaData []*QuotaData, err error) {
+	tx := DB.Table("quota_data").Where("org_id = ? and created_at >= ? and created_at <= ?", orgId, startTime, endTime)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	err = tx.Find(&quotaData).Error
+	return quotaData, err
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -36,18 +37,13 @@ type User struct {
 	AffQuota         int            `json:"aff_quota" gorm:"type:int;default:0;column:aff_quota"`           // 邀请剩余额度
 	AffHistoryQuota  int            `json:"aff_history_quota" gorm:"type:int;default:0;column:aff_history"` // 邀请历史额度
 	InviterId        int            `json:"inviter_id" gorm:"type:int;column:inviter_id;index"`
+	AffFlagged       bool           `json:"aff_flagged"` // 疑似自我邀请，不发放邀请奖励和返佣
+	RegisterIp       string         `json:"register_ip" gorm:"type:varchar(64)"`
+	LastLoginIp      string         `json:"last_login_ip" gorm:"type:varchar(64)"`
+	AdminRoleId      int            `json:"admin_role_id" gorm:"default:0;index"` // 命名管理角色，见 AdminRole
 	CreatedAt        int64          `json:"created_at" gorm:"index"`
 	DeletedAt        gorm.DeletedAt `gorm:"index"`
-}
-
-type RechargeRecord struct {
-	ID        uint  `gorm:"primaryKey" json:"id"` // 充值记录ID
-	UserID    uint  `gorm:"index" json:"user_id"` // 对应的用户ID
-	Amount    int   `json:"amount"`               // 充值额度
-	StartDate int64 `json:"start_date"`           // 充值的起始时间
-	EndDate   int64 `json:"end_date"`             // 充值的结束时间
-	CreatedAt int64 `json:"created_at"`           // 创建时间戳
-	UpdatedAt int64 `json:"updated_at"`           // 更新时间戳
+	Subscription     *Subscription  `json:"subscription,omitempty" gorm:"-:all"` // only for GetSelf
 }
 
 // CheckUserExistOrDeleted check if user exist or deleted, if not exist, return false, nil, if deleted or exist, return true, nil
@@ -104,6 +100,14 @@ func GetUserById(id int, selectAll bool) (*User, error) {
 	return &user, err
 }
 
+// UpdateUserLastLoginIp 记录最近登录 IP，用于识别自我邀请
+func UpdateUserLastLoginIp(id int, ip string) {
+	err := DB.Model(&User{}).Where("id = ? and (last_login_ip IS NULL or last_login_ip <> ?)", id, ip).Update("last_login_ip", ip).Error
+	if err != nil {
+		common.SysError("failed to update last login ip: " + err.Error())
+	}
+}
+
 func GetUserIdByAffCode(affCode string) (int, error) {
 	if affCode == "" {
 		return 0, errors.New("affCode 为空！")
@@ -129,15 +133,18 @@ func HardDeleteUserById(id int) error {
 	return err
 }
 
-func inviteUser(inviterId int) (err error) {
-	user, err := GetUserById(inviterId, true)
-	if err != nil {
-		return err
-	}
-	user.AffCount++
-	user.AffQuota += common.QuotaForInviter
-	user.AffHistoryQuota += common.QuotaForInviter
-	return DB.Save(user).Error
+func inviteUser(inviterId int, inviteeId int) (err error) {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", inviterId).Updates(map[string]interface{}{
+			"aff_count":   gorm.Expr("aff_count + 1"),
+			"aff_quota":   gorm.Expr("aff_quota + ?", common.QuotaForInviter),
+			"aff_history": gorm.Expr("aff_history + ?", common.QuotaForInviter),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return recordLedger(tx, LedgerRef{Type: LedgerTypeInvite, RefType: LedgerRefUser, RefId: inviteeId}, affPosting(inviterId, common.QuotaForInviter))
+	})
 }
 
 func (user *User) TransferAffQuotaToQuota(quota int) error {
@@ -169,11 +176,21 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 	}
 
 	// 更新用户额度
+	result := tx.Model(&User{}).Where("id = ? and aff_quota >= ?", user.Id, transferAmount).Updates(map[string]interface{}{
+		"aff_quota": gorm.Expr("aff_quota - ?", transferAmount),
+		"quota":     gorm.Expr("quota + ?", transferAmount),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("邀请额度不足！")
+	}
 	user.AffQuota -= transferAmount
 	user.Quota += transferAmount
-
-	// 保存用户状态
-	if err := tx.Save(user).Error; err != nil {
+	err = recordLedger(tx, LedgerRef{Type: LedgerTypeAffTransfer, RefType: LedgerRefUser, RefId: user.Id},
+		affPosting(user.Id, -transferAmount), userPosting(user.Id, transferAmount))
+	if err != nil {
 		return err
 	}
 
@@ -207,9 +224,6 @@ func (user *User) AffQuotaToQuota(quota int, alipayAccount string) error {
 		return errors.New("超出最低限额！")
 	}
 
-	// 更新用户额度
-	user.AffQuota -= transferAmount
-
 	// 创建提现订单
 	order := &WithdrawalOrder{
 		UserID:           uint(user.Id),
@@ -227,8 +241,17 @@ func (user *User) AffQuotaToQuota(quota int, alipayAccount string) error {
 		return err
 	}
 
-	// 保存用户状态
-	if err := tx.Save(user).Error; err != nil {
+	// 更新用户额度
+	result := tx.Model(&User{}).Where("id = ? and aff_quota >= ?", user.Id, transferAmount).Update("aff_quota", gorm.Expr("aff_quota - ?", transferAmount))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("提现额度不足！")
+	}
+	user.AffQuota -= transferAmount
+	err = recordLedger(tx, LedgerRef{Type: LedgerTypeWithdrawal, RefType: LedgerRefWithdrawal, RefId: int(order.ID)}, affPosting(user.Id, -transferAmount))
+	if err != nil {
 		return err
 	}
 
@@ -262,34 +285,68 @@ func (user *User) Insert(inviterId int) error {
 	user.Quota = common.QuotaForNewUser
 	user.AccessToken = common.GetUUID()
 	user.AffCode = common.GetRandomString(4)
+	fraudReason := ""
+	if inviterId != 0 {
+		fraudReason = checkSelfReferral(inviterId, user)
+		user.AffFlagged = fraudReason != ""
+	}
 	result := DB.Create(user)
 	if result.Error != nil {
 		return result.Error
 	}
 	if common.QuotaForNewUser > 0 {
+		err = recordLedger(DB, LedgerRef{Type: LedgerTypeRegister, RefType: LedgerRefUser, RefId: user.Id}, userPosting(user.Id, common.QuotaForNewUser))
+		if err != nil {
+			common.SysError("failed to record quota ledger: " + err.Error())
+		}
 		RecordLog(user.Id, LogTypeSystem, 0, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(common.QuotaForNewUser)))
 	}
-	if inviterId != 0 {
+	if fraudReason != "" {
+		common.SysLog(fmt.Sprintf("user %d invited by %d flagged as self-referral: %s", user.Id, inviterId, fraudReason))
+		RecordLog(user.Id, LogTypeSystem, 0, fmt.Sprintf("疑似自我邀请（%s），不发放邀请奖励", fraudReason))
+	} else if inviterId != 0 {
 		if common.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee)
+			_ = GrantUserQuota(user.Id, common.QuotaForInvitee, QuotaLotSourceInvite, inviterId, 0)
 			RecordLog(user.Id, LogTypeSystem, 0, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(common.QuotaForInvitee)))
 		}
 		if common.QuotaForInviter > 0 {
 			RecordLog(inviterId, LogTypeSystem, 0, fmt.Sprintf("邀请用户赠送 %s", common.LogQuota(common.QuotaForInviter)))
-			_ = inviteUser(inviterId)
+			_ = inviteUser(inviterId, user.Id)
 		} else if common.ProporTions > 0 {
-			_ = inviteUser(inviterId)
+			_ = inviteUser(inviterId, user.Id)
 		}
 
 	}
 	return nil
 }
 
-func VipInsert(userid int, affquota int) error {
-	inviterId, err := GetInviterId(userid)
+// VipInsert 按比例给邀请人返佣，refType、refId 记入流水以便退款时冲回。开启返佣引擎后由引擎按配置返佣
+func VipInsert(userid int, affquota int, refType string, refId int) error {
+	if common.AffCommissionEnabled {
+		if common.AffCommissionBase == AffCommissionBaseConsume {
+			return nil
+		}
+		var commissions []*AffCommission
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			var err error
+			commissions, err = recordAffCommissions(tx, userid, AffCommissionBaseTopup, affquota, refType, refId)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		logAffCommissions(commissions)
+		return nil
+	}
+	var invitee User
+	err := DB.Select("inviter_id", "aff_flagged").Where("id = ?", userid).First(&invitee).Error
 	if err != nil {
 		return err
 	}
+	if invitee.AffFlagged {
+		return nil
+	}
+	inviterId := invitee.InviterId
 
 	proportionsStr, _ := common.OptionMap["ProporTions"]
 	proportions, err := strconv.ParseFloat(proportionsStr, 64)
@@ -300,7 +357,7 @@ func VipInsert(userid int, affquota int) error {
 
 	if inviterId != 0 {
 		RecordLog(inviterId, LogTypeSystem, 0, fmt.Sprintf("邀请用户充值返现 %s", common.LogQuota(int(quota))))
-		_ = inviteUserVip(inviterId, int(quota))
+		_ = inviteUserVip(inviterId, int(quota), LedgerRef{Type: LedgerTypeCommission, RefType: refType, RefId: refId})
 	}
 	return nil
 }
@@ -314,14 +371,17 @@ func GetInviterId(userid int) (inviterId int, err error) {
 	return user.InviterId, nil
 }
 
-func inviteUserVip(inviterId int, affquota int) (err error) {
-	user, err := GetUserById(inviterId, true)
-	if err != nil {
-		return err
-	}
-	user.AffQuota += affquota
-	user.AffHistoryQuota += affquota
-	return DB.Save(user).Error
+func inviteUserVip(inviterId int, affquota int, ref LedgerRef) (err error) {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", inviterId).Updates(map[string]interface{}{
+			"aff_quota":   gorm.Expr("aff_quota + ?", affquota),
+			"aff_history": gorm.Expr("aff_history + ?", affquota),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return recordLedger(tx, ref, affPosting(inviterId, affquota))
+	})
 }
 
 func (user *User) Update(updatePassword bool) error {
@@ -334,7 +394,15 @@ func (user *User) Update(updatePassword bool) error {
 	}
 	newUser := *user
 	DB.First(&user, user.Id)
-	err = DB.Model(user).Updates(newUser).Error
+	oldQuota := user.Quota
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(user).Updates(newUser).Error
+		if err != nil || newUser.Quota == 0 || newUser.Quota == oldQuota {
+			return err
+		}
+		// 管理员直接修改余额
+		return recordLedger(tx, LedgerRef{Type: LedgerTypeAdjust, RefType: LedgerRefUser, RefId: user.Id}, userPosting(user.Id, newUser.Quota-oldQuota))
+	})
 	if err == nil {
 		if common.RedisEnabled {
 			_ = common.RedisSet(fmt.Sprintf("user_group:%d", user.Id), user.Group, time.Duration(UserId2GroupCacheSeconds)*time.Second)
@@ -444,8 +512,18 @@ func ResetUserPasswordByEmail(email string, password string) error {
 	if err != nil {
 		return err
 	}
+	var userIds []int
+	DB.Model(&User{}).Where("email = ?", email).Pluck("id", &userIds)
 	err = DB.Model(&User{}).Where("email = ?", email).Update("password", hashedPassword).Error
-	return err
+	if err != nil {
+		return err
+	}
+	for _, userId := range userIds {
+		if err := RevokeUserSessions(userId, ""); err != nil {
+			common.SysError("failed to revoke user sessions: " + err.Error())
+		}
+	}
+	return nil
 }
 
 func IsAdmin(userId int) bool {
@@ -462,11 +540,15 @@ func IsAdmin(userId int) bool {
 }
 
 func IsUserEnabled(userId int) (bool, error) {
+	return isUserEnabled(DB, userId)
+}
+
+func isUserEnabled(db *gorm.DB, userId int) (bool, error) {
 	if userId == 0 {
 		return false, errors.New("user id is empty")
 	}
 	var user User
-	err := DB.Where("id = ?", userId).Select("status").Find(&user).Error
+	err := db.Where("id = ?", userId).Select("status").Find(&user).Error
 	if err != nil {
 		return false, err
 	}
@@ -486,7 +568,11 @@ func ValidateAccessToken(token string) (user *User) {
 }
 
 func GetUserQuota(id int) (quota int, err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Select("quota").Find(&quota).Error
+	return getUserQuota(DB, id)
+}
+
+func getUserQuota(db *gorm.DB, id int) (quota int, err error) {
+	err = db.Model(&User{}).Where("id = ?", id).Select("quota").Find(&quota).Error
 	return quota, err
 }
 
@@ -510,15 +596,24 @@ func GetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
-func IncreaseUserQuota(id int, quota int) (err error) {
+// IncreaseUserQuota 退还已扣除的消费额度，批量更新模式下会与其他消费合并写入，流水只能记为 batch
+func IncreaseUserQuota(id int, quota int, ref LedgerRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
+	return adjustUserQuota(context.Background(), id, quota, ref)
+}
+
+// adjustUserQuota 按 delta 增减用户额度，转发扣费时传入请求的 context
+func adjustUserQuota(ctx context.Context, id int, delta int, ref LedgerRef) error {
 	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
+		addNewRecord(BatchUpdateTypeUserQuota, id, delta)
 		return nil
 	}
-	return increaseUserQuota(id, quota)
+	if delta < 0 {
+		return decreaseUserQuota(ctx, id, -delta, ref)
+	}
+	return increaseUserQuota(ctx, id, delta, ref)
 }
 
 func VipUserQuota(id int) (err error) {
@@ -532,100 +627,41 @@ func VipUserQuota(id int) (err error) {
 	return nil
 }
 
-func increaseUserQuota(id int, quota int) (err error) {
-	// 启动一个事务处理增加配额
-	err = DB.Transaction(func(tx *gorm.DB) error {
-		// 更新用户配额
-		if err := tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error; err != nil {
-			return err
-		}
-		return nil
+func increaseUserQuota(ctx context.Context, id int, quota int, ref LedgerRef) (err error) {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return changeUserQuota(tx, id, quota, ref)
 	})
-
-	return err
 }
 
-func IncreaseRechargeQuota(id int, topupratio string, quota int) (err error) {
-	var endQuotaAt int64
-
-	// 如果topupratio不为空且不是"-1"，计算结束时间戳；如果是"-1"，则设置无限期。
-	if topupratio != "" && topupratio != "-1" {
-		days, convErr := strconv.Atoi(topupratio)
-		if convErr != nil {
-			return convErr
-		}
-		currentTime := time.Now().Unix()
-		endQuotaAt = currentTime + int64(days*24*60*60)
-	} else if topupratio == "-1" {
-		endQuotaAt = -1 // 表示无限期
+func DecreaseUserQuota(id int, quota int, ref LedgerRef) (err error) {
+	if quota < 0 {
+		return errors.New("quota 不能为负数！")
 	}
+	return adjustUserQuota(context.Background(), id, -quota, ref)
+}
 
-	// 启动一个事务处理增加配额和插入充值记录
-	err = DB.Transaction(func(tx *gorm.DB) error {
-
-		// 只有当topupratio不为空时，才添加充值记录
-		if topupratio != "" {
-			record := &RechargeRecord{
-				UserID:    uint(id),
-				Amount:    quota,
-				StartDate: time.Now().Unix(), // 使用当前时间作为StartDate
-				EndDate:   endQuotaAt,        // 根据topupratio设置EndDate
-			}
-			if err := tx.Create(&record).Error; err != nil {
-				return err
-			}
-		}
-
-		return nil
+func decreaseUserQuota(ctx context.Context, id int, quota int, ref LedgerRef) (err error) {
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return changeUserQuota(tx, id, -quota, ref)
 	})
-
-	return err
 }
 
-func DecreaseUserQuota(id int, quota int) (err error) {
-	if quota < 0 {
-		return errors.New("quota 不能为负数！")
+// changeUserQuota 在 tx 中按 delta 增减用户额度并记账。扣减时按过期时间先后扣减额度包，
+// 增加时只有消费退款，额度包按扣减的逆序补回
+func changeUserQuota(tx *gorm.DB, id int, delta int, ref LedgerRef) error {
+	if err := tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", delta)).Error; err != nil {
+		return err
 	}
-	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
-		return nil
+	var err error
+	if delta < 0 {
+		err = drawQuotaLots(tx, id, -delta)
+	} else {
+		err = restoreQuotaLots(tx, id, delta)
 	}
-	return decreaseUserQuota(id, quota)
-}
-
-func decreaseUserQuota(userID int, quotaToDecrease int) (err error) {
-	// 启动事务处理配额减少和辅助表记录更新
-	err = DB.Transaction(func(tx *gorm.DB) error {
-		var records []RechargeRecord
-		err := tx.Where("user_id = ?", userID).Order("end_date ASC").Find(&records).Error
-		if err != nil {
-			return err
-		}
-		// 更新用户主表中的配额，减去所有记录中减去的总额
-		if err := tx.Model(&User{}).Where("id = ?", userID).Update("quota", gorm.Expr("quota - ?", quotaToDecrease)).Error; err != nil {
-			return err
-		}
-
-		// 逐条减去每个记录的配额
-		for _, record := range records {
-			// 只处理剩余额度大于0的记录
-			if record.Amount > 0 {
-				if record.Amount <= quotaToDecrease {
-					record.Amount = 0
-				} else {
-					record.Amount -= quotaToDecrease
-				}
-
-				// 更新辅助表中的记录，反映新的剩余额度
-				if err := tx.Save(&record).Error; err != nil {
-					return err
-				}
-			}
-		}
-
-		return nil
-	})
-	return err
+	if err != nil {
+		return err
+	}
+	return recordLedger(tx, ref, userPosting(id, delta))
 }
 
 func GetRootUserEmail() (email string) {
@@ -684,52 +720,6 @@ func UpdateUserQuotaData() {
 			common.SysLog(fmt.Sprintf("UpdateUserQuotaData panic: %s", r))
 		}
 	}()
-	for {
-		common.SysLog("正在更新用户余额日期...")
-
-		// 获取当前时间戳
-		currentTime := time.Now().Unix()
-
-		// 启动一个事务来更新用户余额和处理过期的充值记录
-		err := DB.Transaction(func(tx *gorm.DB) error {
-			// 查找所有已经过期且金额大于0的充值记录
-			var expiredRecords []RechargeRecord
-			err := tx.Where("end_date <= ? AND end_date != -1 AND amount > 0", currentTime).Find(&expiredRecords).Error
-			if err != nil {
-				return err
-			}
-
-			// 对于每个过期的充值记录，减少对应用户在User主表中的配额，并将充值记录的Amount更新为0表示已处理
-			for _, record := range expiredRecords {
-				err = tx.Model(&User{}).Where("id = ?", record.UserID).
-					Update("quota", gorm.Expr("quota - ?", record.Amount)).Error
-				if err != nil {
-					return err
-				}
-
-				// 设置过期记录的金额为0，表示已经从用户余额中扣除
-				record.Amount = 0
-				err = tx.Save(&record).Error
-				if err != nil {
-					return err
-				}
-			}
-
-			// 执行删除所有处理过的过期记录（即金额为0的记录）
-			err = tx.Where("amount = 0").Delete(&RechargeRecord{}).Error
-			if err != nil {
-				return err
-			}
-
-			return nil
-		})
-
-		if err != nil {
-			common.SysLog(fmt.Sprintf("更新用户余额失败：%s", err))
-		} else {
-			common.SysLog("成功更新用户余额并清理过期充值记录。")
-		}
-
-		time.Sleep(time.Duration(60) * time.Minute) // 每小时运行一次
-	}
+	// 每小时运行一次
+	RunLeaderJobEvery("quota_lot_expiry", time.Duration(60)*time.Minute, ExpireQuotaLots)
 }
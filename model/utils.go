@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"one-api/common"
 	"sync"
 	"time"
@@ -34,6 +35,14 @@ func InitBatchUpdater() {
 	}()
 }
 
+// FlushBatchUpdater 停机前将尚未落库的批量更新立即写入数据库
+func FlushBatchUpdater() {
+	if !common.BatchUpdateEnabled {
+		return
+	}
+	batchUpdate()
+}
+
 func addNewRecord(type_ int, id int, value int) {
 	batchUpdateLocks[type_].Lock()
 	defer batchUpdateLocks[type_].Unlock()
@@ -44,6 +53,9 @@ func addNewRecord(type_ int, id int, value int) {
 	}
 }
 
+// batchLedgerRef 批量更新合并了同一账户的多次消费和退款，流水按净额记录
+var batchLedgerRef = LedgerRef{Type: LedgerTypeConsume, RefType: LedgerRefBatch}
+
 func batchUpdate() {
 	common.SysLog("batch update started")
 	for i := 0; i < BatchUpdateTypeCount; i++ {
@@ -55,12 +67,17 @@ func batchUpdate() {
 		for key, value := range store {
 			switch i {
 			case BatchUpdateTypeUserQuota:
-				err := increaseUserQuota(key, value)
+				var err error
+				if value < 0 {
+					err = decreaseUserQuota(context.Background(), key, -value, batchLedgerRef)
+				} else {
+					err = increaseUserQuota(context.Background(), key, value, batchLedgerRef)
+				}
 				if err != nil {
 					common.SysError("failed to batch update user quota: " + err.Error())
 				}
 			case BatchUpdateTypeTokenQuota:
-				err := increaseTokenQuota(key, value)
+				err := increaseTokenQuota(context.Background(), key, value, batchLedgerRef)
 				if err != nil {
 					common.SysError("failed to batch update token quota: " + err.Error())
 				}
@@ -1,16 +1,34 @@
 package model
 
 import (
+	"errors"
+	"fmt"
+	"one-api/common"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 const (
 	StatusPending   = 1 // 待处理
 	StatusApproved  = 2 // 已批准
 	StatusRejected  = 3 // 已拒绝
-	StatusProcessed = 4 // 已处理
+	StatusProcessed = 4 // 已打款
 )
 
+// withdrawalTransitions 提现订单允许的状态流转，已批准的订单打款失败时也可拒绝并退回额度
+var withdrawalTransitions = map[int][]int{
+	StatusPending:  {StatusApproved, StatusRejected},
+	StatusApproved: {StatusProcessed, StatusRejected},
+}
+
+var withdrawalStatusNames = map[int]string{
+	StatusPending:   "待处理",
+	StatusApproved:  "已批准",
+	StatusRejected:  "已拒绝",
+	StatusProcessed: "已打款",
+}
+
 type WithdrawalOrder struct {
 	ID                    uint    `gorm:"primaryKey;autoIncrement" json:"id"`                // 自增的ID作为主键
 	UserID                uint    `gorm:"index;bigint" json:"user_id"`                       // 用户ID
@@ -25,11 +43,23 @@ type WithdrawalOrder struct {
 	Priority              int     `gorm:"index" json:"priority"`                             // 工单的优先级
 	Resolved              bool    `gorm:"index" json:"resolved"`                             // 工单是否已经解决
 	ResolutionDescription string  `gorm:"size:1024" json:"resolution_description,omitempty"` // 解决方案描述
+	PayoutBatch           string  `gorm:"index;size:32" json:"payout_batch,omitempty"`       // 导出的打款批次号
 	CreatedAt             int64   `gorm:"index;bigint" json:"created"`                       // 记录创建的时间戳
 	UpdatedAt             int64   `gorm:"index;bigint" json:"updated"`                       // 最后更新的时间戳
 
 }
 
+// WithdrawalOrderAudit 提现订单每次状态变更的审计记录
+type WithdrawalOrderAudit struct {
+	Id         int    `json:"id"`
+	OrderId    uint   `json:"order_id" gorm:"index"`
+	OperatorId int    `json:"operator_id"`
+	FromStatus int    `json:"from_status"`
+	ToStatus   int    `json:"to_status"`
+	Comment    string `json:"comment" gorm:"size:1024"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint"`
+}
+
 type WithdrawalOrderView struct {
 	// 包含 WithdrawalOrder 的所有字段
 	WithdrawalOrder
@@ -58,6 +88,12 @@ func GetAllWithdrawalOrders(searchParams map[string]interface{}) ([]WithdrawalOr
 		if endDate, ok := searchParams["endDate"]; ok {
 			dbQuery = dbQuery.Where("withdrawal_orders.created_at <= ?", endDate)
 		}
+		if status, ok := searchParams["status"]; ok {
+			dbQuery = dbQuery.Where("withdrawal_orders.status = ?", status)
+		}
+		if payoutBatch, ok := searchParams["payoutBatch"]; ok {
+			dbQuery = dbQuery.Where("withdrawal_orders.payout_batch = ?", payoutBatch)
+		}
 	}
 
 	result := dbQuery.Order("withdrawal_orders.created_at desc").Scan(&ordersView)
@@ -71,71 +107,131 @@ func GetUserWithdrawalOrders(userID uint) ([]WithdrawalOrder, error) {
 	return orders, result.Error
 }
 
-// UpdateWithdrawalOrderStatus 更新提现订单的状态
-func UpdateWithdrawalOrderStatus(orderID uint, status int, processorID uint, comment string) error {
-	updateInfo := map[string]interface{}{
-		"status":       status,
-		"processor_id": processorID,
-		"comment":      comment,
-		"processed_at": time.Now().Unix(),
-	}
-
-	// 根据订单ID更新状态和处理时间
-	result := DB.Model(&WithdrawalOrder{}).Where("id = ?", orderID).Updates(updateInfo)
-
-	return result.Error
+// TransitionWithdrawalOrder 按状态机变更提现订单状态并记录审计日志，拒绝时在同一事务内退回用户的邀请额度
+func TransitionWithdrawalOrder(orderID uint, status int, operatorId int, comment string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return transitionWithdrawalOrder(tx, orderID, status, operatorId, comment)
+	})
 }
 
-// revertQuotaForRejectedOrder 处理拒绝订单并退回用户AffQuota额度
-func RevertQuotaForRejectedOrder(orderID uint) error {
-	// 开始数据库事务
-	tx := DB.Begin()
-	if tx.Error != nil {
-		return tx.Error
-	}
-	defer func() {
-		if r := recover(); r != nil || tx.Error != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// 获取订单信息
+func transitionWithdrawalOrder(tx *gorm.DB, orderID uint, status int, operatorId int, comment string) error {
 	var order WithdrawalOrder
 	if err := tx.First(&order, orderID).Error; err != nil {
 		return err
 	}
-
-	// 获取用户信息，并加锁以确保数据一致性
-	var user User
-	if err := tx.Set("gorm:query_option", "FOR UPDATE").First(&user, order.UserID).Error; err != nil {
-		return err
+	allowed := false
+	for _, next := range withdrawalTransitions[order.Status] {
+		if next == status {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("提现订单不能从%s变更为%s", withdrawalStatusNames[order.Status], withdrawalStatusNames[status])
 	}
+	now := time.Now().Unix()
+	// 以原状态为条件更新，避免并发处理同一订单
+	result := tx.Model(&WithdrawalOrder{}).Where("id = ? and status = ?", order.ID, order.Status).Updates(map[string]interface{}{
+		"status":       status,
+		"processor_id": operatorId,
+		"comment":      comment,
+		"processed_at": now,
+		"updated_at":   now,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("提现订单状态已变更，请刷新后重试")
+	}
+	if status == StatusRejected {
+		if err := revertWithdrawalQuota(tx, &order); err != nil {
+			return err
+		}
+	}
+	return tx.Create(&WithdrawalOrderAudit{
+		OrderId:    order.ID,
+		OperatorId: operatorId,
+		FromStatus: order.Status,
+		ToStatus:   status,
+		Comment:    comment,
+		CreatedAt:  now,
+	}).Error
+}
 
-	// 计算并退回用户额度
+// revertWithdrawalQuota 退回被拒绝订单占用的邀请额度
+func revertWithdrawalQuota(tx *gorm.DB, order *WithdrawalOrder) error {
 	refundAmount := int(order.WithdrawalAmount)
-	user.AffQuota += refundAmount
-
-	// 更新用户信息
-	if err := tx.Save(&user).Error; err != nil {
+	if err := tx.Model(&User{}).Where("id = ?", order.UserID).Update("aff_quota", gorm.Expr("aff_quota + ?", refundAmount)).Error; err != nil {
 		return err
 	}
+	return recordLedger(tx, LedgerRef{Type: LedgerTypeWithdrawalRevert, RefType: LedgerRefWithdrawal, RefId: int(order.ID)}, affPosting(int(order.UserID), refundAmount))
+}
 
-	// 设置订单为已处理状态和处理时间
-	now := time.Now().Unix()
-	order.ProcessedAt = &now
+// CreateWithdrawalPayoutBatch 将所有尚未导出的已批准订单归入一个新的打款批次
+func CreateWithdrawalPayoutBatch() (string, []WithdrawalOrder, error) {
+	batch := time.Now().Format("20060102150405") + common.GetRandomString(4)
+	result := DB.Model(&WithdrawalOrder{}).Where("status = ? and (payout_batch = '' or payout_batch IS NULL)", StatusApproved).
+		Update("payout_batch", batch)
+	if result.Error != nil {
+		return "", nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", nil, errors.New("没有待打款的提现订单")
+	}
+	orders, err := GetWithdrawalPayoutBatch(batch)
+	return batch, orders, err
+}
 
-	// 更新订单信息
-	if err := tx.Save(&order).Error; err != nil {
-		return err
+// GetWithdrawalPayoutBatch 获取一个打款批次内的订单
+func GetWithdrawalPayoutBatch(batch string) (orders []WithdrawalOrder, err error) {
+	err = DB.Where("payout_batch = ?", batch).Order("id asc").Find(&orders).Error
+	return orders, err
+}
+
+// WithdrawalPayoutResult 打款结果文件中的一行
+type WithdrawalPayoutResult struct {
+	OrderNumber string
+	Success     bool
+	Reason      string
+}
+
+// ApplyWithdrawalPayoutResults 按打款结果将已批准订单标记为已打款，失败的订单拒绝并退回额度，返回成功处理的条数和各行的错误
+func ApplyWithdrawalPayoutResults(results []WithdrawalPayoutResult, operatorId int) (paid int, rejected int, errs []string) {
+	for _, item := range results {
+		var order WithdrawalOrder
+		err := DB.Where("order_number = ?", item.OrderNumber).First(&order).Error
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: 订单不存在", item.OrderNumber))
+			continue
+		}
+		if item.Success {
+			err = TransitionWithdrawalOrder(order.ID, StatusProcessed, operatorId, "打款结果导入")
+		} else {
+			err = TransitionWithdrawalOrder(order.ID, StatusRejected, operatorId, "打款失败："+item.Reason)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", item.OrderNumber, err.Error()))
+			continue
+		}
+		if item.Success {
+			paid++
+		} else {
+			rejected++
+		}
 	}
+	return paid, rejected, errs
+}
 
-	// 提交事务
-	return tx.Commit().Error
+// GetWithdrawalOrderAudits 获取提现订单的状态变更记录
+func GetWithdrawalOrderAudits(orderID uint) (audits []*WithdrawalOrderAudit, err error) {
+	err = DB.Where("order_id = ?", orderID).Order("id asc").Find(&audits).Error
+	return audits, err
 }
 
 // GetWithdrawalOrdersCount 获取待处理合计条数
 func GetWithdrawalOrdersCount() (int64, error) {
 	var count int64
-	result := DB.Model(&WithdrawalOrder{}).Where("status = ?", 1).Count(&count)
+	result := DB.Model(&WithdrawalOrder{}).Where("status = ?", StatusPending).Count(&count)
 	return count, result.Error
 }
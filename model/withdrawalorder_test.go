@@ -0,0 +1,71 @@
+package model
+
+import (
+	"one-api/common"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestAffQuota(t *testing.T, id int) int {
+	t.Helper()
+	var quota int
+	require.NoError(t, DB.Model(&User{}).Where("id = ?", id).Select("aff_quota").Find(&quota).Error)
+	return quota
+}
+
+func TestWithdrawalOrderTransitions(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "withdrawer")
+	unit := int(common.QuotaPerUnit)
+	require.NoError(t, inviteUserVip(user.Id, 5*unit, LedgerRef{Type: LedgerTypeCommission, RefType: LedgerRefUser, RefId: user.Id}))
+	require.NoError(t, user.AffQuotaToQuota(2, "paid@example.com"))
+	require.NoError(t, user.AffQuotaToQuota(1, "failed@example.com"))
+	assert.Error(t, user.AffQuotaToQuota(3, "too-much@example.com"))
+	assert.Equal(t, 2*unit, getTestAffQuota(t, user.Id))
+
+	orders, err := GetUserWithdrawalOrders(uint(user.Id))
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	paid, failed := orders[0], orders[1]
+	if paid.AlipayAccount != "paid@example.com" {
+		paid, failed = failed, paid
+	}
+
+	// 待处理的订单不能直接标记为已打款
+	assert.Error(t, TransitionWithdrawalOrder(paid.ID, StatusProcessed, 1, ""))
+	require.NoError(t, TransitionWithdrawalOrder(paid.ID, StatusApproved, 1, "ok"))
+	require.NoError(t, TransitionWithdrawalOrder(failed.ID, StatusApproved, 1, "ok"))
+	batch, batchOrders, err := CreateWithdrawalPayoutBatch()
+	require.NoError(t, err)
+	assert.NotEmpty(t, batch)
+	assert.Len(t, batchOrders, 2)
+	_, _, err = CreateWithdrawalPayoutBatch()
+	assert.Error(t, err)
+
+	processed, rejected, errs := ApplyWithdrawalPayoutResults([]WithdrawalPayoutResult{
+		{OrderNumber: paid.OrderNumber, Success: true},
+		{OrderNumber: failed.OrderNumber, Success: false, Reason: "账号不存在"},
+		{OrderNumber: "missing", Success: true},
+	}, 1)
+	assert.Equal(t, 1, processed)
+	assert.Equal(t, 1, rejected)
+	assert.Len(t, errs, 1)
+	// 打款失败的订单退回额度，重复导入不会再次退回
+	assert.Equal(t, 3*unit, getTestAffQuota(t, user.Id))
+	_, _, errs = ApplyWithdrawalPayoutResults([]WithdrawalPayoutResult{
+		{OrderNumber: paid.OrderNumber, Success: false},
+		{OrderNumber: failed.OrderNumber, Success: false},
+	}, 1)
+	assert.Len(t, errs, 2)
+	assert.Equal(t, 3*unit, getTestAffQuota(t, user.Id))
+
+	audits, err := GetWithdrawalOrderAudits(failed.ID)
+	require.NoError(t, err)
+	require.Len(t, audits, 2)
+	assert.Equal(t, StatusApproved, audits[1].FromStatus)
+	assert.Equal(t, StatusRejected, audits[1].ToStatus)
+	assert.Equal(t, "打款失败：账号不存在", audits[1].Comment)
+	requireLedgerBalanced(t)
+}
@@ -0,0 +1,172 @@
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"strconv"
+	"strings"
+
+	epay "github.com/star-horizon/go-epay"
+)
+
+type epayProvider struct{}
+
+func init() {
+	register(&epayProvider{})
+}
+
+func (p *epayProvider) Name() string {
+	return ProviderEpay
+}
+
+func (p *epayProvider) Enabled() bool {
+	return common.PayAddress != "" && common.EpayId != "" && common.EpayKey != ""
+}
+
+func (p *epayProvider) client() (*epay.Client, error) {
+	if !p.Enabled() {
+		return nil, errors.New("当前管理员未配置支付信息")
+	}
+	return epay.NewClientWithUrl(&epay.Config{
+		PartnerID: common.EpayId,
+		Key:       common.EpayKey,
+	}, common.PayAddress)
+}
+
+func (p *epayProvider) CreateOrder(order *Order) (*CreateResult, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	var payType epay.PurchaseType
+	switch order.PaymentMethod {
+	case "zfb", "alipay":
+		payType = epay.Alipay
+	case "wx", "wxpay":
+		payType = epay.WechatPay
+	}
+	notifyUrl, err := url.Parse(order.NotifyURL)
+	if err != nil {
+		return nil, err
+	}
+	returnUrl, err := url.Parse(order.ReturnURL)
+	if err != nil {
+		return nil, err
+	}
+	uri, params, err := client.Purchase(&epay.PurchaseArgs{
+		Type:           payType,
+		ServiceTradeNo: order.TradeNo,
+		Name:           order.Name,
+		Money:          strconv.FormatFloat(order.Money, 'f', 2, 64),
+		Device:         epay.PC,
+		NotifyUrl:      notifyUrl,
+		ReturnUrl:      returnUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &CreateResult{URL: uri, Params: params}, nil
+}
+
+func (p *epayProvider) VerifyCallback(req *http.Request, body []byte) (*OrderState, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]string)
+	for key := range req.URL.Query() {
+		params[key] = req.URL.Query().Get(key)
+	}
+	// 部分易支付使用 POST 表单回调
+	if form, err := url.ParseQuery(string(body)); err == nil {
+		for key := range form {
+			params[key] = form.Get(key)
+		}
+	}
+	verifyInfo, err := client.Verify(params)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyInfo.VerifyStatus {
+		return nil, errors.New("易支付回调签名校验失败")
+	}
+	state := &OrderState{
+		TradeNo:         verifyInfo.ServiceTradeNo,
+		ProviderTradeNo: verifyInfo.TradeNo,
+		Status:          StatusPending,
+	}
+	state.Money, _ = strconv.ParseFloat(verifyInfo.Money, 64)
+	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
+		state.Status = StatusPaid
+	}
+	return state, nil
+}
+
+// epayApiResponse 易支付 api.php 接口返回，不同实现中数字字段可能是字符串
+type epayApiResponse struct {
+	Code       interface{} `json:"code"`
+	Msg        string      `json:"msg"`
+	TradeNo    string      `json:"trade_no"`
+	OutTradeNo string      `json:"out_trade_no"`
+	Money      interface{} `json:"money"`
+	Status     interface{} `json:"status"`
+}
+
+func (p *epayProvider) api(act string, params url.Values) (*epayApiResponse, error) {
+	if !p.Enabled() {
+		return nil, errors.New("当前管理员未配置支付信息")
+	}
+	params.Set("pid", common.EpayId)
+	params.Set("key", common.EpayKey)
+	apiUrl := strings.TrimSuffix(common.PayAddress, "/") + "/api.php?act=" + act
+	resp, err := httpClient.PostForm(apiUrl, params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result epayApiResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprint(result.Code) != "1" {
+		return nil, fmt.Errorf("易支付接口返回错误：%s", result.Msg)
+	}
+	return &result, nil
+}
+
+func (p *epayProvider) QueryOrder(tradeNo string, providerTradeNo string) (*OrderState, error) {
+	result, err := p.api("order", url.Values{"out_trade_no": {tradeNo}})
+	if err != nil {
+		return nil, err
+	}
+	state := &OrderState{
+		TradeNo:         tradeNo,
+		ProviderTradeNo: result.TradeNo,
+		Status:          StatusPending,
+	}
+	state.Money, _ = strconv.ParseFloat(fmt.Sprint(result.Money), 64)
+	if fmt.Sprint(result.Status) == "1" {
+		state.Status = StatusPaid
+	}
+	return state, nil
+}
+
+func (p *epayProvider) Refund(tradeNo string, providerTradeNo string, money float64) error {
+	_, err := p.api("refund", url.Values{
+		"out_trade_no": {tradeNo},
+		"money":        {strconv.FormatFloat(money, 'f', 2, 64)},
+	})
+	return err
+}
+
+func (p *epayProvider) CallbackResponse(success bool) string {
+	if success {
+		return "success"
+	}
+	return "fail"
+}
@@ -0,0 +1,106 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"strconv"
+	"sync"
+)
+
+// mockProvider 本地模拟支付：支付链接即回调地址，访问后订单视为已支付。
+// 订单状态保存在内存中，仅用于开发和测试，需设置 PAYMENT_MOCK_ENABLED=true 启用
+type mockProvider struct {
+	lock   sync.Mutex
+	orders map[string]*OrderState
+}
+
+func init() {
+	register(&mockProvider{orders: make(map[string]*OrderState)})
+}
+
+func (p *mockProvider) Name() string {
+	return ProviderMock
+}
+
+func (p *mockProvider) Enabled() bool {
+	return common.PaymentMockEnabled
+}
+
+func mockSign(tradeNo string, money string) string {
+	mac := hmac.New(sha256.New, []byte(common.SessionSecret))
+	mac.Write([]byte(tradeNo + "|" + money))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *mockProvider) CreateOrder(order *Order) (*CreateResult, error) {
+	if !p.Enabled() {
+		return nil, errors.New("模拟支付未启用")
+	}
+	money := strconv.FormatFloat(order.Money, 'f', 2, 64)
+	params := map[string]string{
+		"trade_no": order.TradeNo,
+		"money":    money,
+		"sign":     mockSign(order.TradeNo, money),
+	}
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	p.lock.Lock()
+	p.orders[order.TradeNo] = &OrderState{TradeNo: order.TradeNo, ProviderTradeNo: "MOCK" + order.TradeNo, Status: StatusPending, Money: order.Money}
+	p.lock.Unlock()
+	return &CreateResult{URL: order.NotifyURL + "?" + query.Encode(), Params: params, ProviderTradeNo: "MOCK" + order.TradeNo}, nil
+}
+
+func (p *mockProvider) VerifyCallback(req *http.Request, body []byte) (*OrderState, error) {
+	if !p.Enabled() {
+		return nil, errors.New("模拟支付未启用")
+	}
+	query := req.URL.Query()
+	tradeNo := query.Get("trade_no")
+	money := query.Get("money")
+	if !hmac.Equal([]byte(query.Get("sign")), []byte(mockSign(tradeNo, money))) {
+		return nil, errors.New("模拟支付签名校验失败")
+	}
+	state := &OrderState{TradeNo: tradeNo, ProviderTradeNo: "MOCK" + tradeNo, Status: StatusPaid}
+	state.Money, _ = strconv.ParseFloat(money, 64)
+	p.lock.Lock()
+	p.orders[tradeNo] = state
+	p.lock.Unlock()
+	copied := *state
+	return &copied, nil
+}
+
+func (p *mockProvider) QueryOrder(tradeNo string, providerTradeNo string) (*OrderState, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	state, ok := p.orders[tradeNo]
+	if !ok {
+		return nil, errors.New("模拟支付订单不存在")
+	}
+	copied := *state
+	return &copied, nil
+}
+
+func (p *mockProvider) Refund(tradeNo string, providerTradeNo string, money float64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	state, ok := p.orders[tradeNo]
+	if !ok || state.Status != StatusPaid {
+		return errors.New("模拟支付订单未支付")
+	}
+	state.Status = StatusRefunded
+	return nil
+}
+
+func (p *mockProvider) CallbackResponse(success bool) string {
+	if success {
+		return "success"
+	}
+	return "fail"
+}
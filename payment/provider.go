@@ -0,0 +1,86 @@
+package payment
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	ProviderEpay   = "epay"
+	ProviderStripe = "stripe"
+	ProviderMock   = "mock"
+)
+
+// 支付渠道返回的订单状态
+const (
+	StatusPending  = "pending"
+	StatusPaid     = "paid"
+	StatusClosed   = "closed"
+	StatusRefunded = "refunded"
+)
+
+// Order 创建支付订单所需的信息，Money 为实际支付金额
+type Order struct {
+	TradeNo       string
+	Name          string
+	Money         float64
+	PaymentMethod string
+	NotifyURL     string
+	ReturnURL     string
+}
+
+// CreateResult 前端跳转地址或表单参数，ProviderTradeNo 为支付渠道侧的订单号（如 Stripe 的 session id）
+type CreateResult struct {
+	URL             string
+	Params          map[string]string
+	ProviderTradeNo string
+}
+
+// OrderState 回调或主动查询得到的订单状态，TradeNo 为空表示与充值订单无关的通知
+type OrderState struct {
+	TradeNo         string
+	ProviderTradeNo string
+	Status          string
+	Money           float64
+}
+
+type Provider interface {
+	Name() string
+	Enabled() bool
+	CreateOrder(order *Order) (*CreateResult, error)
+	// VerifyCallback 校验回调签名并解析订单状态，body 为已读取的请求体
+	VerifyCallback(req *http.Request, body []byte) (*OrderState, error)
+	QueryOrder(tradeNo string, providerTradeNo string) (*OrderState, error)
+	Refund(tradeNo string, providerTradeNo string, money float64) error
+	// CallbackResponse 回调处理完成后返回给支付渠道的内容
+	CallbackResponse(success bool) string
+}
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+var providers = map[string]Provider{}
+
+func register(provider Provider) {
+	providers[provider.Name()] = provider
+}
+
+// GetProvider 名称为空时使用易支付，兼容旧版前端
+func GetProvider(name string) Provider {
+	if name == "" {
+		name = ProviderEpay
+	}
+	return providers[name]
+}
+
+// EnabledProviders 返回已配置可用的支付渠道
+func EnabledProviders() []string {
+	names := make([]string, 0, len(providers))
+	for name, provider := range providers {
+		if provider.Enabled() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,223 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeSignatureTolerance Webhook 签名时间戳允许的最大偏差
+const stripeSignatureTolerance = 5 * time.Minute
+
+// stripeProvider 基于 Checkout Session 的 Stripe 支付，兼容 Stripe API 的第三方网关可修改 StripeApiAddress。
+// 金额按两位小数的最小货币单位换算，不支持日元等零小数货币
+type stripeProvider struct{}
+
+func init() {
+	register(&stripeProvider{})
+}
+
+func (p *stripeProvider) Name() string {
+	return ProviderStripe
+}
+
+func (p *stripeProvider) Enabled() bool {
+	return common.StripeEnabled && common.StripeApiSecret != "" && common.StripeWebhookSecret != ""
+}
+
+type stripeSession struct {
+	Id                string `json:"id"`
+	Url               string `json:"url"`
+	Status            string `json:"status"`
+	PaymentStatus     string `json:"payment_status"`
+	PaymentIntent     string `json:"payment_intent"`
+	ClientReferenceId string `json:"client_reference_id"`
+	AmountTotal       int64  `json:"amount_total"`
+	Currency          string `json:"currency"`
+}
+
+type stripeEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		Object stripeSession `json:"object"`
+	} `json:"data"`
+}
+
+func (p *stripeProvider) request(method string, path string, form url.Values, result interface{}) error {
+	if !p.Enabled() {
+		return errors.New("当前管理员未配置 Stripe 支付")
+	}
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(common.StripeApiAddress, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+common.StripeApiSecret)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("Stripe 接口返回错误 %d：%s", resp.StatusCode, errResp.Error.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (p *stripeProvider) CreateOrder(order *Order) (*CreateResult, error) {
+	form := url.Values{
+		"mode":                                   {"payment"},
+		"success_url":                            {order.ReturnURL},
+		"cancel_url":                             {order.ReturnURL},
+		"client_reference_id":                    {order.TradeNo},
+		"metadata[trade_no]":                     {order.TradeNo},
+		"line_items[0][quantity]":                {"1"},
+		"line_items[0][price_data][currency]":    {common.StripeCurrency},
+		"line_items[0][price_data][unit_amount]": {strconv.FormatInt(toMinorUnit(order.Money), 10)},
+		"line_items[0][price_data][product_data][name]": {order.Name},
+	}
+	var session stripeSession
+	err := p.request(http.MethodPost, "/v1/checkout/sessions", form, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &CreateResult{URL: session.Url, ProviderTradeNo: session.Id}, nil
+}
+
+func (p *stripeProvider) VerifyCallback(req *http.Request, body []byte) (*OrderState, error) {
+	if !p.Enabled() {
+		return nil, errors.New("当前管理员未配置 Stripe 支付")
+	}
+	err := verifyStripeSignature(req.Header.Get("Stripe-Signature"), body, common.StripeWebhookSecret, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var event stripeEvent
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		return nil, err
+	}
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded", "checkout.session.expired", "checkout.session.async_payment_failed":
+		return sessionState(&event.Data.Object), nil
+	}
+	return &OrderState{}, nil
+}
+
+func (p *stripeProvider) QueryOrder(tradeNo string, providerTradeNo string) (*OrderState, error) {
+	if providerTradeNo == "" {
+		return nil, errors.New("订单缺少 Stripe session id")
+	}
+	var session stripeSession
+	err := p.request(http.MethodGet, "/v1/checkout/sessions/"+url.PathEscape(providerTradeNo), nil, &session)
+	if err != nil {
+		return nil, err
+	}
+	return sessionState(&session), nil
+}
+
+func (p *stripeProvider) Refund(tradeNo string, providerTradeNo string, money float64) error {
+	if providerTradeNo == "" {
+		return errors.New("订单缺少 Stripe session id")
+	}
+	var session stripeSession
+	err := p.request(http.MethodGet, "/v1/checkout/sessions/"+url.PathEscape(providerTradeNo), nil, &session)
+	if err != nil {
+		return err
+	}
+	if session.PaymentIntent == "" {
+		return errors.New("订单尚未支付，无法退款")
+	}
+	var refund struct {
+		Id string `json:"id"`
+	}
+	return p.request(http.MethodPost, "/v1/refunds", url.Values{
+		"payment_intent": {session.PaymentIntent},
+		"amount":         {strconv.FormatInt(toMinorUnit(money), 10)},
+	}, &refund)
+}
+
+func (p *stripeProvider) CallbackResponse(success bool) string {
+	return fmt.Sprintf(`{"received":%t}`, success)
+}
+
+func sessionState(session *stripeSession) *OrderState {
+	state := &OrderState{
+		TradeNo:         session.ClientReferenceId,
+		ProviderTradeNo: session.Id,
+		Status:          StatusPending,
+		Money:           float64(session.AmountTotal) / 100,
+	}
+	if session.PaymentStatus == "paid" {
+		state.Status = StatusPaid
+	} else if session.Status == "expired" {
+		state.Status = StatusClosed
+	}
+	return state
+}
+
+func toMinorUnit(money float64) int64 {
+	return int64(math.Round(money * 100))
+}
+
+// verifyStripeSignature 校验 Stripe-Signature 头：t=时间戳,v1=HMAC-SHA256(t.body)
+func verifyStripeSignature(header string, body []byte, secret string, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return errors.New("Stripe 回调缺少签名")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("Stripe 回调签名时间戳无效")
+	}
+	if diff := now.Sub(time.Unix(ts, 0)); diff > stripeSignatureTolerance || diff < -stripeSignatureTolerance {
+		return errors.New("Stripe 回调签名已过期")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		actual, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(actual, expected) {
+			return nil
+		}
+	}
+	return errors.New("Stripe 回调签名校验失败")
+}
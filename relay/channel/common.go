@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"one-api/common/tracing"
 	"one-api/relay/util"
 
 	"github.com/gin-gonic/gin"
@@ -44,6 +45,7 @@ func DoRequestHelper(a Adaptor, c *gin.Context, meta *util.RelayMeta, requestBod
 }
 
 func DoRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
+	req = req.WithContext(tracing.DetachedContext(c.Request.Context()))
 	resp, err := util.GetHttpClient().Do(req)
 	if err != nil {
 		return nil, err
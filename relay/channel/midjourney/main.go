@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"one-api/common"
+	"one-api/common/tracing"
 	"one-api/model"
 	"one-api/relay/constant"
 	"one-api/relay/util"
@@ -556,7 +557,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *MidjourneyResponse {
 
 	ratio := modelRatio * groupRatio
 
-	userQuota, err := model.CacheGetUserQuota(userId)
+	userQuota, err := model.CacheGetPayerQuota(c.Request.Context(), userId, c.GetInt("org_id"))
 	if err != nil {
 		return &MidjourneyResponse{
 			Code:        4,
@@ -579,6 +580,26 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *MidjourneyResponse {
 			Description: "quota_not_enough",
 		}
 	}
+	if consumeQuota && quota > 0 {
+		err = model.CheckSubscriptionAllowance(userId, imageModel, quota)
+		if err != nil {
+			consumeQuota = false
+			return &MidjourneyResponse{
+				Code:        4,
+				Description: err.Error(),
+			}
+		}
+	}
+	if consumeQuota && quota > 0 && c.GetBool("token_budgeted") {
+		err = model.PreConsumeTokenQuota(c.Request.Context(), tokenId, 0, imageModel)
+		if err != nil {
+			consumeQuota = false
+			return &MidjourneyResponse{
+				Code:        4,
+				Description: err.Error(),
+			}
+		}
+	}
 
 	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
@@ -597,6 +618,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *MidjourneyResponse {
 	//req.Header.Set("mj-api-secret", strings.Split(c.Request.Header.Get("Authorization"), " ")[1])
 	// print request header
 
+	req = req.WithContext(tracing.DetachedContext(c.Request.Context()))
 	resp, err := util.HTTPClient.Do(req)
 	if err != nil {
 		consumeQuota = false
@@ -628,7 +650,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *MidjourneyResponse {
 	defer func(ctx context.Context) {
 
 		if consumeQuota && !excludedActions[mjAction] {
-			err := model.PostConsumeTokenQuota(tokenId, quota)
+			err := model.PostConsumeTokenQuota(ctx, tokenId, quota)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
@@ -640,12 +662,16 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *MidjourneyResponse {
 				tokenName := c.GetString("token_name")
 				multiplier := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", modelRatio, groupRatio, mjAction)
 				model.RecordConsumeLog(ctx, userId, channelId, channelName, 0, 0, imageModel, tokenName, quota, midjResponse.Result, tokenId, multiplier, userQuota, 0, false)
+				if c.GetBool("token_budgeted") {
+					model.RecordTokenBudgetUsage(tokenId, imageModel, quota)
+				}
+				model.RecordSubscriptionUsage(userId, imageModel, quota)
 				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
 				channelId := c.GetInt("channel_id")
 				model.UpdateChannelUsedQuota(channelId, quota)
 			}
 		}
-	}(c.Request.Context())
+	}(tracing.DetachedContext(c.Request.Context()))
 
 	responseBody, err := io.ReadAll(resp.Body)
 
@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"one-api/common"
+	"one-api/common/tracing"
 	"one-api/model"
 	"one-api/relay/channel/openai"
 	"one-api/relay/constant"
@@ -108,24 +109,31 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
 	}
 
-	userQuota, err := model.CacheGetUserQuota(userId)
+	orgId := c.GetInt("org_id")
+	userQuota, err := model.CacheGetPayerQuota(c.Request.Context(), userId, orgId)
 	if err != nil {
 		return openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
 	if userQuota-preConsumedQuota < 0 {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
-	err = model.CacheDecreaseUserQuota(userId, preConsumedQuota)
+	err = model.CheckSubscriptionAllowance(userId, audioRequest.Model, preConsumedQuota)
 	if err != nil {
-		return openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
+		return openai.ErrorWrapper(err, "subscription_allowance_exceeded", http.StatusForbidden)
+	}
+	if orgId == 0 {
+		err = model.CacheDecreaseUserQuota(c.Request.Context(), userId, preConsumedQuota)
+		if err != nil {
+			return openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
+		}
 	}
 	if userQuota > 100*preConsumedQuota {
 		// in this case, we do not pre-consume quota
 		// because the user has enough quota
 		preConsumedQuota = 0
 	}
-	if preConsumedQuota > 0 {
-		err = model.PreConsumeTokenQuota(tokenId, preConsumedQuota)
+	if preConsumedQuota > 0 || c.GetBool("token_budgeted") {
+		err = model.PreConsumeTokenQuota(c.Request.Context(), tokenId, preConsumedQuota, audioRequest.Model)
 		if err != nil {
 			return openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
@@ -175,6 +183,7 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 	req.Header.Set("Content-Type", c.Request.Header.Get("Content-Type"))
 	req.Header.Set("Accept", c.Request.Header.Get("Accept"))
 
+	req = req.WithContext(tracing.DetachedContext(c.Request.Context()))
 	resp, err := util.HTTPClient.Do(req)
 	if err != nil {
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
@@ -196,7 +205,7 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 	var audioResponse openai.AudioResponse
 
 	defer func(ctx context.Context) {
-		go func() {
+		common.GoBackground(func() {
 			useTimeSeconds := time.Now().Unix() - startTime.Unix()
 			quota := 0
 			var promptTokens = 0
@@ -243,7 +252,7 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 				quota = 1
 			}
 
-			err = model.PostConsumeTokenQuota(tokenId, quota)
+			err = model.PostConsumeTokenQuota(ctx, tokenId, quota)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
@@ -256,12 +265,16 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 				multiplier := fmt.Sprintf("%s，分组倍率 %.2f", modelRatioString, groupRatio)
 				logContent := fmt.Sprintf(" ")
 				model.RecordConsumeLog(ctx, userId, channelId, channelName, promptTokens, 0, audioRequest.Model, tokenName, quota, logContent, tokenId, multiplier, userQuota, int(useTimeSeconds), false)
+				if c.GetBool("token_budgeted") {
+					model.RecordTokenBudgetUsage(tokenId, audioRequest.Model, quota)
+				}
+				model.RecordSubscriptionUsage(userId, audioRequest.Model, quota)
 				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
 				channelId := c.GetInt("channel_id")
 				model.UpdateChannelUsedQuota(channelId, quota)
 			}
-		}()
-	}(c.Request.Context())
+		})
+	}(tracing.DetachedContext(c.Request.Context()))
 
 	responseBody, err := io.ReadAll(resp.Body)
 
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/common/logger"
+	"one-api/common/tracing"
 	"one-api/model"
 	"one-api/relay/channel/openai"
 	"one-api/relay/constant"
@@ -105,16 +106,22 @@ func getPreConsumedQuota(textRequest *relaymodel.GeneralOpenAIRequest, promptTok
 func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64, meta *util.RelayMeta) (int, *relaymodel.ErrorWithStatusCode) {
 	preConsumedQuota := getPreConsumedQuota(textRequest, promptTokens, ratio)
 
-	userQuota, err := model.CacheGetUserQuota(meta.UserId)
+	userQuota, err := model.CacheGetPayerQuota(ctx, meta.UserId, meta.OrgId)
 	if err != nil {
 		return preConsumedQuota, openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
 	if userQuota-preConsumedQuota < 0 {
 		return preConsumedQuota, openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
-	err = model.CacheDecreaseUserQuota(meta.UserId, preConsumedQuota)
+	err = model.CheckSubscriptionAllowance(meta.UserId, textRequest.Model, preConsumedQuota)
 	if err != nil {
-		return preConsumedQuota, openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
+		return preConsumedQuota, openai.ErrorWrapper(err, "subscription_allowance_exceeded", http.StatusForbidden)
+	}
+	if meta.OrgId == 0 {
+		err = model.CacheDecreaseUserQuota(ctx, meta.UserId, preConsumedQuota)
+		if err != nil {
+			return preConsumedQuota, openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
+		}
 	}
 	if userQuota > 100*preConsumedQuota {
 		// in this case, we do not pre-consume quota
@@ -122,8 +129,9 @@ func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIR
 		preConsumedQuota = 0
 		logger.Info(ctx, fmt.Sprintf("user %d has enough quota %d, trusted and no need to pre-consume", meta.UserId, userQuota))
 	}
-	if preConsumedQuota > 0 {
-		err := model.PreConsumeTokenQuota(meta.TokenId, preConsumedQuota)
+	// 设置了预算的令牌即使信任额度也要检查预算
+	if preConsumedQuota > 0 || meta.TokenBudgeted {
+		err := model.PreConsumeTokenQuota(ctx, meta.TokenId, preConsumedQuota, textRequest.Model)
 		if err != nil {
 			return preConsumedQuota, openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
@@ -145,8 +153,17 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *util.R
 		usertext = string(jsonBytes)
 
 	}
-	userQuota, err := model.CacheGetUserQuota(meta.UserId)
+	// 在响应发送后执行，请求的 context 此时已被取消
+	ctx = tracing.DetachedContext(ctx)
+	userQuota, err := model.CacheGetPayerQuota(ctx, meta.UserId, meta.OrgId)
+	if err != nil {
+		logger.Error(ctx, "error get payer quota: "+err.Error())
+	}
 	token, err := model.GetTokenById(meta.TokenId)
+	if err != nil {
+		logger.Error(ctx, "error get token: "+err.Error())
+		token = &model.Token{}
+	}
 	BillingByRequestEnabled, _ := strconv.ParseBool(common.OptionMap["BillingByRequestEnabled"])
 	ModelRatioEnabled, _ := strconv.ParseBool(common.OptionMap["ModelRatioEnabled"])
 	modelRatioString := ""
@@ -214,7 +231,7 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *util.R
 	if LogContentEnabled {
 		logContent = fmt.Sprintf("用户: %s \nAI: %s", usertext, aitext)
 	}
-	err = model.PostConsumeTokenQuota(meta.TokenId, quotaDelta)
+	err = model.PostConsumeTokenQuota(ctx, meta.TokenId, quotaDelta)
 	if err != nil {
 		logger.Error(ctx, "error consuming token remain quota: "+err.Error())
 	}
@@ -229,6 +246,10 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *util.R
 	}
 	if quota != 0 {
 		model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, meta.ChannelName, promptTokens, completionTokens, textRequest.Model, meta.TokenName, quota, logContent, meta.TokenId, multiplier, userQuota, int(duration), meta.IsStream)
+		if meta.TokenBudgeted {
+			model.RecordTokenBudgetUsage(meta.TokenId, textRequest.Model, quota)
+		}
+		model.RecordSubscriptionUsage(meta.UserId, textRequest.Model, quota)
 		model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
 		model.UpdateChannelUsedQuota(meta.ChannelId, quota)
 	}
@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"one-api/common"
+	"one-api/common/tracing"
 	"one-api/model"
 	"one-api/relay/channel/openai"
 	dbmodel "one-api/relay/model"
@@ -148,7 +149,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 	modelRatio := common.GetModelRatio(imageRequest.Model)
 	groupRatio := common.GetGroupRatio(group)
 	ratio := modelRatio * groupRatio
-	userQuota, err := model.CacheGetUserQuota(userId)
+	userQuota, err := model.CacheGetPayerQuota(c.Request.Context(), userId, c.GetInt("org_id"))
 
 	modelRatioString := ""
 	quota := 0
@@ -192,6 +193,18 @@ func RelayImageHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 	if consumeQuota && userQuota-quota < 0 {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
+	if consumeQuota {
+		err = model.CheckSubscriptionAllowance(userId, imageRequest.Model, quota)
+		if err != nil {
+			return openai.ErrorWrapper(err, "subscription_allowance_exceeded", http.StatusForbidden)
+		}
+	}
+	if consumeQuota && c.GetBool("token_budgeted") {
+		err = model.PreConsumeTokenQuota(c.Request.Context(), tokenId, 0, imageRequest.Model)
+		if err != nil {
+			return openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+		}
+	}
 
 	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
@@ -208,6 +221,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 	req.Header.Set("Content-Type", c.Request.Header.Get("Content-Type"))
 	req.Header.Set("Accept", c.Request.Header.Get("Accept"))
 
+	req = req.WithContext(tracing.DetachedContext(c.Request.Context()))
 	resp, err := util.HTTPClient.Do(req)
 	if err != nil {
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
@@ -228,7 +242,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 		if resp.StatusCode != http.StatusOK {
 			return
 		}
-		err := model.PostConsumeTokenQuota(tokenId, quota)
+		err := model.PostConsumeTokenQuota(ctx, tokenId, quota)
 		if err != nil {
 			common.SysError("error consuming token remain quota: " + err.Error())
 		}
@@ -241,11 +255,15 @@ func RelayImageHelper(c *gin.Context, relayMode int) *dbmodel.ErrorWithStatusCod
 			multiplier := fmt.Sprintf(" %s，分组倍率 %.2f", modelRatioString, groupRatio)
 			logContent := fmt.Sprintf(" ")
 			model.RecordConsumeLog(ctx, userId, channelId, channelName, 0, 0, imageRequest.Model, tokenName, quota, logContent, tokenId, multiplier, userQuota, int(useTimeSeconds), false)
+			if c.GetBool("token_budgeted") {
+				model.RecordTokenBudgetUsage(tokenId, imageRequest.Model, quota)
+			}
+			model.RecordSubscriptionUsage(userId, imageRequest.Model, quota)
 			model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
 			channelId := c.GetInt("channel_id")
 			model.UpdateChannelUsedQuota(channelId, quota)
 		}
-	}(c.Request.Context())
+	}(tracing.DetachedContext(c.Request.Context()))
 
 	responseBody, err := io.ReadAll(resp.Body)
 
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/common/logger"
+	"one-api/common/tracing"
 	dbmodel "one-api/model"
 	"one-api/relay/channel/openai"
 	"one-api/relay/constant"
@@ -85,7 +86,10 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
 	}
 
+	_, preConsumeSpan := tracing.Start(ctx, "billing.PreConsumeQuota", tracing.SpanKindInternal)
 	preConsumedQuota, bizErr := preConsumeQuota(ctx, textRequest, promptTokens, ratio, meta)
+	preConsumeSpan.SetAttribute("quota", preConsumedQuota)
+	preConsumeSpan.End()
 	if bizErr != nil {
 		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
 		return bizErr
@@ -110,7 +114,9 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 			requestBody = c.Request.Body
 		}
 	} else {
+		_, convertSpan := tracing.Start(ctx, "adaptor.ConvertRequest", tracing.SpanKindInternal)
 		convertedRequest, err := adaptor.ConvertRequest(c, meta.Mode, textRequest)
+		convertSpan.End()
 		if err != nil {
 			return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
 		}
@@ -123,7 +129,14 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	// do response
 	startTime := time.Now()
 	// do request
+	doRequestSpan, endDoRequestSpan := tracing.StartGinSpan(c, "adaptor.DoRequest", tracing.SpanKindInternal)
+	doRequestSpan.SetAttribute("model", meta.ActualModelName)
+	doRequestSpan.SetAttribute("stream", meta.IsStream)
 	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	if err != nil {
+		doRequestSpan.SetError(err.Error())
+	}
+	endDoRequestSpan()
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
@@ -135,7 +148,12 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	}
 
 	// 执行 DoResponse 方法
+	_, doResponseSpan := tracing.Start(ctx, "adaptor.DoResponse", tracing.SpanKindInternal)
 	aitext, usage, respErr := adaptor.DoResponse(c, resp, meta)
+	if respErr != nil {
+		doResponseSpan.SetError(respErr.Message)
+	}
+	doResponseSpan.End()
 
 	// 记录结束时间
 	endTime := time.Now()
@@ -148,6 +166,10 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		return respErr
 	}
 	// post-consume quota
-	go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, aitext, duration)
+	common.GoBackground(func() {
+		spanCtx, span := tracing.Start(ctx, "billing.PostConsumeQuota", tracing.SpanKindInternal)
+		defer span.End()
+		postConsumeQuota(spanCtx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, aitext, duration)
+	})
 	return nil
 }
@@ -2,18 +2,22 @@ package util
 
 import (
 	"context"
+	"one-api/common"
 	"one-api/common/logger"
+	"one-api/common/metrics"
 	"one-api/model"
 )
 
 func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int, tokenId int) {
 	if preConsumedQuota != 0 {
-		go func(ctx context.Context) {
+		metrics.PreConsumeRefunds.Inc()
+		metrics.PreConsumeRefundedQuota.Add(float64(preConsumedQuota))
+		common.GoBackground(func() {
 			// return pre-consumed quota
-			err := model.PostConsumeTokenQuota(tokenId, -preConsumedQuota)
+			err := model.PostConsumeTokenQuota(ctx, tokenId, -preConsumedQuota)
 			if err != nil {
 				logger.Error(ctx, "error return pre-consumed quota: "+err.Error())
 			}
-		}(ctx)
+		})
 	}
 }
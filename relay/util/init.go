@@ -3,6 +3,7 @@ package util
 import (
 	"net/http"
 	"one-api/common"
+	"one-api/common/tracing"
 	"time"
 )
 
@@ -10,11 +11,15 @@ var HTTPClient *http.Client
 var ImpatientHTTPClient *http.Client
 
 func init() {
+	// 上游请求的 context 携带 span 时创建客户端 span 并传播 traceparent
 	if common.RelayTimeout == 0 {
-		HTTPClient = &http.Client{}
+		HTTPClient = &http.Client{
+			Transport: tracing.NewTransport(http.DefaultTransport),
+		}
 	} else {
 		HTTPClient = &http.Client{
-			Timeout: time.Duration(common.RelayTimeout) * time.Second,
+			Transport: tracing.NewTransport(http.DefaultTransport),
+			Timeout:   time.Duration(common.RelayTimeout) * time.Second,
 		}
 	}
 
@@ -30,6 +30,8 @@ type RelayMeta struct {
 	RequestURLPath  string
 	PromptTokens    int // only for DoResponse
 	FixedContent    string
+	TokenBudgeted   bool // token has daily/weekly/monthly budgets
+	OrgId           int  // token draws from an organization quota pool
 }
 
 func GetRelayMeta(c *gin.Context) *RelayMeta {
@@ -50,6 +52,8 @@ func GetRelayMeta(c *gin.Context) *RelayMeta {
 		Config:         nil,
 		RequestURLPath: c.Request.URL.String(),
 		FixedContent:   c.GetString("fixed_content"),
+		TokenBudgeted:  c.GetBool("token_budgeted"),
+		OrgId:          c.GetInt("org_id"),
 	}
 	if meta.ChannelType == common.ChannelTypeAzure {
 		meta.APIVersion = GetAzureAPIVersion(c)
@@ -3,6 +3,7 @@ package router
 import (
 	"one-api/controller"
 	"one-api/middleware"
+	"one-api/model"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
@@ -26,37 +27,78 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/oauth/wechat", middleware.CriticalRateLimit(), controller.WeChatAuth)
 		apiRouter.GET("/oauth/wechat/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), controller.WeChatBind)
 		apiRouter.GET("/oauth/email/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), controller.EmailBind)
+		apiRouter.GET("/oauth/oidc/:slug/url", middleware.CriticalRateLimit(), controller.GetOidcAuthorizeUrl)
+		apiRouter.GET("/oauth/oidc/:slug", middleware.CriticalRateLimit(), controller.OidcAuth)
 
 		userRoute := apiRouter.Group("/user")
 		{
 			userRoute.POST("/register", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Register)
 			userRoute.POST("/login", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Login)
+			userRoute.POST("/login/2fa", middleware.CriticalRateLimit(), controller.LoginTwoFactor)
 			//userRoute.POST("/tokenlog", middleware.CriticalRateLimit(), controller.TokenLog)
 			userRoute.GET("/logout", controller.Logout)
-			userRoute.GET("/epay/notify", controller.EpayNotify)
+			userRoute.GET("/epay/notify", controller.PaymentNotify)
+			userRoute.GET("/payment/:provider/notify", controller.PaymentNotify)
+			userRoute.POST("/payment/:provider/notify", controller.PaymentNotify)
+
+			// 修改密码、注销账号、生成 access token、管理两步验证和会话不接受个人访问令牌
+			userRoute.PUT("/self", middleware.UserAuth(), controller.UpdateSelf)
+			userRoute.DELETE("/self", middleware.UserAuth(), controller.DeleteSelf)
+			userRoute.GET("/token", middleware.UserAuth(), controller.GenerateAccessToken)
+			twoFactorRoute := userRoute.Group("/2fa")
+			twoFactorRoute.Use(middleware.CriticalRateLimit(), middleware.UserAuth())
+			{
+				twoFactorRoute.GET("", controller.GetSelfTwoFactor)
+				twoFactorRoute.POST("/setup", controller.SetupSelfTwoFactor)
+				twoFactorRoute.POST("/enable", controller.EnableSelfTwoFactor)
+				twoFactorRoute.POST("/disable", controller.DisableSelfTwoFactor)
+				twoFactorRoute.POST("/recovery", controller.RegenerateSelfRecoveryCodes)
+			}
+			sessionRoute := userRoute.Group("/sessions")
+			sessionRoute.Use(middleware.UserAuth())
+			{
+				sessionRoute.GET("", controller.GetSelfSessions)
+				sessionRoute.DELETE("", controller.RevokeOtherSelfSessions)
+				sessionRoute.DELETE("/:id", controller.RevokeSelfSession)
+			}
 
 			selfRoute := userRoute.Group("/")
-			selfRoute.Use(middleware.UserAuth())
+			selfRoute.Use(middleware.UserAuth(model.ScopeSelf))
 			{
 				selfRoute.GET("/dashboard", controller.GetUserDashboard)
 				selfRoute.GET("/self", controller.GetSelf)
+				selfRoute.GET("/lots", controller.GetSelfQuotaLots)
 				selfRoute.GET("/models", controller.GetUserModels)
 				selfRoute.GET("/modelbilling", controller.GetUserModelsBilling)
-				selfRoute.PUT("/self", controller.UpdateSelf)
-				selfRoute.DELETE("/self", controller.DeleteSelf)
-				selfRoute.GET("/token", controller.GenerateAccessToken)
 				selfRoute.GET("/aff", controller.GetAffCode)
+				selfRoute.GET("/aff/commissions", controller.GetSelfAffCommissions)
 				selfRoute.POST("/topup", controller.TopUp)
-				selfRoute.POST("/pay", controller.RequestEpay)
+				selfRoute.POST("/pay", controller.RequestPayment)
 				selfRoute.POST("/amount", controller.RequestAmount)
+				selfRoute.GET("/plans", controller.GetPlans)
+				selfRoute.POST("/subscribe", controller.RequestSubscription)
+				selfRoute.GET("/subscription", controller.GetSelfSubscription)
+				selfRoute.PUT("/subscription", controller.UpdateSelfSubscription)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
 				selfRoute.POST("/aff_withdrawal", controller.AffQuota)
 				selfRoute.GET("/option", controller.GetUserOptions)
 				selfRoute.GET("/userwithdrawals", controller.GetWithdrawalOrdersEndpoint) // 获取用户自己的提现订单列表
+				selfRoute.GET("/permissions", controller.GetSelfPermissions)
+			}
+
+			withdrawalRoute := userRoute.Group("/withdrawals")
+			withdrawalRoute.Use(middleware.PermissionAuth(model.PermWithdrawals))
+			{
+				withdrawalRoute.GET("", controller.GetAllWithdrawalOrdersEndpoint)                  // 获取所有用户的提现订单列表
+				withdrawalRoute.POST("/:id/status", controller.UpdateWithdrawalOrderStatusEndpoint) // 更新提现订单状态
+				withdrawalRoute.GET("/:id/audits", controller.GetWithdrawalOrderAudits)
+				withdrawalRoute.POST("/payout", controller.CreateWithdrawalPayoutBatch)
+				withdrawalRoute.GET("/payout/:batch", controller.ExportWithdrawalPayoutBatch)
+				withdrawalRoute.POST("/payout/import", controller.ImportWithdrawalPayoutResults)
 			}
 
 			adminRoute := userRoute.Group("/")
-			adminRoute.Use(middleware.AdminAuth())
+			adminRoute.Use(middleware.PermissionAuth(model.PermUsers))
 			{
 				adminRoute.GET("/", controller.GetAllUsers)
 				adminRoute.GET("/search", controller.SearchUsers)
@@ -65,21 +107,48 @@ func SetApiRouter(router *gin.Engine) {
 				adminRoute.POST("/manage", controller.ManageUser)
 				adminRoute.PUT("/", controller.UpdateUser)
 				adminRoute.DELETE("/:id", controller.DeleteUser)
-				adminRoute.GET("/withdrawals", controller.GetAllWithdrawalOrdersEndpoint)                  // 获取所有用户的提现订单列表
-				adminRoute.POST("/withdrawals/:id/status", controller.UpdateWithdrawalOrderStatusEndpoint) // 更新提现订单状态
-
+				adminRoute.DELETE("/:id/2fa", controller.ResetUserTwoFactor)
+				adminRoute.GET("/:id/sessions", controller.GetUserSessions)
+				adminRoute.DELETE("/:id/sessions", controller.RevokeUserSessions)
+				adminRoute.GET("/:id/login_failures", controller.GetUserLoginFailures)
+				adminRoute.POST("/:id/unlock", controller.UnlockUserLogin)
 			}
 		}
 		// 创建 /option 路由分组
 		optionRoute := apiRouter.Group("/option")
-		optionRoute.Use(middleware.RootAuth())
+		optionRoute.Use(middleware.PermissionAuth(model.PermOptions))
+		{
+			optionRoute.GET("/", controller.GetOptions)   // 根用户或拥有 options 权限的角色可访问
+			optionRoute.PUT("/", controller.UpdateOption) // 根用户或拥有 options 权限的角色可访问
+		}
+		patRoute := apiRouter.Group("/pat")
+		patRoute.Use(middleware.UserAuth())
+		{
+			patRoute.GET("/", controller.GetPersonalAccessTokens)
+			patRoute.POST("/", controller.AddPersonalAccessToken)
+			patRoute.DELETE("/:id", controller.RevokePersonalAccessToken)
+		}
+		oidcRoute := apiRouter.Group("/oidc")
+		oidcRoute.Use(middleware.PermissionAuth(model.PermOptions))
+		{
+			oidcRoute.GET("/", controller.GetOidcProviders)
+			oidcRoute.POST("/", controller.AddOidcProvider)
+			oidcRoute.PUT("/", controller.UpdateOidcProvider)
+			oidcRoute.DELETE("/:id", controller.DeleteOidcProvider)
+		}
+		roleRoute := apiRouter.Group("/role")
+		roleRoute.Use(middleware.RootAuth())
 		{
-			optionRoute.GET("/", controller.GetOptions)   // 根用户可访问
-			optionRoute.PUT("/", controller.UpdateOption) // 根用户可访问
+			roleRoute.GET("/", controller.GetAllAdminRoles)
+			roleRoute.GET("/permissions", controller.GetPermissions)
+			roleRoute.POST("/", controller.AddAdminRole)
+			roleRoute.PUT("/", controller.UpdateAdminRole)
+			roleRoute.PUT("/assign", controller.AssignAdminRole)
+			roleRoute.DELETE("/:id", controller.DeleteAdminRole)
 		}
 
 		channelRoute := apiRouter.Group("/channel")
-		channelRoute.Use(middleware.AdminAuth())
+		channelRoute.Use(middleware.PermissionAuth(model.PermChannels))
 		{
 			channelRoute.GET("/", controller.GetAllChannels)
 			channelRoute.GET("/search", controller.SearchChannels)
@@ -96,7 +165,7 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/batch", controller.DeleteChannelBatch)
 		}
 		tokenRoute := apiRouter.Group("/token")
-		tokenRoute.Use(middleware.UserAuth())
+		tokenRoute.Use(middleware.UserAuth(model.ScopeTokens))
 		{
 			tokenRoute.GET("/", controller.GetAllTokens)
 			tokenRoute.GET("/search", controller.SearchTokens)
@@ -107,41 +176,109 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
 		}
 		redemptionRoute := apiRouter.Group("/redemption")
-		redemptionRoute.Use(middleware.AdminAuth())
+		redemptionRoute.Use(middleware.PermissionAuth(model.PermRedemptions))
 		{
 			redemptionRoute.GET("/", controller.GetAllRedemptions)
 			redemptionRoute.GET("/search", controller.SearchRedemptions)
+			redemptionRoute.GET("/batch", controller.GetRedemptionBatches)
+			redemptionRoute.GET("/batch/:batch/export", controller.ExportRedemptionBatch)
+			redemptionRoute.POST("/batch/:batch/disable", controller.DisableRedemptionBatch)
 			redemptionRoute.GET("/:id", controller.GetRedemption)
 			redemptionRoute.POST("/", controller.AddRedemption)
 			redemptionRoute.PUT("/", controller.UpdateRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		orgRoute := apiRouter.Group("/org")
+		orgRoute.Use(middleware.UserAuth(model.ScopeOrg))
+		{
+			orgRoute.GET("/", controller.GetSelfOrganizations)
+			orgRoute.POST("/", controller.CreateOrganization)
+			orgRoute.GET("/:id", controller.GetOrganization)
+			orgRoute.PUT("/:id", controller.UpdateOrganization)
+			orgRoute.DELETE("/:id", controller.DeleteOrganization)
+			orgRoute.POST("/:id/members", controller.AddOrgMember)
+			orgRoute.PUT("/:id/members", controller.UpdateOrgMember)
+			orgRoute.DELETE("/:id/members/:user_id", controller.RemoveOrgMember)
+			orgRoute.POST("/:id/fund", controller.FundOrganization)
+		}
+		organizationRoute := apiRouter.Group("/organization")
+		organizationRoute.Use(middleware.PermissionAuth(model.PermOrganizations))
+		{
+			organizationRoute.GET("/", controller.GetAllOrganizations)
+			organizationRoute.POST("/:id/quota", controller.AdjustOrganizationQuota)
+		}
+		affiliateRoute := apiRouter.Group("/affiliate")
+		affiliateRoute.Use(middleware.PermissionAuth(model.PermAffiliates))
+		{
+			affiliateRoute.GET("/report", controller.GetAffEarningsReports)
+			affiliateRoute.GET("/:id/commissions", controller.GetAffiliateCommissions)
+			affiliateRoute.POST("/flag", controller.SetAffFlag)
+		}
+		planRoute := apiRouter.Group("/plan")
+		planRoute.Use(middleware.PermissionAuth(model.PermPlans))
+		{
+			planRoute.GET("/", controller.GetPlans)
+			planRoute.POST("/", controller.AddPlan)
+			planRoute.PUT("/", controller.UpdatePlan)
+			planRoute.DELETE("/:id", controller.DeletePlan)
+		}
+		couponRoute := apiRouter.Group("/coupon")
+		couponRoute.Use(middleware.PermissionAuth(model.PermCoupons))
+		{
+			couponRoute.GET("/", controller.GetAllCoupons)
+			couponRoute.GET("/search", controller.SearchCoupons)
+			couponRoute.GET("/report", controller.GetCouponReports)
+			couponRoute.GET("/:id", controller.GetCoupon)
+			couponRoute.GET("/:id/usages", controller.GetCouponUsages)
+			couponRoute.POST("/", controller.AddCoupon)
+			couponRoute.PUT("/", controller.UpdateCoupon)
+			couponRoute.DELETE("/:id", controller.DeleteCoupon)
+		}
+		refillRoute := apiRouter.Group("/refill")
+		refillRoute.Use(middleware.PermissionAuth(model.PermRefills))
+		{
+			refillRoute.GET("/", controller.GetAllRefillSchedules)
+			refillRoute.GET("/preview", controller.PreviewRefills)
+			refillRoute.POST("/", controller.AddRefillSchedule)
+			refillRoute.PUT("/", controller.UpdateRefillSchedule)
+			refillRoute.DELETE("/:id", controller.DeleteRefillSchedule)
+		}
+		ledgerRoute := apiRouter.Group("/ledger")
+		ledgerRoute.Use(middleware.PermissionAuth(model.PermLedger))
+		{
+			ledgerRoute.GET("/", controller.GetQuotaLedgerEntries)
+			ledgerRoute.GET("/reconcile", controller.ReconcileQuotaLedger)
+		}
 		topupsRoute := apiRouter.Group("/topups")
-		topupsRoute.Use(middleware.AdminAuth())
+		topupsRoute.Use(middleware.PermissionAuth(model.PermTopups))
 		{
 			topupsRoute.GET("/", controller.GetAllTopUps)
 			topupsRoute.GET("/search", controller.SearchTopUps)
 			topupsRoute.GET("/:id", controller.GetTopUp)
+			topupsRoute.POST("/:id/verify", controller.VerifyTopUp)
+			topupsRoute.POST("/:id/resolve", controller.ResolveTopUp)
+			topupsRoute.POST("/:id/refund", controller.RefundTopUp)
+			topupsRoute.GET("/:id/refunds", controller.GetTopUpRefunds)
 			topupsRoute.DELETE("/:id", controller.DeleteTopUp)
 		}
 
 		logRoute := apiRouter.Group("/log")
-		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
-		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
-		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
-		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
-		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
-		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
-		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
+		logRoute.GET("/", middleware.PermissionAuth(model.PermLogs), controller.GetAllLogs)
+		logRoute.DELETE("/", middleware.PermissionAuth(model.PermLogs), controller.DeleteHistoryLogs)
+		logRoute.GET("/stat", middleware.PermissionAuth(model.PermLogs), controller.GetLogsStat)
+		logRoute.GET("/self/stat", middleware.UserAuth(model.PermLogs), controller.GetLogsSelfStat)
+		logRoute.GET("/search", middleware.PermissionAuth(model.PermLogs), controller.SearchAllLogs)
+		logRoute.GET("/self", middleware.UserAuth(model.PermLogs), controller.GetUserLogs)
+		logRoute.GET("/self/search", middleware.UserAuth(model.PermLogs), controller.SearchUserLogs)
 
 		logproRoute := apiRouter.Group("/logall")
-		logproRoute.GET("/", middleware.AdminAuth(), controller.GetProLogs)
-		logproRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsProStat)
-		logproRoute.GET("/search", middleware.AdminAuth(), controller.SearchProLogs)
+		logproRoute.GET("/", middleware.PermissionAuth(model.PermLogs), controller.GetProLogs)
+		logproRoute.GET("/stat", middleware.PermissionAuth(model.PermLogs), controller.GetLogsProStat)
+		logproRoute.GET("/search", middleware.PermissionAuth(model.PermLogs), controller.SearchProLogs)
 
 		dataRoute := apiRouter.Group("/data")
-		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
-		dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
+		dataRoute.GET("/", middleware.PermissionAuth(model.PermLogs), controller.GetAllQuotaDates)
+		dataRoute.GET("/self", middleware.UserAuth(model.PermLogs), controller.GetUserQuotaDates)
 
 		logRoute.Use(middleware.CORS())
 		{
@@ -157,12 +294,17 @@ func SetApiRouter(router *gin.Engine) {
 
 		}
 		groupRoute := apiRouter.Group("/group")
-		groupRoute.Use(middleware.AdminAuth())
+		groupRoute.Use(middleware.PermissionAuth(model.PermUsers, model.PermChannels, model.PermRedemptions, model.PermPlans, model.PermCoupons))
 		{
 			groupRoute.GET("/", controller.GetGroups)
 		}
+		jobRoute := apiRouter.Group("/job")
+		jobRoute.Use(middleware.PermissionAuth(model.PermJobs))
+		{
+			jobRoute.GET("/", controller.GetJobStatuses)
+		}
 		mjRoute := apiRouter.Group("/mj")
-		mjRoute.GET("/self", middleware.UserAuth(), controller.GetUserMidjourney)
-		mjRoute.GET("/", middleware.AdminAuth(), controller.GetAllMidjourney)
+		mjRoute.GET("/self", middleware.UserAuth(model.PermLogs), controller.GetUserMidjourney)
+		mjRoute.GET("/", middleware.PermissionAuth(model.PermLogs), controller.GetAllMidjourney)
 	}
 }
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"one-api/common"
+	"one-api/controller"
+	"one-api/middleware"
 	"os"
 	"strings"
 
@@ -12,6 +14,11 @@ import (
 )
 
 func SetRouter(router *gin.Engine, adminFS embed.FS, userFS embed.FS, adminIndexPage []byte, userIndexPage []byte) {
+	router.GET("/healthz", controller.Liveness)
+	router.GET("/readyz", controller.Readiness)
+	if common.MetricsEnabled {
+		router.GET("/metrics", middleware.MetricsAuth(), controller.Metrics)
+	}
 	SetApiRouter(router)
 	SetDashboardRouter(router)
 	SetRelayRouter(router)
@@ -10,7 +10,7 @@ import (
 
 func configureMidjourneyRoutes(group *gin.RouterGroup) {
 	group.GET("/image/:id", midjourney.RelayMidjourneyImage)
-	group.Use(middleware.TokenAuth(), middleware.Distribute())
+	group.Use(middleware.RelayMetrics(), middleware.TokenAuth(), middleware.Distribute())
 	{
 		group.POST("/submit/imagine", controller.RelayMidjourney)
 		group.POST("/submit/change", controller.RelayMidjourney)
@@ -39,7 +39,7 @@ func SetRelayRouter(router *gin.Engine) {
 		modelsRouter.GET("/:model", controller.RetrieveModel)
 	}
 	relayV1Router := router.Group("/v1")
-	relayV1Router.Use(middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute())
+	relayV1Router.Use(middleware.RelayPanicRecover(), middleware.RelayMetrics(), middleware.TokenAuth(), middleware.Distribute())
 	{
 		relayV1Router.POST("/completions", controller.Relay)
 		relayV1Router.POST("/chat/completions", controller.Relay)
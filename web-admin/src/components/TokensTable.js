@@ -9,17 +9,16 @@ import {
     Tag,
     Table,
     Button,
-    Popover,
     Form,
     Modal,
     Popconfirm,
     SplitButtonGroup,
     Dropdown,
-    Select,Switch,Tooltip
+    Select,Switch,Tooltip,Banner
 } from "@douyinfe/semi-ui";
 
 import {
-    IconTreeTriangleDown,IconEyeOpened
+    IconTreeTriangleDown
 } from '@douyinfe/semi-icons';
 import EditToken from "../pages/Token/EditToken";
 
@@ -186,32 +185,9 @@ const TokensTable = () => {
             dataIndex: 'operate',
             render: (text, record, index) => (
                 <div>
-                    <Popover
-                        content={'sk-' + record.key}
-                        style={{ padding: 20 }}
-                        position="top"
-                    >
-                        <Button theme='light' type='tertiary' icon={<IconEyeOpened />} style={{ marginRight: 1 }} />
-                    </Popover>
-
-                    <Button theme='light' type='secondary' style={{marginRight: 1}}
-                            onClick={async (text) => {
-                                await copyText('sk-' + record.key)
-                            }}
-                    >复制</Button>
-                    {/* <SplitButtonGroup style={{marginRight: 1}} aria-label="项目操作按钮组">
-                        <Button theme="light" style={{ color: 'rgba(var(--semi-teal-7), 1)' }} onClick={()=>{onOpenLink('next', record.key)}}>聊天</Button>
-                        {/*<Dropdown trigger="click" position="bottomRight" menu={
-                            [
-                                {node: 'item', key: 'next', name: 'ChatGPT Next Web', onClick: () => {onOpenLink('next', record.key)}},
-                                {node: 'item', key: 'ama', name: 'AMA 问天（BotGrem）', onClick: () => {onOpenLink('ama', record.key)}},
-                                {node: 'item', key: 'opencat', name: 'OpenCat', onClick: () => {onOpenLink('opencat', record.key)}},
-                            ]
-                        }
-                        >
-                            <Button style={ { padding: '8px 4px', color: 'rgba(var(--semi-teal-7), 1)' }} type="primary" icon={<IconTreeTriangleDown />}></Button>
-                    </Dropdown>
-                    </SplitButtonGroup>*/}
+                    <Tooltip content='完整令牌仅在创建时显示一次，遗失后请重新创建' position='top'>
+                        <Tag size='large' style={{marginRight: 1, fontFamily: 'monospace'}}>{'sk-' + record.key_prefix + '...'}</Tag>
+                    </Tooltip>
                     <Popconfirm
                         title="确定是否要删除此令牌？"
                         content="此修改将不可逆"
@@ -220,7 +196,7 @@ const TokensTable = () => {
                         onConfirm={() => {
                             manageToken(record.id, 'delete', record).then(
                                 () => {
-                                    removeRecord(record.key);
+                                    removeRecord(record.id);
                                 }
                             )
                         }}
@@ -277,6 +253,9 @@ const TokensTable = () => {
     });
 
     const [options, setOptions] = useState({});
+    // 完整令牌只在创建接口中返回一次
+    const [createdTokens, setCreatedTokens] = useState([]);
+    const createdKeysText = createdTokens.map(token => createdTokens.length > 1 ? `${token.name}    sk-${token.key}` : `sk-${token.key}`).join('\n');
 
     const closeEdit = () => {
         setShowEdit(false);
@@ -396,10 +375,10 @@ const TokensTable = () => {
         }
       }, [options]);
 
-    const removeRecord = key => {
+    const removeRecord = id => {
         let newDataSource = [...tokens];
-        if (key != null) {
-            let idx = newDataSource.findIndex(data => data.key === key);
+        if (id != null) {
+            let idx = newDataSource.findIndex(data => data.id === id);
 
             if (idx > -1) {
                 newDataSource.splice(idx, 1);
@@ -576,7 +555,22 @@ const TokensTable = () => {
 
     return (
         <>
-            <EditToken refresh={refresh} editingToken={editingToken} visiable={showEdit} handleClose={closeEdit}></EditToken>
+            <EditToken refresh={refresh} editingToken={editingToken} visiable={showEdit} handleClose={closeEdit} onCreated={setCreatedTokens}></EditToken>
+            <Modal
+                title='令牌已创建'
+                visible={createdTokens.length > 0}
+                onCancel={() => setCreatedTokens([])}
+                footer={
+                    <>
+                        {createdTokens.length === 1 && <Button onClick={() => onOpenLink('next', createdTokens[0].key)}>聊天</Button>}
+                        <Button type='primary' onClick={() => copyText(createdKeysText)}>复制</Button>
+                        <Button onClick={() => setCreatedTokens([])}>关闭</Button>
+                    </>
+                }
+            >
+                <Banner type='warning' description='请立即复制并妥善保存，关闭后将无法再次查看完整令牌。' closeIcon={null} style={{marginBottom: 12}} />
+                <pre style={{whiteSpace: 'pre-wrap', wordBreak: 'break-all'}}>{createdKeysText}</pre>
+            </Modal>
             <Form layout='horizontal' style={{marginTop: 10}} labelPosition={'left'}>
                 <Form.Input
                     field="keyword"
@@ -589,7 +583,7 @@ const TokensTable = () => {
                 <Form.Input
                     field="token"
                     label='Key'
-                    placeholder='密钥'
+                    placeholder='完整密钥或密钥前缀'
                     value={searchToken}
                     loading={searching}
                     onChange={handleSearchTokenChange}
@@ -620,19 +614,6 @@ const TokensTable = () => {
                     setShowEdit(true);
                 }
             }>添加令牌</Button>
-            <Button label='复制所选令牌' type="warning" onClick={
-                async () => {
-                    if (selectedKeys.length === 0) {
-                        showError('请至少选择一个令牌！');
-                        return;
-                    }
-                    let keys = "";
-                    for (let i = 0; i < selectedKeys.length; i++) {
-                        keys += selectedKeys[i].name + "    sk-" + selectedKeys[i].key + "\n";
-                    }
-                    await copyText(keys);
-                }
-            }>复制所选令牌到剪贴板</Button>
             {/* 新增删除按钮 */}
             <Popconfirm
                 title="确定是否要删除所选令牌"
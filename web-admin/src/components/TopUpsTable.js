@@ -12,6 +12,7 @@ import {
     Select,
     Popover,
     Modal,
+    Popconfirm,
     ImagePreview,
     Typography
 } from '@douyinfe/semi-ui';
@@ -33,6 +34,12 @@ function renderStatus(type) {
       return <Tag color="green" size='large'>支付成功</Tag>;
     case 'pending':
       return <Tag color="grey" size='large'>未支付</Tag>;
+    case 'expired':
+      return <Tag color="grey" size='large'>已关闭</Tag>;
+    case 'mismatch':
+      return <Tag color="red" size='large'>金额不符</Tag>;
+    case 'refunded':
+      return <Tag color="orange" size='large'>已退款</Tag>;
     default:
       return <Tag color="black" size='large'>未知</Tag>;
   }
@@ -174,6 +181,33 @@ const TopUpsTable = () => {
                 );
             },
         },
+        {
+            title: '',
+            dataIndex: 'operate',
+            className: isAdmin() ? 'tableShow' : 'tableHiddle',
+            render: (text, record, index) => {
+                if (record.status !== 'mismatch') {
+                    return <></>;
+                }
+                return (
+                  <div>
+                    <Popconfirm
+                      title={`按实付金额 ${record.paid_money} 确认并发放相应额度？`}
+                      onConfirm={() => resolveTopUp(record, 'accept')}
+                    >
+                      <Button theme='light' type='primary' style={{marginRight: 1}}>按实付确认</Button>
+                    </Popconfirm>
+                    <Popconfirm
+                      title={`退回实付金额 ${record.paid_money} 并关闭订单？`}
+                      type='danger'
+                      onConfirm={() => resolveTopUp(record, 'refund')}
+                    >
+                      <Button theme='light' type='danger'>退款</Button>
+                    </Popconfirm>
+                  </div>
+                );
+            },
+        },
         
 
     ];
@@ -276,6 +310,17 @@ const TopUpsTable = () => {
             showError(message);
         }
     };
+    const resolveTopUp = async (record, action) => {
+        const res = await API.post(`/api/topups/${record.id}/resolve`, {action});
+        const {success, message} = res.data;
+        if (success) {
+            showSuccess(action === 'accept' ? '订单已确认' : '订单已退款');
+            await refresh();
+        } else {
+            showError(message);
+        }
+    };
+
     const refresh = async () => {
         // setLoading(true);
         setActivePage(1);
@@ -332,6 +377,9 @@ const TopUpsTable = () => {
                           <Select.Option value="">全部</Select.Option>
                           <Select.Option value="success">支付成功</Select.Option>
                           <Select.Option value="pending">未支付</Select.Option>
+                          <Select.Option value="mismatch">金额不符</Select.Option>
+                          <Select.Option value="expired">已关闭</Select.Option>
+                          <Select.Option value="refunded">已退款</Select.Option>
                         </Form.Select>
 
 
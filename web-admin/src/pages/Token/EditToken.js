@@ -174,6 +174,7 @@ const EditToken = (props) => {
         } else {
             // 处理新增多个令牌的情况
             let successCount = 0; // 记录成功创建的令牌数量
+            let createdTokens = []; // 完整令牌只在创建时返回，创建后交给列表页展示
             for (let i = 0; i < tokenCount; i++) {
                 let localInputs = {...inputs};
                 if (localInputs.models && localInputs.models.length > 0) {
@@ -203,10 +204,11 @@ const EditToken = (props) => {
 
                 let res = await API.post(`/api/token/`, localInputs);
                 //console.log("Create response: ", res.data);
-                const {success, message} = res.data;
+                const {success, message, data} = res.data;
 
                 if (success) {
                     successCount++;
+                    createdTokens.push(data);
                 } else {
                     showError(message);
                     break; // 如果创建失败，终止循环
@@ -214,9 +216,10 @@ const EditToken = (props) => {
             }
 
             if (successCount > 0) {
-                showSuccess(`${successCount}个令牌创建成功，请在列表页面点击复制获取令牌！`);
+                showSuccess(`${successCount}个令牌创建成功！`);
                 props.refresh();
                 props.handleClose();
+                props.onCreated && props.onCreated(createdTokens);
             }
         }
         setLoading(false);
@@ -109,9 +109,11 @@ const EditModal = ({ open, tokenId, onCancel, onOk }) => {
         showError(message);
         setErrors({ submit: message });
       } else {
-        showSuccess(batchAddCount > 1 ? '所有令牌创建成功！' : '令牌创建成功，请在列表页面点击复制获取令牌！');
+        // 完整令牌只在创建接口中返回一次，交给列表页弹窗展示
+        const createdTokens = submissions.filter((submission) => submission.data && submission.data.key).map((submission) => submission.data);
+        showSuccess(values.is_edit ? '令牌更新成功！' : batchAddCount > 1 ? '所有令牌创建成功！' : '令牌创建成功！');
         setStatus({ success: true });
-        onOk(true);
+        onOk(true, createdTokens);
       }
     } catch (error) {
       showError(error.message);
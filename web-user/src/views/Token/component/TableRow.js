@@ -1,6 +1,5 @@
 import PropTypes from 'prop-types';
 import { useState,useEffect } from 'react';
-import { useSelector } from 'react-redux';
 import { API } from 'utils/api';
 
 import {
@@ -17,28 +16,13 @@ import {
   Button,
   Tooltip,
   Stack,
-  ButtonGroup,Select, FormControl,Checkbox
+  Typography,
+  Select, FormControl,Checkbox
 } from '@mui/material';
 import TableSwitch from 'ui-component/Switch';
 import { renderQuota, showSuccess,showError, timestamp2string } from 'utils/common';
 import CircularProgress from '@mui/material/CircularProgress';
-import { IconDotsVertical, IconEdit, IconTrash, IconCaretDownFilled,IconEye  } from '@tabler/icons-react';
-
-const COPY_OPTIONS = [
-  {
-    key: 'next',
-    text: 'ChatGPT Next',
-    url: '',
-    encode: false
-  },
-  { key: 'next-mj', text: 'ChatGPT-Midjourney', url: '', encode: true },
-  { key: 'ama', text: 'BotGem', url: 'ama://set-api-key?server={serverAddress}&key=sk-{key}', encode: true },
-  { key: 'opencat', text: 'OpenCat', url: 'opencat://team/join?domain={serverAddress}&token=sk-{key}', encode: true }
-];
-
-function replacePlaceholders(text, key, serverAddress) {
-  return text.replace('{key}', key).replace('{serverAddress}', serverAddress);
-}
+import { IconDotsVertical, IconEdit, IconTrash } from '@tabler/icons-react';
 
 function createMenu(menuItems) {
   return (
@@ -59,7 +43,6 @@ export default function TokensTableRow({ item, manageToken, handleOpenModal, set
   const [menuItems, setMenuItems] = useState(null);
   const [openDelete, setOpenDelete] = useState(false);
   const [statusSwitch, setStatusSwitch] = useState(item.status);
-  const siteInfo = useSelector((state) => state.siteInfo);
   const [loading, setLoading] = useState(false);
   const [billingEnabled, setBillingEnabled] = useState(item.billing_enabled ? 1 : 0);
   const [modelRatioEnabled, setModelRatioEnabled] = useState('');
@@ -119,19 +102,6 @@ export default function TokensTableRow({ item, manageToken, handleOpenModal, set
     }
   }, [options]);
 
-  const handleChatClick = () => {
-    if (!siteInfo?.chat_link) {
-      showSuccess('管理员未设置聊天界面');
-    } else {
-      const chatOption = COPY_OPTIONS.find(option => option.key === 'next');
-      if (chatOption) {
-        handleCopy(chatOption, 'link');
-      }
-    }
-  };
-  
-  
-
   const handleDeleteOpen = () => {
     handleCloseMenu();
     setOpenDelete(true);
@@ -141,17 +111,8 @@ export default function TokensTableRow({ item, manageToken, handleOpenModal, set
     setOpenDelete(false);
   };
 
-  const handleOpenMenu = (event, type) => {
-    switch (type) {
-      case 'copy':
-        setMenuItems(copyItems);
-        break;
-      case 'link':
-        setMenuItems(linkItems);
-        break;
-      default:
-        setMenuItems(actionItems);
-    }
+  const handleOpenMenu = (event) => {
+    setMenuItems(actionItems);
     setOpen(event.currentTarget);
   };
 
@@ -191,64 +152,6 @@ export default function TokensTableRow({ item, manageToken, handleOpenModal, set
     }
   ]);
 
-  const handleCopy = (option, type) => {
-    let serverAddress = '';
-    if (siteInfo?.server_address) {
-      serverAddress = siteInfo.server_address;
-    } else {
-      serverAddress = window.location.host;
-    }
-
-    if (option.encode) {
-      serverAddress = encodeURIComponent(serverAddress);
-    }
-
-    let url = option.url;
-
-    if (!siteInfo?.chat_link && (option.key === 'next' || option.key === 'next-mj')) {
-      showSuccess('管理员未设置聊天界面');
-      return;
-    }
-    if (option.encode) {
-      serverAddress = encodeURIComponent(serverAddress);
-    }
-
-    if (option.key === 'next' || option.key === 'next-mj') {
-      url = siteInfo.chat_link + `/#/?settings={"key":"sk-{key}","url":"{serverAddress}"}`;
-    } 
-
-
-    const key = item.key;
-    const text = replacePlaceholders(url, key, serverAddress);
-    if (type === 'link') {
-      window.open(text);
-    } else {
-      navigator.clipboard.writeText(text);
-      showSuccess('已复制到剪贴板！');
-    }
-    handleCloseMenu();
-  };
-
-
-
-  const copyItems = createMenu(
-    COPY_OPTIONS.map((option) => ({
-      text: option.text,
-      icon: undefined,
-      onClick: () => handleCopy(option, 'copy'),
-      color: undefined
-    }))
-  );
-
-  const linkItems = createMenu(
-    COPY_OPTIONS.map((option) => ({
-      text: option.text,
-      icon: undefined,
-      onClick: () => handleCopy(option, 'link'),
-      color: undefined
-    }))
-  );
-
   return (
     <>
 
@@ -326,39 +229,13 @@ export default function TokensTableRow({ item, manageToken, handleOpenModal, set
         )}
 
         <TableCell>
-          <Stack direction="row" spacing={1}>
-            <Tooltip title={`sk-${item.key}`} placement="top" >
-                <IconButton
-                  edge="end"
-                  aria-label="view"
-                  sx={{ color: 'rgb(99, 115, 129)' }}
-                >
-                  <IconEye />
-                </IconButton>
+          <Stack direction="row" spacing={1} alignItems="center">
+            <Tooltip title="完整令牌仅在创建时显示一次，遗失后请重新创建" placement="top">
+              <Typography variant="body2" sx={{ fontFamily: 'monospace', color: 'text.secondary' }}>
+                {`sk-${item.key_prefix}...`}
+              </Typography>
             </Tooltip>
-            <ButtonGroup size="small" aria-label="split button">
-            <Button
-              color="primary"
-              onClick={async () => {
-                try {
-                  await navigator.clipboard.writeText(`sk-${item.key}`);
-                  showSuccess('已复制到剪贴板！');
-                } catch (error) {
-                  showError(`复制失败，请手动复制。sk-${item.key}`); // 提示用户手动复制
-                }
-              }}
-            >
-              复制
-            </Button>
-
-            </ButtonGroup>
-            <ButtonGroup size="small" aria-label="split button">
-              <Button color="primary" onClick={handleChatClick} >聊天</Button>
-              <Button size="small" onClick={(e) => handleOpenMenu(e, 'link')}>
-                <IconCaretDownFilled size={'16px'} />
-              </Button>
-            </ButtonGroup>
-            <IconButton onClick={(e) => handleOpenMenu(e, 'action')} sx={{ color: 'rgb(99, 115, 129)' }}>
+            <IconButton onClick={(e) => handleOpenMenu(e)} sx={{ color: 'rgb(99, 115, 129)' }}>
               <IconDotsVertical />
             </IconButton>
           </Stack>
@@ -11,7 +11,7 @@ import Alert from '@mui/material/Alert';
 import ButtonGroup from '@mui/material/ButtonGroup';
 import Toolbar from '@mui/material/Toolbar';
 
-import { Button, Card, Box, Stack, Container, Typography,TextField } from '@mui/material';
+import { Button, Card, Box, Stack, Container, Typography,TextField, Dialog, DialogTitle, DialogContent, DialogActions } from '@mui/material';
 import TokensTableRow from './component/TableRow';
 import TokenTableHead from './component/TableHead';
 import { API } from 'utils/api';
@@ -34,6 +34,7 @@ export default function Token() {
   const [selected, setSelected] = useState([]);
   const [rowsPerPage, setRowsPerPage] = useState(ITEMS_PER_PAGE);
   const [searchToken, setSearchToken] = useState('');
+  const [createdTokens, setCreatedTokens] = useState([]);
 
 
   const loadTokens = async (startIdx, rowsPerPage = ITEMS_PER_PAGE) => {
@@ -179,10 +180,13 @@ export default function Token() {
     setEditTokenId(0);
   };
 
-  const handleOkModal = (status) => {
+  const handleOkModal = (status, created) => {
     if (status === true) {
       handleCloseModal();
       handleRefresh();
+      if (created && created.length > 0) {
+        setCreatedTokens(created);
+      }
     }
   };
 
@@ -231,28 +235,28 @@ export default function Token() {
     setSelected(newSelected);
   };
   
-  const copySelectedKeys = () => {
-    const selectedKeysText = selected.map((id) => {
-      const token = tokens.find((token) => token.id === id);
-      return token ? `sk-${token.key}` : ''; 
-    }).join('\n');
-  
+  // 完整令牌只在创建后显示一次，关闭弹窗后无法再次查看
+  const createdKeysText = createdTokens.map((token) => (createdTokens.length > 1 ? `${token.name}    sk-${token.key}` : `sk-${token.key}`)).join('\n');
+
+  const copyCreatedKeys = () => {
     if (!navigator.clipboard) {
-      showError('复制到剪贴板失败：剪贴板功能不可用');
+      showError('复制到剪贴板失败，请手动复制');
       return;
     }
-  
-    navigator.clipboard.writeText(selectedKeysText)
+    navigator.clipboard
+      .writeText(createdKeysText)
       .then(() => {
-        showSuccess('选中的 keys 已复制到剪贴板');
+        showSuccess('已复制到剪贴板！');
       })
       .catch((err) => {
         showError('复制到剪贴板失败：' + err);
       });
   };
-  
-  
-  
+
+  const openCreatedKeyChat = () => {
+    const serverAddress = siteInfo?.server_address || window.location.host;
+    window.open(siteInfo.chat_link + `/#/?settings={"key":"sk-${createdTokens[0].key}","url":"${serverAddress}"}`);
+  };
 
   return (
     <>
@@ -272,7 +276,7 @@ export default function Token() {
       </Stack>
       <Stack mb={5}>
         <Alert severity="info">
-          将OpenAI API基础地址https://api.openai.com替换为<b>{siteInfo.server_address}</b>，复制下面的密钥即可使用。
+          将OpenAI API基础地址https://api.openai.com替换为<b>{siteInfo.server_address}</b>，使用创建令牌时保存的密钥即可调用。
         </Alert>
       </Stack>
       <Card>
@@ -295,7 +299,7 @@ export default function Token() {
           onChange={handleSearchTokenChange}
           variant="outlined"
           size="small"
-          placeholder="搜索令牌的 key..."
+          placeholder="搜索完整令牌或令牌前缀..."
           fullWidth // 输入框全宽
           sx={{ flex: 1, minWidth: '150px', marginX: 1 }} // 增加左右外边距
         />
@@ -325,15 +329,6 @@ export default function Token() {
                   删除选中
                 </Button>
               )}
-              {selected.length > 0 && (
-                <Button
-                    onClick={copySelectedKeys}
-                    disabled={selected.length === 0} // 当没有选中项时禁用按钮
-                  >
-                    复制选中的 Key
-                  </Button>
-              
-              )}
               <Button onClick={handleRefresh} startIcon={<IconRefresh width={'18px'} />}>
                 刷新
               </Button>
@@ -386,6 +381,20 @@ export default function Token() {
         />
       </Card>
       <EditeModal open={openModal} onCancel={handleCloseModal} onOk={handleOkModal} tokenId={editTokenId} />
+      <Dialog open={createdTokens.length > 0} onClose={() => setCreatedTokens([])} maxWidth="md" fullWidth>
+        <DialogTitle>令牌已创建</DialogTitle>
+        <DialogContent>
+          <Alert severity="warning" sx={{ mb: 2 }}>
+            请立即复制并妥善保存，关闭后将无法再次查看完整令牌。
+          </Alert>
+          <TextField value={createdKeysText} multiline fullWidth InputProps={{ readOnly: true, sx: { fontFamily: 'monospace' } }} />
+        </DialogContent>
+        <DialogActions>
+          {createdTokens.length === 1 && siteInfo?.chat_link && <Button onClick={openCreatedKeyChat}>聊天</Button>}
+          <Button onClick={copyCreatedKeys}>复制</Button>
+          <Button onClick={() => setCreatedTokens([])}>关闭</Button>
+        </DialogActions>
+      </Dialog>
     </>
   );
 }